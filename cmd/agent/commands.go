@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== 远程命令 ====================
+
+// commandMaxOutput 命令输出上限 (面板同样会截断)
+const commandMaxOutput = 64 * 1024
+
+// remoteCommand 面板下发的远程命令
+type remoteCommand struct {
+	ID      uint            `json:"id"`
+	Type    string          `json:"type"`
+	Params  json.RawMessage `json:"params"`
+	Timeout int             `json:"timeout"` // 秒
+}
+
+// commandResult 命令执行结果
+type commandResult struct {
+	Token   string `json:"token,omitempty"` // 仅 HTTP 上报时需要
+	ID      uint   `json:"id"`
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+	Error   string `json:"error"`
+}
+
+// commandHandler 命令处理函数，返回命令输出
+type commandHandler func(a *Agent, ctx context.Context, params json.RawMessage) (string, error)
+
+// commandHandlers 允许执行的命令白名单，Agent 不执行任意 Shell 命令
+var commandHandlers = map[string]commandHandler{
+	"restart_gost":           (*Agent).cmdRestartGost,
+	"diagnostics":            (*Agent).cmdDiagnostics,
+	"connectivity_test":      (*Agent).cmdConnectivityTest,
+	"rotate_api_credentials": (*Agent).cmdRotateAPICredentials,
+	"gost_logs":              (*Agent).cmdGostLogs,
+}
+
+// handleCommand 执行远程命令并上报结果
+func (a *Agent) handleCommand(cmd remoteCommand) {
+	// 同一命令可能经控制通道与心跳重复下发
+	if _, seen := a.seenCommands.LoadOrStore(cmd.ID, struct{}{}); seen {
+		return
+	}
+
+	handler, ok := commandHandlers[cmd.Type]
+	if !ok {
+		log.Printf("Rejected command #%d: %s is not allowed", cmd.ID, cmd.Type)
+		a.reportCommandResult(commandResult{ID: cmd.ID, Error: "command not allowed: " + cmd.Type})
+		return
+	}
+
+	timeout := time.Duration(cmd.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Printf("Executing command #%d: %s", cmd.ID, cmd.Type)
+
+	type outcome struct {
+		output string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		output, err := handler(a, ctx, cmd.Params)
+		done <- outcome{output, err}
+	}()
+
+	res := commandResult{ID: cmd.ID}
+	select {
+	case out := <-done:
+		res.Output = out.output
+		if out.err != nil {
+			res.Error = out.err.Error()
+		}
+	case <-ctx.Done():
+		res.Error = fmt.Sprintf("command timed out after %s", timeout)
+	}
+	res.Success = res.Error == ""
+	res.Output = truncateUTF8(res.Output, commandMaxOutput)
+
+	log.Printf("Command #%d finished (success: %v)", cmd.ID, res.Success)
+	a.reportCommandResult(res)
+}
+
+// reportCommandResult 上报命令结果，优先使用控制通道，失败时回退 HTTP
+func (a *Agent) reportCommandResult(res commandResult) {
+	if a.streamConnected() {
+		if err := a.sendStream("command_result", res); err == nil {
+			return
+		}
+	}
+
+	res.Token = a.token
+	body, _ := json.Marshal(res)
+	for attempt := 1; attempt <= 3; attempt++ {
+		resp, err := a.client.Post(a.panelURL+"/agent/command-result", "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			// 4xx 表示命令已取消/超时或 Token 无效，无需重试
+			if resp.StatusCode < http.StatusInternalServerError {
+				return
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		log.Printf("Failed to report command #%d result (attempt %d): %v", res.ID, attempt, err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// cmdRestartGost 重启 GOST 进程
+func (a *Agent) cmdRestartGost(ctx context.Context, _ json.RawMessage) (string, error) {
+	if err := a.lockGost(ctx); err != nil {
+		return "", err
+	}
+	defer a.unlockGost()
+
+	if err := a.restartGost(); err != nil {
+		return "", fmt.Errorf("start gost: %w", err)
+	}
+	return fmt.Sprintf("GOST restarted (pid %d)", a.gostCmd.Load().Process.Pid), nil
+}
+
+// cmdDiagnostics 收集诊断信息
+func (a *Agent) cmdDiagnostics(ctx context.Context, _ json.RawMessage) (string, error) {
+	hostname, _ := os.Hostname()
+	info := map[string]interface{}{
+		"time":           time.Now().Format(time.RFC3339),
+		"hostname":       hostname,
+		"os":             runtime.GOOS,
+		"arch":           runtime.GOARCH,
+		"go_version":     runtime.Version(),
+		"agent_version":  AgentVersion,
+		"agent_commit":   AgentCommit,
+		"agent_uptime":   time.Since(a.startedAt).Round(time.Second).String(),
+		"auto_update":    a.autoUpdate,
+		"control_stream": a.streamConnected(),
+		"gost_path":      a.gostPath,
+		"gost_api":       a.gostAPI,
+		"config_path":    a.configPath,
+		"config_hash":    a.getConfigHash(),
+	}
+
+	if out, err := exec.CommandContext(ctx, a.gostPath, "-V").CombinedOutput(); err == nil {
+		info["gost_version"] = strings.TrimSpace(string(out))
+	} else {
+		info["gost_version"] = "unknown: " + err.Error()
+	}
+
+	if cmd := a.gostCmd.Load(); cmd != nil && cmd.Process != nil {
+		info["gost_pid"] = cmd.Process.Pid
+	}
+	info["gost_running"] = a.gostRunning.Load()
+
+	if stat, err := os.Stat(a.configPath); err == nil {
+		info["config_size"] = stat.Size()
+		info["config_modified"] = stat.ModTime().Format(time.RFC3339)
+	} else {
+		info["config_error"] = err.Error()
+	}
+
+	if services, err := a.fetchGostServices(); err == nil {
+		info["gost_api_status"] = "ok"
+		info["gost_services"] = len(services)
+	} else {
+		info["gost_api_status"] = err.Error()
+	}
+
+	info["recent_logs"] = a.gostLogs.Tail(50)
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// cmdConnectivityTest 从节点测试到目标地址的 TCP 连通性
+func (a *Agent) cmdConnectivityTest(ctx context.Context, params json.RawMessage) (string, error) {
+	var p struct {
+		Host    string `json:"host"`
+		Port    int    `json:"port"`
+		Count   int    `json:"count"`
+		Timeout int    `json:"timeout"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return "", fmt.Errorf("invalid params: %w", err)
+	}
+	if p.Host == "" || p.Port < 1 || p.Port > 65535 {
+		return "", fmt.Errorf("invalid target")
+	}
+	if p.Count < 1 || p.Count > 10 {
+		p.Count = 3
+	}
+	if p.Timeout < 1 || p.Timeout > 30 {
+		p.Timeout = 5
+	}
+
+	addr := net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+	dialer := net.Dialer{Timeout: time.Duration(p.Timeout) * time.Second}
+
+	var sb strings.Builder
+	var succeeded int
+	var total time.Duration
+	for i := 1; i <= p.Count; i++ {
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		elapsed := time.Since(start)
+		if err != nil {
+			fmt.Fprintf(&sb, "#%d %s: failed after %dms: %v\n", i, addr, elapsed.Milliseconds(), err)
+		} else {
+			conn.Close()
+			succeeded++
+			total += elapsed
+			fmt.Fprintf(&sb, "#%d %s: connected in %dms\n", i, addr, elapsed.Milliseconds())
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if i < p.Count {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	fmt.Fprintf(&sb, "%d/%d succeeded", succeeded, p.Count)
+	if succeeded > 0 {
+		fmt.Fprintf(&sb, ", avg %dms", (total / time.Duration(succeeded)).Milliseconds())
+	}
+	if succeeded == 0 {
+		return sb.String(), fmt.Errorf("%s is unreachable", addr)
+	}
+	return sb.String(), nil
+}
+
+// cmdRotateAPICredentials 面板已生成新的 GOST API 凭据，重新下载配置并重启 GOST 使其生效
+func (a *Agent) cmdRotateAPICredentials(ctx context.Context, _ json.RawMessage) (string, error) {
+	if err := a.lockGost(ctx); err != nil {
+		return "", err
+	}
+	err := a.downloadConfig()
+	if err == nil {
+		// API 服务不支持热重载，需要重启 GOST
+		if err = a.restartGost(); err != nil {
+			err = fmt.Errorf("start gost: %w", err)
+		}
+	} else {
+		err = fmt.Errorf("download config: %w", err)
+	}
+	a.unlockGost()
+	if err != nil {
+		return "", err
+	}
+
+	// 使用新凭据访问 GOST API 验证
+	var lastErr error
+	for ctx.Err() == nil {
+		if _, lastErr = a.fetchGostServices(); lastErr == nil {
+			return "GOST API credentials rotated, API reachable with new credentials", nil
+		}
+		time.Sleep(time.Second)
+	}
+	return "", fmt.Errorf("GOST API not reachable with new credentials: %v", lastErr)
+}
+
+// cmdGostLogs 返回最近的 GOST 输出
+func (a *Agent) cmdGostLogs(ctx context.Context, params json.RawMessage) (string, error) {
+	var p struct {
+		Lines int `json:"lines"`
+	}
+	json.Unmarshal(params, &p)
+	if p.Lines < 1 || p.Lines > gostLogLines {
+		p.Lines = 200
+	}
+
+	lines := a.gostLogs.Tail(p.Lines)
+	if len(lines) == 0 {
+		return "(no GOST output captured)", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// ==================== 配置校验与回滚 ====================
+
+const (
+	configSettleDelay   = 2 * time.Second  // 重载后确认进程存活前的等待时间
+	configVerifyTimeout = 15 * time.Second // 重载后等待 GOST API 恢复的时间
+	configDryRunWait    = 3 * time.Second  // 试运行期间进程未退出即视为通过
+	configFailedTTL     = 5 * time.Minute  // 同一失败配置在此期间内不再重试
+)
+
+// configStatus 配置应用结果
+type configStatus struct {
+	Token      string `json:"token,omitempty"` // 仅 HTTP 上报时需要
+	ConfigHash string `json:"config_hash"`
+	Success    bool   `json:"success"`
+	Stage      string `json:"stage,omitempty"` // validate / reload / start
+	Error      string `json:"error,omitempty"`
+	RolledBack bool   `json:"rolled_back"`
+}
+
+// lastGoodPath 最近一次验证可用的配置
+func (a *Agent) lastGoodPath() string {
+	return a.configPath + ".last-good"
+}
+
+// reloadConfig 下载新配置，校验通过后应用，GOST 重载失败时回滚到最近可用的配置
+func (a *Agent) reloadConfig() {
+	if a.stopping.Load() {
+		return
+	}
+	// 控制通道与心跳可能同时触发重载，GOST 正在重启时由下次心跳重试
+	if !a.reloading.CompareAndSwap(false, true) {
+		return
+	}
+	defer a.unlockGost()
+
+	data, err := a.fetchConfig()
+	if err != nil {
+		log.Printf("Failed to download config: %v", err)
+		return
+	}
+	hash := configHash(data)
+	if hash == a.getConfigHash() {
+		return
+	}
+	if hash == a.failedHash && time.Since(a.failedAt) < configFailedTTL {
+		return
+	}
+
+	if err := a.validateConfig(data); err != nil {
+		log.Printf("New config rejected: %v", err)
+		a.configFailed(hash, "validate", err, false)
+		return
+	}
+
+	previous, _ := os.ReadFile(a.configPath)
+	if err := a.writeConfig(data); err != nil {
+		log.Printf("Failed to write config: %v", err)
+		return
+	}
+	if a.stopping.Load() {
+		return
+	}
+
+	err = a.signalReload()
+	if err == nil {
+		err = a.verifyGost()
+	}
+	if err != nil {
+		log.Printf("GOST failed after config reload: %v, rolling back...", err)
+		rolledBack := a.rollbackConfig(previous)
+		a.configFailed(hash, "reload", err, rolledBack)
+		return
+	}
+
+	log.Println("GOST config reloaded")
+	a.configApplied(hash)
+}
+
+// confirmStartupConfig 启动后确认配置可用，失败时回退到最近可用的配置
+func (a *Agent) confirmStartupConfig() {
+	if !a.reloading.CompareAndSwap(false, true) {
+		return
+	}
+	defer a.unlockGost()
+
+	hash := a.getConfigHash()
+	if err := a.verifyGost(); err != nil {
+		log.Printf("GOST failed with downloaded config: %v", err)
+		rolledBack := false
+		if good, readErr := os.ReadFile(a.lastGoodPath()); readErr == nil && configHash(good) != hash {
+			rolledBack = a.rollbackConfig(nil)
+		}
+		a.configFailed(hash, "start", err, rolledBack)
+		return
+	}
+	a.configApplied(hash)
+}
+
+// signalReload 通知 GOST 加载新配置，优先 SIGHUP 热重载 (不中断连接)，失败时重启
+func (a *Agent) signalReload() error {
+	if cmd := a.gostCmd.Load(); cmd != nil && cmd.Process != nil && a.gostRunning.Load() {
+		log.Println("Config written, sending SIGHUP to GOST for hot reload...")
+		if err := cmd.Process.Signal(syscall.SIGHUP); err == nil {
+			return nil
+		}
+		log.Println("SIGHUP failed, falling back to restart...")
+	}
+	return a.restartGost()
+}
+
+// restartGost 重启 GOST 进程 (调用方需持有 GOST 生命周期锁)
+func (a *Agent) restartGost() error {
+	a.stopGost()
+	time.Sleep(time.Second)
+	return a.startGost()
+}
+
+// verifyGost 确认 GOST 进程存活且 API 可访问
+func (a *Agent) verifyGost() error {
+	time.Sleep(configSettleDelay)
+	deadline := time.Now().Add(configVerifyTimeout)
+	for {
+		if !a.gostRunning.Load() {
+			return fmt.Errorf("GOST exited: %s", strings.Join(a.gostLogs.Tail(5), " | "))
+		}
+		_, err := a.fetchGostServices()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("GOST API not reachable: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// rollbackConfig 恢复最近一次可用的配置 (没有时使用重载前的配置) 并重启 GOST，返回是否恢复成功
+func (a *Agent) rollbackConfig(previous []byte) bool {
+	good, err := os.ReadFile(a.lastGoodPath())
+	if err != nil {
+		good = previous
+	}
+	if len(good) == 0 {
+		log.Println("No known-good config to roll back to")
+		return false
+	}
+	if err := a.writeConfig(good); err != nil {
+		log.Printf("Rollback failed: %v", err)
+		return false
+	}
+	// GOST 可能处于异常状态，回滚时总是重启
+	if err := a.restartGost(); err != nil {
+		log.Printf("Rollback failed: start gost: %v", err)
+		return false
+	}
+	if err := a.verifyGost(); err != nil {
+		log.Printf("Rollback failed: %v", err)
+		return false
+	}
+	log.Println("Rolled back to last known-good config")
+	return true
+}
+
+// configApplied 记录可用配置并上报成功
+func (a *Agent) configApplied(hash string) {
+	if data, err := os.ReadFile(a.configPath); err == nil {
+		if err := os.WriteFile(a.lastGoodPath(), data, 0600); err != nil {
+			log.Printf("Failed to save known-good config: %v", err)
+		}
+	}
+	a.failedHash = ""
+	a.reportConfigStatus(configStatus{ConfigHash: hash, Success: true})
+}
+
+// configFailed 记录失败的配置并上报面板
+func (a *Agent) configFailed(hash, stage string, err error, rolledBack bool) {
+	a.failedHash = hash
+	a.failedAt = time.Now()
+	a.reportConfigStatus(configStatus{ConfigHash: hash, Stage: stage, Error: err.Error(), RolledBack: rolledBack})
+}
+
+// reportConfigStatus 上报配置应用结果，优先使用控制通道，失败时回退 HTTP
+func (a *Agent) reportConfigStatus(status configStatus) {
+	if a.streamConnected() {
+		if err := a.sendStream("config_status", status); err == nil {
+			return
+		}
+	}
+
+	status.Token = a.token
+	body, _ := json.Marshal(status)
+	resp, err := a.client.Post(a.panelURL+"/agent/config-status", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to report config status: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to report config status: status %d", resp.StatusCode)
+	}
+}
+
+// validateConfig 校验新配置: 解析 YAML 并检查服务定义，开启试运行时在临时端口上启动 GOST
+func (a *Agent) validateConfig(data []byte) error {
+	var cfg map[string]interface{}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid YAML: %w", err)
+	}
+	if cfg == nil {
+		return errors.New("empty config")
+	}
+
+	services, ok := cfg["services"].([]interface{})
+	if !ok && cfg["services"] != nil {
+		return errors.New("services must be a list")
+	}
+	for i, item := range services {
+		svc, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("service #%d is not a mapping", i+1)
+		}
+		if name, _ := svc["name"].(string); name == "" {
+			return fmt.Errorf("service #%d has no name", i+1)
+		}
+		if addr, _ := svc["addr"].(string); addr == "" {
+			return fmt.Errorf("service %v has no addr", svc["name"])
+		}
+	}
+
+	if !a.dryRun {
+		return nil
+	}
+	return a.dryRunConfig(cfg)
+}
+
+// dryRunTypes 会创建网络设备或修改路由的服务，无法安全试运行
+var dryRunTypes = map[string]bool{"tun": true, "tap": true, "red": true, "redu": true, "redirect": true, "tproxy": true}
+
+// dryRunConfig 将所有监听地址改为随机端口后启动 GOST，短时间内退出视为配置无效
+func (a *Agent) dryRunConfig(cfg map[string]interface{}) error {
+	services, _ := cfg["services"].([]interface{})
+	for _, item := range services {
+		svc := item.(map[string]interface{})
+		for _, key := range []string{"handler", "listener"} {
+			if m, ok := svc[key].(map[string]interface{}); ok {
+				if typ, _ := m["type"].(string); dryRunTypes[typ] {
+					log.Printf("Skipping dry run: service %v uses %s", svc["name"], typ)
+					return nil
+				}
+			}
+		}
+		svc["addr"] = "127.0.0.1:0"
+	}
+	for _, key := range []string{"api", "metrics"} {
+		if m, ok := cfg[key].(map[string]interface{}); ok {
+			m["addr"] = "127.0.0.1:0"
+		}
+	}
+	// 日志输出到标准输出，便于获取错误信息
+	delete(cfg, "log")
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("dry run: %w", err)
+	}
+	f, err := os.CreateTemp("", "gost-dry-run-*.yml")
+	if err != nil {
+		return fmt.Errorf("dry run: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("dry run: %w", err)
+	}
+	f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), configDryRunWait)
+	defer cancel()
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.gostPath, "-C", f.Name())
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		// 试运行期间未退出，配置可以正常加载
+		return nil
+	}
+
+	out := tailUTF8(strings.TrimSpace(output.String()), 1000)
+	if err == nil {
+		err = errors.New("exited")
+	}
+	return fmt.Errorf("GOST dry run failed (%v): %s", err, out)
+}
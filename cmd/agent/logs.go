@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// gostLogLines GOST 输出在内存中保留的行数
+const gostLogLines = 1000
+
+const (
+	logMaxLineBytes   = 4096       // 单行上限，超出截断
+	logBatchMaxBytes  = 256 * 1024 // 单批上报上限
+	logStreamInterval = 2 * time.Second
+	logHTTPInterval   = 10 * time.Second // 控制通道断开时的 HTTP 上报间隔
+)
+
+// logEntry 一行 GOST 输出
+type logEntry struct {
+	seq  uint64
+	Time time.Time `json:"time"`
+	Line string    `json:"line"`
+}
+
+// logBuffer 保存最近的 GOST 输出 (环形缓冲，按行存储)
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []logEntry
+	seq     uint64 // 最新一行的序号 (从 1 开始)
+	partial []byte
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{entries: make([]logEntry, size)}
+}
+
+// Write 实现 io.Writer，按换行切分 (GOST 的 stdout 与 stderr 可能并发写入)
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := p
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			b.partial = append(b.partial, data...)
+			// 防止无换行的超长输出占用内存
+			if len(b.partial) > 16*1024 {
+				b.add(string(b.partial))
+				b.partial = b.partial[:0]
+			}
+			break
+		}
+		line := append(b.partial, data[:idx]...)
+		b.add(string(bytes.TrimRight(line, "\r")))
+		b.partial = b.partial[:0]
+		data = data[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) add(line string) {
+	line = truncateUTF8(line, logMaxLineBytes)
+	b.seq++
+	b.entries[b.seq%uint64(len(b.entries))] = logEntry{seq: b.seq, Time: time.Now(), Line: line}
+}
+
+// oldest 缓冲中最早一行的序号 (需持有锁)
+func (b *logBuffer) oldest() uint64 {
+	if b.seq < uint64(len(b.entries)) {
+		return 1
+	}
+	return b.seq - uint64(len(b.entries)) + 1
+}
+
+// Tail 返回最近 n 行 (按时间顺序)
+func (b *logBuffer) Tail(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := int(b.seq - b.oldest() + 1)
+	if b.seq == 0 {
+		count = 0
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	result := make([]string, 0, n)
+	for seq := b.seq - uint64(n) + 1; seq <= b.seq; seq++ {
+		result = append(result, b.entries[seq%uint64(len(b.entries))].Line)
+	}
+	return result
+}
+
+// Since 返回序号 after 之后的日志 (单批不超过 maxBytes)
+// dropped 为来不及上报即被覆盖的行数
+func (b *logBuffer) Since(after uint64, maxBytes int) (entries []logEntry, dropped uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.seq <= after {
+		return nil, 0
+	}
+	start := after + 1
+	if oldest := b.oldest(); start < oldest {
+		dropped = oldest - start
+		start = oldest
+	}
+
+	size := 0
+	for seq := start; seq <= b.seq; seq++ {
+		entry := b.entries[seq%uint64(len(b.entries))]
+		size += len(entry.Line) + 48
+		if size > maxBytes && len(entries) > 0 {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, dropped
+}
+
+// logBatch 日志上报内容
+type logBatch struct {
+	Token   string     `json:"token,omitempty"` // 仅 HTTP 上报时需要
+	Lines   []logEntry `json:"lines"`
+	Dropped uint64     `json:"dropped"`
+}
+
+// logShipLoop 批量上报 GOST 输出: 控制通道在线时每 2 秒，否则每 10 秒通过 HTTP
+func (a *Agent) logShipLoop() {
+	ticker := time.NewTicker(logStreamInterval)
+	defer ticker.Stop()
+
+	var shipped uint64
+	var lastHTTP time.Time
+	for range ticker.C {
+		if a.stopping.Load() {
+			return
+		}
+		streaming := a.streamConnected()
+		if !streaming {
+			if time.Since(lastHTTP) < logHTTPInterval {
+				continue
+			}
+			lastHTTP = time.Now()
+		}
+
+		// 积压较多时连续上报多批
+		for i := 0; i < 10; i++ {
+			entries, dropped := a.gostLogs.Since(shipped, logBatchMaxBytes)
+			if len(entries) == 0 {
+				break
+			}
+			batch := logBatch{Lines: entries, Dropped: dropped}
+			var err error
+			if streaming {
+				err = a.sendStream("logs", batch)
+			} else {
+				err = a.postLogs(batch)
+			}
+			if err != nil {
+				log.Printf("Log shipping failed: %v", err)
+				break
+			}
+			shipped = entries[len(entries)-1].seq
+		}
+	}
+}
+
+// postLogs 通过 HTTP 上报日志
+func (a *Agent) postLogs(batch logBatch) error {
+	batch.Token = a.token
+	body, _ := json.Marshal(batch)
+	resp, err := a.client.Post(a.panelURL+"/agent/logs", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -16,9 +16,14 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
+
+	"github.com/goccy/go-yaml"
+	"github.com/gorilla/websocket"
 )
 
 // 版本信息 - 通过 ldflags 在构建时注入
@@ -37,6 +42,7 @@ var (
 	gostUser    = flag.String("gost-user", "", "GOST API username")
 	gostPass    = flag.String("gost-pass", "", "GOST API password")
 	autoUpdate  = flag.Bool("auto-update", true, "Enable auto update")
+	dryRun      = flag.Bool("config-dry-run", true, "Dry-run new GOST configs on scratch ports before applying")
 	showVersion = flag.Bool("version", false, "Show version")
 )
 
@@ -49,9 +55,28 @@ type Agent struct {
 	gostUser   string
 	gostPass   string
 	autoUpdate bool
-	gostCmd    *exec.Cmd
+	dryRun     bool
+	gostCmd    atomic.Pointer[exec.Cmd] // 当前 GOST 进程 (守护、重载与远程命令并发访问)
 	client     *http.Client
+	startedAt  time.Time
 	stopping   atomic.Bool
+	reloading  atomic.Bool
+	removing   atomic.Bool
+	// GOST API 凭据 (未通过参数指定时从下发的配置中读取)
+	credMu         sync.RWMutex
+	credsFromFlags bool
+	gostRunning    atomic.Bool
+	gostLogs       *logBuffer
+	stoppedCmd     atomic.Pointer[exec.Cmd] // 主动停止的进程，退出时不视为崩溃
+	supervisor     gostSupervisor
+	seenCommands   sync.Map // 已执行的远程命令 ID
+	// 最近一次应用失败的配置 (仅在持有 reloading 时访问)
+	failedHash string
+	failedAt   time.Time
+	// 控制通道 (nil 表示未连接，回退 HTTP 心跳)
+	stream    *websocket.Conn
+	streamMu  sync.Mutex
+	reportNow chan struct{}
 	// 用于计算增量流量
 	lastTrafficIn    int64
 	lastTrafficOut   int64
@@ -75,7 +100,12 @@ func NewAgent(panelURL, token, configPath, gostPath, gostAPI, gostUser, gostPass
 		gostUser:         gostUser,
 		gostPass:         gostPass,
 		autoUpdate:       autoUpdate,
+		dryRun:           true,
+		startedAt:        time.Now(),
+		credsFromFlags:   gostUser != "",
+		gostLogs:         newLogBuffer(gostLogLines),
 		lastServiceStats: make(map[string]ServiceStats),
+		reportNow:        make(chan struct{}, 1),
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -110,9 +140,12 @@ func (a *Agent) Run() error {
 		return fmt.Errorf("start gost failed: %w", err)
 	}
 	log.Println("GOST started")
+	go a.confirmStartupConfig()
 
-	// 启动心跳
+	// 启动心跳、控制通道与日志上报
 	go a.heartbeatLoop()
+	go a.streamLoop()
+	go a.logShipLoop()
 
 	// 启动更新检查
 	if a.autoUpdate {
@@ -126,6 +159,7 @@ func (a *Agent) Run() error {
 
 	log.Println("Shutting down...")
 	a.stopping.Store(true)
+	a.closeStream()
 	a.stopGost()
 
 	return nil
@@ -153,28 +187,75 @@ func (a *Agent) register() error {
 }
 
 func (a *Agent) downloadConfig() error {
-	resp, err := a.client.Get(a.panelURL + "/agent/config/" + a.token)
+	configData, err := a.fetchConfig()
 	if err != nil {
 		return err
 	}
+	return a.writeConfig(configData)
+}
+
+// fetchConfig 从面板获取最新配置
+func (a *Agent) fetchConfig() ([]byte, error) {
+	resp, err := a.client.Get(a.panelURL + "/agent/config/" + a.token)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download config failed: status %d", resp.StatusCode)
+		return nil, fmt.Errorf("download config failed: status %d", resp.StatusCode)
 	}
+	return io.ReadAll(resp.Body)
+}
 
+// writeConfig 写入配置文件 (先写临时文件再替换，避免 GOST 读到不完整的配置)
+func (a *Agent) writeConfig(configData []byte) error {
 	// 确保目录存在
-	if err := os.MkdirAll("/etc/gost", 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(a.configPath), 0755); err != nil {
 		return err
 	}
 
-	// 写入配置文件
-	configData, err := io.ReadAll(resp.Body)
-	if err != nil {
+	tmpPath := a.configPath + ".tmp"
+	if err := os.WriteFile(tmpPath, configData, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, a.configPath); err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
+	a.loadAPICredentials(configData)
+	return nil
+}
+
+// loadAPICredentials 从配置中读取 GOST API 认证信息 (面板轮换凭据后自动生效)
+func (a *Agent) loadAPICredentials(configData []byte) {
+	if a.credsFromFlags {
+		return
+	}
+	var cfg struct {
+		API struct {
+			Auth struct {
+				Username string `yaml:"username"`
+				Password string `yaml:"password"`
+			} `yaml:"auth"`
+		} `yaml:"api"`
+	}
+	if err := yaml.Unmarshal(configData, &cfg); err != nil {
+		return
+	}
+	a.credMu.Lock()
+	a.gostUser = cfg.API.Auth.Username
+	a.gostPass = cfg.API.Auth.Password
+	a.credMu.Unlock()
+}
 
-	return os.WriteFile(a.configPath, configData, 0644)
+// setGostAuth 为 GOST API 请求设置认证
+func (a *Agent) setGostAuth(req *http.Request) {
+	a.credMu.RLock()
+	defer a.credMu.RUnlock()
+	if a.gostUser != "" {
+		req.SetBasicAuth(a.gostUser, a.gostPass)
+	}
 }
 
 // findGost 自动检测 GOST 二进制路径
@@ -207,64 +288,93 @@ func findGost(explicit string) (string, error) {
 }
 
 func (a *Agent) startGost() error {
-	a.gostCmd = exec.Command(a.gostPath, "-C", a.configPath)
-	a.gostCmd.Stdout = os.Stdout
-	a.gostCmd.Stderr = os.Stderr
+	cmd := exec.Command(a.gostPath, "-C", a.configPath)
+	// 同时保留最近输出，供远程命令查看；stderr 单独保留用于崩溃上报
+	stderr := newLogBuffer(gostStderrLines)
+	cmd.Stdout = io.MultiWriter(os.Stdout, a.gostLogs)
+	cmd.Stderr = io.MultiWriter(os.Stderr, a.gostLogs, stderr)
 
-	if err := a.gostCmd.Start(); err != nil {
+	if err := cmd.Start(); err != nil {
 		return err
 	}
+	a.gostCmd.Store(cmd)
+	a.gostRunning.Store(true)
 
-	// 监控进程
-	go func() {
-		err := a.gostCmd.Wait()
-		if err != nil {
-			log.Printf("GOST exited with error: %v", err)
-		}
-	}()
+	// 监控进程，意外退出时自动重启
+	go a.watchGost(cmd, time.Now(), stderr)
 
 	return nil
 }
 
 func (a *Agent) stopGost() {
-	if a.gostCmd != nil && a.gostCmd.Process != nil {
-		a.gostCmd.Process.Signal(syscall.SIGTERM)
+	if cmd := a.gostCmd.Load(); cmd != nil && cmd.Process != nil {
+		a.stoppedCmd.Store(cmd)
+		cmd.Process.Signal(syscall.SIGTERM)
 		time.Sleep(2 * time.Second)
-		a.gostCmd.Process.Kill()
+		cmd.Process.Kill()
 	}
 }
 
+const (
+	heartbeatInterval   = 30 * time.Second // HTTP 心跳间隔 (控制通道断开时的回退)
+	streamStatsInterval = 10 * time.Second // 控制通道统计上报间隔
+)
+
+// heartbeatLoop 控制通道在线时通过通道上报统计，否则回退 HTTP 心跳
 func (a *Agent) heartbeatLoop() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(streamStatsInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	var lastHeartbeat time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.reportNow:
+		}
 		if a.stopping.Load() {
 			return
 		}
-		if err := a.sendHeartbeat(); err != nil {
+
+		streaming := a.streamConnected()
+		if !streaming && time.Since(lastHeartbeat) < heartbeatInterval {
+			continue
+		}
+
+		data := a.collectStats()
+		if streaming {
+			err := a.sendStream("stats", data)
+			if err == nil {
+				continue
+			}
+			log.Printf("Stream report failed, falling back to HTTP heartbeat: %v", err)
+		}
+
+		lastHeartbeat = time.Now()
+		if err := a.sendHeartbeat(data); err != nil {
 			log.Printf("Heartbeat failed: %v", err)
 		}
 	}
 }
 
-func (a *Agent) sendHeartbeat() error {
+// collectStats 收集心跳上报数据
+func (a *Agent) collectStats() map[string]interface{} {
 	// 从 GOST API 获取统计数据
 	stats := a.getGostStats()
 	serviceStats := a.getServiceStats()
 
-	// 计算当前配置的哈希值
-	configHash := a.getConfigHash()
-
-	data := map[string]interface{}{
-		"token":          a.token,
-		"connections":    stats.Connections,
-		"traffic_in":     stats.TrafficIn,
-		"traffic_out":    stats.TrafficOut,
-		"config_hash":    configHash,
-		"agent_version":  AgentVersion,
-		"service_stats":  serviceStats, // 按服务名分类的统计
+	return map[string]interface{}{
+		"connections":   stats.Connections,
+		"traffic_in":    stats.TrafficIn,
+		"traffic_out":   stats.TrafficOut,
+		"config_hash":   a.getConfigHash(), // 当前配置的哈希值
+		"agent_version": AgentVersion,
+		"service_stats": serviceStats, // 按服务名分类的统计
+		"gost":          a.gostStatus(), // GOST 进程状态与崩溃统计
 	}
+}
+
+func (a *Agent) sendHeartbeat(data map[string]interface{}) error {
+	data["token"] = a.token
 
 	body, _ := json.Marshal(data)
 	resp, err := a.client.Post(a.panelURL+"/agent/heartbeat", "application/json", bytes.NewReader(body))
@@ -290,6 +400,16 @@ func (a *Agent) sendHeartbeat() error {
 		return fmt.Errorf("heartbeat failed: status %d", resp.StatusCode)
 	}
 
+	// 执行面板下发的远程命令
+	if raw, ok := result["commands"]; ok {
+		var cmds []remoteCommand
+		if data, err := json.Marshal(raw); err == nil && json.Unmarshal(data, &cmds) == nil {
+			for _, cmd := range cmds {
+				go a.handleCommand(cmd)
+			}
+		}
+	}
+
 	// 检查是否需要重载配置
 	if reload, ok := result["reload_config"].(bool); ok && reload {
 		log.Println("Config update detected, reloading...")
@@ -324,6 +444,9 @@ func (a *Agent) performUpdate() {
 
 // uninstall 卸载 Agent 和 GOST
 func (a *Agent) uninstall() {
+	if !a.removing.CompareAndSwap(false, true) {
+		return
+	}
 	log.Println("Stopping GOST...")
 	a.stopGost()
 
@@ -342,48 +465,19 @@ func (a *Agent) uninstall() {
 	os.Exit(0)
 }
 
-// getConfigHash 获取当前配置文件的修改时间作为版本号
+// getConfigHash 计算当前配置文件内容的 SHA-256 (与面板下发内容的哈希一致)
 func (a *Agent) getConfigHash() string {
-	info, err := os.Stat(a.configPath)
+	data, err := os.ReadFile(a.configPath)
 	if err != nil {
 		return ""
 	}
-	return strconv.FormatInt(info.ModTime().Unix(), 10)
+	return configHash(data)
 }
 
-// reloadConfig 重新下载并应用配置
-func (a *Agent) reloadConfig() {
-	if a.stopping.Load() {
-		return
-	}
-
-	if err := a.downloadConfig(); err != nil {
-		log.Printf("Failed to download config: %v", err)
-		return
-	}
-
-	// 优先 SIGHUP 热重载 (不中断连接)
-	if a.gostCmd != nil && a.gostCmd.Process != nil {
-		log.Println("Config downloaded, sending SIGHUP to GOST for hot reload...")
-		if err := a.gostCmd.Process.Signal(syscall.SIGHUP); err == nil {
-			log.Println("GOST config reloaded (hot reload)")
-			return
-		}
-		log.Println("SIGHUP failed, falling back to restart...")
-	}
-
-	if a.stopping.Load() {
-		return
-	}
-
-	// 回退: 重启 GOST
-	a.stopGost()
-	time.Sleep(time.Second)
-	if err := a.startGost(); err != nil {
-		log.Printf("Failed to restart GOST: %v", err)
-	} else {
-		log.Println("GOST restarted successfully")
-	}
+// configHash 计算配置内容的 SHA-256
+func configHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum)
 }
 
 // GostStats GOST 统计数据
@@ -441,9 +535,7 @@ func (a *Agent) fetchObserverStats() (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	if a.gostUser != "" {
-		req.SetBasicAuth(a.gostUser, a.gostPass)
-	}
+	a.setGostAuth(req)
 
 	resp, err := a.client.Do(req)
 	if err != nil {
@@ -583,9 +675,7 @@ func (a *Agent) fetchGostServices() ([]map[string]interface{}, error) {
 		return nil, err
 	}
 
-	if a.gostUser != "" {
-		req.SetBasicAuth(a.gostUser, a.gostPass)
-	}
+	a.setGostAuth(req)
 
 	resp, err := a.client.Do(req)
 	if err != nil {
@@ -680,6 +770,171 @@ func (a *Agent) getServiceStats() map[string]map[string]int64 {
 	return result
 }
 
+// ==================== 控制通道 ====================
+
+const (
+	streamReadWait   = 90 * time.Second
+	streamWriteWait  = 10 * time.Second
+	streamRetryMin   = 5 * time.Second
+	streamRetryMax   = 2 * time.Minute
+	streamStableTime = time.Minute // 连接保持超过该时间视为稳定，重置重连退避
+)
+
+// streamMessage 控制通道消息
+type streamMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// streamURL 控制通道地址 (http -> ws, https -> wss)
+func (a *Agent) streamURL() string {
+	u := strings.TrimRight(a.panelURL, "/")
+	if strings.HasPrefix(u, "https://") {
+		u = "wss://" + strings.TrimPrefix(u, "https://")
+	} else {
+		u = "ws://" + strings.TrimPrefix(u, "http://")
+	}
+	return u + "/agent/stream"
+}
+
+// streamLoop 保持控制通道连接，断开后按指数退避重连
+func (a *Agent) streamLoop() {
+	retry := streamRetryMin
+	for !a.stopping.Load() {
+		started := time.Now()
+		if err := a.runStream(); err != nil && !a.stopping.Load() {
+			log.Printf("Control stream disconnected: %v", err)
+		}
+		if a.stopping.Load() {
+			return
+		}
+		if time.Since(started) > streamStableTime {
+			retry = streamRetryMin
+		}
+		time.Sleep(retry)
+		retry *= 2
+		if retry > streamRetryMax {
+			retry = streamRetryMax
+		}
+	}
+}
+
+// runStream 建立控制通道并处理面板推送的指令，连接断开时返回
+func (a *Agent) runStream() error {
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+a.token)
+
+	conn, resp, err := dialer.Dial(a.streamURL(), header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("dial failed: status %d", resp.StatusCode)
+		}
+		return err
+	}
+
+	a.streamMu.Lock()
+	a.stream = conn
+	a.streamMu.Unlock()
+	defer a.closeStream()
+	log.Println("Control stream connected")
+
+	conn.SetReadDeadline(time.Now().Add(streamReadWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(streamReadWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(streamWriteWait))
+	})
+
+	// 连接建立后立即上报一次，面板据此比对配置
+	a.triggerReport()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(streamReadWait))
+
+		var msg streamMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		a.handleStreamMessage(msg)
+	}
+}
+
+// handleStreamMessage 处理面板推送的指令
+func (a *Agent) handleStreamMessage(msg streamMessage) {
+	switch msg.Type {
+	case "reload_config":
+		log.Println("Config change pushed by panel, reloading...")
+		go a.reloadConfig()
+	case "update":
+		if !a.autoUpdate {
+			log.Println("Update pushed by panel, but auto update is disabled")
+			return
+		}
+		log.Println("Update command pushed by panel, updating...")
+		go a.performUpdate()
+	case "uninstall":
+		log.Println("Received uninstall command from panel, uninstalling...")
+		go a.uninstall()
+	case "command":
+		var cmd remoteCommand
+		if err := json.Unmarshal(msg.Data, &cmd); err != nil {
+			log.Printf("Invalid command message: %v", err)
+			return
+		}
+		go a.handleCommand(cmd)
+	default:
+		log.Printf("Unknown stream message: %s", msg.Type)
+	}
+}
+
+// streamConnected 控制通道是否在线
+func (a *Agent) streamConnected() bool {
+	a.streamMu.Lock()
+	defer a.streamMu.Unlock()
+	return a.stream != nil
+}
+
+// sendStream 通过控制通道发送消息
+func (a *Agent) sendStream(msgType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	message, err := json.Marshal(streamMessage{Type: msgType, Data: payload})
+	if err != nil {
+		return err
+	}
+
+	a.streamMu.Lock()
+	defer a.streamMu.Unlock()
+	if a.stream == nil {
+		return fmt.Errorf("stream not connected")
+	}
+	a.stream.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	if err := a.stream.WriteMessage(websocket.TextMessage, message); err != nil {
+		a.stream.Close()
+		a.stream = nil
+		return err
+	}
+	return nil
+}
+
+func (a *Agent) closeStream() {
+	a.streamMu.Lock()
+	defer a.streamMu.Unlock()
+	if a.stream != nil {
+		a.stream.Close()
+		a.stream = nil
+	}
+}
+
 // ==================== 自动更新相关 ====================
 
 // UpdateInfo represents update check response
@@ -842,6 +1097,31 @@ func (a *Agent) restartSelf() error {
 	return nil
 }
 
+// ==================== 工具函数 ====================
+
+// truncateUTF8 截断为不超过 max 字节，不拆分多字节字符 (面板使用 PostgreSQL 时拒绝非法 UTF-8)
+func truncateUTF8(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
+
+// tailUTF8 保留末尾不超过 max 字节，不拆分多字节字符
+func tailUTF8(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	start := len(value) - max
+	for start < len(value) && !utf8.RuneStart(value[start]) {
+		start++
+	}
+	return value[start:]
+}
+
 func main() {
 	flag.Parse()
 
@@ -859,9 +1139,10 @@ func main() {
 		fmt.Println("  -config      GOST config path (default: /etc/gost/gost.yml)")
 		fmt.Println("  -gost        GOST binary path (auto-detect if empty)")
 		fmt.Println("  -gost-api    GOST API address (default: http://127.0.0.1:18080)")
-		fmt.Println("  -gost-user   GOST API username (optional)")
-		fmt.Println("  -gost-pass   GOST API password (optional)")
+		fmt.Println("  -gost-user   GOST API username (default: read from config)")
+		fmt.Println("  -gost-pass   GOST API password (default: read from config)")
 		fmt.Println("  -auto-update Enable auto update (default: true)")
+		fmt.Println("  -config-dry-run Dry-run new configs on scratch ports before applying (default: true)")
 		fmt.Println("  -version     Show version")
 		os.Exit(1)
 	}
@@ -876,6 +1157,7 @@ func main() {
 	log.Printf("Using GOST: %s", resolvedGostPath)
 
 	agent := NewAgent(*panelURL, *token, *configPath, resolvedGostPath, *gostAPI, *gostUser, *gostPass, *autoUpdate)
+	agent.dryRun = *dryRun
 	if err := agent.Run(); err != nil {
 		log.Fatalf("Agent error: %v", err)
 	}
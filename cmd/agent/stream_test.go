@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakePanel 模拟面板的控制通道与 HTTP 心跳接口
+type fakePanel struct {
+	mu          sync.Mutex
+	streamStats int
+	heartbeats  []map[string]interface{}
+	refuse      bool // 拒绝新的控制通道连接
+	conn        *websocket.Conn
+}
+
+func (p *fakePanel) counts() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.streamStats, len(p.heartbeats)
+}
+
+// disconnect 断开控制通道并拒绝重连
+func (p *fakePanel) disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refuse = true
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+func newFakePanel(t *testing.T) (*fakePanel, *httptest.Server) {
+	t.Helper()
+	p := &fakePanel{}
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/agent/stream", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		refuse := p.refuse
+		p.mu.Unlock()
+		if refuse || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.conn = conn
+		p.mu.Unlock()
+		for {
+			var msg streamMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "stats" {
+				p.mu.Lock()
+				p.streamStats++
+				p.mu.Unlock()
+			}
+		}
+	})
+	mux.HandleFunc("/agent/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&data)
+		p.mu.Lock()
+		p.heartbeats = append(p.heartbeats, data)
+		p.mu.Unlock()
+		w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return p, srv
+}
+
+func TestStreamURL(t *testing.T) {
+	cases := map[string]string{
+		"http://panel.example.com:8080": "ws://panel.example.com:8080/agent/stream",
+		"https://panel.example.com/":    "wss://panel.example.com/agent/stream",
+		"http://10.0.0.1:8080/":         "ws://10.0.0.1:8080/agent/stream",
+	}
+	for panel, want := range cases {
+		a := &Agent{panelURL: panel}
+		if got := a.streamURL(); got != want {
+			t.Errorf("streamURL(%q) = %q, want %q", panel, got, want)
+		}
+	}
+}
+
+func TestHeartbeatFallsBackWhenStreamDisconnects(t *testing.T) {
+	panel, srv := newFakePanel(t)
+	a, _ := newTestAgent(t)
+	a.panelURL = srv.URL
+	go a.heartbeatLoop()
+	go a.streamLoop()
+	t.Cleanup(func() {
+		a.stopping.Store(true)
+		a.closeStream()
+		a.triggerReport()
+	})
+
+	// 连接建立后立即通过控制通道上报，不发送 HTTP 心跳
+	waitFor(t, 5*time.Second, "stats over the control stream", func() bool {
+		stats, _ := panel.counts()
+		return stats == 1
+	})
+	a.triggerReport()
+	waitFor(t, 5*time.Second, "second stream report", func() bool {
+		stats, _ := panel.counts()
+		return stats == 2
+	})
+	if _, heartbeats := panel.counts(); heartbeats != 0 {
+		t.Fatalf("sent %d HTTP heartbeats while the stream was connected", heartbeats)
+	}
+
+	// 控制通道断开后回退 HTTP 心跳
+	panel.disconnect()
+	waitFor(t, 5*time.Second, "stream disconnect", func() bool { return !a.streamConnected() })
+	a.triggerReport()
+	waitFor(t, 5*time.Second, "HTTP heartbeat", func() bool {
+		_, heartbeats := panel.counts()
+		return heartbeats == 1
+	})
+
+	panel.mu.Lock()
+	hb := panel.heartbeats[0]
+	panel.mu.Unlock()
+	if hb["token"] != "token" {
+		t.Errorf("heartbeat token = %v, want %q", hb["token"], "token")
+	}
+	if _, ok := hb["gost"]; !ok {
+		t.Error("heartbeat missing gost status")
+	}
+
+	// HTTP 心跳按心跳间隔发送，触发上报不会立即重复发送
+	a.triggerReport()
+	time.Sleep(300 * time.Millisecond)
+	if _, heartbeats := panel.counts(); heartbeats != 1 {
+		t.Errorf("heartbeats = %d, want 1 within the heartbeat interval", heartbeats)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== GOST 进程守护 ====================
+
+const (
+	gostBackoffMin    = time.Second // 首次崩溃后的重启等待
+	gostBackoffMax    = time.Minute // 重启等待上限
+	gostStableUptime  = time.Minute // 运行超过此时间后退出不计入连续崩溃
+	gostStderrLines   = 20          // 崩溃时保留的 stderr 行数
+	gostLastErrorSize = 2000        // 上报的 stderr 最大长度
+	gostLockRetry     = 500 * time.Millisecond
+)
+
+// gostExit 最近一次意外退出
+type gostExit struct {
+	Code  int
+	At    time.Time
+	Error string
+}
+
+// gostSupervisor 崩溃统计
+type gostSupervisor struct {
+	mu       sync.Mutex
+	crashes  int // Agent 启动以来的意外退出次数
+	streak   int // 连续崩溃次数，用于计算退避时间
+	restarts int // 守护进程自动重启次数
+	lastExit *gostExit
+}
+
+// gostStatus 心跳中上报的 GOST 进程状态
+type gostStatus struct {
+	Running      bool       `json:"running"`
+	PID          int        `json:"pid,omitempty"`
+	Crashes      int        `json:"crashes"`
+	Restarts     int        `json:"restarts"`
+	LastExitCode *int       `json:"last_exit_code,omitempty"`
+	LastExitAt   *time.Time `json:"last_exit_at,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// watchGost 等待 GOST 退出，非主动停止时记录崩溃并按退避时间重启
+func (a *Agent) watchGost(cmd *exec.Cmd, startedAt time.Time, stderr *logBuffer) {
+	err := cmd.Wait()
+	if a.gostCmd.Load() == cmd {
+		a.gostRunning.Store(false)
+	}
+	if a.stoppedCmd.Load() == cmd || a.stopping.Load() || a.removing.Load() {
+		return
+	}
+	if err == nil {
+		err = errors.New("exited")
+	}
+	log.Printf("GOST exited unexpectedly: %v", err)
+
+	delay := a.recordGostCrash(cmd, err, time.Since(startedAt), stderr)
+	a.triggerReport()
+	a.restartCrashedGost(cmd, delay)
+}
+
+// recordGostCrash 记录崩溃信息，返回下次重启前的等待时间
+func (a *Agent) recordGostCrash(cmd *exec.Cmd, err error, uptime time.Duration, stderr *logBuffer) time.Duration {
+	lines := stderr.Tail(gostStderrLines)
+	if len(lines) == 0 {
+		lines = a.gostLogs.Tail(gostStderrLines)
+	}
+	msg := strings.TrimSpace(strings.Join(lines, "\n"))
+	if msg == "" {
+		msg = err.Error()
+	}
+	msg = tailUTF8(msg, gostLastErrorSize)
+
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+
+	s := &a.supervisor
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.crashes++
+	if uptime >= gostStableUptime {
+		s.streak = 0
+	}
+	s.streak++
+	s.lastExit = &gostExit{Code: code, At: time.Now(), Error: msg}
+	return gostBackoff(s.streak)
+}
+
+// gostBackoff 指数退避: 1s, 2s, 4s ... 最长 1 分钟
+func gostBackoff(streak int) time.Duration {
+	delay := gostBackoffMin
+	for i := 1; i < streak && delay < gostBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > gostBackoffMax {
+		delay = gostBackoffMax
+	}
+	return delay
+}
+
+// lockGost 获取 GOST 进程生命周期锁 (复用 reloading)，配置重载、守护进程重启与远程命令互斥
+// 锁被占用时等待，ctx 结束或 Agent 退出时放弃
+func (a *Agent) lockGost(ctx context.Context) error {
+	for !a.reloading.CompareAndSwap(false, true) {
+		if a.stopping.Load() || a.removing.Load() {
+			return errors.New("agent is shutting down")
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for GOST reload/restart: %w", ctx.Err())
+		case <-time.After(gostLockRetry):
+		}
+	}
+	return nil
+}
+
+// unlockGost 释放 GOST 进程生命周期锁
+func (a *Agent) unlockGost() {
+	a.reloading.Store(false)
+}
+
+// restartCrashedGost 等待退避时间后重启 GOST，启动失败时继续退避重试
+// 配置重载期间由重载流程负责恢复进程，重载结束后仍未运行才由守护进程重启
+func (a *Agent) restartCrashedGost(cmd *exec.Cmd, delay time.Duration) {
+	for {
+		log.Printf("Restarting GOST in %s", delay)
+		time.Sleep(delay)
+
+		if a.lockGost(context.Background()) != nil {
+			return
+		}
+		// 已被重载、远程命令重启或 Agent 正在退出
+		if a.stopping.Load() || a.removing.Load() || a.gostCmd.Load() != cmd || a.gostRunning.Load() {
+			a.unlockGost()
+			return
+		}
+
+		err := a.startGost()
+		if err == nil {
+			pid := a.gostCmd.Load().Process.Pid
+			a.unlockGost()
+			a.supervisor.mu.Lock()
+			a.supervisor.restarts++
+			a.supervisor.mu.Unlock()
+			log.Printf("GOST restarted by supervisor (pid %d)", pid)
+			a.triggerReport()
+			return
+		}
+		a.unlockGost()
+
+		log.Printf("Failed to restart GOST: %v", err)
+		a.supervisor.mu.Lock()
+		a.supervisor.streak++
+		a.supervisor.lastExit = &gostExit{Code: -1, At: time.Now(), Error: fmt.Sprintf("restart failed: %v", err)}
+		delay = gostBackoff(a.supervisor.streak)
+		a.supervisor.mu.Unlock()
+	}
+}
+
+// gostStatus 当前 GOST 进程状态
+func (a *Agent) gostStatus() gostStatus {
+	st := gostStatus{Running: a.gostRunning.Load()}
+	if cmd := a.gostCmd.Load(); st.Running && cmd != nil && cmd.Process != nil {
+		st.PID = cmd.Process.Pid
+	}
+
+	s := &a.supervisor
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Crashes = s.crashes
+	st.Restarts = s.restarts
+	if s.lastExit != nil {
+		code, at := s.lastExit.Code, s.lastExit.At
+		st.LastExitCode = &code
+		st.LastExitAt = &at
+		st.LastError = s.lastExit.Error
+	}
+	return st
+}
+
+// triggerReport 立即上报一次状态 (不阻塞)
+func (a *Agent) triggerReport() {
+	select {
+	case a.reportNow <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// newTestAgent 使用假 GOST (记录 PID 后休眠) 创建 Agent，返回 PID 记录文件
+func newTestAgent(t *testing.T) (*Agent, string) {
+	t.Helper()
+	dir := t.TempDir()
+	pids := filepath.Join(dir, "pids")
+	gost := filepath.Join(dir, "gost")
+	script := "#!/bin/sh\necho $$ >> " + pids + "\nexec sleep 300\n"
+	if err := os.WriteFile(gost, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAgent("http://127.0.0.1:0", "token", filepath.Join(dir, "gost.yml"), gost, "http://127.0.0.1:0", "", "", false)
+	t.Cleanup(func() {
+		a.stopping.Store(true)
+		for _, pid := range alivePids(t, pids) {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	})
+	return a, pids
+}
+
+// alivePids 返回仍在运行的假 GOST 进程
+func alivePids(t *testing.T, path string) []int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var alive []int
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			continue
+		}
+		if syscall.Kill(pid, 0) == nil {
+			alive = append(alive, pid)
+		}
+	}
+	return alive
+}
+
+// waitFor 轮询直到条件成立
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestGostBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		6:  32 * time.Second,
+		7:  time.Minute,
+		50: time.Minute,
+	}
+	for streak, want := range cases {
+		if got := gostBackoff(streak); got != want {
+			t.Errorf("gostBackoff(%d) = %s, want %s", streak, got, want)
+		}
+	}
+}
+
+func TestTailUTF8(t *testing.T) {
+	if got := tailUTF8("abc", 10); got != "abc" {
+		t.Errorf("short string changed: %q", got)
+	}
+	// "错误" 每个字符 3 字节，截断不能落在字符中间
+	got := tailUTF8("x错误", 4)
+	if !utf8.ValidString(got) {
+		t.Fatalf("tailUTF8 returned invalid UTF-8: %q", got)
+	}
+	if got != "误" {
+		t.Errorf("tailUTF8 = %q, want %q", got, "误")
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	if got := truncateUTF8("abc", 10); got != "abc" {
+		t.Errorf("short string changed: %q", got)
+	}
+	got := truncateUTF8("错误x", 4)
+	if !utf8.ValidString(got) {
+		t.Fatalf("truncateUTF8 returned invalid UTF-8: %q", got)
+	}
+	if got != "错" {
+		t.Errorf("truncateUTF8 = %q, want %q", got, "错")
+	}
+}
+
+func TestSupervisorRestartsCrashedGost(t *testing.T) {
+	a, pids := newTestAgent(t)
+	if err := a.startGost(); err != nil {
+		t.Fatal(err)
+	}
+	first := a.gostCmd.Load().Process.Pid
+
+	syscall.Kill(first, syscall.SIGKILL)
+	waitFor(t, 5*time.Second, "supervisor restart", func() bool {
+		st := a.gostStatus()
+		return st.Running && st.Restarts == 1
+	})
+
+	st := a.gostStatus()
+	if st.Crashes != 1 {
+		t.Errorf("crashes = %d, want 1", st.Crashes)
+	}
+	if st.PID == first || st.PID == 0 {
+		t.Errorf("pid after restart = %d (first %d)", st.PID, first)
+	}
+	if st.LastExitCode == nil || *st.LastExitCode != -1 {
+		t.Errorf("last exit code = %v, want -1 (killed by signal)", st.LastExitCode)
+	}
+	if alive := alivePids(t, pids); len(alive) != 1 || alive[0] != st.PID {
+		t.Errorf("alive gost processes = %v, want [%d]", alive, st.PID)
+	}
+}
+
+func TestStopGostIsNotCrash(t *testing.T) {
+	a, pids := newTestAgent(t)
+	if err := a.startGost(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.lockGost(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a.stopGost()
+	a.unlockGost()
+
+	waitFor(t, 2*time.Second, "gost exit", func() bool { return !a.gostRunning.Load() })
+	time.Sleep(1500 * time.Millisecond) // 超过首次退避时间
+	if st := a.gostStatus(); st.Crashes != 0 || st.Restarts != 0 {
+		t.Errorf("intentional stop counted as crash: %+v", st)
+	}
+	if alive := alivePids(t, pids); len(alive) != 0 {
+		t.Errorf("gost still running after stop: %v", alive)
+	}
+}
+
+func TestRestartCommandWaitsForLifecycleLock(t *testing.T) {
+	a, _ := newTestAgent(t)
+	if err := a.startGost(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置重载或守护进程持有锁时，远程命令等待直到超时
+	if err := a.lockGost(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := a.cmdRestartGost(ctx, nil); err == nil {
+		t.Fatal("restart succeeded while the lifecycle lock was held")
+	}
+	a.unlockGost()
+
+	if _, err := a.cmdRestartGost(context.Background(), nil); err != nil {
+		t.Fatalf("restart after unlock: %v", err)
+	}
+	if a.reloading.Load() {
+		t.Error("lifecycle lock not released after restart")
+	}
+}
+
+func TestConcurrentRestartAndCrashKeepSingleGost(t *testing.T) {
+	a, pids := newTestAgent(t)
+	if err := a.startGost(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 崩溃后的守护重启与两个远程重启命令并发
+	syscall.Kill(a.gostCmd.Load().Process.Pid, syscall.SIGKILL)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			if _, err := a.cmdRestartGost(ctx, nil); err != nil {
+				t.Errorf("restart command: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	// 等待守护进程完成 (或放弃) 重启
+	time.Sleep(2 * time.Second)
+	waitFor(t, 5*time.Second, "lifecycle lock release", func() bool { return !a.reloading.Load() })
+
+	current := a.gostCmd.Load().Process.Pid
+	if alive := alivePids(t, pids); len(alive) != 1 || alive[0] != current {
+		t.Errorf("alive gost processes = %v, want only current %d", alive, current)
+	}
+	if !a.gostRunning.Load() {
+		t.Error("gost not running")
+	}
+}
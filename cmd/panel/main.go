@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"time"
 
 	"github.com/AliceNetworks/gost-panel/internal/api"
 	"github.com/AliceNetworks/gost-panel/internal/config"
@@ -50,7 +49,7 @@ func main() {
 	}
 
 	// 初始化数据库
-	db, err := model.InitDB(cfg.DBPath)
+	db, err := model.InitDB(cfg.DBDriver, cfg.DatabaseDSN())
 	if err != nil {
 		log.Fatalf("Failed to init database: %v", err)
 	}
@@ -58,11 +57,8 @@ func main() {
 	// 初始化服务
 	svc := service.NewService(db, cfg)
 
-	// 启动流量历史记录定时任务
-	go startTrafficRecorder(svc)
-
-	// 启动会话清理定时任务
-	go startSessionCleaner(svc)
+	// 启动定时任务 (流量记录、会话清理、配额重置、套餐过期等)
+	svc.StartScheduler()
 
 	// 启动 API 服务
 	server := api.NewServer(svc, cfg)
@@ -90,7 +86,9 @@ func printUsage() {
 	fmt.Println()
 	fmt.Println("Environment Variables:")
 	fmt.Println("  LISTEN_ADDR       Listen address (same as -listen)")
-	fmt.Println("  DB_PATH           Database path (same as -db)")
+	fmt.Println("  DB_DRIVER         Database driver: sqlite, postgres, mysql (default \"sqlite\")")
+	fmt.Println("  DB_DSN            Database DSN (required for postgres/mysql)")
+	fmt.Println("  DB_PATH           SQLite database path (same as -db)")
 	fmt.Println("  JWT_SECRET        JWT secret key (required for production)")
 	fmt.Println("  DEBUG             Enable debug mode (true/false)")
 	fmt.Println("  ALLOWED_ORIGINS   Comma-separated list of allowed CORS origins")
@@ -99,33 +97,5 @@ func printUsage() {
 	fmt.Println("  gost-panel -listen :9000")
 	fmt.Println("  gost-panel -listen 0.0.0.0:8080 -db /var/lib/gost-panel/panel.db")
 	fmt.Println("  LISTEN_ADDR=:9000 JWT_SECRET=mysecret gost-panel")
-}
-
-// startTrafficRecorder 启动流量记录定时任务
-func startTrafficRecorder(svc *service.Service) {
-	// 每分钟记录一次流量数据
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	// 立即记录一次
-	svc.RecordTrafficHistory()
-
-	for range ticker.C {
-		if err := svc.RecordTrafficHistory(); err != nil {
-			log.Printf("Failed to record traffic history: %v", err)
-		}
-	}
-}
-
-// startSessionCleaner 启动会话清理定时任务
-func startSessionCleaner(svc *service.Service) {
-	// 每小时清理一次过期会话
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		if err := svc.CleanupExpiredSessions(); err != nil {
-			log.Printf("Failed to cleanup expired sessions: %v", err)
-		}
-	}
+	fmt.Println("  DB_DRIVER=postgres DB_DSN=\"host=localhost user=gost password=secret dbname=gost_panel\" gost-panel")
 }
@@ -6,9 +6,9 @@ import (
 	"time"
 
 	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 )
 
 // Login2FARequest 2FA 登录请求
@@ -17,15 +17,20 @@ type Login2FARequest struct {
 	Code      string `json:"code" binding:"required"`
 }
 
-func (s *Server) login2FA(c *gin.Context) {
-	var req Login2FARequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+// signTemp2FAToken 密码验证通过后签发第二因素临时令牌（5分钟有效）
+func (s *Server) signTemp2FAToken(user *model.User) (string, error) {
+	tempToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"temp_2fa": true,
+		"exp":      time.Now().Add(5 * time.Minute).Unix(),
+	})
+	return tempToken.SignedString([]byte(s.cfg.JWTSecret))
+}
 
-	// 验证临时令牌
-	token, err := jwt.Parse(req.TempToken, func(token *jwt.Token) (interface{}, error) {
+// parseTemp2FAToken 校验第二因素临时令牌并返回对应用户
+func (s *Server) parseTemp2FAToken(c *gin.Context, raw string) (*model.User, bool) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
@@ -34,7 +39,7 @@ func (s *Server) login2FA(c *gin.Context) {
 
 	if err != nil || !token.Valid {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired temp token"})
-		return
+		return nil, false
 	}
 
 	claims := token.Claims.(jwt.MapClaims)
@@ -43,16 +48,46 @@ func (s *Server) login2FA(c *gin.Context) {
 	temp2FA, ok := claims["temp_2fa"].(bool)
 	if !ok || !temp2FA {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid temp token"})
-		return
+		return nil, false
 	}
 
 	userIDFloat, _ := claims["user_id"].(float64)
-	userID := uint(userIDFloat)
-
-	// 获取用户信息
-	var user model.User
-	if err := s.svc.DB().First(&user, userID).Error; err != nil {
+	user, err := s.svc.GetUser(uint(userIDFloat))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, false
+	}
+	if !user.Enabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is disabled"})
+		return nil, false
+	}
+	if service.AccountLocked(user) {
+		respondAccountLocked(c, user.LockedUntil)
+		return nil, false
+	}
+	return user, true
+}
+
+// requireSecondFactor 检查用户当前是否允许使用该第二因素
+func (s *Server) requireSecondFactor(c *gin.Context, user *model.User, factor string) bool {
+	for _, f := range s.svc.SecondFactors(user) {
+		if f == factor {
+			return true
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "this verification method is not allowed for your account"})
+	return false
+}
+
+func (s *Server) login2FA(c *gin.Context) {
+	var req Login2FARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := s.parseTemp2FAToken(c, req.TempToken)
+	if !ok || !s.requireSecondFactor(c, user, service.SecondFactorTOTP) {
 		return
 	}
 
@@ -68,7 +103,8 @@ func (s *Server) login2FA(c *gin.Context) {
 
 	if !validTOTP && !validBackup {
 		// 记录失败尝试
-		s.svc.LogOperation(userID, user.Username, "login", "2fa", userID, "2FA verification failed", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		s.svc.LogOperation(user.ID, user.Username, "login", "2fa", user.ID, "2FA verification failed", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		s.svc.RecordLoginFailure(user.Username, c.ClientIP())
 		RecordLoginAttempt(false)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid 2FA code"})
 		return
@@ -76,52 +112,53 @@ func (s *Server) login2FA(c *gin.Context) {
 
 	// 如果使用了备份码，更新数据库
 	if validBackup {
-		s.svc.DB().Model(&user).Update("backup_codes", newBackupCodes)
+		s.svc.DB().Model(user).Update("backup_codes", newBackupCodes)
 	}
 
-	// 登录成功，重置限流计数
-	s.loginLimiter.Reset(c.ClientIP())
-	RecordLoginAttempt(true)
+	// 生成正式 JWT（带会话管理）
+	s.completeLogin(c, user, "2fa", "2FA login success")
+}
 
-	// 更新登录信息
-	s.svc.UpdateUserLoginInfo(user.ID, c.ClientIP())
+// ==================== 账户锁定 / 2FA 管理 ====================
 
-	// 记录登录成功
-	s.svc.LogOperation(user.ID, user.Username, "login", "2fa", user.ID, "2FA login success", c.ClientIP(), c.GetHeader("User-Agent"), "success")
+// respondAccountLocked 账户锁定响应 (423)
+func respondAccountLocked(c *gin.Context, lockedUntil *time.Time) {
+	c.JSON(http.StatusLocked, gin.H{
+		"error":        service.ErrAccountLocked.Error(),
+		"code":         "ACCOUNT_LOCKED",
+		"locked_until": lockedUntil,
+	})
+}
 
-	// 生成正式 JWT（带会话管理）
-	jti := uuid.NewString()
-	expiresAt := time.Now().Add(24 * time.Hour)
+// unlockUser 管理员解除账户锁定
+func (s *Server) unlockUser(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
 
-	finalToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"role":     user.Role,
-		"jti":      jti,
-		"exp":      expiresAt.Unix(),
-	})
+	if err := s.svc.UnlockUser(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 
-	tokenString, err := finalToken.SignedString([]byte(s.cfg.JWTSecret))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+	s.audit.LogSuccess(c, "unlock", "user", id, "")
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// resetUserTwoFactor 管理员重置用户的 2FA (TOTP 与安全密钥)
+func (s *Server) resetUserTwoFactor(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
 		return
 	}
 
-	// 创建会话记录
-	if err := s.svc.CreateUserSession(user.ID, jti, c.ClientIP(), c.GetHeader("User-Agent"), expiresAt); err != nil {
-		// 会话创建失败不影响登录，只记录错误
-		s.svc.LogOperation(user.ID, user.Username, "session_create", "user_session", 0, fmt.Sprintf("failed to create session: %v", err), c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+	if err := s.svc.ResetUserTwoFactor(id); err != nil {
+		s.audit.LogFailed(c, "reset_2fa", "user", id, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
-		"user": gin.H{
-			"id":               user.ID,
-			"username":         user.Username,
-			"email":            user.Email,
-			"role":             user.Role,
-			"email_verified":   user.EmailVerified,
-			"password_changed": user.PasswordChanged,
-		},
-	})
+	s.audit.LogSuccess(c, "reset_2fa", "user", id, "")
+	c.JSON(http.StatusOK, gin.H{"success": true})
 }
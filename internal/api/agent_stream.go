@@ -0,0 +1,390 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ==================== Agent 控制通道 ====================
+//
+// Agent 通过 GET /agent/stream (WebSocket，Authorization: Bearer <AgentToken>) 建立长连接:
+//   - 面板 -> Agent: reload_config / update / uninstall 指令，配置变更后立即推送；command 远程命令
+//   - Agent -> 面板: stats 统计上报 (字段与 HTTP 心跳一致)；command_result 命令执行结果；logs GOST 日志；
+//     config_status 配置校验/重载结果
+// 控制通道断开时 Agent 回退到 HTTP 心跳轮询
+
+const (
+	agentStreamPingPeriod = 30 * time.Second
+	agentStreamReadWait   = 90 * time.Second
+	agentStreamWriteWait  = 10 * time.Second
+
+	// agentSweepDelay 写操作后合并触发配置检查的等待时间
+	agentSweepDelay = 500 * time.Millisecond
+	// agentSweepInterval 定时全量检查 (覆盖调度任务等非 API 触发的配置变化)
+	agentSweepInterval = time.Minute
+)
+
+// Agent 指令类型
+const (
+	agentCmdReloadConfig = "reload_config"
+	agentCmdUpdate       = "update"
+	agentCmdUninstall    = "uninstall"
+	agentCmdCommand      = "command"
+)
+
+// agentUpgrader Agent 不是浏览器客户端，握手时不携带 Origin
+var agentUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 1024,
+}
+
+// agentStreamMessage Agent 上报的消息
+type agentStreamMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// agentConn 单个 Agent 的控制通道连接
+type agentConn struct {
+	kind  string // node / client
+	id    uint
+	token string
+	conn  *websocket.Conn
+	send  chan []byte
+
+	mu           sync.Mutex
+	closed       bool
+	version      string // Agent 版本
+	reportedHash string // Agent 最近上报的配置哈希
+	pushedHash   string // 最近一次通知重载时面板的配置哈希
+	updatePushed bool
+}
+
+func agentConnKey(kind string, id uint) string {
+	return fmt.Sprintf("%s:%d", kind, id)
+}
+
+// push 向 Agent 发送指令 (发送队列已满时丢弃，由下次检查或心跳补偿)
+func (a *agentConn) push(msgType string, data interface{}) {
+	message, err := json.Marshal(WSMessage{Type: msgType, Data: data})
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.send <- message:
+	default:
+	}
+}
+
+func (a *agentConn) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.closed {
+		a.closed = true
+		close(a.send)
+	}
+}
+
+// AgentHub 管理在线 Agent 的控制通道
+type AgentHub struct {
+	mu      sync.RWMutex
+	conns   map[string]*agentConn
+	changed chan struct{}
+}
+
+// NewAgentHub 创建 Agent 控制通道管理器
+func NewAgentHub() *AgentHub {
+	return &AgentHub{
+		conns:   make(map[string]*agentConn),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// add 登记连接，同一 Agent 重复连接时关闭旧连接
+func (h *AgentHub) add(a *agentConn) {
+	key := agentConnKey(a.kind, a.id)
+	h.mu.Lock()
+	old := h.conns[key]
+	h.conns[key] = a
+	h.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+func (h *AgentHub) remove(a *agentConn) {
+	key := agentConnKey(a.kind, a.id)
+	h.mu.Lock()
+	if h.conns[key] == a {
+		delete(h.conns, key)
+	}
+	h.mu.Unlock()
+	a.close()
+}
+
+func (h *AgentHub) snapshot() []*agentConn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	conns := make([]*agentConn, 0, len(h.conns))
+	for _, a := range h.conns {
+		conns = append(conns, a)
+	}
+	return conns
+}
+
+// Connected 节点或客户端的 Agent 是否已建立控制通道
+func (h *AgentHub) Connected(kind string, id uint) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.conns[agentConnKey(kind, id)]
+	return ok
+}
+
+func (h *AgentHub) get(kind string, id uint) *agentConn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.conns[agentConnKey(kind, id)]
+}
+
+// Count 在线控制通道数量
+func (h *AgentHub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// NotifyChanged 通知配置可能已变化 (多次调用合并为一次检查)
+func (h *AgentHub) NotifyChanged() {
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
+}
+
+// runAgentSweeper 配置变化或定时触发时检查所有在线 Agent
+func (s *Server) runAgentSweeper() {
+	ticker := time.NewTicker(agentSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.agentHub.changed:
+			time.Sleep(agentSweepDelay)
+		case <-ticker.C:
+		}
+		for _, a := range s.agentHub.snapshot() {
+			s.syncAgent(a, false)
+		}
+	}
+}
+
+// agentSyncMiddleware 写操作成功后清除配置哈希缓存并触发配置检查，通过控制通道立即通知在线 Agent
+func (s *Server) agentSyncMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Request.Method != http.MethodGet && c.Writer.Status() < http.StatusBadRequest {
+			s.svc.InvalidateNodeConfigHashes()
+			s.agentHub.NotifyChanged()
+		}
+	}
+}
+
+// syncAgent 比对 Agent 配置与版本，按需推送指令
+// reported 为 true 表示刚收到 Agent 上报，配置不一致时总是重新通知 (与 HTTP 心跳行为一致)
+func (s *Server) syncAgent(a *agentConn, reported bool) {
+	var currentHash string
+	var rejected bool // Agent 已拒绝当前配置
+	switch a.kind {
+	case "node":
+		node, err := s.svc.GetNodeByToken(a.token)
+		if err != nil || node.ID != a.id {
+			a.push(agentCmdUninstall, nil)
+			return
+		}
+		currentHash = s.svc.GetNodeConfigHash(node.ID)
+		rejected = service.NodeConfigRejected(node, currentHash)
+		a.mu.Lock()
+		reportedHash := a.reportedHash
+		a.mu.Unlock()
+		s.svc.ResolveNodeConfigFailure(node, currentHash, reportedHash)
+		s.pushNodeCommands(a)
+	case "client":
+		client, err := s.svc.GetClientByToken(a.token)
+		if err != nil || client.ID != a.id {
+			a.push(agentCmdUninstall, nil)
+			return
+		}
+		currentHash = s.svc.GetClientConfigHash(client.ID)
+	default:
+		return
+	}
+
+	a.mu.Lock()
+	reload := !rejected && a.reportedHash != "" && currentHash != a.reportedHash && (reported || currentHash != a.pushedHash)
+	if reload {
+		a.pushedHash = currentHash
+	}
+	version := a.version
+	pushUpdate := false
+	if !a.updatePushed {
+		if _, force := s.checkAgentNeedsUpdate(version); force {
+			a.updatePushed = true
+			pushUpdate = true
+		}
+	}
+	a.mu.Unlock()
+
+	if reload {
+		a.push(agentCmdReloadConfig, nil)
+	}
+	if pushUpdate {
+		a.push(agentCmdUpdate, gin.H{"version": CurrentAgentVersion})
+	}
+}
+
+// agentStream 建立 Agent 控制通道
+func (s *Server) agentStream(c *gin.Context) {
+	token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+		return
+	}
+
+	a := &agentConn{token: token, send: make(chan []byte, 16)}
+	if node, err := s.svc.GetNodeByToken(token); err == nil {
+		a.kind, a.id = "node", node.ID
+	} else if client, err := s.svc.GetClientByToken(token); err == nil {
+		a.kind, a.id = "client", client.ID
+	} else {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+
+	conn, err := agentUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade agent stream: %v", err)
+		return
+	}
+	a.conn = conn
+
+	s.agentHub.add(a)
+	log.Printf("Agent stream connected: %s #%d, total: %d", a.kind, a.id, s.agentHub.Count())
+
+	go a.writePump()
+	s.agentReadPump(a)
+}
+
+// writePump 发送指令并定期 ping
+func (a *agentConn) writePump() {
+	ticker := time.NewTicker(agentStreamPingPeriod)
+	defer func() {
+		ticker.Stop()
+		a.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-a.send:
+			a.conn.SetWriteDeadline(time.Now().Add(agentStreamWriteWait))
+			if !ok {
+				a.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := a.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			a.conn.SetWriteDeadline(time.Now().Add(agentStreamWriteWait))
+			if err := a.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// agentReadPump 读取 Agent 上报
+func (s *Server) agentReadPump(a *agentConn) {
+	defer func() {
+		s.agentHub.remove(a)
+		a.conn.Close()
+		log.Printf("Agent stream disconnected: %s #%d, total: %d", a.kind, a.id, s.agentHub.Count())
+	}()
+
+	a.conn.SetReadLimit(1 << 20)
+	a.conn.SetReadDeadline(time.Now().Add(agentStreamReadWait))
+	a.conn.SetPongHandler(func(string) error {
+		a.conn.SetReadDeadline(time.Now().Add(agentStreamReadWait))
+		return nil
+	})
+
+	for {
+		_, data, err := a.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNoStatusReceived, websocket.CloseNormalClosure) {
+				log.Printf("Agent stream error: %v", err)
+			}
+			return
+		}
+		a.conn.SetReadDeadline(time.Now().Add(agentStreamReadWait))
+
+		var msg agentStreamMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "stats":
+			var req AgentHeartbeatRequest
+			if err := json.Unmarshal(msg.Data, &req); err != nil {
+				continue
+			}
+			req.Token = a.token
+			// 配置比对由 syncAgent 完成，避免重复渲染
+			reportedHash := req.ConfigHash
+			req.ConfigHash = ""
+			if kind, _, _ := s.recordAgentHeartbeat(&req); kind == "" {
+				a.push(agentCmdUninstall, nil)
+				continue
+			}
+			a.mu.Lock()
+			a.version = req.AgentVersion
+			if reportedHash != "" {
+				a.reportedHash = reportedHash
+			}
+			a.mu.Unlock()
+			s.syncAgent(a, true)
+		case "command_result":
+			var res AgentCommandResult
+			if err := json.Unmarshal(msg.Data, &res); err != nil || a.kind != "node" {
+				continue
+			}
+			s.recordCommandResult(a.id, &res)
+		case "logs":
+			var batch AgentLogBatch
+			if err := json.Unmarshal(msg.Data, &batch); err != nil || a.kind != "node" {
+				continue
+			}
+			s.recordNodeLogs(a.id, &batch)
+		case "config_status":
+			var st AgentConfigStatus
+			if err := json.Unmarshal(msg.Data, &st); err != nil || a.kind != "node" || st.ConfigHash == "" {
+				continue
+			}
+			s.recordConfigStatus(a.id, &st)
+		}
+	}
+}
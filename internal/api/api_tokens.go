@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 个人访问令牌 ====================
+
+// authenticateAPIToken 使用个人访问令牌认证 (由 authMiddleware 调用)
+func (s *Server) authenticateAPIToken(c *gin.Context, raw string) {
+	token, user, err := s.svc.AuthenticateAPIToken(raw, c.ClientIP())
+	if err != nil {
+		status := "invalid token"
+		if errors.Is(err, service.ErrAPITokenExpired) {
+			status = "token expired"
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": status})
+		c.Abort()
+		return
+	}
+
+	// 与 JWT claims 保持一致的上下文字段
+	c.Set("user_id", float64(user.ID))
+	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	c.Set("api_token_id", token.ID)
+	c.Set("api_token_name", token.Name)
+	c.Set("api_token_scopes", service.APITokenPermissions(token))
+	c.Next()
+}
+
+// auditAPITokenRequest 记录通过 API 令牌发起的写操作 (处理函数已记录审计日志时跳过)
+func (s *Server) auditAPITokenRequest(c *gin.Context, resource string) {
+	if c.GetBool("audited") {
+		return
+	}
+
+	var resourceID uint
+	if id, err := strconv.ParseUint(c.Param("id"), 10, 32); err == nil {
+		resourceID = uint(id)
+	}
+	status := "success"
+	if c.Writer.Status() >= http.StatusBadRequest {
+		status = "failed"
+	}
+
+	s.audit.Log(c, strings.ToLower(c.Request.Method), resource, resourceID,
+		fmt.Sprintf("%s %s (%d)", c.Request.Method, c.Request.URL.Path, c.Writer.Status()), status)
+}
+
+// CreateAPITokenRequest 创建个人访问令牌请求
+type CreateAPITokenRequest struct {
+	Name          string     `json:"name" binding:"required"`
+	Scopes        []string   `json:"scopes" binding:"required"`
+	ExpiresAt     *time.Time `json:"expires_at"`      // 过期时间 (可选)
+	ExpiresInDays int        `json:"expires_in_days"` // 有效天数 (可选，expires_at 未设置时生效)
+}
+
+// listAPITokens 获取当前用户的个人访问令牌
+func (s *Server) listAPITokens(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	tokens, err := s.svc.ListAPITokens(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// createAPIToken 创建个人访问令牌 (明文令牌仅在创建时返回一次)
+func (s *Server) createAPIToken(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	if expiresAt == nil && req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	token, raw, err := s.svc.CreateAPIToken(userID, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "create", "api_token", token.ID, token.Name)
+	c.JSON(http.StatusOK, gin.H{
+		"token":     raw,
+		"api_token": token,
+	})
+}
+
+// deleteAPIToken 撤销个人访问令牌
+func (s *Server) deleteAPIToken(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	token, err := s.svc.DeleteAPIToken(userID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+
+	s.audit.LogSuccess(c, "delete", "api_token", id, token.Name)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
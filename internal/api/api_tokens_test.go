@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/service"
+)
+
+// createTestAPIToken 通过接口创建令牌，返回明文与令牌 ID
+func createTestAPIToken(t *testing.T, s *Server, jwt string, scopes []string) (string, uint) {
+	t.Helper()
+	w := doJSON(s, http.MethodPost, "/api/profile/tokens", jwt, map[string]interface{}{"name": "test", "scopes": scopes})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create token: status %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Token    string `json:"token"`
+		APIToken struct {
+			ID uint `json:"id"`
+		} `json:"api_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("create token: bad response %s", w.Body.String())
+	}
+	return resp.Token, resp.APIToken.ID
+}
+
+func TestAPITokenScopesIntersectRole(t *testing.T) {
+	s := newTestServer(t, nil)
+	createTestUser(t, s, "viewer", service.RoleViewer)
+	token, _ := createTestAPIToken(t, s, loginAs(t, s, "viewer"), []string{"nodes:read", "nodes:write"})
+
+	// 令牌授权范围内且角色允许
+	if w := doJSON(s, http.MethodGet, "/api/nodes", token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /api/nodes: status %d, want 200", w.Code)
+	}
+	// 令牌授权了写权限，但角色只读
+	if w := doJSON(s, http.MethodPost, "/api/nodes", token, map[string]string{"name": "x"}); w.Code != http.StatusForbidden {
+		t.Errorf("POST /api/nodes: status %d, want 403", w.Code)
+	}
+	// 角色允许读取，但超出令牌授权范围
+	if w := doJSON(s, http.MethodGet, "/api/tunnels", token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET /api/tunnels: status %d, want 403", w.Code)
+	}
+	// 个人账户接口不允许通过令牌访问
+	if w := doJSON(s, http.MethodGet, "/api/profile/tokens", token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET /api/profile/tokens: status %d, want 403", w.Code)
+	}
+}
+
+func TestAPITokenRejectedAfterRevoke(t *testing.T) {
+	s := newTestServer(t, nil)
+	createTestUser(t, s, "root", service.RoleAdmin)
+	jwt := loginAs(t, s, "root")
+	token, id := createTestAPIToken(t, s, jwt, []string{"nodes:read"})
+
+	if w := doJSON(s, http.MethodGet, "/api/nodes", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/nodes: status %d, want 200", w.Code)
+	}
+	if w := doJSON(s, http.MethodDelete, fmt.Sprintf("/api/profile/tokens/%d", id), jwt, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke token: status %d", w.Code)
+	}
+	if w := doJSON(s, http.MethodGet, "/api/nodes", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d, want 401", w.Code)
+	}
+}
@@ -3,19 +3,20 @@ package api
 import (
 	"encoding/json"
 
+	"github.com/AliceNetworks/gost-panel/internal/model"
 	"github.com/gin-gonic/gin"
 )
 
 // AuditLogger 审计日志记录器
 type AuditLogger struct {
 	svc interface {
-		LogOperation(userID uint, username, action, resource string, resourceID uint, detail, ip, userAgent, status string)
+		AddOperationLog(entry *model.OperationLog)
 	}
 }
 
 // NewAuditLogger 创建审计日志记录器
 func NewAuditLogger(svc interface {
-	LogOperation(userID uint, username, action, resource string, resourceID uint, detail, ip, userAgent, status string)
+	AddOperationLog(entry *model.OperationLog)
 }) *AuditLogger {
 	return &AuditLogger{svc: svc}
 }
@@ -47,17 +48,19 @@ func (a *AuditLogger) Log(c *gin.Context, action, resource string, resourceID ui
 		}
 	}
 
-	a.svc.LogOperation(
-		uid,
-		uname,
-		action,
-		resource,
-		resourceID,
-		detailStr,
-		c.ClientIP(),
-		c.GetHeader("User-Agent"),
-		status,
-	)
+	a.svc.AddOperationLog(&model.OperationLog{
+		UserID:     uid,
+		Username:   uname,
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Detail:     detailStr,
+		IP:         c.ClientIP(),
+		UserAgent:  c.GetHeader("User-Agent"),
+		Status:     status,
+		TokenName:  c.GetString("api_token_name"),
+	})
+	c.Set("audited", true)
 }
 
 // LogSuccess 记录成功的操作
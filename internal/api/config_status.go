@@ -0,0 +1,71 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== Agent 配置应用结果 ====================
+
+// AgentConfigStatus Agent 上报的配置校验/重载结果
+type AgentConfigStatus struct {
+	Token      string `json:"token"` // 仅 HTTP 上报时需要
+	ConfigHash string `json:"config_hash" binding:"required"`
+	Success    bool   `json:"success"`
+	Stage      string `json:"stage"` // validate / reload / start
+	Error      string `json:"error"`
+	RolledBack bool   `json:"rolled_back"` // 已回滚到最近可用的配置
+}
+
+// recordConfigStatus 记录节点配置应用结果
+func (s *Server) recordConfigStatus(nodeID uint, st *AgentConfigStatus) error {
+	if st.Success {
+		return s.svc.RecordNodeConfigApplied(nodeID)
+	}
+
+	msg := st.Error
+	if st.Stage != "" {
+		msg = st.Stage + ": " + msg
+	}
+	if st.RolledBack {
+		msg += " (rolled back to last known-good config)"
+	}
+	version, err := s.svc.RecordNodeConfigFailure(nodeID, st.ConfigHash, msg)
+	if version != nil {
+		log.Printf("Node %d failed to apply config version #%d: %s", nodeID, version.ID, msg)
+	} else {
+		log.Printf("Node %d failed to apply config %.12s: %s", nodeID, st.ConfigHash, msg)
+	}
+	return err
+}
+
+// agentConfigStatus Agent 通过 HTTP 上报配置应用结果 (控制通道断开时)
+func (s *Server) agentConfigStatus(c *gin.Context) {
+	var st AgentConfigStatus
+	if err := c.ShouldBindJSON(&st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if st.Token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+	node, err := s.svc.GetNodeByToken(st.Token)
+	if err != nil {
+		// 客户端 Agent 不记录配置状态
+		if _, err := s.svc.GetClientByToken(st.Token); err == nil {
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+
+	if err := s.recordConfigStatus(node.ID, &st); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
@@ -32,6 +34,11 @@ func getUserInfo(c *gin.Context) (userID uint, isAdmin bool) {
 			userID = uint(id)
 		}
 	}
+	// 经过权限中间件时，isAdmin 表示当前角色可访问所有用户的该类资源
+	if scopeAll, ok := c.Get("scope_all"); ok {
+		isAdmin = scopeAll.(bool)
+		return
+	}
 	if role != nil {
 		if r, ok := role.(string); ok {
 			isAdmin = r == "admin"
@@ -230,7 +237,7 @@ func (s *Server) updateNode(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetNodeByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("node", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点"})
 		return
 	}
@@ -246,6 +253,7 @@ func (s *Server) updateNode(c *gin.Context) {
 	delete(updates, "agent_token")
 	delete(updates, "created_at")
 	delete(updates, "owner_id")
+	delete(updates, "org_id")
 
 	if err := s.svc.UpdateNode(uint(id), updates); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -260,7 +268,7 @@ func (s *Server) deleteNode(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetNodeByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("node", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点"})
 		return
 	}
@@ -578,6 +586,7 @@ func (s *Server) cloneNode(c *gin.Context) {
 		TrafficQuota:     node.TrafficQuota,
 		QuotaResetDay:    node.QuotaResetDay,
 		OwnerID:          &userID,
+		OrgID:            node.OrgID,
 	}
 
 	if err := s.svc.CreateNode(cloned); err != nil {
@@ -609,6 +618,7 @@ func (s *Server) cloneClient(c *gin.Context) {
 		TrafficQuota:  client.TrafficQuota,
 		QuotaResetDay: client.QuotaResetDay,
 		OwnerID:       &userID,
+		OrgID:         client.OrgID,
 	}
 
 	if err := s.svc.CreateClient(cloned); err != nil {
@@ -624,18 +634,12 @@ func (s *Server) clonePortForward(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 	userID, isAdmin := getUserInfo(c)
 
-	forward, err := s.svc.GetPortForward(uint(id))
+	forward, err := s.svc.GetPortForwardByOwner(uint(id), userID, isAdmin)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "port forward not found"})
 		return
 	}
 
-	// 检查权限
-	if !isAdmin && forward.OwnerID != nil && *forward.OwnerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	// 解析地址以递增端口
 	localAddr := forward.LocalAddr
 	if host, port, err := net.SplitHostPort(forward.LocalAddr); err == nil {
@@ -653,6 +657,7 @@ func (s *Server) clonePortForward(c *gin.Context) {
 		ChainID:    forward.ChainID,
 		Enabled:    forward.Enabled,
 		OwnerID:    &userID,
+		OrgID:      forward.OrgID,
 	}
 
 	if err := s.svc.CreatePortForward(cloned); err != nil {
@@ -668,18 +673,12 @@ func (s *Server) cloneTunnel(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 	userID, isAdmin := getUserInfo(c)
 
-	tunnel, err := s.svc.GetTunnel(uint(id))
+	tunnel, err := s.svc.GetTunnelByOwner(uint(id), userID, isAdmin)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "tunnel not found"})
 		return
 	}
 
-	// 检查权限
-	if !isAdmin && tunnel.OwnerID != nil && *tunnel.OwnerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	cloned := &model.Tunnel{
 		Name:          tunnel.Name + " (副本)",
 		Description:   tunnel.Description,
@@ -693,6 +692,7 @@ func (s *Server) cloneTunnel(c *gin.Context) {
 		QuotaResetDay: tunnel.QuotaResetDay,
 		SpeedLimit:    tunnel.SpeedLimit,
 		OwnerID:       &userID,
+		OrgID:         tunnel.OrgID,
 	}
 
 	if err := s.svc.CreateTunnel(cloned); err != nil {
@@ -708,18 +708,12 @@ func (s *Server) cloneProxyChain(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 	userID, isAdmin := getUserInfo(c)
 
-	chain, err := s.svc.GetProxyChain(uint(id))
+	chain, err := s.svc.GetProxyChainByOwner(uint(id), userID, isAdmin)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "proxy chain not found"})
 		return
 	}
 
-	// 检查权限
-	if !isAdmin && chain.OwnerID != nil && *chain.OwnerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	// 解析监听地址以递增端口
 	listenAddr := chain.ListenAddr
 	if host, port, err := net.SplitHostPort(chain.ListenAddr); err == nil {
@@ -736,6 +730,7 @@ func (s *Server) cloneProxyChain(c *gin.Context) {
 		TargetAddr:  chain.TargetAddr,
 		Enabled:     chain.Enabled,
 		OwnerID:     &userID,
+		OrgID:       chain.OrgID,
 	}
 
 	if err := s.svc.CreateProxyChain(cloned); err != nil {
@@ -765,18 +760,12 @@ func (s *Server) cloneNodeGroup(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
 	userID, isAdmin := getUserInfo(c)
 
-	group, err := s.svc.GetNodeGroup(uint(id))
+	group, err := s.svc.GetNodeGroupByOwner(uint(id), userID, isAdmin)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "node group not found"})
 		return
 	}
 
-	// 检查权限
-	if !isAdmin && group.OwnerID != nil && *group.OwnerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
-		return
-	}
-
 	cloned := &model.NodeGroup{
 		Name:          group.Name + " (副本)",
 		Strategy:      group.Strategy,
@@ -786,6 +775,7 @@ func (s *Server) cloneNodeGroup(c *gin.Context) {
 		HealthCheck:   group.HealthCheck,
 		CheckInterval: group.CheckInterval,
 		OwnerID:       &userID,
+		OrgID:         group.OrgID,
 	}
 
 	if err := s.svc.CreateNodeGroup(cloned); err != nil {
@@ -822,32 +812,30 @@ func (s *Server) syncNodeConfig(c *gin.Context) {
 	}
 
 	// 生成配置并自动保存版本快照
-	generator := gost.NewConfigGenerator()
-	bypasses, _ := s.svc.GetBypassesByNode(node.ID)
-	admissions, _ := s.svc.GetAdmissionsByNode(node.ID)
-	hostMappings, _ := s.svc.GetHostMappingsByNode(node.ID)
-	ingresses, _ := s.svc.GetIngressesByNode(node.ID)
-	config := generator.GenerateNodeConfigWithRules(node, bypasses, admissions, hostMappings, ingresses)
-
-	// 将配置序列化为 YAML 字符串并保存版本
-	configYAML, err := yaml.Marshal(config)
+	// 在线 Agent 通过控制通道立即收到重载通知，否则在心跳比对配置哈希后重新加载
+	// 手动同步时清除失败标记，允许 Agent 重试之前被拒绝的配置
+	s.svc.ClearNodeConfigFailure(node.ID)
+	configYAML, warnings, err := s.svc.RenderNodeConfig(node)
 	if err == nil {
 		s.svc.SaveConfigVersion(uint(id), string(configYAML), "Auto-saved on sync")
 		s.svc.CleanupOldVersions(uint(id), 20) // 保留最新 20 个版本
 	}
-
-	// 标记节点需要重新加载配置（通过更新 updated_at）
-	s.svc.TouchNode(uint(id))
+	for _, w := range warnings {
+		log.Printf("Node %d config: %s", node.ID, w)
+	}
 
 	// 根据节点状态返回不同提示
 	msg := "配置已更新，Agent 将在下次心跳时自动同步（最多 30 秒）"
-	if node.Status != "online" {
+	if s.agentHub.Connected("node", node.ID) {
+		msg = "配置已更新，已通过控制通道通知 Agent 重新加载"
+	} else if node.Status != "online" {
 		msg = "配置已生成，Agent 上线后将自动加载最新配置"
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": msg,
+		"success":  true,
+		"message":  msg,
+		"warnings": warnings,
 	})
 }
 
@@ -860,15 +848,13 @@ func (s *Server) getNodeGostConfig(c *gin.Context) {
 		return
 	}
 
-	// 使用新的配置生成器
-	generator := gost.NewConfigGenerator()
-	bypasses, _ := s.svc.GetBypassesByNode(node.ID)
-	admissions, _ := s.svc.GetAdmissionsByNode(node.ID)
-	hostMappings, _ := s.svc.GetHostMappingsByNode(node.ID)
-	ingresses, _ := s.svc.GetIngressesByNode(node.ID)
-	config := generator.GenerateNodeConfigWithRules(node, bypasses, admissions, hostMappings, ingresses)
+	configYAML, _, err := s.svc.RenderNodeConfig(node)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to serialize config"})
+		return
+	}
 
-	c.YAML(http.StatusOK, config)
+	c.Data(http.StatusOK, "application/x-yaml; charset=utf-8", configYAML)
 }
 
 func (s *Server) getNodeInstallScript(c *gin.Context) {
@@ -1029,7 +1015,7 @@ func (s *Server) updateClient(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetClientByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("client", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此客户端"})
 		return
 	}
@@ -1044,6 +1030,7 @@ func (s *Server) updateClient(c *gin.Context) {
 	delete(updates, "token")
 	delete(updates, "created_at")
 	delete(updates, "owner_id")
+	delete(updates, "org_id")
 
 	if err := s.svc.UpdateClient(uint(id), updates); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -1058,7 +1045,7 @@ func (s *Server) deleteClient(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetClientByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("client", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此客户端"})
 		return
 	}
@@ -1133,8 +1120,12 @@ func (s *Server) getClientGostConfig(c *gin.Context) {
 		return
 	}
 
-	config := s.generateClientConfig(client)
-	c.YAML(http.StatusOK, config)
+	configYAML, err := s.svc.RenderClientConfig(client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "application/x-yaml; charset=utf-8", configYAML)
 }
 
 func (s *Server) getClientProxyURI(c *gin.Context) {
@@ -1159,120 +1150,6 @@ func (s *Server) getClientProxyURI(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"uri": uri})
 }
 
-func (s *Server) generateClientConfig(client *model.Client) map[string]interface{} {
-	node := client.Node
-	chainName := "forward-chain"
-
-	config := map[string]interface{}{
-		"services": []map[string]interface{}{
-			// 本地 SOCKS5
-			{
-				"name": "local-socks5",
-				"addr": fmt.Sprintf(":%d", client.LocalPort),
-				"handler": map[string]interface{}{
-					"type":   "socks5",
-					"auther": "local-auth",
-					"metadata": map[string]interface{}{
-						"udp":         true,
-						"udpAddr":     fmt.Sprintf(":%d", client.LocalPort),
-						"ignoreChain": true,
-					},
-				},
-				"listener": map[string]interface{}{
-					"type": "tcp",
-				},
-			},
-			// RTCP 反向隧道
-			{
-				"name": "rtcp-tunnel",
-				"addr": fmt.Sprintf(":%d", client.RemotePort),
-				"handler": map[string]interface{}{
-					"type": "rtcp",
-				},
-				"listener": map[string]interface{}{
-					"type":  "rtcp",
-					"chain": chainName,
-					"metadata": map[string]interface{}{
-						"keepalive": true,
-					},
-				},
-				"forwarder": map[string]interface{}{
-					"nodes": []map[string]interface{}{
-						{"name": "target", "addr": fmt.Sprintf("127.0.0.1:%d", client.LocalPort)},
-					},
-				},
-			},
-			// RUDP 反向隧道
-			{
-				"name": "rudp-tunnel",
-				"addr": fmt.Sprintf(":%d", client.RemotePort),
-				"handler": map[string]interface{}{
-					"type": "rudp",
-				},
-				"listener": map[string]interface{}{
-					"type":  "rudp",
-					"chain": chainName,
-					"metadata": map[string]interface{}{
-						"keepalive": true,
-					},
-				},
-				"forwarder": map[string]interface{}{
-					"nodes": []map[string]interface{}{
-						{"name": "target", "addr": fmt.Sprintf("127.0.0.1:%d", client.LocalPort)},
-					},
-				},
-			},
-		},
-		"chains": []map[string]interface{}{
-			{
-				"name": chainName,
-				"hops": []map[string]interface{}{
-					{
-						"name": "hop-0",
-						"nodes": []map[string]interface{}{
-							{
-								"name": "node-0",
-								// 连接到节点的 SOCKS5 服务 (使用 bind 功能建立反向隧道)
-								"addr": fmt.Sprintf("%s:%d", node.Host, node.Port),
-								"connector": func() map[string]interface{} {
-									c := map[string]interface{}{
-										"type": "socks5",
-									}
-									// 如果节点有代理认证，添加认证信息
-									if node.ProxyUser != "" {
-										c["auth"] = map[string]string{
-											"username": node.ProxyUser,
-											"password": node.ProxyPass,
-										}
-									}
-									return c
-								}(),
-								"dialer": map[string]interface{}{
-									"type": "tcp",
-									"metadata": map[string]interface{}{
-										"keepAlive":       true,
-										"keepAlivePeriod": "15s",
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-		"authers": []map[string]interface{}{
-			{
-				"name": "local-auth",
-				"auths": []map[string]string{
-					{"username": client.ProxyUser, "password": client.ProxyPass},
-				},
-			},
-		},
-	}
-
-	return config
-}
-
 // ==================== Agent 接口 ====================
 
 type AgentRegisterRequest struct {
@@ -1323,6 +1200,7 @@ type AgentHeartbeatRequest struct {
 	ConfigHash   string                       `json:"config_hash"`   // 当前配置的哈希值
 	AgentVersion string                       `json:"agent_version"` // Agent 版本
 	ServiceStats map[string]map[string]int64  `json:"service_stats"` // 按服务名分类的统计
+	Gost         *service.GostProcessStatus   `json:"gost"`          // GOST 进程状态 (旧版 Agent 不上报)
 }
 
 func (s *Server) agentHeartbeat(c *gin.Context) {
@@ -1332,76 +1210,87 @@ func (s *Server) agentHeartbeat(c *gin.Context) {
 		return
 	}
 
+	kind, id, currentHash := s.recordAgentHeartbeat(&req)
+	if kind == "" {
+		// Token 无效，通知 Agent 卸载自己
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":     "invalid token",
+			"uninstall": true,
+		})
+		return
+	}
+
+	// 检查配置是否需要更新（客户端包括关联节点的密码变更）
+	reloadConfig := req.ConfigHash != "" && currentHash != req.ConfigHash
+
+	// 检查 Agent 是否需要更新
+	needsUpdate, forceUpdate := s.checkAgentNeedsUpdate(req.AgentVersion)
+
+	resp := gin.H{
+		"status":        "ok",
+		"reload_config": reloadConfig,
+		"needs_update":  needsUpdate,
+		"force_update":  forceUpdate,
+	}
+	// 控制通道未连接时，通过心跳响应下发待执行的远程命令
+	if kind == "node" && !s.agentHub.Connected(kind, id) {
+		if cmds, err := s.svc.TakePendingNodeCommands(id); err == nil && len(cmds) > 0 {
+			resp["commands"] = agentCommands(cmds)
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// recordAgentHeartbeat 记录 Agent 上报的状态与流量 (HTTP 心跳与控制通道共用)
+// 返回 Agent 类型 (node/client，Token 无效时为空)、ID 及面板当前配置的哈希值 (未上报配置哈希时为空，
+// Agent 已拒绝当前配置时返回其上报的哈希，即不需要重载)
+func (s *Server) recordAgentHeartbeat(req *AgentHeartbeatRequest) (kind string, id uint, configHash string) {
 	// 尝试更新节点
 	node, err := s.svc.GetNodeByToken(req.Token)
 	if err == nil {
 		s.svc.UpdateNodeStatus(node.ID, "online", req.Connections, req.TrafficIn, req.TrafficOut)
+		gostStatus := node.GostStatus
+		if req.Gost != nil {
+			if gostStatus, err = s.svc.RecordNodeGostStatus(node, req.Gost); err != nil {
+				log.Printf("Node %d: failed to save GOST status: %v", node.ID, err)
+			}
+		}
 		// 广播节点状态更新
-		s.BroadcastNodeStatus(node.ID, "online", req.Connections, node.TrafficIn+req.TrafficIn, node.TrafficOut+req.TrafficOut)
+		s.BroadcastNodeStatus(node.ID, "online", gostStatus, req.Connections, node.TrafficIn+req.TrafficIn, node.TrafficOut+req.TrafficOut)
 
 		// 处理服务级别统计 (隧道流量)
 		if req.ServiceStats != nil {
 			s.processServiceStats(node.ID, req.ServiceStats)
 		}
 
-		// 检查配置是否需要更新
-		reloadConfig := false
-		if req.ConfigHash != "" {
-			// 计算当前节点配置的哈希值
-			currentHash := s.svc.GetNodeConfigHash(node.ID)
-			if currentHash != req.ConfigHash {
-				reloadConfig = true
-			}
+		if req.ConfigHash == "" {
+			return "node", node.ID, ""
 		}
-
-		// 检查 Agent 是否需要更新
-		needsUpdate, forceUpdate := s.checkAgentNeedsUpdate(req.AgentVersion)
-
-		c.JSON(http.StatusOK, gin.H{
-			"status":        "ok",
-			"reload_config": reloadConfig,
-			"needs_update":  needsUpdate,
-			"force_update":  forceUpdate,
-		})
-		return
+		currentHash := s.svc.GetNodeConfigHash(node.ID)
+		s.svc.ResolveNodeConfigFailure(node, currentHash, req.ConfigHash)
+		// Agent 已拒绝当前配置 (校验或重载失败)，视为无需重载
+		if service.NodeConfigRejected(node, currentHash) {
+			return "node", node.ID, req.ConfigHash
+		}
+		return "node", node.ID, currentHash
 	}
 
 	// 尝试更新客户端
 	client, err := s.svc.GetClientByToken(req.Token)
 	if err == nil {
 		s.svc.UpdateClient(client.ID, map[string]interface{}{
-			"status":      "online",
-			"last_seen":   time.Now(),
-			"traffic_in":  client.TrafficIn + req.TrafficIn,
-			"traffic_out": client.TrafficOut + req.TrafficOut,
+			"status":    "online",
+			"last_seen": time.Now(),
 		})
+		s.svc.UpdateClientTraffic(client.ID, req.TrafficIn, req.TrafficOut)
 
-		// 检查配置是否需要更新（包括关联节点的密码变更）
-		reloadConfig := false
-		if req.ConfigHash != "" {
-			currentHash := s.svc.GetClientConfigHash(client.ID)
-			if currentHash != req.ConfigHash {
-				reloadConfig = true
-			}
+		if req.ConfigHash == "" {
+			return "client", client.ID, ""
 		}
-
-		// 检查 Agent 是否需要更新
-		needsUpdate, forceUpdate := s.checkAgentNeedsUpdate(req.AgentVersion)
-
-		c.JSON(http.StatusOK, gin.H{
-			"status":        "ok",
-			"reload_config": reloadConfig,
-			"needs_update":  needsUpdate,
-			"force_update":  forceUpdate,
-		})
-		return
+		return "client", client.ID, s.svc.GetClientConfigHash(client.ID)
 	}
 
-	// Token 无效，通知 Agent 卸载自己
-	c.JSON(http.StatusUnauthorized, gin.H{
-		"error":     "invalid token",
-		"uninstall": true,
-	})
+	return "", 0, ""
 }
 
 // checkAgentNeedsUpdate 检查 Agent 是否需要更新
@@ -1434,13 +1323,17 @@ func (s *Server) processServiceStats(nodeID uint, stats map[string]map[string]in
 		trafficIn := serviceStats["traffic_in"]
 		trafficOut := serviceStats["traffic_out"]
 
-		// 解析服务名，匹配隧道或客户端
+		// 解析服务名，匹配隧道、客户端或端口转发
 		// 隧道服务名格式: tunnel-{id}-tcp, tunnel-{id}-udp, tunnel-{id}
 		// 客户端服务名格式: rtcp-tunnel, rudp-tunnel, client-{id}
+		// 端口转发服务名即规则名称
 		if tunnelID := parseTunnelID(serviceName); tunnelID > 0 {
 			s.svc.UpdateTunnelTraffic(uint(tunnelID), trafficIn, trafficOut)
+			s.BroadcastTunnelTraffic(uint(tunnelID))
 		} else if clientID := parseClientID(serviceName); clientID > 0 {
 			s.svc.UpdateClientTraffic(uint(clientID), trafficIn, trafficOut)
+		} else {
+			s.svc.UpdatePortForwardTrafficByName(nodeID, serviceName, trafficIn, trafficOut)
 		}
 	}
 }
@@ -1476,22 +1369,28 @@ func (s *Server) agentGetConfig(c *gin.Context) {
 	// 尝试查找节点
 	node, err := s.svc.GetNodeByToken(token)
 	if err == nil {
-		// 使用 ConfigGenerator 生成完整配置（包含规则）
-		generator := gost.NewConfigGenerator()
-		bypasses, _ := s.svc.GetBypassesByNode(node.ID)
-		admissions, _ := s.svc.GetAdmissionsByNode(node.ID)
-		hostMappings, _ := s.svc.GetHostMappingsByNode(node.ID)
-		ingresses, _ := s.svc.GetIngressesByNode(node.ID)
-		config := generator.GenerateNodeConfigWithRules(node, bypasses, admissions, hostMappings, ingresses)
-		c.YAML(http.StatusOK, config)
+		// 下发内容与心跳配置哈希的计算来源一致
+		configYAML, warnings, err := s.svc.RenderNodeConfig(node)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to serialize config"})
+			return
+		}
+		for _, w := range warnings {
+			log.Printf("Node %d config: %s", node.ID, w)
+		}
+		c.Data(http.StatusOK, "application/x-yaml; charset=utf-8", configYAML)
 		return
 	}
 
 	// 尝试查找客户端
 	client, err := s.svc.GetClientByToken(token)
 	if err == nil {
-		config := s.generateClientConfig(client)
-		c.YAML(http.StatusOK, config)
+		configYAML, err := s.svc.RenderClientConfig(client)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "application/x-yaml; charset=utf-8", configYAML)
 		return
 	}
 
@@ -1567,6 +1466,12 @@ func (s *Server) createUser(c *gin.Context) {
 		emailVerified = *req.EmailVerified
 	}
 
+	// 指定非默认角色需要角色管理权限，防止越权提升
+	if req.Role != "" && req.Role != service.RoleUser && !hasPermission(c, "roles", service.ActionWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: roles:write"})
+		return
+	}
+
 	user, err := s.svc.CreateUserFull(req.Username, req.Email, req.Password, req.Role, enabled, emailVerified)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -1595,6 +1500,12 @@ func (s *Server) updateUser(c *gin.Context) {
 	delete(updates, "id")
 	delete(updates, "created_at")
 
+	// 修改角色需要角色管理权限，防止越权提升
+	if _, ok := updates["role"]; ok && !hasPermission(c, "roles", service.ActionWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: roles:write"})
+		return
+	}
+
 	if err := s.svc.UpdateUser(uint(id), updates); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1860,6 +1771,12 @@ func (s *Server) disable2FA(c *gin.Context) {
 		return
 	}
 
+	// 角色要求 2FA 时，需保留至少一种第二因素
+	if err := s.svc.CanDisableTOTP(&user); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "当前角色要求启用两步验证，请先添加安全密钥后再禁用"})
+		return
+	}
+
 	s.svc.DB().Model(&user).Updates(map[string]interface{}{
 		"two_factor_enabled": false,
 		"two_factor_secret":  "",
@@ -1876,8 +1793,10 @@ func (s *Server) disable2FA(c *gin.Context) {
 // ==================== 流量历史 ====================
 
 func (s *Server) getTrafficHistory(c *gin.Context) {
-	hoursStr := c.DefaultQuery("hours", "1")
-	hours, _ := strconv.Atoi(hoursStr)
+	from, to, granularity, ok := s.parseTrafficHistoryQuery(c)
+	if !ok {
+		return
+	}
 
 	nodeIDStr := c.Query("node_id")
 	var nodeID *uint
@@ -1889,15 +1808,124 @@ func (s *Server) getTrafficHistory(c *gin.Context) {
 		}
 	}
 
-	history, err := s.svc.GetTrafficHistory(nodeID, hours)
+	history, err := s.svc.GetTrafficHistory(nodeID, from, to, granularity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 响应体保持数据点数组，实际使用的粒度通过响应头返回
+	c.Header("X-Granularity", granularity)
+	c.JSON(http.StatusOK, history)
+}
+
+// getTunnelTrafficHistory 获取隧道流量历史
+func (s *Server) getTunnelTrafficHistory(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if _, err := s.svc.GetTunnelByOwner(uint(id), userID, isAdmin); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tunnel not found"})
+		return
+	}
+	s.respondTargetTrafficHistory(c, "tunnel", uint(id))
+}
+
+// getClientTrafficHistory 获取客户端流量历史
+func (s *Server) getClientTrafficHistory(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if _, err := s.svc.GetClientByOwner(uint(id), userID, isAdmin); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+		return
+	}
+	s.respondTargetTrafficHistory(c, "client", uint(id))
+}
+
+// getPortForwardTrafficHistory 获取端口转发流量历史
+func (s *Server) getPortForwardTrafficHistory(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if _, err := s.svc.GetPortForwardByOwner(uint(id), userID, isAdmin); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "port forward not found"})
+		return
+	}
+	s.respondTargetTrafficHistory(c, "port_forward", uint(id))
+}
+
+// getUserTrafficHistory 获取用户流量历史 (管理员或用户本人)
+func (s *Server) getUserTrafficHistory(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if !isAdmin && uint(id) != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return
+	}
+	s.respondTargetTrafficHistory(c, "user", uint(id))
+}
+
+func (s *Server) respondTargetTrafficHistory(c *gin.Context, targetType string, targetID uint) {
+	from, to, granularity, ok := s.parseTrafficHistoryQuery(c)
+	if !ok {
+		return
+	}
+
+	history, err := s.svc.GetTargetTrafficHistory(targetType, targetID, from, to, granularity)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("X-Granularity", granularity)
 	c.JSON(http.StatusOK, history)
 }
 
+// parseTrafficHistoryQuery 解析流量历史查询参数，参数无效时写入 400 响应并返回 false
+// 时间范围: from/to (RFC3339 或 Unix 秒)，未指定 from 时使用 hours (默认最近 1 小时)
+func (s *Server) parseTrafficHistoryQuery(c *gin.Context) (from, to time.Time, granularity string, ok bool) {
+	to = time.Now()
+	if toStr := c.Query("to"); toStr != "" {
+		t, err := parseTimeParam(toStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		to = t
+	}
+	if fromStr := c.Query("from"); fromStr != "" {
+		t, err := parseTimeParam(fromStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		from = t
+	} else {
+		hours, _ := strconv.Atoi(c.DefaultQuery("hours", "1"))
+		if hours <= 0 {
+			hours = 1
+		}
+		from = to.Add(-time.Duration(hours) * time.Hour)
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	granularity, err := s.svc.ResolveTrafficGranularity(from, to, c.Query("granularity"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	return from, to, granularity, true
+}
+
+// parseTimeParam 解析时间参数 (RFC3339 或 Unix 秒)
+func parseTimeParam(value string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
 // ==================== 通知渠道管理 ====================
 
 func (s *Server) listNotifyChannels(c *gin.Context) {
@@ -2535,7 +2563,7 @@ func (s *Server) updatePortForward(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetPortForwardByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("port_forward", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此转发规则"})
 		return
 	}
@@ -2548,6 +2576,7 @@ func (s *Server) updatePortForward(c *gin.Context) {
 
 	delete(updates, "id")
 	delete(updates, "owner_id")
+	delete(updates, "org_id")
 	delete(updates, "created_at")
 	delete(updates, "updated_at")
 	delete(updates, "description") // 前端发送但后端不支持
@@ -2596,7 +2625,7 @@ func (s *Server) deletePortForward(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetPortForwardByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("port_forward", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此转发规则"})
 		return
 	}
@@ -2715,7 +2744,7 @@ func (s *Server) updateNodeGroup(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetNodeGroupByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("node_group", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点组"})
 		return
 	}
@@ -2728,6 +2757,7 @@ func (s *Server) updateNodeGroup(c *gin.Context) {
 
 	delete(updates, "id")
 	delete(updates, "owner_id")
+	delete(updates, "org_id")
 	delete(updates, "created_at")
 	delete(updates, "description")          // 前端发送但不支持
 	delete(updates, "health_check_timeout") // 前端发送但不支持
@@ -2762,7 +2792,7 @@ func (s *Server) deleteNodeGroup(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetNodeGroupByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("node_group", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点组"})
 		return
 	}
@@ -2909,7 +2939,7 @@ func (s *Server) pingNode(c *gin.Context) {
 	}
 
 	// 测试 TCP 连接延迟到节点的代理端口
-	addr := fmt.Sprintf("%s:%d", node.Host, node.Port)
+	addr := net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
 	start := time.Now()
 
 	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
@@ -2950,7 +2980,7 @@ func (s *Server) pingAllNodes(c *gin.Context) {
 			semaphore <- struct{}{}        // 获取信号量
 			defer func() { <-semaphore }() // 释放信号量
 
-			addr := fmt.Sprintf("%s:%d", n.Host, n.Port)
+			addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
 			start := time.Now()
 
 			conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
@@ -3036,7 +3066,7 @@ func (s *Server) getHealthSummary(c *gin.Context) {
 			AvgLatency float64
 		}
 		s.svc.DB().Model(&model.HealthCheckLog{}).
-			Select("AVG(latency) as avg_latency").
+			Select("COALESCE(AVG(latency), 0) as avg_latency").
 			Where("node_id = ? AND checked_at >= ? AND status = ?", node.ID, since, "healthy").
 			Scan(&result)
 		avgLatency = result.AvgLatency
@@ -3309,7 +3339,7 @@ func (s *Server) importData(c *gin.Context) {
 
 // ==================== 数据库备份/恢复 ====================
 
-// backupDatabase 下载数据库备份
+// backupDatabase 下载数据库备份 (JSON 逻辑备份，适用于所有数据库驱动)
 func (s *Server) backupDatabase(c *gin.Context) {
 	_, isAdmin := getUserInfo(c)
 	if !isAdmin {
@@ -3317,46 +3347,32 @@ func (s *Server) backupDatabase(c *gin.Context) {
 		return
 	}
 
-	dbPath := s.cfg.DBPath
-
-	// 检查文件是否存在
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "database file not found"})
-		return
-	}
-
-	// 创建临时备份文件（避免读写冲突）
-	backupPath := dbPath + ".backup"
-
-	// 复制数据库文件
-	src, err := os.Open(dbPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open database"})
-		return
-	}
-	defer src.Close()
-
-	dst, err := os.Create(backupPath)
+	// 先导出到临时文件，避免导出失败时返回不完整的备份
+	tmp, err := os.CreateTemp("", "gost-panel-backup-*.json")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create backup"})
 		return
 	}
-	defer dst.Close()
-	defer os.Remove(backupPath) // 清理临时文件
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to copy database"})
+	if err := model.DumpDatabase(s.svc.DB(), tmp); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to dump database: " + err.Error()})
 		return
 	}
-	dst.Close()
+	tmp.Close()
+
+	s.audit.LogSuccess(c, "backup", "database", 0, s.cfg.DBDriver)
 
 	// 发送备份文件
-	filename := fmt.Sprintf("gost-panel-backup-%s.db", time.Now().Format("20060102-150405"))
+	filename := fmt.Sprintf("gost-panel-backup-%s.json", time.Now().Format("20060102-150405"))
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.File(backupPath)
+	c.Header("Content-Type", "application/json")
+	c.File(tmp.Name())
 }
 
 // restoreDatabase 恢复数据库
+// 支持 JSON 逻辑备份 (所有驱动，在线恢复) 和 SQLite 数据库文件 (仅 SQLite，需重启)
 func (s *Server) restoreDatabase(c *gin.Context) {
 	_, isAdmin := getUserInfo(c)
 	if !isAdmin {
@@ -3376,6 +3392,45 @@ func (s *Server) restoreDatabase(c *gin.Context) {
 		return
 	}
 
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read uploaded file"})
+		return
+	}
+	defer src.Close()
+
+	// 旧版 SQLite 文件备份
+	header := make([]byte, len(sqliteFileHeader))
+	if n, _ := io.ReadFull(src, header); n == len(header) && string(header) == sqliteFileHeader {
+		if !s.cfg.IsSQLite() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "SQLite database files can only be restored with the sqlite driver, use a JSON backup instead"})
+			return
+		}
+		s.restoreSQLiteFile(c, file)
+		return
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read uploaded file"})
+		return
+	}
+
+	if err := model.RestoreDatabase(s.svc.DB(), src); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "restore", "database", 0, file.Filename)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Database restored successfully.",
+	})
+}
+
+// sqliteFileHeader SQLite 数据库文件头
+const sqliteFileHeader = "SQLite format 3\x00"
+
+// restoreSQLiteFile 使用 SQLite 数据库文件替换当前数据库 (需重启服务)
+func (s *Server) restoreSQLiteFile(c *gin.Context, file *multipart.FileHeader) {
 	// 保存上传的文件到临时位置
 	tempPath := s.cfg.DBPath + ".restore"
 	if err := c.SaveUploadedFile(file, tempPath); err != nil {
@@ -3385,7 +3440,7 @@ func (s *Server) restoreDatabase(c *gin.Context) {
 	defer os.Remove(tempPath)
 
 	// 验证是 SQLite 数据库
-	testDB, err := model.InitDB(tempPath)
+	testDB, err := model.InitDB(model.DriverSQLite, tempPath)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid database file"})
 		return
@@ -3477,6 +3532,7 @@ func (s *Server) createProxyChain(c *gin.Context) {
 
 	// 强制设置所有者 (防止用户指定任意 owner_id)
 	chain.OwnerID = &userID
+	chain.OrgID = nil // 组织归属仅通过组织资源接口变更
 
 	if err := s.svc.CreateProxyChain(&chain); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -3491,7 +3547,7 @@ func (s *Server) updateProxyChain(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetProxyChainByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("proxy_chain", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此代理链"})
 		return
 	}
@@ -3505,6 +3561,7 @@ func (s *Server) updateProxyChain(c *gin.Context) {
 	// 防止篡改受保护字段
 	delete(updates, "id")
 	delete(updates, "owner_id")
+	delete(updates, "org_id")
 	delete(updates, "created_at")
 
 	if err := s.svc.UpdateProxyChainMap(uint(id), updates); err != nil {
@@ -3521,7 +3578,7 @@ func (s *Server) deleteProxyChain(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetProxyChainByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("proxy_chain", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此代理链"})
 		return
 	}
@@ -3674,6 +3731,7 @@ func (s *Server) createTunnel(c *gin.Context) {
 
 	// 强制设置所有者 (防止用户指定任意 owner_id)
 	tunnel.OwnerID = &userID
+	tunnel.OrgID = nil // 组织归属仅通过组织资源接口变更
 
 	if err := s.svc.CreateTunnel(&tunnel); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -3690,7 +3748,7 @@ func (s *Server) updateTunnel(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetTunnelByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("tunnel", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此隧道"})
 		return
 	}
@@ -3704,6 +3762,7 @@ func (s *Server) updateTunnel(c *gin.Context) {
 	// 防止篡改受保护字段
 	delete(updates, "id")
 	delete(updates, "owner_id")
+	delete(updates, "org_id")
 	delete(updates, "created_at")
 
 	if err := s.svc.UpdateTunnelMap(uint(id), updates); err != nil {
@@ -3720,7 +3779,7 @@ func (s *Server) deleteTunnel(c *gin.Context) {
 	userID, isAdmin := getUserInfo(c)
 
 	// 权限检查
-	if _, err := s.svc.GetTunnelByOwner(uint(id), userID, isAdmin); err != nil {
+	if !s.svc.ResourceWritableBy("tunnel", uint(id), userID, isAdmin) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此隧道"})
 		return
 	}
@@ -3819,6 +3878,30 @@ func (s *Server) getTunnelExitConfig(c *gin.Context) {
 
 // ==================== 网站配置 ====================
 
+// secretSiteConfigs 只写的敏感配置，读取时以占位符代替
+var secretSiteConfigs = []string{model.ConfigOIDCClientSecret, model.ConfigLDAPBindPassword}
+
+// secretPlaceholder 已设置的敏感配置返回的占位符，提交占位符表示保持不变
+const secretPlaceholder = "******"
+
+// redactSecretConfigs 将已设置的敏感配置替换为占位符
+func redactSecretConfigs(configs map[string]string) {
+	for _, key := range secretSiteConfigs {
+		if configs[key] != "" {
+			configs[key] = secretPlaceholder
+		}
+	}
+}
+
+// dropUnchangedSecrets 移除提交的占位符，保留已保存的敏感配置
+func dropUnchangedSecrets(configs map[string]string) {
+	for _, key := range secretSiteConfigs {
+		if configs[key] == secretPlaceholder {
+			delete(configs, key)
+		}
+	}
+}
+
 func (s *Server) getSiteConfigs(c *gin.Context) {
 	_, isAdmin := getUserInfo(c)
 	if !isAdmin {
@@ -3827,6 +3910,7 @@ func (s *Server) getSiteConfigs(c *gin.Context) {
 	}
 
 	configs := s.svc.GetSiteConfigs()
+	redactSecretConfigs(configs)
 	c.JSON(http.StatusOK, configs)
 }
 
@@ -3842,6 +3926,44 @@ func (s *Server) updateSiteConfigs(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	dropUnchangedSecrets(configs)
+	if value, ok := configs[model.ConfigSessionIdleTimeout]; ok {
+		if minutes, err := strconv.Atoi(value); err != nil || minutes < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "session_idle_timeout must be a non-negative number of minutes"})
+			return
+		}
+	}
+	for _, key := range []string{model.ConfigLockoutThreshold, model.ConfigLockoutDuration, model.ConfigBruteForceIPThreshold} {
+		if value, ok := configs[key]; ok {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": key + " must be a non-negative number"})
+				return
+			}
+		}
+	}
+	for _, key := range []string{model.ConfigWebAuthnRequiredRoles, model.ConfigTwoFactorRequiredRoles} {
+		roles, ok := configs[key]
+		if !ok {
+			continue
+		}
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role == "" {
+				continue
+			}
+			if err := s.svc.ValidateRole(role); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key + ": unknown role " + role})
+				return
+			}
+		}
+	}
+	for _, key := range []string{model.ConfigOIDCRoleMapping, model.ConfigLDAPRoleMapping} {
+		if mapping, ok := configs[key]; ok {
+			if err := s.svc.ValidateRoleMapping(key, mapping); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
 
 	if err := s.svc.SetSiteConfigs(configs); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -3856,12 +3978,15 @@ func (s *Server) getPublicSiteConfig(c *gin.Context) {
 	configs := s.svc.GetSiteConfigs()
 	// 只返回前端需要的公开配置
 	public := map[string]string{
-		"site_name":        configs["site_name"],
-		"site_description": configs["site_description"],
-		"favicon_url":      configs["favicon_url"],
-		"logo_url":         configs["logo_url"],
-		"footer_text":      configs["footer_text"],
-		"custom_css":       configs["custom_css"],
+		"site_name":             configs["site_name"],
+		"site_description":      configs["site_description"],
+		"favicon_url":           configs["favicon_url"],
+		"logo_url":              configs["logo_url"],
+		"footer_text":           configs["footer_text"],
+		"custom_css":            configs["custom_css"],
+		"oidc_enabled":          configs["oidc_enabled"],
+		"oidc_button_text":      configs["oidc_button_text"],
+		"passkey_login_enabled": configs["passkey_login_enabled"],
 	}
 	c.JSON(http.StatusOK, public)
 }
@@ -4390,6 +4515,7 @@ func (s *Server) createBypass(c *gin.Context) {
 		return
 	}
 	bypass.OwnerID = &userID
+	bypass.OrgID = nil
 	if err := s.svc.CreateBypass(&bypass); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -4453,6 +4579,7 @@ func (s *Server) createAdmission(c *gin.Context) {
 		return
 	}
 	admission.OwnerID = &userID
+	admission.OrgID = nil
 	if err := s.svc.CreateAdmission(&admission); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -4516,6 +4643,7 @@ func (s *Server) createHostMapping(c *gin.Context) {
 		return
 	}
 	mapping.OwnerID = &userID
+	mapping.OrgID = nil
 	if err := s.svc.CreateHostMapping(&mapping); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -4579,6 +4707,7 @@ func (s *Server) createIngress(c *gin.Context) {
 		return
 	}
 	ingress.OwnerID = &userID
+	ingress.OrgID = nil
 	if err := s.svc.CreateIngress(&ingress); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -4642,6 +4771,7 @@ func (s *Server) createRecorder(c *gin.Context) {
 		return
 	}
 	recorder.OwnerID = &userID
+	recorder.OrgID = nil
 	if err := s.svc.CreateRecorder(&recorder); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -4705,6 +4835,7 @@ func (s *Server) createRouter(c *gin.Context) {
 		return
 	}
 	router.OwnerID = &userID
+	router.OrgID = nil
 	if err := s.svc.CreateRouter(&router); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -4767,7 +4898,12 @@ func (s *Server) createSD(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := gost.ValidateSD(sd.Type, sd.Config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	sd.OwnerID = &userID
+	sd.OrgID = nil
 	if err := s.svc.CreateSD(&sd); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -4783,6 +4919,26 @@ func (s *Server) updateSD(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// 类型或配置变更后需仍能生成 GOST 插件配置
+	_, hasType := updates["type"]
+	_, hasConfig := updates["config"]
+	if hasType || hasConfig {
+		sd, err := s.svc.GetSD(uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sd not found"})
+			return
+		}
+		if v, ok := updates["type"].(string); ok {
+			sd.Type = v
+		}
+		if v, ok := updates["config"].(string); ok {
+			sd.Config = v
+		}
+		if err := gost.ValidateSD(sd.Type, sd.Config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	if err := s.svc.UpdateSD(uint(id), updates); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -5021,15 +5177,7 @@ func (s *Server) createConfigVersion(c *gin.Context) {
 	}
 
 	// 生成 YAML 配置
-	generator := gost.NewConfigGenerator()
-	bypasses, _ := s.svc.GetBypassesByNode(node.ID)
-	admissions, _ := s.svc.GetAdmissionsByNode(node.ID)
-	hostMappings, _ := s.svc.GetHostMappingsByNode(node.ID)
-	ingresses, _ := s.svc.GetIngressesByNode(node.ID)
-	config := generator.GenerateNodeConfigWithRules(node, bypasses, admissions, hostMappings, ingresses)
-
-	// 将配置序列化为 YAML 字符串
-	configYAML, err := yaml.Marshal(config)
+	configYAML, _, err := s.svc.RenderNodeConfig(node)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to serialize config"})
 		return
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// listJobs 获取定时任务列表 (仅管理员)
+func (s *Server) listJobs(c *gin.Context) {
+	_, isAdmin := getUserInfo(c)
+	if !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin only"})
+		return
+	}
+
+	jobs, err := s.svc.ListJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// runJob 手动触发定时任务 (仅管理员)
+func (s *Server) runJob(c *gin.Context) {
+	_, isAdmin := getUserInfo(c)
+	if !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin only"})
+		return
+	}
+
+	name := c.Param("name")
+	if err := s.svc.TriggerJob(name); err != nil {
+		switch {
+		case errors.Is(err, service.ErrJobNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrJobAlreadyRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	s.audit.LogSuccess(c, "run", "job", 0, name)
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message": "任务已触发"})
+}
+
+// UpdateJobRequest 更新定时任务请求
+type UpdateJobRequest struct {
+	Spec    *string `json:"spec"` // 调度表达式，空字符串表示恢复默认
+	Enabled *bool   `json:"enabled"`
+}
+
+// updateJob 更新定时任务调度表达式/启用状态 (仅管理员)
+func (s *Server) updateJob(c *gin.Context) {
+	_, isAdmin := getUserInfo(c)
+	if !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin only"})
+		return
+	}
+
+	var req UpdateJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.Param("name")
+	if err := s.svc.UpdateJob(name, req.Spec, req.Enabled); err != nil {
+		if errors.Is(err, service.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	detail := name
+	if req.Spec != nil {
+		detail += fmt.Sprintf(" spec=%q", *req.Spec)
+	}
+	if req.Enabled != nil {
+		detail += fmt.Sprintf(" enabled=%v", *req.Enabled)
+	}
+	s.audit.LogSuccess(c, "update", "job", 0, detail)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
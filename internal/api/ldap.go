@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== LDAP 认证 ====================
+
+// TestLDAPRequest LDAP 连接测试请求
+type TestLDAPRequest struct {
+	Configs  map[string]string `json:"configs"`  // 尚未保存的 LDAP 配置 (可选)
+	Username string            `json:"username"` // 测试用户 (可选)
+	Password string            `json:"password"`
+}
+
+// testLDAP 测试 LDAP 连接、服务账户绑定，以及可选的用户认证与组映射
+func (s *Server) testLDAP(c *gin.Context) {
+	_, isAdmin := getUserInfo(c)
+	if !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin only"})
+		return
+	}
+
+	var req TestLDAPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 设置页提交的密码占位符表示使用已保存的服务账户密码
+	dropUnchangedSecrets(req.Configs)
+
+	result := s.svc.TestLDAP(req.Configs, req.Username, req.Password)
+	if result.Success {
+		s.audit.LogSuccess(c, "test", "ldap", 0, result.Message)
+	} else {
+		s.audit.LogFailed(c, "test", "ldap", 0, result.Message)
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 节点远程命令 ====================
+
+// CreateNodeCommandRequest 创建远程命令请求
+type CreateNodeCommandRequest struct {
+	Type    string                 `json:"type" binding:"required"`
+	Params  map[string]interface{} `json:"params"`
+	Timeout int                    `json:"timeout"` // 执行超时 (秒)，0 使用默认值
+}
+
+// agentCommand 下发给 Agent 的命令
+type agentCommand struct {
+	ID      uint            `json:"id"`
+	Type    string          `json:"type"`
+	Params  json.RawMessage `json:"params"`
+	Timeout int             `json:"timeout"`
+}
+
+func agentCommands(cmds []model.NodeCommand) []agentCommand {
+	list := make([]agentCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		params := json.RawMessage(cmd.Params)
+		if len(params) == 0 {
+			params = json.RawMessage("{}")
+		}
+		list = append(list, agentCommand{ID: cmd.ID, Type: cmd.Type, Params: params, Timeout: cmd.Timeout})
+	}
+	return list
+}
+
+// AgentCommandResult Agent 上报的命令执行结果
+type AgentCommandResult struct {
+	Token   string `json:"token"` // 仅 HTTP 上报时需要
+	ID      uint   `json:"id" binding:"required"`
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+	Error   string `json:"error"`
+}
+
+func nodeCommandErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrNodeCommandNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrNodeCommandFinished):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// pushNodeCommands 通过控制通道下发节点待执行的命令
+func (s *Server) pushNodeCommands(a *agentConn) {
+	cmds, err := s.svc.TakePendingNodeCommands(a.id)
+	if err != nil {
+		log.Printf("Node %d: failed to load pending commands: %v", a.id, err)
+		return
+	}
+	for _, cmd := range agentCommands(cmds) {
+		a.push(agentCmdCommand, cmd)
+	}
+}
+
+// recordCommandResult 记录命令执行结果 (命令已取消或超时时忽略)
+func (s *Server) recordCommandResult(nodeID uint, res *AgentCommandResult) error {
+	err := s.svc.CompleteNodeCommand(nodeID, res.ID, res.Success, res.Output, res.Error)
+	if err != nil && !errors.Is(err, service.ErrNodeCommandFinished) {
+		log.Printf("Node %d: failed to record command #%d result: %v", nodeID, res.ID, err)
+	}
+	return err
+}
+
+// listNodeCommands 获取节点最近的远程命令
+func (s *Server) listNodeCommands(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if _, err := s.svc.GetNodeByOwner(uint(id), userID, isAdmin); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	cmds, err := s.svc.ListNodeCommands(uint(id), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"commands":        cmds,
+		"types":           service.NodeCommandTypes(),
+		"agent_connected": s.agentHub.Connected("node", uint(id)),
+	})
+}
+
+// createNodeCommand 创建远程命令，Agent 在线时通过控制通道立即下发
+func (s *Server) createNodeCommand(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if !s.svc.ResourceWritableBy("node", uint(id), userID, isAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点"})
+		return
+	}
+
+	var req CreateNodeCommandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cmd, err := s.svc.CreateNodeCommand(uint(id), req.Type, req.Params, req.Timeout, userID, c.GetString("username"))
+	if err != nil {
+		c.JSON(nodeCommandErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if a := s.agentHub.get("node", uint(id)); a != nil {
+		s.pushNodeCommands(a)
+		if sent, err := s.svc.GetNodeCommand(uint(id), cmd.ID); err == nil {
+			cmd = sent
+		}
+	}
+
+	s.audit.LogSuccess(c, "command", "node", uint(id), fmt.Sprintf("#%d %s %s", cmd.ID, cmd.Type, cmd.Params))
+	c.JSON(http.StatusOK, cmd)
+}
+
+// getNodeCommand 获取远程命令详情
+func (s *Server) getNodeCommand(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	commandID, _ := strconv.ParseUint(c.Param("commandId"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if _, err := s.svc.GetNodeByOwner(uint(id), userID, isAdmin); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点"})
+		return
+	}
+
+	cmd, err := s.svc.GetNodeCommand(uint(id), uint(commandID))
+	if err != nil {
+		c.JSON(nodeCommandErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cmd)
+}
+
+// cancelNodeCommand 取消尚未完成的远程命令
+func (s *Server) cancelNodeCommand(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	commandID, _ := strconv.ParseUint(c.Param("commandId"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if !s.svc.ResourceWritableBy("node", uint(id), userID, isAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此节点"})
+		return
+	}
+
+	if err := s.svc.CancelNodeCommand(uint(id), uint(commandID)); err != nil {
+		c.JSON(nodeCommandErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "cancel_command", "node", uint(id), fmt.Sprintf("#%d", commandID))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// agentCommandResult Agent 通过 HTTP 上报命令执行结果 (控制通道断开时)
+func (s *Server) agentCommandResult(c *gin.Context) {
+	var res AgentCommandResult
+	if err := c.ShouldBindJSON(&res); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if res.Token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+	node, err := s.svc.GetNodeByToken(res.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+
+	if err := s.recordCommandResult(node.ID, &res); err != nil {
+		c.JSON(nodeCommandErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
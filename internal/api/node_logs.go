@@ -0,0 +1,111 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 节点 GOST 日志 ====================
+
+// AgentLogBatch Agent 上报的 GOST 日志
+type AgentLogBatch struct {
+	Token   string                `json:"token"` // 仅 HTTP 上报时需要
+	Lines   []service.NodeLogLine `json:"lines"`
+	Dropped int                   `json:"dropped"` // Agent 缓冲溢出未能上报的行数
+}
+
+// recordNodeLogs 保存日志并推送给实时跟踪的客户端
+func (s *Server) recordNodeLogs(nodeID uint, batch *AgentLogBatch) error {
+	logs, err := s.svc.AddNodeLogs(nodeID, batch.Lines, batch.Dropped)
+	if err != nil {
+		log.Printf("Node %d: failed to save logs: %v", nodeID, err)
+		return err
+	}
+	s.BroadcastNodeLogs(nodeID, logs)
+	return nil
+}
+
+// getNodeLogs 查询节点 GOST 日志
+// 参数: level (逗号分隔，如 warn,error)、from/to (RFC3339 或 Unix 秒)、q 关键字、limit/offset
+func (s *Server) getNodeLogs(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 32)
+	userID, isAdmin := getUserInfo(c)
+	if _, err := s.svc.GetNodeByOwner(uint(id), userID, isAdmin); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问此节点"})
+		return
+	}
+
+	query := service.NodeLogQuery{Search: strings.TrimSpace(c.Query("q"))}
+	query.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "100"))
+	query.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if query.Limit <= 0 || query.Limit > 500 {
+		query.Limit = 100
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	if levels := c.Query("level"); levels != "" {
+		for _, level := range strings.Split(levels, ",") {
+			if level = strings.TrimSpace(level); level != "" {
+				query.Levels = append(query.Levels, strings.ToLower(level))
+			}
+		}
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		query.Since = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTimeParam(to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		query.Until = &t
+	}
+
+	logs, total, err := s.svc.GetNodeLogs(uint(id), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"logs":  logs,
+		"total": total,
+	})
+}
+
+// agentLogs Agent 通过 HTTP 上报 GOST 日志 (控制通道断开时)
+func (s *Server) agentLogs(c *gin.Context) {
+	var batch AgentLogBatch
+	if err := c.ShouldBindJSON(&batch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if batch.Token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+	node, err := s.svc.GetNodeByToken(batch.Token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return
+	}
+
+	if err := s.recordNodeLogs(node.ID, &batch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
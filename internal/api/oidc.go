@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// ==================== OpenID Connect 单点登录 ====================
+
+const (
+	oidcStateCookie = "gost_oidc"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// oidcRedirectURL 回调地址: 优先使用配置，其次为站点 URL
+func (s *Server) oidcRedirectURL(c *gin.Context, settings *service.OIDCSettings) string {
+	if settings.RedirectURL != "" {
+		return settings.RedirectURL
+	}
+	return s.getPanelURL(c) + "/api/oidc/callback"
+}
+
+// oidcLogin 发起 OIDC 授权码登录 (PKCE)
+func (s *Server) oidcLogin(c *gin.Context) {
+	settings := s.svc.GetOIDCSettings()
+	state := service.GenerateToken()
+	nonce := service.GenerateToken()
+	verifier := oauth2.GenerateVerifier()
+
+	// state/nonce/verifier 存入签名 Cookie，回调时校验
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"redirect": safeRedirectPath(c.Query("redirect")),
+		"exp":      time.Now().Add(oidcStateTTL).Unix(),
+	}).SignedString(s.oidcStateKey())
+	if err != nil {
+		s.oidcFail(c, "failed to create state")
+		return
+	}
+
+	authURL, err := s.svc.OIDCAuthURL(settings, s.oidcRedirectURL(c, settings), state, nonce, verifier)
+	if err != nil {
+		s.oidcFail(c, err.Error())
+		return
+	}
+	s.setOIDCCookie(c, cookie, int(oidcStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// oidcCallback OIDC 回调: 校验 state、兑换授权码、校验 ID Token 并签发登录令牌
+func (s *Server) oidcCallback(c *gin.Context) {
+	claims, err := s.readOIDCCookie(c)
+	s.setOIDCCookie(c, "", -1)
+	if err != nil {
+		s.oidcFail(c, "login session expired, please try again")
+		return
+	}
+	if errParam := c.Query("error"); errParam != "" {
+		s.oidcFail(c, strings.TrimSpace(errParam+" "+c.Query("error_description")))
+		return
+	}
+	if state, _ := claims["state"].(string); state == "" || state != c.Query("state") {
+		s.oidcFail(c, "invalid state")
+		return
+	}
+
+	settings := s.svc.GetOIDCSettings()
+	codeVerifier, _ := claims["verifier"].(string)
+	nonce, _ := claims["nonce"].(string)
+	identity, err := s.svc.OIDCExchange(c.Request.Context(), settings, s.oidcRedirectURL(c, settings),
+		c.Query("code"), codeVerifier, nonce)
+	if err != nil {
+		s.svc.LogOperation(0, "", "login", "oidc", 0, err.Error(), c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		s.oidcFail(c, "failed to verify identity")
+		return
+	}
+
+	user, err := s.svc.ResolveOIDCUser(settings, identity)
+	if err != nil {
+		s.svc.LogOperation(0, identity.Email, "login", "oidc", 0, err.Error(), c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		if errors.Is(err, service.ErrOIDCNoAccount) {
+			s.oidcFail(c, err.Error())
+		} else {
+			s.oidcFail(c, "failed to sign in")
+		}
+		return
+	}
+	if !user.Enabled {
+		s.svc.LogOperation(user.ID, user.Username, "login", "oidc", user.ID, "account disabled", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		s.oidcFail(c, "account disabled")
+		return
+	}
+	if service.AccountLocked(user) {
+		s.svc.LogOperation(user.ID, user.Username, "login", "oidc", user.ID, "account locked", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		s.oidcFail(c, service.ErrAccountLocked.Error())
+		return
+	}
+
+	// 已启用第二因素的账户仍需在登录页完成验证
+	redirect, _ := claims["redirect"].(string)
+	if factors := s.svc.SecondFactors(user); len(factors) > 0 {
+		tempToken, err := s.signTemp2FAToken(user)
+		if err != nil {
+			s.oidcFail(c, "failed to generate temp token")
+			return
+		}
+		fragment := url.Values{"oidc_temp_token": {tempToken}, "methods": {strings.Join(factors, ",")}}
+		if redirect != "" {
+			fragment.Set("redirect", redirect)
+		}
+		c.Redirect(http.StatusFound, "/login#"+fragment.Encode())
+		return
+	}
+
+	token, refreshToken, err := s.issueSessionToken(c, user)
+	if err != nil {
+		s.oidcFail(c, "failed to generate token")
+		return
+	}
+	s.loginLimiter.Reset(c.ClientIP())
+	s.svc.UpdateUserLoginInfo(user.ID, c.ClientIP())
+	s.svc.LogOperation(user.ID, user.Username, "login", "oidc", user.ID, "login success", c.ClientIP(), c.GetHeader("User-Agent"), "success")
+
+	// 令牌放在 URL fragment 中，不会发送到服务器或记录在访问日志
+	fragment := url.Values{"oidc_token": {token}, "oidc_refresh_token": {refreshToken}}
+	if redirect != "" {
+		fragment.Set("redirect", redirect)
+	}
+	c.Redirect(http.StatusFound, "/login#"+fragment.Encode())
+}
+
+// oidcFail 跳转回登录页并显示错误
+func (s *Server) oidcFail(c *gin.Context, message string) {
+	c.Redirect(http.StatusFound, "/login#"+url.Values{"oidc_error": {message}}.Encode())
+}
+
+func (s *Server) setOIDCCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	secure := strings.HasPrefix(s.getPanelURL(c), "https://")
+	c.SetCookie(oidcStateCookie, value, maxAge, "/api/oidc", "", secure, true)
+}
+
+func (s *Server) readOIDCCookie(c *gin.Context) (jwt.MapClaims, error) {
+	raw, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return s.oidcStateKey(), nil
+	})
+	return claims, err
+}
+
+// oidcStateKey 登录状态 Cookie 的签名密钥 (与会话 JWT 区分，防止被当作登录令牌使用)
+func (s *Server) oidcStateKey() []byte {
+	return []byte(s.cfg.JWTSecret + ":oidc-state")
+}
+
+// safeRedirectPath 仅允许站内相对路径，防止开放重定向
+func safeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, "\\") {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,395 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIssuer 进程内 OIDC 身份提供方 (discovery、JWKS、令牌端点)
+type testIssuer struct {
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu     sync.Mutex
+	grants map[string]testGrant
+}
+
+// testGrant 授权端点签发的授权码
+type testGrant struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{key: key, clientID: "gost-panel", grants: map[string]testGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                iss.srv.URL,
+			"authorization_endpoint":                iss.srv.URL + "/authorize",
+			"token_endpoint":                        iss.srv.URL + "/token",
+			"jwks_uri":                              iss.srv.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := &iss.key.PublicKey
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", iss.token)
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+// token 令牌端点: 授权码只能使用一次，并校验 PKCE code_verifier
+func (iss *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	iss.mu.Lock()
+	grant, ok := iss.grants[r.PostForm.Get("code")]
+	delete(iss.grants, r.PostForm.Get("code"))
+	iss.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   iss.srv.URL,
+		"aud":   iss.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(iss.key)
+	if err != nil {
+		writeTestJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeTestJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// authorize 模拟用户在 IdP 登录并同意授权，返回授权码
+// mutate 可修改签发时记录的 PKCE challenge 与 nonce
+func (iss *testIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims, mutate func(*testGrant)) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(authURL, iss.srv.URL+"/authorize") {
+		t.Fatalf("unexpected authorization endpoint: %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without PKCE S256: %s", authURL)
+	}
+	if q.Get("state") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorization request without state/nonce: %s", authURL)
+	}
+
+	grant := testGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	if mutate != nil {
+		mutate(&grant)
+	}
+	code := service.GenerateToken()
+	iss.mu.Lock()
+	iss.grants[code] = grant
+	iss.mu.Unlock()
+	return code
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newOIDCTestServer 创建启用 OIDC 的面板
+func newOIDCTestServer(t *testing.T, iss *testIssuer, configs map[string]string) *Server {
+	t.Helper()
+	settings := map[string]string{
+		model.ConfigSiteURL:      "http://panel.test",
+		model.ConfigOIDCEnabled:  "true",
+		model.ConfigOIDCIssuer:   iss.srv.URL,
+		model.ConfigOIDCClientID: iss.clientID,
+	}
+	for k, v := range configs {
+		settings[k] = v
+	}
+	return newTestServer(t, settings)
+}
+
+// oidcLoginFlow 走完一次登录: 发起登录、IdP 授权、回调，返回回调跳转的 URL fragment
+func oidcLoginFlow(t *testing.T, s *Server, iss *testIssuer, claims jwt.MapClaims, mutate func(*testGrant)) url.Values {
+	t.Helper()
+	authURL, cookie := startOIDCLogin(t, s)
+	u, _ := url.Parse(authURL)
+	code := iss.authorize(t, authURL, claims, mutate)
+	return oidcCallback(t, s, cookie, url.Values{"code": {code}, "state": {u.Query().Get("state")}})
+}
+
+func startOIDCLogin(t *testing.T, s *Server) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/oidc/login?redirect=/nodes", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("oidc login status = %d", w.Code)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			return w.Header().Get("Location"), c
+		}
+	}
+	t.Fatalf("oidc login did not set state cookie (location %s)", w.Header().Get("Location"))
+	return "", nil
+}
+
+func oidcCallback(t *testing.T, s *Server, cookie *http.Cookie, query url.Values) url.Values {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusFound || !strings.HasPrefix(location, "/login#") {
+		t.Fatalf("callback status = %d, location = %q", w.Code, location)
+	}
+	fragment, err := url.ParseQuery(strings.TrimPrefix(location, "/login#"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fragment
+}
+
+func identityClaims(sub, email string, verified bool, groups ...string) jwt.MapClaims {
+	claims := jwt.MapClaims{"sub": sub, "email": email, "email_verified": verified, "preferred_username": sub}
+	if len(groups) > 0 {
+		claims["groups"] = groups
+	}
+	return claims
+}
+
+func TestOIDCLoginProvisionsUserWithMappedRole(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, map[string]string{
+		// Keycloak 的组声明带路径前缀
+		model.ConfigOIDCRoleMapping: `[{"group":"panel-admins","role":"admin"}]`,
+	})
+
+	fragment := oidcLoginFlow(t, s, iss, identityClaims("alice", "alice@example.com", true, "/staff", "/panel-admins"), nil)
+	if fragment.Get("oidc_error") != "" || fragment.Get("oidc_token") == "" || fragment.Get("oidc_refresh_token") == "" {
+		t.Fatalf("login failed: %v", fragment)
+	}
+	if fragment.Get("redirect") != "/nodes" {
+		t.Errorf("redirect = %q, want /nodes", fragment.Get("redirect"))
+	}
+
+	user, err := s.svc.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != service.RoleAdmin || user.AuthProvider != service.AuthProviderOIDC {
+		t.Errorf("provisioned user role/provider = %s/%s", user.Role, user.AuthProvider)
+	}
+	if user.OIDCSubject == nil || *user.OIDCSubject != iss.srv.URL+"|alice" {
+		t.Errorf("oidc subject = %v", user.OIDCSubject)
+	}
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, nil)
+
+	authURL, cookie := startOIDCLogin(t, s)
+	code := iss.authorize(t, authURL, identityClaims("alice", "", false), nil)
+	fragment := oidcCallback(t, s, cookie, url.Values{"code": {code}, "state": {"forged"}})
+	if fragment.Get("oidc_error") != "invalid state" {
+		t.Errorf("state mismatch: %v", fragment)
+	}
+
+	// 缺少登录状态 Cookie
+	u, _ := url.Parse(authURL)
+	fragment = oidcCallback(t, s, nil, url.Values{"code": {code}, "state": {u.Query().Get("state")}})
+	if fragment.Get("oidc_error") == "" || fragment.Get("oidc_token") != "" {
+		t.Errorf("callback without state cookie: %v", fragment)
+	}
+}
+
+func TestOIDCCallbackRejectsWrongPKCEVerifier(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, nil)
+
+	fragment := oidcLoginFlow(t, s, iss, identityClaims("alice", "", false), func(g *testGrant) {
+		sum := sha256.Sum256([]byte("another-verifier"))
+		g.challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	})
+	if fragment.Get("oidc_error") != "failed to verify identity" || fragment.Get("oidc_token") != "" {
+		t.Errorf("wrong PKCE verifier: %v", fragment)
+	}
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, nil)
+
+	fragment := oidcLoginFlow(t, s, iss, identityClaims("alice", "", false), func(g *testGrant) {
+		g.nonce = "replayed-nonce"
+	})
+	if fragment.Get("oidc_error") != "failed to verify identity" || fragment.Get("oidc_token") != "" {
+		t.Errorf("nonce mismatch: %v", fragment)
+	}
+	if _, err := s.svc.GetUserByUsername("alice"); err == nil {
+		t.Error("user provisioned despite invalid nonce")
+	}
+}
+
+func TestOIDCLinksLocalUserByVerifiedEmail(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, map[string]string{model.ConfigOIDCAutoProvision: "false"})
+	local, err := s.svc.CreateUserFull("bob", "bob@example.com", testPassword, service.RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragment := oidcLoginFlow(t, s, iss, identityClaims("kc-bob", "bob@example.com", true), nil)
+	if fragment.Get("oidc_token") == "" {
+		t.Fatalf("login with verified email failed: %v", fragment)
+	}
+	user, _ := s.svc.GetUser(local.ID)
+	if user.OIDCSubject == nil || *user.OIDCSubject != iss.srv.URL+"|kc-bob" {
+		t.Errorf("local user not linked: %v", user.OIDCSubject)
+	}
+}
+
+func TestOIDCRefusesUnverifiedEmailLink(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, nil)
+	local, err := s.svc.CreateUserFull("bob", "bob@example.com", testPassword, service.RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragment := oidcLoginFlow(t, s, iss, identityClaims("mallory", "bob@example.com", false), nil)
+	if fragment.Get("oidc_token") == "" {
+		t.Fatalf("provisioning login failed: %v", fragment)
+	}
+	user, _ := s.svc.GetUser(local.ID)
+	if user.OIDCSubject != nil {
+		t.Errorf("unverified email linked local user to %s", *user.OIDCSubject)
+	}
+
+	// 自动创建的账户不能占用已存在的邮箱
+	created, err := s.svc.GetUserByUsername("mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == local.ID || created.Email != nil {
+		t.Errorf("provisioned user = id %d email %v", created.ID, created.Email)
+	}
+}
+
+func TestOIDCNoAccountWithoutAutoProvision(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, map[string]string{model.ConfigOIDCAutoProvision: "false"})
+
+	fragment := oidcLoginFlow(t, s, iss, identityClaims("carol", "carol@example.com", true), nil)
+	if fragment.Get("oidc_error") != service.ErrOIDCNoAccount.Error() {
+		t.Errorf("unknown identity without auto provisioning: %v", fragment)
+	}
+	if _, err := s.svc.GetUserByUsername("carol"); err == nil {
+		t.Error("user provisioned although auto provisioning is disabled")
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	iss := newTestIssuer(t)
+	s := newOIDCTestServer(t, iss, nil)
+
+	fragment := oidcLoginFlow(t, s, iss, identityClaims("dave", "dave@example.com", true), nil)
+	if fragment.Get("oidc_token") == "" {
+		t.Fatalf("first login failed: %v", fragment)
+	}
+	user, err := s.svc.GetUserByUsername("dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.svc.DB().Model(user).Updates(map[string]interface{}{
+		"two_factor_enabled": true,
+		"two_factor_secret":  "JBSWY3DPEHPK3PXP",
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 启用 TOTP 后，OIDC 登录只签发第二因素临时令牌
+	fragment = oidcLoginFlow(t, s, iss, identityClaims("dave", "dave@example.com", true), nil)
+	if fragment.Get("oidc_token") != "" || fragment.Get("oidc_refresh_token") != "" {
+		t.Fatalf("session issued without second factor: %v", fragment)
+	}
+	if fragment.Get("oidc_temp_token") == "" || fragment.Get("methods") != service.SecondFactorTOTP {
+		t.Fatalf("missing second-factor handoff: %v", fragment)
+	}
+	if fragment.Get("redirect") != "/nodes" {
+		t.Errorf("redirect = %q, want /nodes", fragment.Get("redirect"))
+	}
+
+	// 临时令牌不能当作会话令牌使用
+	req := httptest.NewRequest(http.MethodGet, "/api/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+fragment.Get("oidc_temp_token"))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("temp token accepted as session: status %d", w.Code)
+	}
+}
@@ -0,0 +1,426 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 组织 (多租户) ====================
+
+// orgErrorStatus 组织相关错误对应的 HTTP 状态码
+func orgErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrOrgNotFound), errors.Is(err, service.ErrOrgNotMember):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrOrgForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrOrgLastOwner), errors.Is(err, service.ErrOrgMemberExists):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// requireOrgRole 校验当前用户在组织中的角色，失败时写入响应并返回 false
+func (s *Server) requireOrgRole(c *gin.Context, orgID uint, minRole string) bool {
+	userID, isAdmin := getUserInfo(c)
+	if err := s.svc.RequireOrgRole(orgID, userID, isAdmin, minRole); err != nil {
+		c.JSON(orgErrorStatus(err), gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+func (s *Server) listOrganizations(c *gin.Context) {
+	userID, isAdmin := getUserInfo(c)
+	orgs, err := s.svc.ListOrganizations(userID, isAdmin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, orgs)
+}
+
+func (s *Server) getOrganization(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleMember) {
+		return
+	}
+	userID, _ := getUserInfo(c)
+	org, err := s.svc.GetOrganization(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	org.MyRole = s.svc.OrgRole(id, userID)
+	c.JSON(http.StatusOK, org)
+}
+
+func (s *Server) createOrganization(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	org := &model.Organization{Name: req.Name, Description: req.Description}
+	if err := s.svc.CreateOrganization(org, userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "create", "organization", org.ID, org.Name)
+	c.JSON(http.StatusOK, org)
+}
+
+func (s *Server) updateOrganization(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleAdmin) {
+		return
+	}
+	_, isAdmin := getUserInfo(c)
+
+	var req struct {
+		Name         string `json:"name" binding:"required"`
+		Description  string `json:"description"`
+		TrafficQuota *int64 `json:"traffic_quota"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.UpdateOrganization(id, req.Name, req.Description); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 组织配额仅管理员可修改，组织管理员提交的配额字段被忽略
+	if req.TrafficQuota != nil && isAdmin {
+		if err := s.svc.UpdateOrganizationQuota(id, *req.TrafficQuota); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	s.audit.LogSuccess(c, "update", "organization", id, req.Name)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) deleteOrganization(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleOwner) {
+		return
+	}
+	userID, _ := getUserInfo(c)
+
+	if err := s.svc.DeleteOrganization(id, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "delete", "organization", id, "")
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ==================== 组织成员 ====================
+
+func (s *Server) listOrgMembers(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleMember) {
+		return
+	}
+	members, err := s.svc.ListOrgMembers(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+func (s *Server) addOrgMember(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleAdmin) {
+		return
+	}
+
+	var req struct {
+		UserID   uint   `json:"user_id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID == 0 && req.Username != "" {
+		user, err := s.svc.GetUserByUsername(req.Username)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		req.UserID = user.ID
+	}
+	if req.Role == "" {
+		req.Role = service.OrgRoleMember
+	}
+	if !s.canGrantOrgRole(c, id, req.Role) {
+		return
+	}
+
+	member, err := s.svc.AddOrgMember(id, req.UserID, req.Role)
+	if err != nil {
+		c.JSON(orgErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "add_member", "organization", id, fmt.Sprintf("user #%d as %s", req.UserID, req.Role))
+	c.JSON(http.StatusOK, member)
+}
+
+func (s *Server) updateOrgMember(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleAdmin) {
+		return
+	}
+	memberID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户 ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 授予或撤销所有者角色需要所有者权限
+	if s.svc.OrgRole(id, uint(memberID)) == service.OrgRoleOwner && !s.canGrantOrgRole(c, id, service.OrgRoleOwner) {
+		return
+	}
+	if !s.canGrantOrgRole(c, id, req.Role) {
+		return
+	}
+
+	if err := s.svc.UpdateOrgMemberRole(id, uint(memberID), req.Role); err != nil {
+		c.JSON(orgErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "update_member", "organization", id, fmt.Sprintf("user #%d as %s", memberID, req.Role))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) removeOrgMember(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户 ID"})
+		return
+	}
+
+	// 成员可以自行退出组织，移除他人需要组织管理员
+	userID, _ := getUserInfo(c)
+	minRole := service.OrgRoleAdmin
+	if uint(memberID) == userID {
+		minRole = service.OrgRoleMember
+	}
+	if !s.requireOrgRole(c, id, minRole) {
+		return
+	}
+	if uint(memberID) != userID && s.svc.OrgRole(id, uint(memberID)) == service.OrgRoleOwner &&
+		!s.canGrantOrgRole(c, id, service.OrgRoleOwner) {
+		return
+	}
+
+	if err := s.svc.RemoveOrgMember(id, uint(memberID)); err != nil {
+		c.JSON(orgErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "remove_member", "organization", id, fmt.Sprintf("user #%d", memberID))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// canGrantOrgRole 仅组织所有者 (或全局管理员) 可以授予所有者角色
+func (s *Server) canGrantOrgRole(c *gin.Context, orgID uint, role string) bool {
+	if role != service.OrgRoleOwner {
+		return true
+	}
+	return s.requireOrgRole(c, orgID, service.OrgRoleOwner)
+}
+
+// ==================== 组织资源 ====================
+
+func (s *Server) listOrgResources(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleMember) {
+		return
+	}
+	resources, err := s.svc.ListOrgResources(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resources)
+}
+
+func (s *Server) assignOrgResource(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleMember) {
+		return
+	}
+	userID, isAdmin := getUserInfo(c)
+
+	var req struct {
+		Type string `json:"type" binding:"required"`
+		ID   uint   `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.AssignResourceToOrg(id, req.Type, req.ID, userID, isAdmin); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "assign_resource", "organization", id, fmt.Sprintf("%s #%d", req.Type, req.ID))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) removeOrgResource(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgRole(c, id, service.OrgRoleMember) {
+		return
+	}
+	resourceID, err := strconv.ParseUint(c.Param("rid"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的资源 ID"})
+		return
+	}
+	resourceType := c.Param("type")
+
+	// 普通成员只能移出自己的资源
+	userID, isAdmin := getUserInfo(c)
+	ownerOnly := !isAdmin && s.svc.OrgRole(id, userID) == service.OrgRoleMember
+
+	if err := s.svc.RemoveResourceFromOrg(id, resourceType, uint(resourceID), userID, ownerOnly); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "remove_resource", "organization", id, fmt.Sprintf("%s #%d", resourceType, resourceID))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ==================== 组织套餐与配额 ====================
+
+func (s *Server) assignOrgPlan(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgAdminScope(c, id) {
+		return
+	}
+
+	var req struct {
+		PlanID uint `json:"plan_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.AssignOrgPlan(id, req.PlanID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "assign_plan", "organization", id, fmt.Sprintf("plan #%d", req.PlanID))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) removeOrgPlan(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgAdminScope(c, id) {
+		return
+	}
+
+	if err := s.svc.RemoveOrgPlan(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "remove_plan", "organization", id, "")
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) renewOrgPlan(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgAdminScope(c, id) {
+		return
+	}
+
+	var req struct {
+		Days int `json:"days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "续期天数必须大于0"})
+		return
+	}
+
+	if err := s.svc.RenewOrgPlan(id, req.Days); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "renew_plan", "organization", id, fmt.Sprintf("%d days", req.Days))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) resetOrgQuota(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok || !s.requireOrgAdminScope(c, id) {
+		return
+	}
+
+	if err := s.svc.ResetOrgQuota(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "reset_quota", "organization", id, "")
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// requireOrgAdminScope 组织套餐与配额由可访问所有用户资源的角色 (管理员/计费) 管理
+func (s *Server) requireOrgAdminScope(c *gin.Context, orgID uint) bool {
+	_, isAdmin := getUserInfo(c)
+	if !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin only"})
+		return false
+	}
+	if _, err := s.svc.GetOrganization(orgID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+)
+
+// orgFixture 组织 "team": alice 为所有者，dave 为管理员，bob 与 carol (系统角色 viewer) 为成员
+type orgFixture struct {
+	s      *Server
+	org    *model.Organization
+	users  map[string]*model.User
+	tokens map[string]string
+}
+
+func newOrgFixture(t *testing.T) *orgFixture {
+	t.Helper()
+	f := &orgFixture{s: newTestServer(t, nil), users: map[string]*model.User{}, tokens: map[string]string{}}
+	roles := map[string]string{"alice": service.RoleUser, "bob": service.RoleUser, "carol": service.RoleViewer, "dave": service.RoleUser, "eve": service.RoleUser}
+	for name, role := range roles {
+		f.users[name] = createTestUser(t, f.s, name, role)
+		f.tokens[name] = loginAs(t, f.s, name)
+	}
+
+	f.org = &model.Organization{Name: "team"}
+	if err := f.s.svc.CreateOrganization(f.org, f.users["alice"].ID); err != nil {
+		t.Fatal(err)
+	}
+	members := map[string]string{"bob": service.OrgRoleMember, "carol": service.OrgRoleMember, "dave": service.OrgRoleAdmin}
+	for name, role := range members {
+		if _, err := f.s.svc.AddOrgMember(f.org.ID, f.users[name].ID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// createNode 创建节点，owner 非空时归属该用户，inOrg 时由所有者划入组织
+func (f *orgFixture) createNode(t *testing.T, name, owner string, inOrg bool) *model.Node {
+	t.Helper()
+	node := &model.Node{Name: name, Host: name + ".example.com", Port: 1080}
+	if owner != "" {
+		node.OwnerID = &f.users[owner].ID
+	}
+	if err := f.s.svc.CreateNode(node); err != nil {
+		t.Fatal(err)
+	}
+	if inOrg {
+		if err := f.s.svc.AssignResourceToOrg(f.org.ID, "node", node.ID, f.users[owner].ID, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return node
+}
+
+func (f *orgFixture) nodeExists(id uint) bool {
+	_, err := f.s.svc.GetNode(id)
+	return err == nil
+}
+
+func TestOrgMembersCannotModifyOthersNodes(t *testing.T) {
+	f := newOrgFixture(t)
+	node := f.createNode(t, "shared", "alice", true)
+	path := fmt.Sprintf("/api/nodes/%d", node.ID)
+
+	for _, name := range []string{"bob", "carol"} {
+		// 组织成员可以查看组织资源
+		if w := doJSON(f.s, http.MethodGet, path, f.tokens[name], nil); w.Code != http.StatusOK {
+			t.Errorf("%s get org node: status %d", name, w.Code)
+		}
+		if w := doJSON(f.s, http.MethodPut, path, f.tokens[name], map[string]string{"name": "renamed-by-" + name}); w.Code != http.StatusForbidden {
+			t.Errorf("%s update org node: status %d, want 403", name, w.Code)
+		}
+		if w := doJSON(f.s, http.MethodDelete, path, f.tokens[name], nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s delete org node: status %d, want 403", name, w.Code)
+		}
+		if w := doJSON(f.s, http.MethodPost, "/api/nodes/batch-delete", f.tokens[name], map[string][]uint{"ids": {node.ID}}); w.Code == http.StatusOK && !f.nodeExists(node.ID) {
+			t.Errorf("%s batch-deleted org node", name)
+		}
+	}
+
+	// 组织外用户既看不到也不能修改
+	if w := doJSON(f.s, http.MethodGet, path, f.tokens["eve"], nil); w.Code != http.StatusNotFound {
+		t.Errorf("outsider get org node: status %d, want 404", w.Code)
+	}
+	if w := doJSON(f.s, http.MethodPut, path, f.tokens["eve"], map[string]string{"name": "renamed-by-eve"}); w.Code != http.StatusForbidden {
+		t.Errorf("outsider update org node: status %d, want 403", w.Code)
+	}
+
+	stored, err := f.s.svc.GetNode(node.ID)
+	if err != nil {
+		t.Fatalf("org node deleted by a member: %v", err)
+	}
+	if stored.Name != "shared" {
+		t.Errorf("org node renamed to %q by a member", stored.Name)
+	}
+}
+
+func TestOrgAdminsAndOwnersModifyOrgNodes(t *testing.T) {
+	f := newOrgFixture(t)
+	node := f.createNode(t, "shared", "bob", true)
+	path := fmt.Sprintf("/api/nodes/%d", node.ID)
+
+	// 组织管理员可以修改成员划入组织的资源
+	if w := doJSON(f.s, http.MethodPut, path, f.tokens["dave"], map[string]string{"name": "renamed"}); w.Code != http.StatusOK {
+		t.Fatalf("org admin update: status %d body %s", w.Code, w.Body.String())
+	}
+	// 资源所有者仍可修改自己的资源
+	if w := doJSON(f.s, http.MethodPut, path, f.tokens["bob"], map[string]string{"name": "renamed-again"}); w.Code != http.StatusOK {
+		t.Fatalf("resource owner update: status %d", w.Code)
+	}
+	if w := doJSON(f.s, http.MethodDelete, path, f.tokens["alice"], nil); w.Code != http.StatusOK {
+		t.Fatalf("org owner delete: status %d body %s", w.Code, w.Body.String())
+	}
+	if f.nodeExists(node.ID) {
+		t.Error("node still exists after delete by org owner")
+	}
+}
+
+func TestPublicNodesReadOnlyForUsers(t *testing.T) {
+	f := newOrgFixture(t)
+	node := f.createNode(t, "public", "", false)
+	path := fmt.Sprintf("/api/nodes/%d", node.ID)
+
+	if w := doJSON(f.s, http.MethodGet, path, f.tokens["bob"], nil); w.Code != http.StatusOK {
+		t.Errorf("get public node: status %d", w.Code)
+	}
+	if w := doJSON(f.s, http.MethodPut, path, f.tokens["bob"], map[string]string{"name": "taken"}); w.Code != http.StatusForbidden {
+		t.Errorf("update public node: status %d, want 403", w.Code)
+	}
+	if w := doJSON(f.s, http.MethodDelete, path, f.tokens["dave"], nil); w.Code != http.StatusForbidden {
+		t.Errorf("delete public node: status %d, want 403", w.Code)
+	}
+	if w := doJSON(f.s, http.MethodPost, "/api/nodes/batch-delete", f.tokens["bob"], map[string][]uint{"ids": {node.ID}}); w.Code == http.StatusOK && !f.nodeExists(node.ID) {
+		t.Error("public node batch-deleted by a user")
+	}
+	// 组织成员不能把他人的组织资源或公共资源划入其他组织
+	if err := f.s.svc.AssignResourceToOrg(f.org.ID, "node", node.ID, f.users["bob"].ID, false); err == nil {
+		t.Error("member assigned a public node to the organization")
+	}
+}
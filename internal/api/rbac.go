@@ -0,0 +1,279 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 角色权限 (RBAC) ====================
+
+// selfResources 仅涉及当前用户自身的接口，所有角色均可访问
+var selfResources = map[string]bool{
+	"profile":         true,
+	"change-password": true,
+	"sessions":        true,
+	"logout":          true,
+}
+
+// resourceAliases 路由前缀到权限资源的映射 (未列出的前缀即资源名)
+var resourceAliases = map[string]string{
+	"stats":            "dashboard",
+	"search":           "dashboard",
+	"health-summary":   "nodes",
+	"config-versions":  "nodes",
+	"client-templates": "templates",
+	"export":           "backup",
+	"import":           "backup",
+	"restore":          "backup",
+	"permissions":      "roles",
+}
+
+// configSuffixes 返回生成配置/凭据的子路由
+var configSuffixes = map[string]bool{
+	"gost-config":     true,
+	"install-script":  true,
+	"proxy-uri":       true,
+	"entry-config":    true,
+	"exit-config":     true,
+	"config":          true,
+	"config-versions": true,
+}
+
+// planSuffixes 用户/组织套餐与配额相关子路由，归属 plans 资源
+var planSuffixes = map[string]bool{
+	"reset-quota": true,
+	"assign-plan": true,
+	"remove-plan": true,
+	"renew-plan":  true,
+}
+
+// routePermission 根据路由推导所需权限，resource 为空表示无需授权
+func routePermission(method, fullPath string) (resource, action string) {
+	// /metrics 等 /api 之外的路由同样以首段为资源
+	segments := strings.Split(strings.TrimPrefix(strings.TrimPrefix(fullPath, "/api"), "/"), "/")
+	prefix := segments[0]
+	last := segments[len(segments)-1]
+
+	if selfResources[prefix] {
+		return "", ""
+	}
+
+	resource = prefix
+	if alias, ok := resourceAliases[prefix]; ok {
+		resource = alias
+	}
+	if (prefix == "users" || prefix == "organizations") && planSuffixes[last] {
+		resource = "plans"
+	}
+
+	switch {
+	case method == http.MethodGet && (configSuffixes[last] || prefix == "config-versions"):
+		action = service.ActionConfig
+	case method == http.MethodGet || method == http.MethodHead:
+		action = service.ActionRead
+	default:
+		action = service.ActionWrite
+	}
+	return resource, action
+}
+
+// permissionMiddleware 按 资源×操作 校验当前用户角色的权限
+// 通过后在上下文中记录权限集合与作用范围，getUserInfo 据此判断是否可访问所有用户的资源
+func (s *Server) permissionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := getUserInfo(c)
+		perms, err := s.svc.GetUserPermissions(userID)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "role not found"})
+			c.Abort()
+			return
+		}
+		c.Set("role", perms.Role)
+		c.Set("permissions", perms)
+
+		resource, action := routePermission(c.Request.Method, c.FullPath())
+		tokenPerms, isToken := c.Get("api_token_scopes")
+		if resource == "" {
+			// 个人账户接口 (含令牌管理) 不允许通过 API 令牌访问
+			if isToken {
+				c.JSON(http.StatusForbidden, gin.H{"error": "not available for api tokens"})
+				c.Abort()
+				return
+			}
+			c.Set("scope_all", perms.Role == service.RoleAdmin)
+			c.Next()
+			return
+		}
+
+		// 角色要求 WebAuthn / 2FA 但尚未注册时，仅允许访问个人账户接口 (用于注册)
+		switch s.svc.SecondFactorSetupRequired(userID, perms.Role) {
+		case service.SetupWebAuthn:
+			c.JSON(http.StatusForbidden, gin.H{"error": "security key registration required", "code": "WEBAUTHN_SETUP_REQUIRED"})
+			c.Abort()
+			return
+		case service.SetupTwoFactor:
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication setup required", "code": "TWO_FACTOR_SETUP_REQUIRED"})
+			c.Abort()
+			return
+		}
+
+		if !containsResource(resource) || !perms.Allow(resource, action) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "permission denied: " + resource + ":" + action})
+			c.Abort()
+			return
+		}
+		// API 令牌的权限为角色权限与令牌授权范围的交集
+		if isToken && !tokenPerms.(*service.RolePermissions).Allow(resource, action) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "token scope does not permit " + resource + ":" + action})
+			c.Abort()
+			return
+		}
+
+		c.Set("scope_all", perms.AllScope())
+		c.Next()
+
+		if isToken && action != service.ActionRead && action != service.ActionConfig {
+			s.auditAPITokenRequest(c, resource)
+		}
+	}
+}
+
+func containsResource(resource string) bool {
+	for _, r := range service.PermissionResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPermission 判断当前用户是否拥有指定权限 (API 令牌同时受授权范围限制)
+func hasPermission(c *gin.Context, resource, action string) bool {
+	perms, ok := c.Get("permissions")
+	if !ok || !perms.(*service.RolePermissions).Allow(resource, action) {
+		return false
+	}
+	if tokenPerms, ok := c.Get("api_token_scopes"); ok {
+		return tokenPerms.(*service.RolePermissions).Allow(resource, action)
+	}
+	return true
+}
+
+// getProfilePermissions 获取当前用户的角色与权限
+func (s *Server) getProfilePermissions(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	role, err := s.svc.GetUserRole(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// getPermissions 获取权限资源与操作列表
+func (s *Server) getPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"resources": service.PermissionResources,
+		"actions":   service.PermissionActions,
+		"scopes":    []string{service.RoleScopeAll, service.RoleScopeOwn},
+	})
+}
+
+// listRoles 获取角色列表 (内置 + 自定义)
+func (s *Server) listRoles(c *gin.Context) {
+	roles, err := s.svc.ListRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// getRole 获取角色详情 (:id 为自定义角色 ID 或角色名)
+func (s *Server) getRole(c *gin.Context) {
+	var role *model.Role
+	var err error
+	if id, parseErr := strconv.ParseUint(c.Param("id"), 10, 32); parseErr == nil {
+		role, err = s.svc.GetRole(uint(id))
+	} else {
+		role, err = s.svc.GetRoleByName(c.Param("id"))
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// createRole 创建自定义角色
+func (s *Server) createRole(c *gin.Context) {
+	var role model.Role
+	if err := c.ShouldBindJSON(&role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role.ID = 0
+
+	if err := s.svc.CreateRole(&role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "create", "role", role.ID, role.Name)
+	c.JSON(http.StatusOK, role)
+}
+
+// updateRole 更新自定义角色
+func (s *Server) updateRole(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var role model.Role
+	if err := c.ShouldBindJSON(&role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.UpdateRole(id, &role); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	s.audit.LogSuccess(c, "update", "role", id, role.Name)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// deleteRole 删除自定义角色
+func (s *Server) deleteRole(c *gin.Context) {
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	if err := s.svc.DeleteRole(id); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+
+	s.audit.LogSuccess(c, "delete", "role", id, "")
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func respondRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
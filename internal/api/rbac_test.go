@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+)
+
+func TestRoutePermission(t *testing.T) {
+	cases := []struct {
+		method, path     string
+		resource, action string
+	}{
+		{http.MethodGet, "/api/nodes", "nodes", service.ActionRead},
+		{http.MethodPost, "/api/nodes", "nodes", service.ActionWrite},
+		{http.MethodDelete, "/api/nodes/:id", "nodes", service.ActionWrite},
+		{http.MethodGet, "/api/nodes/:id/gost-config", "nodes", service.ActionConfig},
+		{http.MethodGet, "/api/clients/:id/install-script", "clients", service.ActionConfig},
+		{http.MethodGet, "/api/config-versions/:versionId", "nodes", service.ActionConfig},
+		{http.MethodGet, "/api/stats", "dashboard", service.ActionRead},
+		{http.MethodGet, "/api/export", "backup", service.ActionRead},
+		{http.MethodPost, "/api/users/:id/reset-quota", "plans", service.ActionWrite},
+		{http.MethodPost, "/api/organizations/:id/assign-plan", "plans", service.ActionWrite},
+		{http.MethodPost, "/api/users/:id/verify-email", "users", service.ActionWrite},
+		{http.MethodGet, "/metrics", "metrics", service.ActionRead},
+		{http.MethodGet, "/api/profile", "", ""},
+		{http.MethodPost, "/api/change-password", "", ""},
+	}
+	for _, tc := range cases {
+		resource, action := routePermission(tc.method, tc.path)
+		if resource != tc.resource || action != tc.action {
+			t.Errorf("routePermission(%s %s) = %q, %q; want %q, %q", tc.method, tc.path, resource, action, tc.resource, tc.action)
+		}
+	}
+}
+
+func TestPermissionMiddlewareDeniesViewerWrites(t *testing.T) {
+	s := newTestServer(t, nil)
+	createTestUser(t, s, "viewer", service.RoleViewer)
+	token := loginAs(t, s, "viewer")
+
+	// 只读角色可以查看
+	for _, path := range []string{"/api/nodes", "/api/tunnels", "/api/stats"} {
+		if w := doJSON(s, http.MethodGet, path, token, nil); w.Code != http.StatusOK {
+			t.Errorf("viewer GET %s: status %d", path, w.Code)
+		}
+	}
+
+	writes := []struct{ method, path string }{
+		{http.MethodPost, "/api/nodes"},
+		{http.MethodPut, "/api/nodes/1"},
+		{http.MethodDelete, "/api/nodes/1"},
+		{http.MethodPost, "/api/tunnels"},
+		{http.MethodPost, "/api/users"},
+		{http.MethodPut, "/api/site-configs"},
+	}
+	for _, r := range writes {
+		if w := doJSON(s, r.method, r.path, token, map[string]string{"name": "x"}); w.Code != http.StatusForbidden {
+			t.Errorf("viewer %s %s: status %d, want 403", r.method, r.path, w.Code)
+		}
+	}
+	// 不可查看含凭据的配置
+	if w := doJSON(s, http.MethodGet, "/api/nodes/1/gost-config", token, nil); w.Code != http.StatusForbidden {
+		t.Errorf("viewer gost-config: status %d, want 403", w.Code)
+	}
+}
+
+func TestMetricsRequiresPermission(t *testing.T) {
+	s := newTestServer(t, nil)
+	createTestUser(t, s, "alice", service.RoleUser)
+	createTestUser(t, s, "viewer", service.RoleViewer)
+
+	if w := doJSON(s, http.MethodGet, "/metrics", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous /metrics: status %d, want 401", w.Code)
+	}
+	if w := doJSON(s, http.MethodGet, "/metrics", loginAs(t, s, "alice"), nil); w.Code != http.StatusForbidden {
+		t.Errorf("user /metrics: status %d, want 403", w.Code)
+	}
+	if w := doJSON(s, http.MethodGet, "/metrics", loginAs(t, s, "viewer"), nil); w.Code != http.StatusOK {
+		t.Errorf("viewer /metrics: status %d, want 200", w.Code)
+	}
+}
+
+func TestTwoFactorSetupRequiredBlocksResources(t *testing.T) {
+	s := newTestServer(t, map[string]string{model.ConfigTwoFactorRequiredRoles: service.RoleUser})
+	createTestUser(t, s, "alice", service.RoleUser)
+	token := loginAs(t, s, "alice")
+
+	w := doJSON(s, http.MethodGet, "/api/nodes", token, nil)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "TWO_FACTOR_SETUP_REQUIRED") {
+		t.Errorf("GET /api/nodes: status %d body %s, want 403 TWO_FACTOR_SETUP_REQUIRED", w.Code, w.Body.String())
+	}
+	// 个人账户接口仍可访问 (用于完成 2FA 注册)
+	if w := doJSON(s, http.MethodGet, "/api/profile", token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /api/profile: status %d, want 200", w.Code)
+	}
+}
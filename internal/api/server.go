@@ -3,6 +3,7 @@ package api
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -32,6 +33,7 @@ type Server struct {
 	loginLimiter *RateLimiter
 	audit        *AuditLogger
 	wsHub        *WSHub
+	agentHub     *AgentHub
 	// API rate limiters
 	globalAPILimiter *APIRateLimiter
 	writeAPILimiter  *APIRateLimiter
@@ -89,6 +91,7 @@ func NewServer(svc *service.Service, cfg *config.Config) *Server {
 		loginLimiter:     NewRateLimiter(5, time.Minute, 5*time.Minute), // 每分钟5次，封锁5分钟
 		audit:            NewAuditLogger(svc),
 		wsHub:            NewWSHub(),
+		agentHub:         NewAgentHub(),
 		globalAPILimiter: NewAPIRateLimiter(200, time.Minute),           // 全局 API 限流: 每分钟 200 次
 		writeAPILimiter:  NewAPIRateLimiter(30, time.Minute),            // 写操作限流: 每分钟 30 次
 	}
@@ -103,6 +106,13 @@ func NewServer(svc *service.Service, cfg *config.Config) *Server {
 	// Start WebSocket hub
 	go s.wsHub.Run()
 
+	// Agent 控制通道配置变更检查
+	go s.runAgentSweeper()
+
+	// 告警日志与操作日志写入后实时推送
+	s.svc.SetOperationLogHook(s.BroadcastOperationLog)
+	s.svc.GetAlertService().SetLogHook(s.BroadcastAlertLog)
+
 	// 初始化默认网站配置
 	s.svc.InitDefaultSiteConfigs()
 
@@ -115,8 +125,8 @@ func (s *Server) setupRoutes() {
 	// Prometheus 指标中间件
 	s.router.Use(PrometheusMiddleware())
 
-	// Prometheus 指标端点 (需要认证)
-	s.router.GET("/metrics", s.authMiddleware(), MetricsHandler())
+	// Prometheus 指标端点 (需要 metrics 读权限，包含全部用户的资源统计)
+	s.router.GET("/metrics", s.authMiddleware(), s.permissionMiddleware(), MetricsHandler())
 
 	// API 路由
 	api := s.router.Group("/api")
@@ -127,6 +137,10 @@ func (s *Server) setupRoutes() {
 		// 公开接口 (带限流)
 		api.POST("/login", RateLimitMiddleware(s.loginLimiter), s.login)
 		api.POST("/login/2fa", RateLimitMiddleware(s.loginLimiter), s.login2FA)
+		api.POST("/login/webauthn/begin", RateLimitMiddleware(s.loginLimiter), s.loginWebAuthnBegin)
+		api.POST("/login/webauthn/finish", RateLimitMiddleware(s.loginLimiter), s.loginWebAuthnFinish)
+		api.POST("/login/passkey/begin", RateLimitMiddleware(s.loginLimiter), s.passkeyLoginBegin)
+		api.POST("/login/passkey/finish", RateLimitMiddleware(s.loginLimiter), s.passkeyLoginFinish)
 		api.GET("/site-config", s.getPublicSiteConfig) // 公开的网站配置
 
 		// 用户注册和验证 (公开，带限流)
@@ -135,11 +149,18 @@ func (s *Server) setupRoutes() {
 		api.POST("/forgot-password", RateLimitMiddleware(s.loginLimiter), s.forgotPassword)
 		api.POST("/reset-password", s.resetPassword)
 		api.GET("/registration-status", s.getRegistrationStatus)
+		api.POST("/token/refresh", APIRateLimitMiddleware(s.globalAPILimiter), s.refreshToken)
+
+		// OIDC 单点登录 (公开)
+		api.GET("/oidc/login", s.oidcLogin)
+		api.GET("/oidc/callback", RateLimitMiddleware(s.loginLimiter), s.oidcCallback)
 
 		// 需要认证的接口
 		auth := api.Group("")
 		auth.Use(s.authMiddleware())
 		auth.Use(APIRateLimitMiddleware(s.globalAPILimiter)) // 全局 API 限流
+		auth.Use(s.permissionMiddleware())                   // 角色权限校验
+		auth.Use(s.agentSyncMiddleware())                    // 写操作后通知在线 Agent
 		{
 			// 统计
 			auth.GET("/stats", s.getStats)
@@ -148,6 +169,7 @@ func (s *Server) setupRoutes() {
 			auth.GET("/search", s.globalSearch)
 
 			// 会话管理
+			auth.POST("/logout", s.logout)
 			auth.GET("/sessions", s.getSessions)
 			auth.DELETE("/sessions/:id", s.deleteSession)
 			auth.DELETE("/sessions/others", s.deleteOtherSessions)
@@ -170,6 +192,15 @@ func (s *Server) setupRoutes() {
 			auth.GET("/nodes/:id/health-logs", s.getNodeHealthLogs)
 			auth.GET("/health-summary", s.getHealthSummary)
 
+			// 节点远程命令
+			auth.GET("/nodes/:id/commands", s.listNodeCommands)
+			auth.POST("/nodes/:id/commands", APIRateLimitMiddleware(s.writeAPILimiter), s.createNodeCommand)
+			auth.GET("/nodes/:id/commands/:commandId", s.getNodeCommand)
+			auth.POST("/nodes/:id/commands/:commandId/cancel", s.cancelNodeCommand)
+
+			// 节点 GOST 日志
+			auth.GET("/nodes/:id/logs", s.getNodeLogs)
+
 			// 节点配置版本历史
 			auth.GET("/nodes/:id/config-versions", s.getConfigVersions)
 			auth.POST("/nodes/:id/config-versions", s.createConfigVersion)
@@ -194,6 +225,7 @@ func (s *Server) setupRoutes() {
 			auth.GET("/clients/:id/gost-config", s.getClientGostConfig)
 			auth.GET("/clients/:id/proxy-uri", s.getClientProxyURI)
 			auth.POST("/clients/:id/clone", s.cloneClient)
+			auth.GET("/clients/:id/traffic-history", s.getClientTrafficHistory)
 
 			// 客户端批量操作
 			auth.POST("/clients/batch-enable", s.batchEnableClients)
@@ -207,20 +239,43 @@ func (s *Server) setupRoutes() {
 			auth.GET("/users/:id", s.getUser)
 			auth.PUT("/users/:id", s.updateUser)
 			auth.DELETE("/users/:id", s.deleteUser)
+			auth.GET("/users/:id/traffic-history", s.getUserTrafficHistory)
 			auth.POST("/change-password", s.changePassword)
 
 			// 个人账户设置
 			auth.GET("/profile", s.getProfile)
 			auth.PUT("/profile", s.updateProfile)
 
+			auth.GET("/profile/permissions", s.getProfilePermissions)
+
+			// 个人访问令牌
+			auth.GET("/profile/tokens", s.listAPITokens)
+			auth.POST("/profile/tokens", s.createAPIToken)
+			auth.DELETE("/profile/tokens/:id", s.deleteAPIToken)
+
 			// 2FA 双因素认证
 			auth.POST("/profile/2fa/enable", s.enable2FA)
 			auth.POST("/profile/2fa/verify", s.verify2FA)
 			auth.POST("/profile/2fa/disable", s.disable2FA)
 
+			// WebAuthn 安全密钥 / 通行密钥
+			auth.GET("/profile/webauthn", s.listWebAuthnCredentials)
+			auth.POST("/profile/webauthn/register/begin", s.beginWebAuthnRegistration)
+			auth.POST("/profile/webauthn/register/finish", s.finishWebAuthnRegistration)
+			auth.PUT("/profile/webauthn/:id", s.renameWebAuthnCredential)
+			auth.DELETE("/profile/webauthn/:id", s.deleteWebAuthnCredential)
+
 			// 流量历史
 			auth.GET("/traffic-history", s.getTrafficHistory)
 
+			// 角色权限
+			auth.GET("/permissions", s.getPermissions)
+			auth.GET("/roles", s.listRoles)
+			auth.GET("/roles/:id", s.getRole)
+			auth.POST("/roles", s.createRole)
+			auth.PUT("/roles/:id", s.updateRole)
+			auth.DELETE("/roles/:id", s.deleteRole)
+
 			// 通知渠道管理
 			auth.GET("/notify-channels", s.listNotifyChannels)
 			auth.POST("/notify-channels", s.createNotifyChannel)
@@ -257,6 +312,7 @@ func (s *Server) setupRoutes() {
 			auth.PUT("/port-forwards/:id", s.updatePortForward)
 			auth.DELETE("/port-forwards/:id", s.deletePortForward)
 			auth.POST("/port-forwards/:id/clone", s.clonePortForward)
+			auth.GET("/port-forwards/:id/traffic-history", s.getPortForwardTrafficHistory)
 
 			// 节点组 (负载均衡)
 			auth.GET("/node-groups", s.listNodeGroups)
@@ -293,6 +349,7 @@ func (s *Server) setupRoutes() {
 			auth.GET("/tunnels/:id/entry-config", s.getTunnelEntryConfig)
 			auth.GET("/tunnels/:id/exit-config", s.getTunnelExitConfig)
 			auth.POST("/tunnels/:id/clone", s.cloneTunnel)
+			auth.GET("/tunnels/:id/traffic-history", s.getTunnelTrafficHistory)
 
 			// 预配置模板
 			auth.GET("/templates", s.listTemplates)
@@ -307,6 +364,12 @@ func (s *Server) setupRoutes() {
 			// 网站配置 (仅管理员)
 			auth.GET("/site-configs", s.getSiteConfigs)
 			auth.PUT("/site-configs", s.updateSiteConfigs)
+			auth.POST("/site-configs/ldap/test", s.testLDAP)
+
+			// 定时任务 (仅管理员)
+			auth.GET("/jobs", s.listJobs)
+			auth.PUT("/jobs/:name", s.updateJob)
+			auth.POST("/jobs/:name/run", s.runJob)
 
 			// 节点标签管理
 			auth.GET("/tags", s.listTags)
@@ -329,6 +392,8 @@ func (s *Server) setupRoutes() {
 			auth.POST("/users/:id/assign-plan", s.assignUserPlan)
 			auth.POST("/users/:id/remove-plan", s.removeUserPlan)
 			auth.POST("/users/:id/renew-plan", s.renewUserPlan)
+			auth.POST("/users/:id/unlock", s.unlockUser)
+			auth.POST("/users/:id/reset-2fa", s.resetUserTwoFactor)
 
 			// 套餐管理
 			auth.GET("/plans", s.listPlans)
@@ -339,6 +404,24 @@ func (s *Server) setupRoutes() {
 			auth.GET("/plans/:id/resources", s.getPlanResources)
 			auth.PUT("/plans/:id/resources", s.setPlanResources)
 
+			// 组织 (多租户)
+			auth.GET("/organizations", s.listOrganizations)
+			auth.POST("/organizations", s.createOrganization)
+			auth.GET("/organizations/:id", s.getOrganization)
+			auth.PUT("/organizations/:id", s.updateOrganization)
+			auth.DELETE("/organizations/:id", s.deleteOrganization)
+			auth.GET("/organizations/:id/members", s.listOrgMembers)
+			auth.POST("/organizations/:id/members", s.addOrgMember)
+			auth.PUT("/organizations/:id/members/:user_id", s.updateOrgMember)
+			auth.DELETE("/organizations/:id/members/:user_id", s.removeOrgMember)
+			auth.GET("/organizations/:id/resources", s.listOrgResources)
+			auth.POST("/organizations/:id/resources", s.assignOrgResource)
+			auth.DELETE("/organizations/:id/resources/:type/:rid", s.removeOrgResource)
+			auth.POST("/organizations/:id/assign-plan", s.assignOrgPlan)
+			auth.POST("/organizations/:id/remove-plan", s.removeOrgPlan)
+			auth.POST("/organizations/:id/renew-plan", s.renewOrgPlan)
+			auth.POST("/organizations/:id/reset-quota", s.resetOrgQuota)
+
 			// Bypass 分流规则
 			auth.GET("/bypasses", s.listBypasses)
 			auth.GET("/bypasses/:id", s.getBypass)
@@ -402,6 +485,10 @@ func (s *Server) setupRoutes() {
 	{
 		agent.POST("/register", s.agentRegister)
 		agent.POST("/heartbeat", s.agentHeartbeat)
+		agent.GET("/stream", s.agentStream) // 控制通道 (Authorization: Bearer <token>)
+		agent.POST("/command-result", s.agentCommandResult)
+		agent.POST("/logs", s.agentLogs)
+		agent.POST("/config-status", s.agentConfigStatus)
 		agent.GET("/config/:token", s.agentGetConfig)
 		agent.GET("/version", s.agentGetVersion)
 		agent.GET("/check-update", s.agentCheckUpdate)
@@ -410,8 +497,8 @@ func (s *Server) setupRoutes() {
 		agent.POST("/client-heartbeat/:token", s.clientHeartbeat)
 	}
 
-	// WebSocket 接口
-	s.router.GET("/ws", s.handleWebSocket)
+	// WebSocket 接口 (JWT 或 API 令牌认证，令牌可通过 "bearer" 子协议传递)
+	s.router.GET("/ws", wsTokenMiddleware(), s.authMiddleware(), s.handleWebSocket)
 
 	// 安装脚本接口 (公开)
 	scripts := s.router.Group("/scripts")
@@ -506,6 +593,12 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 			tokenStr = tokenStr[7:]
 		}
 
+		// 个人访问令牌
+		if strings.HasPrefix(tokenStr, service.APITokenPrefix) {
+			s.authenticateAPIToken(c, tokenStr)
+			return
+		}
+
 		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 			// 验证签名方法，防止算法替换攻击
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -522,17 +615,21 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 
 		claims := token.Claims.(jwt.MapClaims)
 
-		// 验证 JTI (会话管理)
-		if jti, ok := claims["jti"].(string); ok && jti != "" {
-			// 检查会话是否存在且有效
-			if !s.svc.ValidateSession(jti) {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired or invalid"})
-				c.Abort()
-				return
-			}
-			// 每5分钟更新一次 last_active 时间（减少数据库写入）
-			go s.svc.UpdateSessionActivity(jti)
+		// 验证 JTI (会话管理)，2FA 临时令牌等不带会话的令牌不能访问接口
+		jti, _ := claims["jti"].(string)
+		if temp2FA, _ := claims["temp_2fa"].(bool); temp2FA || jti == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			c.Abort()
+			return
+		}
+		// 检查会话是否存在且有效
+		if !s.svc.ValidateSession(jti) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired or invalid"})
+			c.Abort()
+			return
 		}
+		// 每5分钟更新一次 last_active 时间（减少数据库写入）
+		go s.svc.UpdateSessionActivity(jti)
 
 		c.Set("user_id", claims["user_id"])
 		c.Set("username", claims["username"])
@@ -556,10 +653,18 @@ func (s *Server) login(c *gin.Context) {
 		return
 	}
 
+	// 账户锁定期间直接拒绝，不再校验密码
+	if lockedUntil, err := s.svc.CheckAccountLock(req.Username); err != nil {
+		s.svc.LogOperation(0, req.Username, "login", "user", 0, "account locked", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		respondAccountLocked(c, lockedUntil)
+		return
+	}
+
 	user, err := s.svc.ValidateUser(req.Username, req.Password)
 	if err != nil {
-		// 记录登录失败
+		// 记录登录失败 (累计失败次数，可能触发锁定)
 		s.svc.LogOperation(0, req.Username, "login", "user", 0, "login failed", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		s.svc.RecordLoginFailure(req.Username, c.ClientIP())
 		RecordLoginAttempt(false)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
@@ -578,17 +683,9 @@ func (s *Server) login(c *gin.Context) {
 		return
 	}
 
-	// 检查是否启用了 2FA
-	if user.TwoFactorEnabled {
-		// 生成临时令牌（5分钟有效）
-		tempToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"user_id":  user.ID,
-			"username": user.Username,
-			"temp_2fa": true,
-			"exp":      time.Now().Add(5 * time.Minute).Unix(),
-		})
-
-		tempTokenString, err := tempToken.SignedString([]byte(s.cfg.JWTSecret))
+	// 检查是否需要第二因素 (TOTP / WebAuthn)
+	if factors := s.svc.SecondFactors(user); len(factors) > 0 {
+		tempTokenString, err := s.signTemp2FAToken(user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate temp token"})
 			return
@@ -597,10 +694,16 @@ func (s *Server) login(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"requires_2fa": true,
 			"temp_token":   tempTokenString,
+			"methods":      factors,
 		})
 		return
 	}
 
+	s.completeLogin(c, user, "user", "login success")
+}
+
+// completeLogin 认证完成后签发会话令牌并返回用户信息 (所有登录方式共用)
+func (s *Server) completeLogin(c *gin.Context, user *model.User, resource, detail string) {
 	// 登录成功，重置限流计数
 	s.loginLimiter.Reset(c.ClientIP())
 	RecordLoginAttempt(true)
@@ -609,33 +712,21 @@ func (s *Server) login(c *gin.Context) {
 	s.svc.UpdateUserLoginInfo(user.ID, c.ClientIP())
 
 	// 记录登录成功
-	s.svc.LogOperation(user.ID, user.Username, "login", "user", user.ID, "login success", c.ClientIP(), c.GetHeader("User-Agent"), "success")
+	s.svc.LogOperation(user.ID, user.Username, "login", resource, user.ID, detail, c.ClientIP(), c.GetHeader("User-Agent"), "success")
 
-	// 生成 JWT with JTI
-	jti := uuid.New().String()
-	expiresAt := time.Now().Add(24 * time.Hour)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"role":     user.Role,
-		"jti":      jti,
-		"exp":      expiresAt.Unix(),
-	})
-
-	tokenString, err := token.SignedString([]byte(s.cfg.JWTSecret))
+	tokenString, refreshToken, err := s.issueSessionToken(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	// 创建会话记录
-	if err := s.svc.CreateUserSession(user.ID, jti, c.ClientIP(), c.GetHeader("User-Agent"), expiresAt); err != nil {
-		// 会话创建失败不影响登录，只记录错误
-		s.svc.LogOperation(user.ID, user.Username, "session_create", "user_session", 0, fmt.Sprintf("failed to create session: %v", err), c.ClientIP(), c.GetHeader("User-Agent"), "failed")
-	}
-
+	setup := s.svc.SecondFactorSetupRequired(user.ID, user.Role)
 	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString,
+		"token":                     tokenString,
+		"refresh_token":             refreshToken,
+		"expires_in":                int(service.AccessTokenTTL.Seconds()),
+		"webauthn_setup_required":   setup == service.SetupWebAuthn,
+		"two_factor_setup_required": setup == service.SetupTwoFactor,
 		"user": gin.H{
 			"id":                user.ID,
 			"username":          user.Username,
@@ -652,6 +743,81 @@ func (s *Server) login(c *gin.Context) {
 	})
 }
 
+// issueSessionToken 签发短期访问令牌 (JWT) 与刷新令牌，并创建会话记录 (JTI 用于会话撤销)
+func (s *Server) issueSessionToken(c *gin.Context, user *model.User) (accessToken, refreshToken string, err error) {
+	jti := uuid.New().String()
+	accessToken, err = s.signAccessToken(user, jti)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err = s.svc.CreateUserSession(user.ID, jti, c.ClientIP(), c.GetHeader("User-Agent"), time.Now().Add(service.SessionRefreshTTL))
+	if err != nil {
+		s.svc.LogOperation(user.ID, user.Username, "session_create", "user_session", 0, fmt.Sprintf("failed to create session: %v", err), c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// signAccessToken 签发访问令牌，同一会话内刷新时沿用会话 JTI
+func (s *Server) signAccessToken(user *model.User, jti string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"jti":      jti,
+		"exp":      time.Now().Add(service.AccessTokenTTL).Unix(),
+	})
+	return token.SignedString([]byte(s.cfg.JWTSecret))
+}
+
+// RefreshTokenRequest 刷新令牌请求
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// refreshToken 使用刷新令牌换取新的访问令牌 (刷新令牌同时轮换)
+func (s *Server) refreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, user, refreshToken, err := s.svc.RefreshSession(req.RefreshToken, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, service.ErrRefreshTokenRotated) {
+			// 其他标签页已完成刷新，客户端应重新读取本地保存的令牌
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	accessToken, err := s.signAccessToken(user, session.TokenJTI)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(service.AccessTokenTTL.Seconds()),
+	})
+}
+
+// logout 退出登录，撤销当前会话 (刷新令牌随之失效)
+func (s *Server) logout(c *gin.Context) {
+	if jti := c.GetString("jti"); jti != "" {
+		if err := s.svc.DeleteSessionByJTI(jti); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
 // ==================== 用户注册与验证 ====================
 
 // RegisterRequest 注册请求
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/config"
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+)
+
+const testPassword = "Str0ng-Passw0rd!"
+
+// newTestServer 创建使用临时 SQLite 数据库的面板，configs 写入网站设置
+func newTestServer(t *testing.T, configs map[string]string) *Server {
+	t.Helper()
+	db, err := model.InitDB(model.DriverSQLite, filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{JWTSecret: "test-secret"}
+	svc := service.NewService(db, cfg)
+	t.Cleanup(func() {
+		svc.Close()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if len(configs) > 0 {
+		if err := svc.SetSiteConfigs(configs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewServer(svc, cfg)
+}
+
+// createTestUser 创建已验证邮箱的本地用户
+func createTestUser(t *testing.T, s *Server, username, role string) *model.User {
+	t.Helper()
+	user, err := s.svc.CreateUserFull(username, username+"@example.com", testPassword, role, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// loginAs 使用密码登录并返回访问令牌
+func loginAs(t *testing.T, s *Server, username string) string {
+	t.Helper()
+	w := doJSON(s, http.MethodPost, "/api/login", "", map[string]string{"username": username, "password": testPassword})
+	var resp struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Token == "" {
+		t.Fatalf("login as %s: status %d body %s", username, w.Code, w.Body.String())
+	}
+	return resp.Token
+}
+
+// doJSON 发送 JSON 请求，token 非空时携带 Bearer 认证
+func doJSON(s *Server, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
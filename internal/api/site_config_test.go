@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+)
+
+func TestSiteConfigsRedactSecrets(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		model.ConfigOIDCClientSecret: "client-secret",
+		model.ConfigLDAPBindPassword: "bind-password",
+	})
+	createTestUser(t, s, "root", service.RoleAdmin)
+	token := loginAs(t, s, "root")
+
+	getConfigs := func() map[string]string {
+		t.Helper()
+		w := doJSON(s, http.MethodGet, "/api/site-configs", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("get site configs: status %d", w.Code)
+		}
+		var configs map[string]string
+		json.Unmarshal(w.Body.Bytes(), &configs)
+		return configs
+	}
+	putConfigs := func(configs map[string]string) {
+		t.Helper()
+		if w := doJSON(s, http.MethodPut, "/api/site-configs", token, configs); w.Code != http.StatusOK {
+			t.Fatalf("update site configs: status %d body %s", w.Code, w.Body.String())
+		}
+	}
+
+	configs := getConfigs()
+	for _, key := range []string{model.ConfigOIDCClientSecret, model.ConfigLDAPBindPassword} {
+		if got := configs[key]; got != secretPlaceholder {
+			t.Errorf("%s returned as %q", key, got)
+		}
+	}
+
+	// 原样提交读取到的配置不会覆盖已保存的密钥
+	configs[model.ConfigSiteName] = "panel"
+	putConfigs(configs)
+	if got := s.svc.GetSiteConfig(model.ConfigOIDCClientSecret); got != "client-secret" {
+		t.Errorf("secret after saving placeholder = %q", got)
+	}
+	if got := s.svc.GetSiteConfig(model.ConfigLDAPBindPassword); got != "bind-password" {
+		t.Errorf("bind password after saving placeholder = %q", got)
+	}
+
+	putConfigs(map[string]string{model.ConfigOIDCClientSecret: "rotated"})
+	if got := s.svc.GetSiteConfig(model.ConfigOIDCClientSecret); got != "rotated" {
+		t.Errorf("secret after update = %q", got)
+	}
+	// 清空后不再返回占位符
+	putConfigs(map[string]string{model.ConfigOIDCClientSecret: ""})
+	if got := getConfigs()[model.ConfigOIDCClientSecret]; got != "" {
+		t.Errorf("cleared secret returned as %q", got)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== WebAuthn / 通行密钥 ====================
+
+// WebAuthnFinishRequest 完成注册/认证请求，credential 为浏览器返回的 PublicKeyCredential (JSON)
+type WebAuthnFinishRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+	Name       string          `json:"name"`       // 注册时的凭据名称
+	TempToken  string          `json:"temp_token"` // 第二因素认证时的临时令牌
+}
+
+// listWebAuthnCredentials 获取当前用户的安全密钥
+func (s *Server) listWebAuthnCredentials(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	user, err := s.svc.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	credentials, err := s.svc.ListWebAuthnCredentials(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"credentials": credentials,
+		"required":    s.svc.WebAuthnRequired(user.Role),
+	})
+}
+
+// beginWebAuthnRegistration 发起安全密钥注册
+func (s *Server) beginWebAuthnRegistration(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	user, err := s.svc.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	options, sessionID, err := s.svc.BeginWebAuthnRegistration(s.getPanelURL(c), user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "options": options})
+}
+
+// finishWebAuthnRegistration 校验注册响应并保存安全密钥
+func (s *Server) finishWebAuthnRegistration(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	user, err := s.svc.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	var req WebAuthnFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := s.svc.FinishWebAuthnRegistration(s.getPanelURL(c), user, req.SessionID, req.Name, req.Credential)
+	if err != nil {
+		s.audit.LogFailed(c, "create", "webauthn_credential", 0, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.audit.LogSuccess(c, "create", "webauthn_credential", credential.ID, credential.Name)
+	c.JSON(http.StatusOK, credential)
+}
+
+// renameWebAuthnCredential 重命名安全密钥
+func (s *Server) renameWebAuthnCredential(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.svc.RenameWebAuthnCredential(userID, id, req.Name); err != nil {
+		if errors.Is(err, service.ErrWebAuthnNotRegistered) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "security key not found"})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	s.audit.LogSuccess(c, "update", "webauthn_credential", id, req.Name)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// deleteWebAuthnCredential 删除安全密钥
+func (s *Server) deleteWebAuthnCredential(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	id, ok := parseID(c)
+	if !ok {
+		return
+	}
+	user, err := s.svc.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if err := s.svc.DeleteWebAuthnCredential(user, id); err != nil {
+		switch {
+		case errors.Is(err, service.ErrWebAuthnNotRegistered):
+			c.JSON(http.StatusNotFound, gin.H{"error": "security key not found"})
+		case errors.Is(err, service.ErrWebAuthnRequired), errors.Is(err, service.ErrTwoFactorRequired):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	s.audit.LogSuccess(c, "delete", "webauthn_credential", id, "")
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// loginWebAuthnBegin 第二因素: 使用密码登录后返回的临时令牌发起安全密钥认证
+func (s *Server) loginWebAuthnBegin(c *gin.Context) {
+	var req struct {
+		TempToken string `json:"temp_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := s.parseTemp2FAToken(c, req.TempToken)
+	if !ok || !s.requireSecondFactor(c, user, service.SecondFactorWebAuthn) {
+		return
+	}
+
+	options, sessionID, err := s.svc.BeginWebAuthnLogin(s.getPanelURL(c), user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "options": options})
+}
+
+// loginWebAuthnFinish 第二因素: 校验安全密钥认证响应并完成登录
+func (s *Server) loginWebAuthnFinish(c *gin.Context) {
+	var req WebAuthnFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := s.parseTemp2FAToken(c, req.TempToken)
+	if !ok || !s.requireSecondFactor(c, user, service.SecondFactorWebAuthn) {
+		return
+	}
+
+	if err := s.svc.FinishWebAuthnLogin(s.getPanelURL(c), user, req.SessionID, req.Credential); err != nil {
+		s.svc.LogOperation(user.ID, user.Username, "login", "webauthn", user.ID, err.Error(), c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		s.svc.RecordLoginFailure(user.Username, c.ClientIP())
+		RecordLoginAttempt(false)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "security key verification failed"})
+		return
+	}
+
+	s.completeLogin(c, user, "webauthn", "security key login success")
+}
+
+// passkeyLoginBegin 无密码登录: 发起可发现凭据认证
+func (s *Server) passkeyLoginBegin(c *gin.Context) {
+	options, sessionID, err := s.svc.BeginPasskeyLogin(s.getPanelURL(c))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrPasskeyLoginDisabled) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "options": options})
+}
+
+// passkeyLoginFinish 无密码登录: 校验通行密钥 (已验证用户身份，视为多因素) 并完成登录
+func (s *Server) passkeyLoginFinish(c *gin.Context) {
+	var req WebAuthnFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.svc.PasskeyLoginEnabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrPasskeyLoginDisabled.Error()})
+		return
+	}
+
+	user, err := s.svc.FinishPasskeyLogin(s.getPanelURL(c), req.SessionID, req.Credential)
+	if err != nil {
+		s.svc.LogOperation(0, "", "login", "passkey", 0, err.Error(), c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		RecordLoginAttempt(false)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey verification failed"})
+		return
+	}
+	if !user.Enabled {
+		s.svc.LogOperation(user.ID, user.Username, "login", "passkey", user.ID, "account disabled", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is disabled"})
+		return
+	}
+	if service.AccountLocked(user) {
+		s.svc.LogOperation(user.ID, user.Username, "login", "passkey", user.ID, "account locked", c.ClientIP(), c.GetHeader("User-Agent"), "failed")
+		respondAccountLocked(c, user.LockedUntil)
+		return
+	}
+	if s.svc.IsEmailVerificationRequired() && !user.EmailVerified && user.Email != nil && *user.Email != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email not verified", "code": "EMAIL_NOT_VERIFIED"})
+		return
+	}
+
+	s.completeLogin(c, user, "passkey", "passkey login success")
+}
@@ -2,12 +2,17 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
@@ -85,10 +90,15 @@ func extractHost(urlStr string) string {
 	return s
 }
 
+// wsBearerProtocol 浏览器无法为 WebSocket 设置 Authorization 头，
+// 通过子协议传递令牌: new WebSocket(url, ["bearer", token])
+const wsBearerProtocol = "bearer"
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 	CheckOrigin:     checkWSOrigin,
+	Subprotocols:    []string{wsBearerProtocol},
 }
 
 // WSMessage represents a WebSocket message
@@ -97,18 +107,115 @@ type WSMessage struct {
 	Data interface{} `json:"data"`
 }
 
+// wsTopic 订阅主题: resource 为查看事件所需的读权限，ownResource 为按归属过滤的资源类型 (可订阅 主题:ID)
+type wsTopic struct {
+	resource    string
+	ownResource string
+}
+
+// wsTopics 可订阅的主题
+var wsTopics = map[string]wsTopic{
+	"nodes":          {resource: "nodes", ownResource: "node"},
+	"tunnels":        {resource: "tunnels", ownResource: "tunnel"},
+	"alerts":         {resource: "alert-logs"},
+	"operation-logs": {resource: "operation-logs"},
+	"node-logs":      {resource: "nodes", ownResource: "node"},
+	"stats":          {resource: "dashboard"},
+}
+
+// wsDefaultTopics 未指定订阅时的默认主题
+var wsDefaultTopics = []string{"nodes", "stats"}
+
+// parseWSTopic 解析订阅主题: "nodes" 或 "nodes:12" (兼容 "node:12")
+func parseWSTopic(raw string) (name string, id uint, ok bool) {
+	name, idStr, hasID := strings.Cut(strings.TrimSpace(raw), ":")
+	if _, exists := wsTopics[name]; !exists {
+		if _, exists = wsTopics[name+"s"]; !exists {
+			return "", 0, false
+		}
+		name += "s"
+	}
+	if !hasID {
+		return name, 0, true
+	}
+	if wsTopics[name].ownResource == "" {
+		return "", 0, false
+	}
+	n, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil || n == 0 {
+		return "", 0, false
+	}
+	return name, uint(n), true
+}
+
+func wsTopicKey(name string, id uint) string {
+	if id == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s:%d", name, id)
+}
+
 // WSClient represents a WebSocket client connection
 type WSClient struct {
 	hub    *WSHub
 	conn   *websocket.Conn
 	send   chan []byte
 	userID uint
+
+	// refresh 定期校验会话并重新加载权限，返回 false 时断开连接
+	refresh func(c *WSClient) bool
+
+	mu         sync.RWMutex
+	perms      *service.RolePermissions // 角色权限
+	tokenPerms *service.RolePermissions // API 令牌授权范围 (JWT 登录时为 nil)
+	topics     map[string]bool
+}
+
+// allow 判断客户端是否拥有资源的读权限 (API 令牌同时受授权范围限制)
+func (c *WSClient) allow(resource string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.perms == nil || !c.perms.Allow(resource, service.ActionRead) {
+		return false
+	}
+	return c.tokenPerms == nil || c.tokenPerms.Allow(resource, service.ActionRead)
+}
+
+// scopeAll 客户端角色是否可访问所有用户的资源
+func (c *WSClient) scopeAll() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.perms != nil && c.perms.AllScope()
+}
+
+// subscribed 客户端是否订阅了主题 (订阅整个主题或具体资源)
+func (c *WSClient) subscribed(name string, id uint) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.topics[name] || (id != 0 && c.topics[wsTopicKey(name, id)])
+}
+
+func (c *WSClient) topicList() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		list = append(list, topic)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// wsDelivery 待投递的消息及接收者 (由发布方按权限与订阅筛选)
+type wsDelivery struct {
+	message    []byte
+	recipients []*WSClient
 }
 
 // WSHub maintains active WebSocket connections
 type WSHub struct {
 	clients    map[*WSClient]bool
-	broadcast  chan []byte
+	broadcast  chan wsDelivery
 	register   chan *WSClient
 	unregister chan *WSClient
 	mu         sync.RWMutex
@@ -118,7 +225,7 @@ type WSHub struct {
 func NewWSHub() *WSHub {
 	return &WSHub{
 		clients:    make(map[*WSClient]bool),
-		broadcast:  make(chan []byte, 256),
+		broadcast:  make(chan wsDelivery, 256),
 		register:   make(chan *WSClient),
 		unregister: make(chan *WSClient),
 	}
@@ -132,56 +239,172 @@ func (h *WSHub) Run() {
 			h.mu.Lock()
 			h.clients[client] = true
 			h.mu.Unlock()
-			log.Printf("WebSocket client connected, total: %d", len(h.clients))
+			log.Printf("WebSocket client connected, total: %d", h.ClientCount())
 
 		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-			h.mu.Unlock()
-			log.Printf("WebSocket client disconnected, total: %d", len(h.clients))
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
+			h.remove(client)
+			log.Printf("WebSocket client disconnected, total: %d", h.ClientCount())
+
+		case delivery := <-h.broadcast:
+			for _, client := range delivery.recipients {
+				h.mu.RLock()
+				_, ok := h.clients[client]
+				h.mu.RUnlock()
+				if !ok {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- delivery.message:
 				default:
-					close(client.send)
-					delete(h.clients, client)
+					h.remove(client)
 				}
 			}
-			h.mu.RUnlock()
 		}
 	}
 }
 
-// Broadcast sends a message to all connected clients
-func (h *WSHub) Broadcast(msgType string, data interface{}) {
-	msg := WSMessage{
-		Type: msgType,
-		Data: data,
+func (h *WSHub) remove(client *WSClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.send)
 	}
-	jsonData, err := json.Marshal(msg)
+}
+
+// snapshot 当前连接的客户端
+func (h *WSHub) snapshot() []*WSClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*WSClient, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// ClientCount returns the number of connected clients
+func (h *WSHub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// wsEvent 推送事件
+type wsEvent struct {
+	Type  string // 消息类型
+	Topic string // 所属主题
+	ID    uint   // 关联资源 ID，订阅 主题:ID 的客户端也会收到
+	Data  interface{}
+
+	// visible 判断不可访问全部资源的用户能否看到该事件，为 nil 时使用主题的归属过滤
+	visible func(userID uint) bool
+}
+
+// publish 按订阅主题、读权限与资源归属筛选接收者后推送事件
+// 权限与归属判断在调用方的 goroutine 中完成，避免阻塞 hub 主循环
+func (s *Server) publish(ev wsEvent) {
+	if s.wsHub == nil {
+		return
+	}
+	topic := wsTopics[ev.Topic]
+	visible := ev.visible
+	if visible == nil && topic.ownResource != "" && ev.ID != 0 {
+		visible = func(userID uint) bool {
+			return s.svc.ResourceVisibleTo(topic.ownResource, ev.ID, userID)
+		}
+	}
+
+	var recipients []*WSClient
+	visibleByUser := make(map[uint]bool)
+	for _, client := range s.wsHub.snapshot() {
+		if !client.subscribed(ev.Topic, ev.ID) || !client.allow(topic.resource) {
+			continue
+		}
+		if visible != nil && !client.scopeAll() {
+			ok, cached := visibleByUser[client.userID]
+			if !cached {
+				ok = visible(client.userID)
+				visibleByUser[client.userID] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+		recipients = append(recipients, client)
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	message, err := json.Marshal(WSMessage{Type: ev.Type, Data: ev.Data})
 	if err != nil {
 		log.Printf("Failed to marshal WebSocket message: %v", err)
 		return
 	}
-
 	select {
-	case h.broadcast <- jsonData:
+	case s.wsHub.broadcast <- wsDelivery{message: message, recipients: recipients}:
 	default:
 		log.Println("WebSocket broadcast channel full, dropping message")
 	}
 }
 
-// ClientCount returns the number of connected clients
-func (h *WSHub) ClientCount() int {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return len(h.clients)
+// hasSubscribers 是否有客户端订阅了主题 (用于跳过无人订阅时的数据查询)
+func (s *Server) hasSubscribers(name string, id uint) bool {
+	if s.wsHub == nil {
+		return false
+	}
+	for _, client := range s.wsHub.snapshot() {
+		if client.subscribed(name, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsClientMessage 客户端发送的订阅指令
+type wsClientMessage struct {
+	Action string   `json:"action"` // subscribe / unsubscribe / ping
+	Topics []string `json:"topics"`
+}
+
+// subscribe 订阅主题，返回被拒绝的主题 (无效、无权限或资源不可见)
+func (s *Server) wsSubscribe(c *WSClient, topics []string) []string {
+	denied := []string{}
+	for _, raw := range topics {
+		name, id, ok := parseWSTopic(raw)
+		if !ok || !c.allow(wsTopics[name].resource) {
+			denied = append(denied, raw)
+			continue
+		}
+		if id != 0 && !c.scopeAll() && !s.svc.ResourceVisibleTo(wsTopics[name].ownResource, id, c.userID) {
+			denied = append(denied, raw)
+			continue
+		}
+		c.mu.Lock()
+		c.topics[wsTopicKey(name, id)] = true
+		c.mu.Unlock()
+	}
+	return denied
+}
+
+func (c *WSClient) unsubscribe(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, raw := range topics {
+		if name, id, ok := parseWSTopic(raw); ok {
+			delete(c.topics, wsTopicKey(name, id))
+		}
+	}
+}
+
+// reply 直接回复客户端 (订阅结果等)
+func (c *WSClient) reply(msgType string, data interface{}) {
+	message, err := json.Marshal(WSMessage{Type: msgType, Data: data})
+	if err != nil {
+		return
+	}
+	c.hub.broadcast <- wsDelivery{message: message, recipients: []*WSClient{c}}
 }
 
 // writePump pumps messages from the hub to the WebSocket connection
@@ -206,6 +429,13 @@ func (c *WSClient) writePump() {
 			}
 
 		case <-ticker.C:
+			// 会话失效 (登出、被强制下线、空闲超时) 或令牌被撤销时断开
+			if c.refresh != nil && !c.refresh(c) {
+				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session expired"))
+				return
+			}
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
@@ -214,14 +444,14 @@ func (c *WSClient) writePump() {
 	}
 }
 
-// readPump pumps messages from the WebSocket connection to the hub
-func (c *WSClient) readPump() {
+// readPump 读取客户端的订阅指令
+func (c *WSClient) readPump(s *Server) {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(4096)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -229,18 +459,68 @@ func (c *WSClient) readPump() {
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNoStatusReceived, websocket.CloseNormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
+
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.reply("error", gin.H{"error": "invalid message"})
+			continue
+		}
+		switch msg.Action {
+		case "subscribe":
+			denied := s.wsSubscribe(c, msg.Topics)
+			c.reply("subscribed", gin.H{"topics": c.topicList(), "denied": denied})
+		case "unsubscribe":
+			c.unsubscribe(msg.Topics)
+			c.reply("subscribed", gin.H{"topics": c.topicList(), "denied": []string{}})
+		case "ping":
+			c.reply("pong", gin.H{"timestamp": time.Now().Unix()})
+		default:
+			c.reply("error", gin.H{"error": "unknown action: " + msg.Action})
+		}
+	}
+}
+
+// wsTokenMiddleware 将子协议中携带的令牌转为 Authorization 头，交由 authMiddleware 认证
+// 非浏览器客户端可以直接使用 Authorization 头 (JWT 或 API 令牌)
+func wsTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			protocols := websocket.Subprotocols(c.Request)
+			if len(protocols) == 2 && protocols[0] == wsBearerProtocol {
+				c.Request.Header.Set("Authorization", "Bearer "+protocols[1])
+			}
+		}
+		c.Next()
 	}
 }
 
 // handleWebSocket handles WebSocket connections
+// 连接需先通过 authMiddleware 认证；事件按订阅主题、角色读权限与资源归属过滤
 func (s *Server) handleWebSocket(c *gin.Context) {
+	userID, _ := getUserInfo(c)
+	perms, err := s.svc.GetUserPermissions(userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "role not found"})
+		return
+	}
+	if s.svc.SecondFactorSetupRequired(userID, perms.Role) != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "second factor setup required"})
+		return
+	}
+	var tokenPerms *service.RolePermissions
+	if scopes, ok := c.Get("api_token_scopes"); ok {
+		tokenPerms = scopes.(*service.RolePermissions)
+	}
+	jti, _ := c.Get("jti")
+	tokenID, _ := c.Get("api_token_id")
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade WebSocket: %v", err)
@@ -248,37 +528,129 @@ func (s *Server) handleWebSocket(c *gin.Context) {
 	}
 
 	client := &WSClient{
-		hub:  s.wsHub,
-		conn: conn,
-		send: make(chan []byte, 256),
+		hub:        s.wsHub,
+		conn:       conn,
+		send:       make(chan []byte, 256),
+		userID:     userID,
+		perms:      perms,
+		tokenPerms: tokenPerms,
+		topics:     make(map[string]bool),
+		refresh: func(client *WSClient) bool {
+			if jti, ok := jti.(string); ok && jti != "" && !s.svc.ValidateSession(jti) {
+				return false
+			}
+			if tokenID, ok := tokenID.(uint); ok && !s.svc.APITokenActive(tokenID) {
+				return false
+			}
+			perms, err := s.svc.GetUserPermissions(client.userID)
+			if err != nil {
+				return false
+			}
+			client.mu.Lock()
+			client.perms = perms
+			client.mu.Unlock()
+			return true
+		},
+	}
+
+	topics := wsDefaultTopics
+	if q := c.Query("topics"); q != "" {
+		topics = strings.Split(q, ",")
 	}
+	s.wsSubscribe(client, topics)
 
 	s.wsHub.register <- client
 
 	go client.writePump()
-	go client.readPump()
+	go client.readPump(s)
 }
 
 // BroadcastNodeStatus broadcasts node status update
-func (s *Server) BroadcastNodeStatus(nodeID uint, status string, connections int, trafficIn, trafficOut int64) {
-	if s.wsHub == nil {
+func (s *Server) BroadcastNodeStatus(nodeID uint, status, gostStatus string, connections int, trafficIn, trafficOut int64) {
+	s.publish(wsEvent{
+		Type:  "node_status",
+		Topic: "nodes",
+		ID:    nodeID,
+		Data: map[string]interface{}{
+			"node_id":     nodeID,
+			"status":      status,
+			"gost_status": gostStatus, // running/down，为空表示 Agent 未上报
+			"connections": connections,
+			"traffic_in":  trafficIn,
+			"traffic_out": trafficOut,
+			"timestamp":   time.Now().Unix(),
+		},
+	})
+}
+
+// BroadcastTunnelTraffic 推送隧道累计流量
+func (s *Server) BroadcastTunnelTraffic(tunnelID uint) {
+	if !s.hasSubscribers("tunnels", tunnelID) {
 		return
 	}
+	tunnel, err := s.svc.GetTunnel(tunnelID)
+	if err != nil {
+		return
+	}
+	s.publish(wsEvent{
+		Type:  "tunnel_traffic",
+		Topic: "tunnels",
+		ID:    tunnelID,
+		Data: map[string]interface{}{
+			"tunnel_id":   tunnel.ID,
+			"traffic_in":  tunnel.TrafficIn,
+			"traffic_out": tunnel.TrafficOut,
+			"quota_used":  tunnel.QuotaUsed,
+			"suspended":   tunnel.Suspended,
+			"timestamp":   time.Now().Unix(),
+		},
+	})
+}
 
-	s.wsHub.Broadcast("node_status", map[string]interface{}{
-		"node_id":     nodeID,
-		"status":      status,
-		"connections": connections,
-		"traffic_in":  trafficIn,
-		"traffic_out": trafficOut,
-		"timestamp":   time.Now().Unix(),
+// BroadcastAlertLog 推送新的告警日志，仅能访问自己资源的用户只收到可见目标的告警
+func (s *Server) BroadcastAlertLog(entry *model.AlertLog) {
+	s.publish(wsEvent{
+		Type:  "alert",
+		Topic: "alerts",
+		Data:  entry,
+		visible: func(userID uint) bool {
+			if entry.TargetType == "user" {
+				return entry.TargetID == userID
+			}
+			return s.svc.ResourceVisibleTo(entry.TargetType, entry.TargetID, userID)
+		},
 	})
 }
 
-// BroadcastStats broadcasts dashboard stats update
-func (s *Server) BroadcastStats(stats interface{}) {
-	if s.wsHub == nil {
+// BroadcastOperationLog 推送新的操作日志，仅能访问自己资源的用户只收到本人的操作
+func (s *Server) BroadcastOperationLog(entry *model.OperationLog) {
+	s.publish(wsEvent{
+		Type:  "operation_log",
+		Topic: "operation-logs",
+		Data:  entry,
+		visible: func(userID uint) bool {
+			return entry.UserID == userID
+		},
+	})
+}
+
+// BroadcastNodeLogs 推送节点新上报的 GOST 日志 (实时跟踪)
+func (s *Server) BroadcastNodeLogs(nodeID uint, logs []model.NodeLog) {
+	if len(logs) == 0 || !s.hasSubscribers("node-logs", nodeID) {
 		return
 	}
-	s.wsHub.Broadcast("stats", stats)
+	s.publish(wsEvent{
+		Type:  "node_log",
+		Topic: "node-logs",
+		ID:    nodeID,
+		Data: map[string]interface{}{
+			"node_id": nodeID,
+			"logs":    logs,
+		},
+	})
+}
+
+// BroadcastStats broadcasts dashboard stats update
+func (s *Server) BroadcastStats(stats interface{}) {
+	s.publish(wsEvent{Type: "stats", Topic: "stats", Data: stats})
 }
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/AliceNetworks/gost-panel/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestWSClient 注册一个不带网络连接的 WebSocket 客户端并订阅主题，返回被拒绝的主题
+func newTestWSClient(t *testing.T, s *Server, user *model.User, topics ...string) (*WSClient, []string) {
+	t.Helper()
+	perms, err := s.svc.GetUserPermissions(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &WSClient{
+		hub:    s.wsHub,
+		send:   make(chan []byte, 16),
+		userID: user.ID,
+		perms:  perms,
+		topics: make(map[string]bool),
+	}
+	// 直接加入 hub (经 register 通道注册时，加入客户端列表晚于通道发送返回)
+	s.wsHub.mu.Lock()
+	s.wsHub.clients[client] = true
+	s.wsHub.mu.Unlock()
+	t.Cleanup(func() { s.wsHub.remove(client) })
+	return client, s.wsSubscribe(client, topics)
+}
+
+// receiveWS 等待客户端收到消息，超时返回空类型
+func receiveWS(c *WSClient, timeout time.Duration) string {
+	select {
+	case data := <-c.send:
+		var msg WSMessage
+		json.Unmarshal(data, &msg)
+		return msg.Type
+	case <-time.After(timeout):
+		return ""
+	}
+}
+
+func TestParseWSTopic(t *testing.T) {
+	cases := []struct {
+		raw  string
+		name string
+		id   uint
+		ok   bool
+	}{
+		{"nodes", "nodes", 0, true},
+		{" stats ", "stats", 0, true},
+		{"nodes:12", "nodes", 12, true},
+		{"node:12", "nodes", 12, true},
+		{"node-logs:3", "node-logs", 3, true},
+		{"stats:1", "", 0, false},
+		{"operation-logs:1", "", 0, false},
+		{"nodes:0", "", 0, false},
+		{"nodes:abc", "", 0, false},
+		{"users", "", 0, false},
+	}
+	for _, tc := range cases {
+		name, id, ok := parseWSTopic(tc.raw)
+		if name != tc.name || id != tc.id || ok != tc.ok {
+			t.Errorf("parseWSTopic(%q) = %q, %d, %v; want %q, %d, %v", tc.raw, name, id, ok, tc.name, tc.id, tc.ok)
+		}
+	}
+}
+
+func TestWSSubscribeChecksPermissionAndOwnership(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := createTestUser(t, s, "alice", service.RoleUser)
+	bob := createTestUser(t, s, "bob", service.RoleUser)
+	viewer := createTestUser(t, s, "viewer", service.RoleViewer)
+	node := &model.Node{Name: "alice-node", Host: "10.0.0.1", AgentToken: "a", OwnerID: &alice.ID}
+	if err := s.svc.DB().Create(node).Error; err != nil {
+		t.Fatal(err)
+	}
+	nodeTopic := fmt.Sprintf("nodes:%d", node.ID)
+
+	if _, denied := newTestWSClient(t, s, alice, nodeTopic, "stats"); len(denied) != 0 {
+		t.Errorf("owner denied %v", denied)
+	}
+	// 他人的资源与无读权限的主题被拒绝
+	_, denied := newTestWSClient(t, s, bob, nodeTopic, "operation-logs", "alerts", "bogus", "nodes")
+	if want := []string{nodeTopic, "operation-logs", "alerts", "bogus"}; fmt.Sprint(denied) != fmt.Sprint(want) {
+		t.Errorf("bob denied %v, want %v", denied, want)
+	}
+	// 可访问全部资源的角色可订阅任意节点
+	if _, denied := newTestWSClient(t, s, viewer, nodeTopic); len(denied) != 0 {
+		t.Errorf("viewer denied %v", denied)
+	}
+
+	// API 令牌同时受授权范围限制
+	client, _ := newTestWSClient(t, s, viewer)
+	client.tokenPerms = service.APITokenPermissions(&model.APIToken{Scopes: `["nodes:read"]`})
+	if denied := s.wsSubscribe(client, []string{"nodes", "stats"}); fmt.Sprint(denied) != "[stats]" {
+		t.Errorf("token client denied %v, want [stats]", denied)
+	}
+}
+
+func TestWSPublishFiltersByTopicAndOwnership(t *testing.T) {
+	s := newTestServer(t, nil)
+	alice := createTestUser(t, s, "alice", service.RoleUser)
+	bob := createTestUser(t, s, "bob", service.RoleUser)
+	viewer := createTestUser(t, s, "viewer", service.RoleViewer)
+	node := &model.Node{Name: "alice-node", Host: "10.0.0.1", AgentToken: "a", OwnerID: &alice.ID}
+	if err := s.svc.DB().Create(node).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	aliceAll, _ := newTestWSClient(t, s, alice, "nodes")
+	aliceNode, _ := newTestWSClient(t, s, alice, fmt.Sprintf("nodes:%d", node.ID))
+	aliceStats, _ := newTestWSClient(t, s, alice, "stats")
+	bobAll, _ := newTestWSClient(t, s, bob, "nodes", "stats")
+	viewerAll, _ := newTestWSClient(t, s, viewer, "nodes")
+
+	s.BroadcastNodeStatus(node.ID, "online", "running", 1, 0, 0)
+	for name, c := range map[string]*WSClient{"alice nodes": aliceAll, "alice nodes:id": aliceNode, "viewer": viewerAll} {
+		if got := receiveWS(c, time.Second); got != "node_status" {
+			t.Errorf("%s: received %q, want node_status", name, got)
+		}
+	}
+	for name, c := range map[string]*WSClient{"alice stats": aliceStats, "bob": bobAll} {
+		if got := receiveWS(c, 100*time.Millisecond); got != "" {
+			t.Errorf("%s: received %q for another topic or another user's node", name, got)
+		}
+	}
+
+	// 仅推送给订阅了该主题的客户端
+	s.BroadcastStats(gin.H{})
+	if got := receiveWS(bobAll, time.Second); got != "stats" {
+		t.Errorf("bob: received %q, want stats", got)
+	}
+	if got := receiveWS(aliceAll, 100*time.Millisecond); got != "" {
+		t.Errorf("alice nodes: received %q for the stats topic", got)
+	}
+}
+
+func TestWSLogStreamsFilteredByOwnership(t *testing.T) {
+	s := newTestServer(t, nil)
+	// 仅能查看自己资源的审计角色
+	if err := s.svc.CreateRole(&model.Role{
+		Name:        "auditor",
+		Scope:       service.RoleScopeOwn,
+		Permissions: `["alert-logs:read","operation-logs:read","nodes:read"]`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	carol := createTestUser(t, s, "carol", "auditor")
+	dave := createTestUser(t, s, "dave", "auditor")
+	operator := createTestUser(t, s, "ops", service.RoleOperator)
+	alice := createTestUser(t, s, "alice", service.RoleUser)
+	node := &model.Node{Name: "carol-node", Host: "10.0.0.1", AgentToken: "c", OwnerID: &carol.ID}
+	if err := s.svc.DB().Create(node).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	carolC, _ := newTestWSClient(t, s, carol, "alerts", "operation-logs")
+	daveC, _ := newTestWSClient(t, s, dave, "alerts", "operation-logs")
+	opsC, _ := newTestWSClient(t, s, operator, "alerts", "operation-logs")
+	// 普通用户没有日志读权限，订阅被拒绝
+	if _, denied := newTestWSClient(t, s, alice, "alerts", "operation-logs"); len(denied) != 2 {
+		t.Errorf("user without log permissions denied %v", denied)
+	}
+
+	expect := func(event string, want map[*WSClient]bool) {
+		t.Helper()
+		for c, ok := range want {
+			timeout := 100 * time.Millisecond
+			if ok {
+				timeout = time.Second
+			}
+			got := receiveWS(c, timeout)
+			if ok && got != event {
+				t.Errorf("user %d: received %q, want %s", c.userID, got, event)
+			}
+			if !ok && got != "" {
+				t.Errorf("user %d: received %q, want nothing", c.userID, got)
+			}
+		}
+	}
+
+	s.BroadcastAlertLog(&model.AlertLog{Type: "node_offline", TargetType: "node", TargetID: node.ID})
+	expect("alert", map[*WSClient]bool{carolC: true, opsC: true, daveC: false})
+
+	s.BroadcastAlertLog(&model.AlertLog{Type: "account_locked", TargetType: "user", TargetID: dave.ID})
+	expect("alert", map[*WSClient]bool{daveC: true, opsC: true, carolC: false})
+
+	s.BroadcastOperationLog(&model.OperationLog{UserID: carol.ID, Action: "update", Resource: "node"})
+	expect("operation_log", map[*WSClient]bool{carolC: true, opsC: true, daveC: false})
+}
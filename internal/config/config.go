@@ -18,7 +18,9 @@ const (
 
 type Config struct {
 	ListenAddr     string   // 面板监听地址
-	DBPath         string   // 数据库路径
+	DBDriver       string   // 数据库驱动: sqlite/postgres/mysql
+	DBDSN          string   // 数据库连接串 (postgres/mysql 必填)
+	DBPath         string   // SQLite 数据库路径
 	JWTSecret      string   // JWT 密钥
 	AgentGRPCAddr  string   // Agent gRPC 监听地址
 	Debug          bool     // 调试模式
@@ -48,8 +50,10 @@ func Load() *Config {
 	// 解析允许的 CORS 来源
 	allowedOrigins := parseAllowedOrigins(getEnv("ALLOWED_ORIGINS", ""))
 
-	return &Config{
+	cfg := &Config{
 		ListenAddr:     getEnv("LISTEN_ADDR", ":8080"),
+		DBDriver:       getEnv("DB_DRIVER", "sqlite"),
+		DBDSN:          getEnv("DB_DSN", ""),
 		DBPath:         getEnv("DB_PATH", "./data/panel.db"),
 		JWTSecret:      jwtSecret,
 		AgentGRPCAddr:  getEnv("AGENT_GRPC_ADDR", ":9090"),
@@ -58,6 +62,26 @@ func Load() *Config {
 		GitHubRawURL:   getEnv("GITHUB_RAW_URL", DefaultGitHubRawURL),
 		GOSTVersion:    getEnv("GOST_VERSION", DefaultGOSTVersion),
 	}
+
+	// SQLite 的 DB_DSN 即数据库文件路径
+	if cfg.IsSQLite() && cfg.DBDSN != "" {
+		cfg.DBPath = cfg.DBDSN
+	}
+
+	return cfg
+}
+
+// DatabaseDSN 返回数据库连接串，SQLite 返回数据库文件路径
+func (c *Config) DatabaseDSN() string {
+	if c.IsSQLite() {
+		return c.DBPath
+	}
+	return c.DBDSN
+}
+
+// IsSQLite 是否使用 SQLite 数据库
+func (c *Config) IsSQLite() bool {
+	return c.DBDriver == "" || c.DBDriver == "sqlite" || c.DBDriver == "sqlite3"
 }
 
 // parseAllowedOrigins 解析允许的 CORS 来源
@@ -280,6 +280,16 @@ func (c *Client) SyncTunnelConfig(config map[string]interface{}, tunnelID uint)
 		}
 	}
 
+	// 限速器 (tunnel-limiter-{id})
+	c.delete(fmt.Sprintf("/config/limiters/tunnel-limiter-%d", tunnelID))
+	if newLimiters, ok := config["limiters"].([]map[string]interface{}); ok {
+		for _, limiter := range newLimiters {
+			if err := c.post("/config/limiters", limiter); err != nil {
+				return fmt.Errorf("create limiter failed: %w", err)
+			}
+		}
+	}
+
 	// 创建新的转发链
 	if newChains, ok := config["chains"].([]map[string]interface{}); ok {
 		for _, chain := range newChains {
@@ -1,11 +1,15 @@
 package gost
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/goccy/go-yaml"
 )
 
 // ConfigGenerator GOST 配置生成器
@@ -15,6 +19,17 @@ func NewConfigGenerator() *ConfigGenerator {
 	return &ConfigGenerator{}
 }
 
+// RenderConfig 将配置渲染为 YAML (map 键有序，相同配置输出相同内容)
+func RenderConfig(config map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(config)
+}
+
+// ConfigHash 计算渲染后配置内容的 SHA-256
+func ConfigHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 // GenerateNodeConfig 生成节点完整配置
 func (g *ConfigGenerator) GenerateNodeConfig(node *model.Node) map[string]interface{} {
 	return g.GenerateNodeConfigWithRules(node, nil, nil, nil)
@@ -616,9 +631,13 @@ func (g *ConfigGenerator) GeneratePortForwardConfig(pf *model.PortForward) map[s
 		"type": pf.Type,
 	}
 
-	// RTCP/RUDP 远程转发需要在 listener 上配置 chain
-	if (pf.Type == "rtcp" || pf.Type == "rudp") && pf.ChainID != nil && *pf.ChainID > 0 {
-		listener["chain"] = fmt.Sprintf("chain-pf-%d", *pf.ChainID)
+	// RTCP/RUDP 远程转发需要在 listener 上配置 chain，其他类型通过 handler 走转发链
+	if pf.ChainID != nil && *pf.ChainID > 0 {
+		if isRemotePortForward(pf.Type) {
+			listener["chain"] = fmt.Sprintf("chain-pf-%d", *pf.ChainID)
+		} else {
+			handler["chain"] = fmt.Sprintf("chain-pf-%d", *pf.ChainID)
+		}
 	}
 
 	service := map[string]interface{}{
@@ -636,6 +655,398 @@ func (g *ConfigGenerator) GeneratePortForwardConfig(pf *model.PortForward) map[s
 	return service
 }
 
+// PortForwardChain 端口转发使用的代理链及其跳点
+type PortForwardChain struct {
+	Chain *model.ProxyChain
+	Hops  []model.ProxyChainHop
+}
+
+// MergePortForwards 将端口转发规则合并到节点配置中
+// 返回因名称/端口冲突或转发链无效而被跳过的规则说明
+func (g *ConfigGenerator) MergePortForwards(config map[string]interface{}, forwards []model.PortForward, chains map[uint]PortForwardChain) []string {
+	var warnings []string
+
+	services, _ := config["services"].([]map[string]interface{})
+	chainConfigs, _ := config["chains"].([]map[string]interface{})
+	serviceNames, listens, chainNames := indexConfig(config)
+
+	for i := range forwards {
+		pf := &forwards[i]
+		if !pf.Enabled {
+			continue
+		}
+
+		if pf.Name == "" || serviceNames[pf.Name] {
+			warnings = append(warnings, fmt.Sprintf("port forward #%d skipped: service name %q already in use", pf.ID, pf.Name))
+			continue
+		}
+
+		// 远程转发在链路对端监听，不占用本地端口
+		var listen *listenAddr
+		if !isRemotePortForward(pf.Type) {
+			l := newListenAddr(pf.Name, pf.LocalAddr, pf.Type)
+			if conflict := l.conflictsWith(listens); conflict != "" {
+				warnings = append(warnings, fmt.Sprintf("port forward #%d skipped: %s conflicts with service %q", pf.ID, pf.LocalAddr, conflict))
+				continue
+			}
+			listen = &l
+		}
+
+		// 转发链
+		var chainConfig map[string]interface{}
+		if pf.ChainID != nil && *pf.ChainID > 0 {
+			chainName := fmt.Sprintf("chain-pf-%d", *pf.ChainID)
+			if !chainNames[chainName] {
+				pc, ok := chains[*pf.ChainID]
+				if !ok || pc.Chain == nil || !pc.Chain.Enabled {
+					warnings = append(warnings, fmt.Sprintf("port forward #%d skipped: proxy chain #%d not found or disabled", pf.ID, *pf.ChainID))
+					continue
+				}
+				chainConfig = g.GenerateProxyChainConfig(pc.Chain, pc.Hops)
+				if hops, _ := chainConfig["hops"].([]map[string]interface{}); len(hops) == 0 {
+					warnings = append(warnings, fmt.Sprintf("port forward #%d skipped: proxy chain #%d has no enabled hops", pf.ID, *pf.ChainID))
+					continue
+				}
+				chainConfig["name"] = chainName
+			}
+		} else if isRemotePortForward(pf.Type) {
+			warnings = append(warnings, fmt.Sprintf("port forward #%d skipped: %s requires a proxy chain", pf.ID, pf.Type))
+			continue
+		}
+
+		services = append(services, g.GeneratePortForwardConfig(pf))
+		serviceNames[pf.Name] = true
+		if listen != nil {
+			listens = append(listens, *listen)
+		}
+		if chainConfig != nil {
+			chainConfigs = append(chainConfigs, chainConfig)
+			chainNames[chainConfig["name"].(string)] = true
+		}
+	}
+
+	config["services"] = services
+	if len(chainConfigs) > 0 {
+		config["chains"] = chainConfigs
+	}
+
+	return warnings
+}
+
+// MergeTunnels 将隧道合并到节点配置中
+// entryTunnels 为以该节点为入口的隧道，生成入口服务/转发链/限速器；
+// exitTunnels 为以该节点为出口的隧道，出口由主服务承接；主服务无法转发 UDP 时补充 UDP 服务
+// 返回因冲突或配置无效而被跳过的隧道说明
+func (g *ConfigGenerator) MergeTunnels(config map[string]interface{}, entryTunnels, exitTunnels []model.Tunnel) []string {
+	var warnings []string
+
+	services, _ := config["services"].([]map[string]interface{})
+	chainConfigs, _ := config["chains"].([]map[string]interface{})
+	limiters, _ := config["limiters"].([]map[string]interface{})
+	serviceNames, listens, chainNames := indexConfig(config)
+
+	for i := range entryTunnels {
+		tunnel := &entryTunnels[i]
+		if !tunnel.Enabled {
+			continue
+		}
+
+		tunnelConfig := g.GenerateTunnelEntryConfig(tunnel)
+		if tunnelConfig == nil {
+			warnings = append(warnings, fmt.Sprintf("tunnel #%d skipped: exit node not found", tunnel.ID))
+			continue
+		}
+		tunnelServices, _ := tunnelConfig["services"].([]map[string]interface{})
+		tunnelChains, _ := tunnelConfig["chains"].([]map[string]interface{})
+		tunnelLimiters, _ := tunnelConfig["limiters"].([]map[string]interface{})
+
+		// 冲突检测 (任一服务冲突则跳过整个隧道)
+		conflict := ""
+		newListens := []listenAddr{}
+		for _, svc := range tunnelServices {
+			name, _ := svc["name"].(string)
+			if serviceNames[name] {
+				conflict = fmt.Sprintf("service name %q already in use", name)
+				break
+			}
+			addr, _ := svc["addr"].(string)
+			l := newListenAddr(name, addr, serviceListenerType(svc))
+			if other := l.conflictsWith(listens); other != "" {
+				conflict = fmt.Sprintf("port %d conflicts with service %q", tunnel.EntryPort, other)
+				break
+			}
+			newListens = append(newListens, l)
+		}
+		for _, ch := range tunnelChains {
+			if name, _ := ch["name"].(string); conflict == "" && chainNames[name] {
+				conflict = fmt.Sprintf("chain name %q already in use", name)
+			}
+		}
+		if conflict != "" {
+			warnings = append(warnings, fmt.Sprintf("tunnel #%d skipped: %s", tunnel.ID, conflict))
+			continue
+		}
+
+		for _, svc := range tunnelServices {
+			serviceNames[svc["name"].(string)] = true
+		}
+		listens = append(listens, newListens...)
+		for _, ch := range tunnelChains {
+			chainNames[ch["name"].(string)] = true
+		}
+		services = append(services, tunnelServices...)
+		chainConfigs = append(chainConfigs, tunnelChains...)
+		limiters = append(limiters, tunnelLimiters...)
+	}
+
+	// 出口节点: 入口转发链按出口节点协议连接主服务
+	// Shadowsocks 主服务只转发 TCP，UDP 隧道需要同端口的 ssu 服务；其余不支持 UDP 的协议给出提示
+	var exitUDPNode *model.Node
+	var exitUDPTunnels []uint
+	for _, tunnel := range exitTunnels {
+		if !tunnel.Enabled || tunnel.ExitNode == nil || !hasProtocol(g.parseProtocols(tunnel.Protocol), "udp") {
+			continue
+		}
+		switch {
+		case tunnel.ExitNode.Protocol == "ss":
+			exitUDPNode = tunnel.ExitNode
+			exitUDPTunnels = append(exitUDPTunnels, tunnel.ID)
+		case !supportsUDPRelay(tunnel.ExitNode.Protocol):
+			warnings = append(warnings, fmt.Sprintf("tunnel #%d: exit protocol %q does not relay UDP", tunnel.ID, tunnel.ExitNode.Protocol))
+		}
+	}
+	if exitUDPNode != nil {
+		svc := g.generateTunnelExitUDPService(exitUDPNode)
+		l := newListenAddr(svc["name"].(string), svc["addr"].(string), serviceListenerType(svc))
+		conflict := l.conflictsWith(listens)
+		if serviceNames[svc["name"].(string)] {
+			conflict = svc["name"].(string)
+		}
+		if conflict == "" {
+			services = append(services, svc)
+		} else {
+			for _, id := range exitUDPTunnels {
+				warnings = append(warnings, fmt.Sprintf("tunnel #%d: exit UDP service conflicts with service %q", id, conflict))
+			}
+		}
+	}
+
+	config["services"] = services
+	if len(chainConfigs) > 0 {
+		config["chains"] = chainConfigs
+	}
+	if len(limiters) > 0 {
+		config["limiters"] = limiters
+	}
+
+	return warnings
+}
+
+// supportsUDPRelay 协议是否支持通过转发链中转 UDP
+func supportsUDPRelay(protocol string) bool {
+	switch protocol {
+	case "socks5", "", "relay", "ssu", "trojan", "vmess":
+		return true
+	}
+	return false
+}
+
+// indexConfig 收集配置中已占用的服务名、监听地址与转发链名
+func indexConfig(config map[string]interface{}) (map[string]bool, []listenAddr, map[string]bool) {
+	serviceNames := make(map[string]bool)
+	listens := []listenAddr{}
+	services, _ := config["services"].([]map[string]interface{})
+	for _, svc := range services {
+		name, _ := svc["name"].(string)
+		serviceNames[name] = true
+		if addr, ok := svc["addr"].(string); ok {
+			listens = append(listens, newListenAddr(name, addr, serviceListenerType(svc)))
+		}
+	}
+
+	chainNames := make(map[string]bool)
+	chainConfigs, _ := config["chains"].([]map[string]interface{})
+	for _, ch := range chainConfigs {
+		if name, ok := ch["name"].(string); ok {
+			chainNames[name] = true
+		}
+	}
+
+	return serviceNames, listens, chainNames
+}
+
+// isRemotePortForward 是否为远程端口转发 (rtcp/rudp)
+func isRemotePortForward(pfType string) bool {
+	return pfType == "rtcp" || pfType == "rudp"
+}
+
+// listenAddr 服务监听地址 (用于端口冲突检测)
+type listenAddr struct {
+	service string
+	host    string
+	port    string
+	network string
+}
+
+func newListenAddr(service, addr, listenerType string) listenAddr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = "", strings.TrimPrefix(addr, ":")
+	}
+	network := "tcp"
+	switch listenerType {
+	case "udp", "kcp", "quic", "h3", "wt", "dtls":
+		network = "udp"
+	}
+	return listenAddr{service: service, host: host, port: port, network: network}
+}
+
+// conflictsWith 返回与之冲突的服务名，无冲突返回空字符串
+func (l listenAddr) conflictsWith(others []listenAddr) string {
+	isWildcard := func(h string) bool {
+		return h == "" || h == "0.0.0.0" || h == "::"
+	}
+	for _, o := range others {
+		if o.port != l.port || o.network != l.network {
+			continue
+		}
+		if o.host == l.host || isWildcard(o.host) || isWildcard(l.host) {
+			return o.service
+		}
+	}
+	return ""
+}
+
+// serviceListenerType 获取服务 listener 类型
+func serviceListenerType(svc map[string]interface{}) string {
+	if listener, ok := svc["listener"].(map[string]interface{}); ok {
+		if t, ok := listener["type"].(string); ok {
+			return t
+		}
+	}
+	return "tcp"
+}
+
+// SuspendServices 移除配置中的全部服务 (配额超限/套餐到期时停用数据面)，API 保留以便恢复后重新下发
+func SuspendServices(config map[string]interface{}) {
+	config["services"] = []map[string]interface{}{}
+}
+
+// GenerateClientConfig 生成客户端配置 (本地 SOCKS5 + 经节点的反向隧道)
+func (g *ConfigGenerator) GenerateClientConfig(client *model.Client) map[string]interface{} {
+	node := client.Node
+	chainName := "forward-chain"
+
+	config := map[string]interface{}{
+		"services": []map[string]interface{}{
+			// 本地 SOCKS5
+			{
+				"name": "local-socks5",
+				"addr": fmt.Sprintf(":%d", client.LocalPort),
+				"handler": map[string]interface{}{
+					"type":   "socks5",
+					"auther": "local-auth",
+					"metadata": map[string]interface{}{
+						"udp":         true,
+						"udpAddr":     fmt.Sprintf(":%d", client.LocalPort),
+						"ignoreChain": true,
+					},
+				},
+				"listener": map[string]interface{}{
+					"type": "tcp",
+				},
+			},
+			// RTCP 反向隧道
+			{
+				"name": "rtcp-tunnel",
+				"addr": fmt.Sprintf(":%d", client.RemotePort),
+				"handler": map[string]interface{}{
+					"type": "rtcp",
+				},
+				"listener": map[string]interface{}{
+					"type":  "rtcp",
+					"chain": chainName,
+					"metadata": map[string]interface{}{
+						"keepalive": true,
+					},
+				},
+				"forwarder": map[string]interface{}{
+					"nodes": []map[string]interface{}{
+						{"name": "target", "addr": fmt.Sprintf("127.0.0.1:%d", client.LocalPort)},
+					},
+				},
+			},
+			// RUDP 反向隧道
+			{
+				"name": "rudp-tunnel",
+				"addr": fmt.Sprintf(":%d", client.RemotePort),
+				"handler": map[string]interface{}{
+					"type": "rudp",
+				},
+				"listener": map[string]interface{}{
+					"type":  "rudp",
+					"chain": chainName,
+					"metadata": map[string]interface{}{
+						"keepalive": true,
+					},
+				},
+				"forwarder": map[string]interface{}{
+					"nodes": []map[string]interface{}{
+						{"name": "target", "addr": fmt.Sprintf("127.0.0.1:%d", client.LocalPort)},
+					},
+				},
+			},
+		},
+		"chains": []map[string]interface{}{
+			{
+				"name": chainName,
+				"hops": []map[string]interface{}{
+					{
+						"name": "hop-0",
+						"nodes": []map[string]interface{}{
+							{
+								"name": "node-0",
+								// 连接到节点的 SOCKS5 服务 (使用 bind 功能建立反向隧道)
+								"addr": fmt.Sprintf("%s:%d", node.Host, node.Port),
+								"connector": func() map[string]interface{} {
+									c := map[string]interface{}{
+										"type": "socks5",
+									}
+									// 如果节点有代理认证，添加认证信息
+									if node.ProxyUser != "" {
+										c["auth"] = map[string]string{
+											"username": node.ProxyUser,
+											"password": node.ProxyPass,
+										}
+									}
+									return c
+								}(),
+								"dialer": map[string]interface{}{
+									"type": "tcp",
+									"metadata": map[string]interface{}{
+										"keepAlive":       true,
+										"keepAlivePeriod": "15s",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"authers": []map[string]interface{}{
+			{
+				"name": "local-auth",
+				"auths": []map[string]string{
+					{"username": client.ProxyUser, "password": client.ProxyPass},
+				},
+			},
+		},
+	}
+
+	return config
+}
+
 // GenerateChainConfig 生成转发链配置 (用于负载均衡)
 func (g *ConfigGenerator) GenerateChainConfig(group *model.NodeGroup, members []NodeMemberWithNode) map[string]interface{} {
 	nodes := make([]map[string]interface{}, 0, len(members))
@@ -810,53 +1221,41 @@ func (g *ConfigGenerator) GenerateTunnelEntryConfig(tunnel *model.Tunnel) map[st
 	}
 
 	chainName := fmt.Sprintf("tunnel-chain-%d", tunnel.ID)
+	limiterName := fmt.Sprintf("tunnel-limiter-%d", tunnel.ID)
+	protocols := g.parseProtocols(tunnel.Protocol)
 
-	// 转发链配置 - 连接到出口节点
-	connector := map[string]interface{}{
-		"type": exitNode.Protocol,
-	}
-	if exitNode.ProxyUser != "" {
-		connector["auth"] = map[string]string{
-			"username": exitNode.ProxyUser,
-			"password": exitNode.ProxyPass,
-		}
-	}
-
+	// 转发链配置 - 连接到出口节点主服务
 	dialer := map[string]interface{}{
 		"type": normalizeTransport(exitNode.Transport),
 	}
 	if exitNode.TLSEnabled {
 		dialer["tls"] = g.generateTLSConfig(exitNode)
 	}
+	chains := []map[string]interface{}{
+		tunnelChain(chainName, exitNode, tunnelConnector(exitNode, exitNode.Protocol), dialer),
+	}
 
-	chain := map[string]interface{}{
-		"name": chainName,
-		"hops": []map[string]interface{}{
-			{
-				"name": "hop-0",
-				"nodes": []map[string]interface{}{
-					{
-						"name":      fmt.Sprintf("exit-%d", exitNode.ID),
-						"addr":      fmt.Sprintf("%s:%d", exitNode.Host, exitNode.Port),
-						"connector": connector,
-						"dialer":    dialer,
-					},
-				},
-			},
-		},
+	// Shadowsocks 主服务不转发 UDP，UDP 经出口节点同端口的 ssu 服务转发 (见 MergeTunnels)
+	udpChainName := chainName
+	if exitNode.Protocol == "ss" && hasProtocol(protocols, "udp") {
+		udpChainName = chainName + "-udp"
+		chains = append(chains, tunnelChain(udpChainName, exitNode, tunnelConnector(exitNode, "ssu"), map[string]interface{}{"type": "udp"}))
 	}
 
 	// 生成服务列表 - 支持端口复用 (tcp+udp)
 	services := []map[string]interface{}{}
-	protocols := g.parseProtocols(tunnel.Protocol)
 
 	for _, proto := range protocols {
+		serviceChain := chainName
+		if proto == "udp" {
+			serviceChain = udpChainName
+		}
 		service := map[string]interface{}{
 			"name": fmt.Sprintf("tunnel-%d-%s", tunnel.ID, proto),
 			"addr": fmt.Sprintf(":%d", tunnel.EntryPort),
 			"handler": map[string]interface{}{
 				"type":  proto,
-				"chain": chainName,
+				"chain": serviceChain,
 			},
 			"listener": map[string]interface{}{
 				"type": proto,
@@ -874,7 +1273,7 @@ func (g *ConfigGenerator) GenerateTunnelEntryConfig(tunnel *model.Tunnel) map[st
 
 		// 限速配置
 		if tunnel.SpeedLimit > 0 {
-			service["limiter"] = "tunnel-limiter"
+			service["limiter"] = limiterName
 		}
 
 		services = append(services, service)
@@ -882,7 +1281,7 @@ func (g *ConfigGenerator) GenerateTunnelEntryConfig(tunnel *model.Tunnel) map[st
 
 	config := map[string]interface{}{
 		"services": services,
-		"chains":   []map[string]interface{}{chain},
+		"chains":   chains,
 	}
 
 	// 添加限速器
@@ -897,7 +1296,7 @@ func (g *ConfigGenerator) GenerateTunnelEntryConfig(tunnel *model.Tunnel) map[st
 		}
 		config["limiters"] = []map[string]interface{}{
 			{
-				"name":   "tunnel-limiter",
+				"name":   limiterName,
 				"limits": []string{"$ " + limit},
 			},
 		}
@@ -906,6 +1305,65 @@ func (g *ConfigGenerator) GenerateTunnelEntryConfig(tunnel *model.Tunnel) map[st
 	return config
 }
 
+// tunnelConnector 入口转发链连接出口主服务的 connector，认证方式与出口 handler 一致
+func tunnelConnector(exitNode *model.Node, connectorType string) map[string]interface{} {
+	connector := map[string]interface{}{
+		"type": connectorType,
+	}
+	switch {
+	case connectorType == "ss" || connectorType == "ssu":
+		connector["auth"] = map[string]string{
+			"username": exitNode.SSMethod,
+			"password": exitNode.SSPassword,
+		}
+	case exitNode.ProxyUser != "":
+		connector["auth"] = map[string]string{
+			"username": exitNode.ProxyUser,
+			"password": exitNode.ProxyPass,
+		}
+	}
+	return connector
+}
+
+// tunnelChain 生成连接出口节点的单跳转发链
+func tunnelChain(name string, exitNode *model.Node, connector, dialer map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name,
+		"hops": []map[string]interface{}{
+			{
+				"name": "hop-0",
+				"nodes": []map[string]interface{}{
+					{
+						"name":      fmt.Sprintf("exit-%d", exitNode.ID),
+						"addr":      fmt.Sprintf("%s:%d", exitNode.Host, exitNode.Port),
+						"connector": connector,
+						"dialer":    dialer,
+					},
+				},
+			},
+		},
+	}
+}
+
+// generateTunnelExitUDPService 生成 Shadowsocks 出口节点的 UDP 服务 (与主服务同端口，承接隧道 UDP 流量)
+func (g *ConfigGenerator) generateTunnelExitUDPService(node *model.Node) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     "tunnel-exit-udp",
+		"addr":     fmt.Sprintf(":%d", node.Port),
+		"observer": "stats-observer",
+		"handler": map[string]interface{}{
+			"type": "ssu",
+			"auth": map[string]string{
+				"username": node.SSMethod,
+				"password": node.SSPassword,
+			},
+		},
+		"listener": map[string]interface{}{
+			"type": "udp",
+		},
+	}
+}
+
 // parseProtocols 解析协议字符串，支持 tcp+udp 格式
 func (g *ConfigGenerator) parseProtocols(protocol string) []string {
 	switch protocol {
@@ -920,6 +1378,16 @@ func (g *ConfigGenerator) parseProtocols(protocol string) []string {
 	}
 }
 
+// hasProtocol 协议列表是否包含指定协议
+func hasProtocol(protocols []string, proto string) bool {
+	for _, p := range protocols {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
 // normalizeTransport 标准化传输层协议 (tcp+udp -> tcp)
 func normalizeTransport(transport string) string {
 	switch transport {
@@ -933,12 +1401,14 @@ func normalizeTransport(transport string) string {
 }
 
 // GenerateTunnelExitConfig 生成隧道出口端配置 (部署在出口节点)
-// 出口节点使用标准节点配置即可
+// 出口节点使用标准节点配置，并补充隧道需要的出口服务
 func (g *ConfigGenerator) GenerateTunnelExitConfig(tunnel *model.Tunnel) map[string]interface{} {
 	if tunnel.ExitNode == nil {
 		return nil
 	}
-	return g.GenerateNodeConfig(tunnel.ExitNode)
+	config := g.GenerateNodeConfig(tunnel.ExitNode)
+	g.MergeTunnels(config, nil, []model.Tunnel{*tunnel})
+	return config
 }
 
 // ==================== Bypass/Admission/Hosts 配置生成 ====================
@@ -1105,3 +1575,179 @@ func (g *ConfigGenerator) generateRouterConfigs(nodeID uint, routers []model.Rou
 		},
 	}
 }
+
+// generateRecorderConfigs 生成 Recorder 记录器配置 (每个记录器独立输出)
+func (g *ConfigGenerator) generateRecorderConfigs(recorders []model.Recorder) []map[string]interface{} {
+	configs := []map[string]interface{}{}
+
+	for _, r := range recorders {
+		switch r.Type {
+		case "file", "redis", "http":
+		default:
+			continue
+		}
+
+		opts := map[string]interface{}{}
+		if r.Config != "" {
+			if err := json.Unmarshal([]byte(r.Config), &opts); err != nil {
+				continue
+			}
+		}
+		normalizeTimeout(opts)
+
+		configs = append(configs, map[string]interface{}{
+			"name": fmt.Sprintf("recorder-%d", r.ID),
+			r.Type: opts,
+		})
+	}
+
+	if len(configs) == 0 {
+		return nil
+	}
+	return configs
+}
+
+// generateSDConfigs 生成 SD 服务发现配置，返回无法生成的条目说明
+func (g *ConfigGenerator) generateSDConfigs(sds []model.SD) ([]map[string]interface{}, []string) {
+	configs := []map[string]interface{}{}
+	var warnings []string
+
+	for _, sd := range sds {
+		plugin, err := sdPlugin(sd.Type, sd.Config)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("sd #%d skipped: %v", sd.ID, err))
+			continue
+		}
+		configs = append(configs, map[string]interface{}{
+			"name":   fmt.Sprintf("sd-%d", sd.ID),
+			"plugin": plugin,
+		})
+	}
+
+	if len(configs) == 0 {
+		return nil, warnings
+	}
+	return configs, warnings
+}
+
+// ValidateSD 校验服务发现配置能否生成 GOST 配置
+func ValidateSD(sdType, config string) error {
+	_, err := sdPlugin(sdType, config)
+	return err
+}
+
+// sdPlugin 生成服务发现插件配置
+// GOST 仅支持通过 HTTP/gRPC 插件对接服务发现，Consul/Etcd/Redis 需自行部署插件服务
+func sdPlugin(sdType, config string) (map[string]interface{}, error) {
+	opts := map[string]interface{}{}
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &opts); err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	normalizeTimeout(opts)
+
+	// HTTP 插件地址为 url，gRPC 插件为 addr
+	addrKey := "url"
+	switch sdType {
+	case "http", "":
+		sdType = "http"
+	case "grpc":
+		addrKey = "addr"
+	default:
+		return nil, fmt.Errorf("unsupported type %q, GOST only supports http/grpc plugins", sdType)
+	}
+	addr, _ := opts[addrKey].(string)
+	if strings.TrimSpace(addr) == "" {
+		return nil, fmt.Errorf("%s plugin requires %q", sdType, addrKey)
+	}
+
+	plugin := map[string]interface{}{
+		"type": sdType,
+		"addr": addr,
+	}
+	for _, key := range []string{"timeout", "token"} {
+		if v, ok := opts[key]; ok {
+			plugin[key] = v
+		}
+	}
+	return plugin, nil
+}
+
+// normalizeTimeout 将数字形式的 timeout (秒) 转换为 GOST 时长格式
+func normalizeTimeout(opts map[string]interface{}) {
+	if v, ok := opts["timeout"].(float64); ok {
+		opts["timeout"] = fmt.Sprintf("%ds", int(v))
+	}
+}
+
+// MergeRecorderRouterSDs 将 Recorder/Router/SD 合并到节点配置并挂载到主服务
+// 返回被跳过的服务发现配置说明
+func (g *ConfigGenerator) MergeRecorderRouterSDs(config map[string]interface{}, nodeID uint, recorders []model.Recorder, routers []model.Router, sds []model.SD) []string {
+	var mainService map[string]interface{}
+	services, _ := config["services"].([]map[string]interface{})
+	for _, svc := range services {
+		if svc["name"] == "main-service" {
+			mainService = svc
+			break
+		}
+	}
+
+	handlerMetadata := func() map[string]interface{} {
+		handler, ok := mainService["handler"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		metadata, _ := handler["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = map[string]interface{}{}
+			handler["metadata"] = metadata
+		}
+		return metadata
+	}
+
+	// Recorder 配置
+	if recorderConfigs := g.generateRecorderConfigs(recorders); len(recorderConfigs) > 0 {
+		config["recorders"] = recorderConfigs
+		if mainService != nil {
+			refs := make([]map[string]interface{}, 0, len(recorderConfigs))
+			for _, r := range recorderConfigs {
+				refs = append(refs, map[string]interface{}{
+					"name":   r["name"],
+					"record": "recorder.service.handler",
+				})
+			}
+			mainService["recorders"] = refs
+		}
+	}
+
+	// Router 配置
+	if routerConfigs := g.generateRouterConfigs(nodeID, routers); len(routerConfigs) > 0 {
+		config["routers"] = routerConfigs
+		if metadata := handlerMetadata(); metadata != nil {
+			metadata["router"] = routerConfigs[0]["name"]
+		}
+	}
+
+	// SD 配置 (节点专属优先于全局)
+	sdConfigs, warnings := g.generateSDConfigs(sds)
+	if len(sdConfigs) > 0 {
+		config["sds"] = sdConfigs
+		generated := make(map[string]bool, len(sdConfigs))
+		for _, sd := range sdConfigs {
+			generated[sd["name"].(string)] = true
+		}
+		ref := sdConfigs[0]["name"].(string)
+		for _, sd := range sds {
+			name := fmt.Sprintf("sd-%d", sd.ID)
+			if sd.NodeID != nil && generated[name] {
+				ref = name
+				break
+			}
+		}
+		if metadata := handlerMetadata(); metadata != nil {
+			metadata["sd"] = ref
+		}
+	}
+	return warnings
+}
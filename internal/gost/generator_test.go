@@ -0,0 +1,297 @@
+package gost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// testNode 监听 :1080 的 SOCKS5 节点
+func testNode() *model.Node {
+	return &model.Node{ID: 1, Name: "node", Host: "node.example.com", Port: 1080, Protocol: "socks5", Transport: "tcp"}
+}
+
+// findService 按名称查找配置中的服务
+func findService(config map[string]interface{}, name string) map[string]interface{} {
+	services, _ := config["services"].([]map[string]interface{})
+	for _, svc := range services {
+		if svc["name"] == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+func TestMergeRecorderRouterSDs(t *testing.T) {
+	g := NewConfigGenerator()
+	config := g.GenerateNodeConfig(testNode())
+	nodeID := uint(1)
+
+	recorders := []model.Recorder{
+		{ID: 1, Type: "file", Config: `{"path": "/var/log/gost/traffic.log"}`},
+		{ID: 2, Type: "http", Config: `{"url": "http://rec.example.com", "timeout": 5}`},
+		{ID: 3, Type: "unknown", Config: `{}`},
+	}
+	routers := []model.Router{
+		{ID: 1, Routes: `[{"net": "192.168.0.0/16", "gateway": "192.168.0.1"}]`},
+		{ID: 2, Routes: `[{"net": "10.0.0.0/8", "gateway": "10.0.0.1"}]`},
+	}
+	sds := []model.SD{
+		{ID: 1, Type: "http", Config: `{"url": "http://sd.example.com", "timeout": 3}`},
+		{ID: 2, Type: "grpc", Config: `{"addr": "sd.example.com:8000", "token": "secret"}`, NodeID: &nodeID},
+		{ID: 3, Type: "http", Config: `{"timeout": 3}`},
+		{ID: 4, Type: "consul", Config: `{"addr": "127.0.0.1:8500"}`},
+	}
+	warnings := g.MergeRecorderRouterSDs(config, nodeID, recorders, routers, sds)
+
+	// 记录器: 未知类型被忽略，timeout 转换为时长
+	recorderConfigs, _ := config["recorders"].([]map[string]interface{})
+	if len(recorderConfigs) != 2 {
+		t.Fatalf("recorders = %v", recorderConfigs)
+	}
+	if opts, _ := recorderConfigs[1]["http"].(map[string]interface{}); opts["timeout"] != "5s" {
+		t.Errorf("recorder http options = %v", recorderConfigs[1])
+	}
+
+	// 路由: 合并为节点级路由表
+	routerConfigs, _ := config["routers"].([]map[string]interface{})
+	if len(routerConfigs) != 1 || routerConfigs[0]["name"] != "router-1" {
+		t.Fatalf("routers = %v", routerConfigs)
+	}
+	if routes, _ := routerConfigs[0]["routes"].([]map[string]interface{}); len(routes) != 2 {
+		t.Errorf("routes = %v", routes)
+	}
+
+	// 服务发现: 只生成插件配置，缺少地址或不支持的类型被跳过并说明
+	sdConfigs, _ := config["sds"].([]map[string]interface{})
+	if len(sdConfigs) != 2 {
+		t.Fatalf("sds = %v", sdConfigs)
+	}
+	httpPlugin, _ := sdConfigs[0]["plugin"].(map[string]interface{})
+	if httpPlugin["type"] != "http" || httpPlugin["addr"] != "http://sd.example.com" || httpPlugin["timeout"] != "3s" {
+		t.Errorf("http sd plugin = %v", httpPlugin)
+	}
+	grpcPlugin, _ := sdConfigs[1]["plugin"].(map[string]interface{})
+	if grpcPlugin["type"] != "grpc" || grpcPlugin["addr"] != "sd.example.com:8000" || grpcPlugin["token"] != "secret" {
+		t.Errorf("grpc sd plugin = %v", grpcPlugin)
+	}
+	for _, sd := range sdConfigs {
+		if _, ok := sd["consul"]; ok {
+			t.Errorf("unsupported sd type emitted: %v", sd)
+		}
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "sd #3") || !strings.Contains(warnings[1], "sd #4") {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	// 主服务引用记录器、路由与节点专属的服务发现
+	main := findService(config, "main-service")
+	if refs, _ := main["recorders"].([]map[string]interface{}); len(refs) != 2 || refs[0]["name"] != "recorder-1" {
+		t.Errorf("main service recorders = %v", main["recorders"])
+	}
+	metadata, _ := main["handler"].(map[string]interface{})["metadata"].(map[string]interface{})
+	if metadata["router"] != "router-1" || metadata["sd"] != "sd-2" {
+		t.Errorf("handler metadata = %v", metadata)
+	}
+}
+
+func TestValidateSD(t *testing.T) {
+	cases := []struct {
+		sdType, config string
+		ok             bool
+	}{
+		{"http", `{"url": "http://sd.example.com"}`, true},
+		{"", `{"url": "http://sd.example.com"}`, true},
+		{"grpc", `{"addr": "127.0.0.1:8000"}`, true},
+		{"http", `{}`, false},
+		{"grpc", `{"url": "http://sd.example.com"}`, false},
+		{"consul", `{"addr": "127.0.0.1:8500"}`, false},
+		{"http", `not json`, false},
+	}
+	for _, tc := range cases {
+		if err := ValidateSD(tc.sdType, tc.config); (err == nil) != tc.ok {
+			t.Errorf("ValidateSD(%q, %s) = %v", tc.sdType, tc.config, err)
+		}
+	}
+}
+
+// testTunnel 以 exit 为出口、监听入口端口 10000 的 tcp+udp 隧道
+func testTunnel(exit *model.Node) model.Tunnel {
+	return model.Tunnel{ID: 7, EntryNodeID: 1, EntryPort: 10000, Protocol: "tcp+udp", ExitNodeID: exit.ID, ExitNode: exit, TargetAddr: "1.1.1.1:53", Enabled: true}
+}
+
+// serviceChain 服务 handler 引用的转发链
+func serviceChain(svc map[string]interface{}) interface{} {
+	handler, _ := svc["handler"].(map[string]interface{})
+	return handler["chain"]
+}
+
+func TestMergeTunnelsEntrySide(t *testing.T) {
+	g := NewConfigGenerator()
+	exit := &model.Node{ID: 2, Host: "exit.example.com", Port: 8388, Protocol: "socks5", Transport: "tcp", ProxyUser: "u", ProxyPass: "p"}
+	config := g.GenerateNodeConfig(testNode())
+	tunnel := testTunnel(exit)
+	tunnel.SpeedLimit = 1024 * 1024
+
+	// 端口冲突的隧道整体跳过
+	conflicting := testTunnel(exit)
+	conflicting.ID, conflicting.EntryPort = 8, 1080
+	disabled := testTunnel(exit)
+	disabled.ID, disabled.EntryPort, disabled.Enabled = 9, 10001, false
+
+	warnings := g.MergeTunnels(config, []model.Tunnel{tunnel, conflicting, disabled}, nil)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "tunnel #8 skipped") {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	tcp, udp := findService(config, "tunnel-7-tcp"), findService(config, "tunnel-7-udp")
+	if tcp == nil || udp == nil {
+		t.Fatalf("services = %v", config["services"])
+	}
+	if tcp["addr"] != ":10000" || udp["addr"] != ":10000" || serviceListenerType(udp) != "udp" {
+		t.Errorf("tunnel listeners = %v / %v", tcp, udp)
+	}
+	if serviceChain(tcp) != "tunnel-chain-7" || serviceChain(udp) != "tunnel-chain-7" || tcp["limiter"] != "tunnel-limiter-7" {
+		t.Errorf("tunnel handlers = %v / %v", tcp, udp)
+	}
+	if findService(config, "tunnel-8-tcp") != nil || findService(config, "tunnel-9-tcp") != nil {
+		t.Error("conflicting or disabled tunnel merged")
+	}
+
+	chains, _ := config["chains"].([]map[string]interface{})
+	if len(chains) != 1 || chains[0]["name"] != "tunnel-chain-7" {
+		t.Fatalf("chains = %v", chains)
+	}
+	hop := chains[0]["hops"].([]map[string]interface{})[0]["nodes"].([]map[string]interface{})[0]
+	connector := hop["connector"].(map[string]interface{})
+	if hop["addr"] != "exit.example.com:8388" || connector["type"] != "socks5" || connector["auth"].(map[string]string)["username"] != "u" {
+		t.Errorf("chain node = %v", hop)
+	}
+	if limiters, _ := config["limiters"].([]map[string]interface{}); len(limiters) != 1 || limiters[0]["name"] != "tunnel-limiter-7" {
+		t.Errorf("limiters = %v", config["limiters"])
+	}
+}
+
+func TestMergeTunnelsShadowsocksExit(t *testing.T) {
+	g := NewConfigGenerator()
+	exit := &model.Node{ID: 2, Host: "exit.example.com", Port: 8388, Protocol: "ss", Transport: "tcp", SSMethod: "aes-256-gcm", SSPassword: "secret"}
+	tunnel := testTunnel(exit)
+
+	// 入口: TCP 经 ss 主服务，UDP 经 ssu 转发链
+	entry := g.GenerateNodeConfig(testNode())
+	g.MergeTunnels(entry, []model.Tunnel{tunnel}, nil)
+	if serviceChain(findService(entry, "tunnel-7-tcp")) != "tunnel-chain-7" || serviceChain(findService(entry, "tunnel-7-udp")) != "tunnel-chain-7-udp" {
+		t.Fatalf("entry services = %v", entry["services"])
+	}
+	chains := entry["chains"].([]map[string]interface{})
+	udpNode := chains[1]["hops"].([]map[string]interface{})[0]["nodes"].([]map[string]interface{})[0]
+	if udpNode["connector"].(map[string]interface{})["type"] != "ssu" || udpNode["dialer"].(map[string]interface{})["type"] != "udp" {
+		t.Errorf("udp chain node = %v", udpNode)
+	}
+	if auth := udpNode["connector"].(map[string]interface{})["auth"].(map[string]string); auth["username"] != "aes-256-gcm" || auth["password"] != "secret" {
+		t.Errorf("ssu connector auth = %v", auth)
+	}
+
+	// 出口: 主服务同端口增加 ssu UDP 服务，多条隧道共用
+	exitConfig := g.GenerateNodeConfig(exit)
+	second := testTunnel(exit)
+	second.ID = 8
+	if warnings := g.MergeTunnels(exitConfig, nil, []model.Tunnel{tunnel, second}); len(warnings) != 0 {
+		t.Errorf("warnings = %v", warnings)
+	}
+	services := exitConfig["services"].([]map[string]interface{})
+	if len(services) != 2 {
+		t.Fatalf("exit services = %v", services)
+	}
+	udp := findService(exitConfig, "tunnel-exit-udp")
+	handler, _ := udp["handler"].(map[string]interface{})
+	if udp["addr"] != ":8388" || serviceListenerType(udp) != "udp" || handler["type"] != "ssu" {
+		t.Errorf("exit udp service = %v", udp)
+	}
+	if cfg := g.GenerateTunnelExitConfig(&tunnel); findService(cfg, "tunnel-exit-udp") == nil {
+		t.Error("tunnel exit config lacks the udp service")
+	}
+
+	// 仅 TCP 的隧道不需要 UDP 服务
+	tcpOnly := g.GenerateNodeConfig(exit)
+	tunnel.Protocol = "tcp"
+	g.MergeTunnels(tcpOnly, nil, []model.Tunnel{tunnel})
+	if findService(tcpOnly, "tunnel-exit-udp") != nil {
+		t.Error("udp service generated for a tcp tunnel")
+	}
+}
+
+func TestMergeTunnelsExitWithoutUDPRelay(t *testing.T) {
+	g := NewConfigGenerator()
+	exit := &model.Node{ID: 2, Host: "exit.example.com", Port: 8080, Protocol: "http", Transport: "tcp"}
+	config := g.GenerateNodeConfig(exit)
+	warnings := g.MergeTunnels(config, nil, []model.Tunnel{testTunnel(exit)})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "does not relay UDP") {
+		t.Errorf("warnings = %v", warnings)
+	}
+	if len(config["services"].([]map[string]interface{})) != 1 {
+		t.Errorf("services = %v", config["services"])
+	}
+}
+
+func TestListenAddrConflicts(t *testing.T) {
+	existing := []listenAddr{
+		newListenAddr("main-service", ":1080", "tcp"),
+		newListenAddr("dns", "127.0.0.1:53", "udp"),
+		newListenAddr("kcp", "[::]:4000", "kcp"),
+	}
+	cases := []struct {
+		addr, listenerType, want string
+	}{
+		{":1080", "tcp", "main-service"},
+		{"0.0.0.0:1080", "tcp", "main-service"},
+		{"10.0.0.1:1080", "tcp", "main-service"}, // 通配地址占用全部网卡
+		{":1080", "udp", ""},                     // TCP 与 UDP 可共用端口
+		{"127.0.0.1:53", "udp", "dns"},
+		{"10.0.0.1:53", "udp", ""},
+		{":53", "udp", "dns"},
+		{":4000", "quic", "kcp"},
+		{":4000", "tcp", ""},
+		{"1081", "tcp", ""},
+	}
+	for _, tc := range cases {
+		if got := newListenAddr("new", tc.addr, tc.listenerType).conflictsWith(existing); got != tc.want {
+			t.Errorf("%s/%s conflicts with %q, want %q", tc.addr, tc.listenerType, got, tc.want)
+		}
+	}
+}
+
+func TestMergePortForwards(t *testing.T) {
+	g := NewConfigGenerator()
+	config := g.GenerateNodeConfig(testNode())
+	chainID := uint(3)
+	forwards := []model.PortForward{
+		{ID: 1, Name: "web", Type: "tcp", LocalAddr: ":8080", RemoteAddr: "10.0.0.2:80", Enabled: true},
+		{ID: 2, Name: "dns", Type: "udp", LocalAddr: ":8080", RemoteAddr: "10.0.0.2:53", Enabled: true},
+		{ID: 3, Name: "clash", Type: "tcp", LocalAddr: "0.0.0.0:1080", RemoteAddr: "10.0.0.2:22", Enabled: true},
+		{ID: 4, Name: "web", Type: "tcp", LocalAddr: ":8081", RemoteAddr: "10.0.0.2:80", Enabled: true},
+		{ID: 5, Name: "remote", Type: "rtcp", LocalAddr: ":1080", RemoteAddr: "127.0.0.1:22", Enabled: true},
+		{ID: 6, Name: "chained", Type: "tcp", LocalAddr: ":9000", RemoteAddr: "10.0.0.2:22", ChainID: &chainID, Enabled: true},
+		{ID: 7, Name: "off", Type: "tcp", LocalAddr: ":1080", RemoteAddr: "10.0.0.2:22"},
+	}
+
+	warnings := g.MergePortForwards(config, forwards, nil)
+	for _, name := range []string{"web", "dns"} {
+		if findService(config, name) == nil {
+			t.Errorf("port forward %s not merged", name)
+		}
+	}
+	if findService(config, "clash") != nil || findService(config, "remote") != nil || findService(config, "chained") != nil || findService(config, "off") != nil {
+		t.Errorf("services = %v", config["services"])
+	}
+	want := []string{"#3 skipped: 0.0.0.0:1080 conflicts", "#4 skipped: service name", "#5 skipped: rtcp requires a proxy chain", "#6 skipped: proxy chain #3 not found"}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %v", warnings)
+	}
+	for i, w := range want {
+		if !strings.Contains(warnings[i], w) {
+			t.Errorf("warning %d = %q, want %q", i, warnings[i], w)
+		}
+	}
+}
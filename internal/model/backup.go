@@ -0,0 +1,177 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ==================== 逻辑备份/恢复 ====================
+
+// BackupVersion 逻辑备份格式版本
+const BackupVersion = 1
+
+const backupBatchSize = 500
+
+// Backup 逻辑备份文件结构 (与数据库驱动无关)
+type Backup struct {
+	Version   int                                     `json:"version"`
+	Driver    string                                  `json:"driver"`
+	CreatedAt time.Time                               `json:"created_at"`
+	Tables    map[string][]map[string]json.RawMessage `json:"tables"`
+}
+
+// DumpDatabase 将所有数据表导出为 JSON 逻辑备份
+// 按列名导出 (包含 json:"-" 的隐藏字段，如密码哈希)，可在不同数据库驱动之间恢复
+func DumpDatabase(db *gorm.DB, w io.Writer) error {
+	header := fmt.Sprintf(`{"version":%d,"driver":%q,"created_at":%q,"tables":{`,
+		BackupVersion, db.Dialector.Name(), time.Now().Format(time.RFC3339))
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	for i, m := range AllModels() {
+		sch, err := parseSchema(db, m)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		name, _ := json.Marshal(sch.Table)
+		if _, err := fmt.Fprintf(w, "%s:[", name); err != nil {
+			return err
+		}
+
+		first := true
+		rows := reflect.New(reflect.SliceOf(sch.ModelType)).Interface()
+		result := db.Model(m).FindInBatches(rows, backupBatchSize, func(tx *gorm.DB, batch int) error {
+			slice := reflect.ValueOf(rows).Elem()
+			for j := 0; j < slice.Len(); j++ {
+				row := make(map[string]interface{}, len(sch.DBNames))
+				for _, field := range sch.Fields {
+					if field.DBName == "" {
+						continue
+					}
+					row[field.DBName] = field.ReflectValueOf(db.Statement.Context, slice.Index(j)).Interface()
+				}
+				data, err := json.Marshal(row)
+				if err != nil {
+					return err
+				}
+				if !first {
+					if _, err := io.WriteString(w, ","); err != nil {
+						return err
+					}
+				}
+				first = false
+				if _, err := w.Write(data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if result.Error != nil {
+			return fmt.Errorf("dump %s: %w", sch.Table, result.Error)
+		}
+		if _, err := io.WriteString(w, "]"); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "}}")
+	return err
+}
+
+// RestoreDatabase 从 JSON 逻辑备份恢复所有数据表 (单事务，失败时回滚)
+// 备份中不存在的表会被清空，备份中多余的表/列会被忽略
+func RestoreDatabase(db *gorm.DB, r io.Reader) error {
+	var backup Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return fmt.Errorf("invalid backup file: %w", err)
+	}
+	if backup.Version == 0 || backup.Tables == nil {
+		return fmt.Errorf("invalid backup file: missing version or tables")
+	}
+	if backup.Version > BackupVersion {
+		return fmt.Errorf("unsupported backup version: %d", backup.Version)
+	}
+
+	models := AllModels()
+	return db.Transaction(func(tx *gorm.DB) error {
+		// 清空现有数据
+		for i := len(models) - 1; i >= 0; i-- {
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(models[i]).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, m := range models {
+			sch, err := parseSchema(tx, m)
+			if err != nil {
+				return err
+			}
+			rows := backup.Tables[sch.Table]
+			if len(rows) == 0 {
+				continue
+			}
+
+			// 按列写入 map: 结构体插入会把零值字段 (如 enabled=false) 替换为 default 标签的值
+			values := make([]map[string]interface{}, 0, len(rows))
+			for _, row := range rows {
+				item := reflect.New(sch.ModelType).Elem()
+				value := make(map[string]interface{}, len(row))
+				for _, field := range sch.Fields {
+					raw, ok := row[field.DBName]
+					if field.DBName == "" || !ok {
+						continue
+					}
+					target := field.ReflectValueOf(tx.Statement.Context, item).Addr().Interface()
+					if err := json.Unmarshal(raw, target); err != nil {
+						return fmt.Errorf("restore %s.%s: %w", sch.Table, field.DBName, err)
+					}
+					value[field.DBName], _ = field.ValueOf(tx.Statement.Context, item)
+				}
+				values = append(values, value)
+			}
+
+			if err := tx.Table(sch.Table).CreateInBatches(values, backupBatchSize).Error; err != nil {
+				return fmt.Errorf("restore %s: %w", sch.Table, err)
+			}
+
+			if err := resetSequence(tx, sch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// resetSequence 恢复显式主键后同步 PostgreSQL 自增序列
+func resetSequence(tx *gorm.DB, sch *schema.Schema) error {
+	if tx.Dialector.Name() != DriverPostgres {
+		return nil
+	}
+	pk := sch.PrioritizedPrimaryField
+	if pk == nil || !pk.AutoIncrement {
+		return nil
+	}
+	return tx.Exec(fmt.Sprintf(
+		"SELECT setval(pg_get_serial_sequence('%s', '%s'), COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)",
+		sch.Table, pk.DBName, pk.DBName, sch.Table)).Error
+}
+
+func parseSchema(db *gorm.DB, m interface{}) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(m); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
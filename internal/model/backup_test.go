@@ -0,0 +1,243 @@
+package model
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// seedBackupData 写入包含零值布尔、隐藏字段与不连续主键的数据
+func seedBackupData(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	email := "bob@example.com"
+	bob := &User{Username: "bob", Email: &email, Password: HashPassword("Str0ng-Passw0rd!"), Role: "user", Enabled: true}
+	if err := db.Create(bob).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Model(bob).Update("enabled", false)
+
+	for _, name := range []string{"hk", "tmp", "jp"} {
+		node := &Node{Name: name, Host: name + ".example.com", APIUser: "gost", APIPass: "secret-" + name, AgentToken: "token-" + name}
+		if err := db.Create(node).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Where("name = ?", "tmp").Delete(&Node{})
+}
+
+func dumpToBuffer(t *testing.T, db *gorm.DB) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := DumpDatabase(db, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// assertSameData 比较两个数据库中各表行数以及用户、节点数据
+func assertSameData(t *testing.T, want, got *gorm.DB) {
+	t.Helper()
+	for _, m := range AllModels() {
+		var a, b int64
+		want.Model(m).Count(&a)
+		got.Model(m).Count(&b)
+		if a != b {
+			t.Errorf("%T rows = %d, want %d", m, b, a)
+		}
+	}
+
+	var wantUser, gotUser User
+	want.Where("username = ?", "bob").First(&wantUser)
+	if err := got.Where("username = ?", "bob").First(&gotUser).Error; err != nil {
+		t.Fatalf("restored user: %v", err)
+	}
+	if gotUser.ID != wantUser.ID || gotUser.Enabled || gotUser.Password != wantUser.Password ||
+		gotUser.Email == nil || *gotUser.Email != *wantUser.Email {
+		t.Errorf("restored user = %+v", gotUser)
+	}
+
+	var wantNodes, gotNodes []Node
+	want.Order("id").Find(&wantNodes)
+	got.Order("id").Find(&gotNodes)
+	if len(gotNodes) != len(wantNodes) {
+		t.Fatalf("restored nodes = %d, want %d", len(gotNodes), len(wantNodes))
+	}
+	for i := range wantNodes {
+		if gotNodes[i].ID != wantNodes[i].ID || gotNodes[i].Name != wantNodes[i].Name ||
+			gotNodes[i].APIPass != wantNodes[i].APIPass || gotNodes[i].AgentToken != wantNodes[i].AgentToken {
+			t.Errorf("restored node %d = %+v, want %+v", i, gotNodes[i], wantNodes[i])
+		}
+	}
+}
+
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := openTestDB(t, filepath.Join(dir, "src.db"))
+	seedBackupData(t, src)
+	data := dumpToBuffer(t, src)
+
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		t.Fatalf("dump is not valid JSON: %v", err)
+	}
+	if backup.Version != BackupVersion || backup.Driver != DriverSQLite {
+		t.Errorf("backup header = version %d driver %s", backup.Version, backup.Driver)
+	}
+	if _, ok := backup.Tables["users"][0]["password"]; !ok {
+		t.Error("password hash missing from dump")
+	}
+
+	// 目标库中已有的数据会被替换
+	dst := openTestDB(t, filepath.Join(dir, "dst.db"))
+	dst.Create(&Node{Name: "stale", Host: "stale.example.com", AgentToken: "stale"})
+	if err := RestoreDatabase(dst, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	assertSameData(t, src, dst)
+
+	// 恢复后新建记录的主键不与已恢复的记录冲突
+	node := &Node{Name: "new", Host: "new.example.com", AgentToken: "token-new"}
+	if err := dst.Create(node).Error; err != nil {
+		t.Fatalf("insert after restore: %v", err)
+	}
+	if node.ID <= 3 {
+		t.Errorf("new node id = %d, want > 3", node.ID)
+	}
+}
+
+func TestRestoreDatabaseRejectsInvalidBackup(t *testing.T) {
+	dir := t.TempDir()
+	src := openTestDB(t, filepath.Join(dir, "src.db"))
+	seedBackupData(t, src)
+	data := dumpToBuffer(t, src)
+
+	dst := openTestDB(t, filepath.Join(dir, "dst.db"))
+	dst.Create(&Node{Name: "keep", Host: "keep.example.com", AgentToken: "keep"})
+
+	invalid := map[string]string{
+		"malformed":     `{"version":1,`,
+		"no version":    `{"tables":{}}`,
+		"no tables":     `{"version":1}`,
+		"newer version": `{"version":99,"tables":{}}`,
+		// 字段类型错误时整个事务回滚
+		"bad row": strings.Replace(string(data), `"username":"bob"`, `"username":42`, 1),
+	}
+	for name, backup := range invalid {
+		if err := RestoreDatabase(dst, strings.NewReader(backup)); err == nil {
+			t.Errorf("%s: restore succeeded", name)
+		}
+	}
+
+	var nodes []Node
+	dst.Find(&nodes)
+	if len(nodes) != 1 || nodes[0].Name != "keep" {
+		t.Errorf("existing data changed by failed restore: %+v", nodes)
+	}
+	var admins int64
+	dst.Model(&User{}).Where("username = ?", "admin").Count(&admins)
+	if admins != 1 {
+		t.Error("failed restore removed existing users")
+	}
+}
+
+// pgSequenceCalls 记录 setval 调用: 序列名 -> 设置的值
+type pgSequenceCalls struct {
+	mu    sync.Mutex
+	calls map[string]int64
+}
+
+var (
+	pgShimOnce  sync.Once
+	pgShimCalls = &pgSequenceCalls{calls: map[string]int64{}}
+)
+
+// openPostgresShim 以 PostgreSQL 方言访问 SQLite 数据库，并用自定义函数模拟序列函数
+// 用于在没有 PostgreSQL 服务器时覆盖恢复后同步序列的路径
+func openPostgresShim(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	pgShimOnce.Do(func() {
+		sql.Register("sqlite3_pg_sequences", &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				if err := conn.RegisterFunc("pg_get_serial_sequence", func(table, column string) string {
+					return table + "_" + column + "_seq"
+				}, true); err != nil {
+					return err
+				}
+				return conn.RegisterFunc("setval", func(sequence string, value int64, isCalled bool) int64 {
+					pgShimCalls.mu.Lock()
+					defer pgShimCalls.mu.Unlock()
+					pgShimCalls.calls[sequence] = value
+					return value
+				}, false)
+			},
+		})
+	})
+
+	sqlDB, err := sql.Open("sqlite3_pg_sequences", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRestoreDatabaseResetsPostgresSequences(t *testing.T) {
+	dir := t.TempDir()
+	src := openTestDB(t, filepath.Join(dir, "src.db"))
+	seedBackupData(t, src)
+	data := dumpToBuffer(t, src)
+
+	path := filepath.Join(dir, "pg.db")
+	dst := openTestDB(t, path)
+	pg := openPostgresShim(t, path)
+	if pg.Dialector.Name() != DriverPostgres {
+		t.Fatalf("shim dialector = %s", pg.Dialector.Name())
+	}
+	if err := RestoreDatabase(pg, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	assertSameData(t, src, dst)
+
+	// 每个有数据的表都同步序列到 MAX(id)+1
+	var backup Backup
+	json.Unmarshal(data, &backup)
+	pgShimCalls.mu.Lock()
+	defer pgShimCalls.mu.Unlock()
+	for table, rows := range backup.Tables {
+		if len(rows) == 0 {
+			continue
+		}
+		var maxID int64
+		src.Table(table).Select("COALESCE(MAX(id), 0)").Scan(&maxID)
+		if got, ok := pgShimCalls.calls[table+"_id_seq"]; !ok || got != maxID+1 {
+			t.Errorf("setval for %s = %d (called %v), want %d", table, got, ok, maxID+1)
+		}
+	}
+	if pgShimCalls.calls["nodes_id_seq"] != 4 {
+		t.Errorf("nodes sequence = %d, want 4", pgShimCalls.calls["nodes_id_seq"])
+	}
+}
+
+func TestResetSequenceSkipsOtherDrivers(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "panel.db"))
+	sch, err := parseSchema(db, &Node{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite 没有 setval，执行即会报错
+	if err := resetSequence(db, sch); err != nil {
+		t.Errorf("resetSequence on sqlite: %v", err)
+	}
+}
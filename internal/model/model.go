@@ -1,12 +1,16 @@
 package model
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -60,8 +64,24 @@ type Node struct {
 	QuotaUsed      int64  `gorm:"default:0" json:"quota_used"`          // 本周期已用流量
 	QuotaResetAt   time.Time `json:"quota_reset_at"`                    // 上次重置时间
 	QuotaExceeded  bool   `gorm:"default:false" json:"quota_exceeded"`  // 是否超限
+	Suspended      bool   `gorm:"default:false" json:"suspended"`       // 服务已停用 (配额超限)
+	// 配置应用状态 (Agent 上报)
+	ConfigStatus          string     `gorm:"size:20" json:"config_status"`                 // applied/failed
+	ConfigError           string     `gorm:"size:500" json:"config_error"`                 // 校验或重载失败原因
+	ConfigFailedHash      string     `gorm:"size:64" json:"-"`                             // Agent 拒绝的配置哈希，配置变更前不再通知重载
+	ConfigFailedVersionID *uint      `json:"config_failed_version_id,omitempty"`           // 失败配置对应的版本快照
+	ConfigReportedAt      *time.Time `json:"config_reported_at,omitempty"`
+	// GOST 进程状态 (Agent 上报，status 仍表示 Agent 是否在线)
+	GostStatus       string     `gorm:"size:20" json:"gost_status"`         // running/down，旧版 Agent 不上报时为空
+	GostCrashes      int        `gorm:"default:0" json:"gost_crashes"`      // Agent 本次运行以来 GOST 意外退出次数
+	GostRestarts     int        `gorm:"default:0" json:"gost_restarts"`     // Agent 自动重启次数
+	GostLastExitCode *int       `json:"gost_last_exit_code,omitempty"`
+	GostLastExitAt   *time.Time `json:"gost_last_exit_at,omitempty"`
+	GostLastError    string     `gorm:"type:text" json:"gost_last_error"`   // 最近一次退出前的 stderr 输出
+	GostReportedAt   *time.Time `json:"gost_reported_at,omitempty"`
 	// 所有者 (权限控制)
 	OwnerID     *uint     `gorm:"index" json:"owner_id,omitempty"`      // 所有者用户ID
+	OrgID       *uint     `gorm:"index" json:"org_id,omitempty"`        // 所属组织 (组织成员共享)
 	LastSeen    time.Time `json:"last_seen"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -87,8 +107,10 @@ type Client struct {
 	QuotaUsed      int64  `gorm:"default:0" json:"quota_used"`           // 本周期已用流量
 	QuotaResetAt   time.Time `json:"quota_reset_at"`                     // 上次重置时间
 	QuotaExceeded  bool   `gorm:"default:false" json:"quota_exceeded"`   // 是否超限
+	Suspended      bool   `gorm:"default:false" json:"suspended"`        // 服务已停用 (配额超限)
 	// 所有者 (权限控制)
 	OwnerID     *uint     `gorm:"index" json:"owner_id,omitempty"`       // 所有者用户ID
+	OrgID       *uint     `gorm:"index" json:"org_id,omitempty"`         // 所属组织 (组织成员共享)
 	LastSeen    time.Time `json:"last_seen"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -119,7 +141,10 @@ type PortForward struct {
 	RemoteAddr  string    `gorm:"size:255" json:"remote_addr"`            // 远程目标地址
 	ChainID     *uint     `gorm:"index" json:"chain_id,omitempty"`        // 使用的转发链
 	Enabled     bool      `gorm:"default:true" json:"enabled"`
+	TrafficIn   int64     `gorm:"default:0" json:"traffic_in"`
+	TrafficOut  int64     `gorm:"default:0" json:"traffic_out"`
 	OwnerID     *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID       *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -135,6 +160,7 @@ type NodeGroup struct {
 	HealthCheck   bool      `gorm:"default:true" json:"health_check"`      // 是否启用健康检查
 	CheckInterval int       `gorm:"default:30" json:"check_interval"`      // 健康检查间隔(秒)
 	OwnerID       *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID         *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
@@ -170,10 +196,15 @@ type Tunnel struct {
 	// 流量配额
 	TrafficQuota  int64   `gorm:"default:0" json:"traffic_quota"`          // 流量配额 (bytes), 0=无限制
 	QuotaResetDay int     `gorm:"default:1" json:"quota_reset_day"`
+	QuotaUsed     int64   `gorm:"default:0" json:"quota_used"`             // 本周期已用流量
+	QuotaResetAt  *time.Time `json:"quota_reset_at,omitempty"`             // 上次重置时间
+	QuotaExceeded bool    `gorm:"default:false" json:"quota_exceeded"`     // 是否超限
+	Suspended     bool    `gorm:"default:false" json:"suspended"`          // 服务已停用 (配额超限)
 	// 限速
 	SpeedLimit    int64   `gorm:"default:0" json:"speed_limit"`            // 限速 (bytes/s), 0=不限
 	// 所有者
 	OwnerID     *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID       *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -188,6 +219,7 @@ type ProxyChain struct {
 	TargetAddr  string    `gorm:"size:255" json:"target_addr"`           // 最终目标地址 (可选，用于端口转发)
 	Enabled     bool      `gorm:"default:true" json:"enabled"`
 	OwnerID     *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID       *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -236,7 +268,7 @@ type User struct {
 	Username          string     `gorm:"size:50;uniqueIndex;not null" json:"username"`
 	Email             *string    `gorm:"size:100;uniqueIndex" json:"email"`
 	Password          string     `gorm:"size:100;not null" json:"-"`
-	Role              string     `gorm:"size:20;default:user" json:"role"`    // admin/user/viewer
+	Role              string     `gorm:"size:50;default:user" json:"role"`    // 内置角色 admin/operator/user/viewer/billing 或自定义角色名
 	Enabled           bool       `gorm:"default:true" json:"enabled"`         // 账户是否启用
 	PasswordChanged   bool       `gorm:"default:false" json:"password_changed"` // 是否已修改初始密码
 	EmailVerified     bool       `gorm:"default:false" json:"email_verified"` // 邮箱是否已验证
@@ -245,10 +277,16 @@ type User struct {
 	ResetTokenExpiry  *time.Time `json:"-"`                                   // 重置令牌过期时间
 	LastLoginAt       *time.Time `json:"last_login_at,omitempty"`             // 上次登录时间
 	LastLoginIP       string     `gorm:"size:50" json:"last_login_ip,omitempty"` // 上次登录 IP
+	// 外部身份认证
+	AuthProvider string  `gorm:"size:20;default:local" json:"auth_provider"`                // local/oidc
+	OIDCSubject  *string `gorm:"column:oidc_subject;size:255;uniqueIndex" json:"-"` // OIDC 身份标识 (issuer 下的 sub)
 	// 2FA 双因素认证
 	TwoFactorEnabled bool   `gorm:"default:false" json:"two_factor_enabled"`
 	TwoFactorSecret  string `gorm:"size:100" json:"-"`
 	BackupCodes      string `gorm:"type:text" json:"-"` // JSON array of hashed codes
+	// 账户锁定 (连续登录失败)
+	FailedLoginAttempts int        `gorm:"default:0" json:"failed_login_attempts"` // 连续失败次数，登录成功或锁定时清零
+	LockedUntil         *time.Time `json:"locked_until,omitempty"`                 // 锁定截止时间
 	// 用户套餐
 	PlanID         *uint      `gorm:"index" json:"plan_id,omitempty"`        // 当前套餐ID
 	Plan           *Plan      `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
@@ -261,6 +299,7 @@ type User struct {
 	QuotaResetDay  int       `gorm:"default:1" json:"quota_reset_day"`     // 每月重置日 (1-28)
 	QuotaResetAt   time.Time `json:"quota_reset_at"`                       // 上次重置时间
 	QuotaExceeded  bool      `gorm:"default:false" json:"quota_exceeded"`  // 是否超限
+	Suspended      bool      `gorm:"default:false" json:"suspended"`       // 名下资源已停用 (套餐到期/超限)
 	CreatedAt         time.Time  `json:"created_at"`
 	UpdatedAt         time.Time  `json:"updated_at"`
 }
@@ -275,6 +314,37 @@ type UserSession struct {
 	CreatedAt  time.Time `json:"created_at"`
 	ExpiresAt  time.Time `json:"expires_at"`
 	LastActive time.Time `json:"last_active"`
+	// 刷新令牌 (仅保存哈希，每次刷新轮换；旧令牌被重复使用时撤销整个会话)
+	RefreshTokenHash    string     `gorm:"size:64;index" json:"-"`
+	PreviousRefreshHash string     `gorm:"size:64" json:"-"`
+	RefreshedAt         *time.Time `json:"refreshed_at,omitempty"`
+}
+
+// APIToken 个人访问令牌 (用于脚本/CI 调用 API)
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex;not null" json:"-"` // SHA-256
+	Prefix     string     `gorm:"size:20" json:"prefix"`                // 令牌前缀，便于识别
+	Scopes     string     `gorm:"type:text" json:"scopes"`              // JSON 数组: ["nodes:read", "tunnels:write"]
+	ExpiresAt  *time.Time `json:"expires_at"`                           // nil=永不过期
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"size:45" json:"last_used_ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// WebAuthnCredential WebAuthn 凭据 (安全密钥 / 通行密钥)
+type WebAuthnCredential struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"index;not null" json:"user_id"`
+	Name         string     `gorm:"size:100;not null" json:"name"`
+	CredentialID string     `gorm:"size:255;uniqueIndex;not null" json:"-"` // base64url 编码的凭据 ID
+	Data         string     `gorm:"type:text;not null" json:"-"`            // 公钥、签名计数等 (JSON)
+	Transports   string     `gorm:"size:100" json:"transports"`             // 逗号分隔: usb,nfc,internal...
+	Discoverable bool       `json:"discoverable"`                           // 可发现凭据，可用于无密码登录
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
 }
 
 // Plan 套餐
@@ -305,16 +375,85 @@ type PlanResource struct {
 	ResourceID   uint   `gorm:"not null" json:"resource_id"`
 }
 
-// TrafficHistory 流量历史记录
+// Organization 组织 (多租户): 成员共享组织名下的资源，可在组织级别分配套餐与流量配额
+type Organization struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Name          string     `gorm:"size:100;uniqueIndex;not null" json:"name"`
+	Description   string     `gorm:"size:255" json:"description"`
+	// 组织套餐 (资源数量限制针对组织名下的资源)
+	PlanID        *uint      `gorm:"index" json:"plan_id,omitempty"`
+	Plan          *Plan      `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
+	PlanStartAt   *time.Time `json:"plan_start_at,omitempty"`
+	PlanExpireAt  *time.Time `json:"plan_expire_at,omitempty"`
+	// 组织流量配额 (累计组织名下节点/客户端/隧道的流量，可被套餐覆盖)
+	TrafficQuota  int64      `gorm:"default:0" json:"traffic_quota"`      // 流量配额 (bytes), 0=无限制
+	QuotaUsed     int64      `gorm:"default:0" json:"quota_used"`         // 本周期已用流量
+	QuotaResetAt  *time.Time `json:"quota_reset_at,omitempty"`            // 上次重置时间
+	QuotaExceeded bool       `gorm:"default:false" json:"quota_exceeded"` // 是否超限
+	Suspended     bool       `gorm:"default:false" json:"suspended"`      // 组织资源已停用 (套餐到期/超限)
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	// 列表展示字段 (不入库)
+	MemberCount   int64      `gorm:"-" json:"member_count"`
+	MyRole        string     `gorm:"-" json:"my_role,omitempty"` // 当前用户在组织中的角色
+}
+
+// OrganizationMember 组织成员 (用户可加入多个组织，每个组织内有独立角色)
+type OrganizationMember struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	OrgID     uint      `gorm:"uniqueIndex:idx_org_member;not null" json:"org_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_org_member;index;not null" json:"user_id"`
+	User      *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role      string    `gorm:"size:20;not null;default:member" json:"role"` // owner/admin/member
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TrafficHistory 流量历史记录 (每分钟采样，流量为采样间隔内的增量)
+// TargetType 为空时表示节点数据 (NodeID 为空表示总体数据)
 type TrafficHistory struct {
 	ID         uint      `gorm:"primaryKey" json:"id"`
 	NodeID     *uint     `gorm:"index" json:"node_id,omitempty"`
+	TargetType string    `gorm:"size:20;default:''" json:"target_type,omitempty"` // tunnel/client/port_forward/user
+	TargetID   uint      `json:"target_id,omitempty"`
 	TrafficIn  int64     `gorm:"default:0" json:"traffic_in"`
 	TrafficOut int64     `gorm:"default:0" json:"traffic_out"`
 	Connections int      `gorm:"default:0" json:"connections"`
 	RecordedAt time.Time `gorm:"index" json:"recorded_at"`
 }
 
+// TrafficHistoryHourly 流量历史小时汇总 (RecordedAt 为小时起点，连接数取峰值)
+type TrafficHistoryHourly struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	NodeID      *uint     `gorm:"index" json:"node_id,omitempty"`
+	TargetType  string    `gorm:"size:20;default:''" json:"target_type,omitempty"`
+	TargetID    uint      `json:"target_id,omitempty"`
+	TrafficIn   int64     `gorm:"default:0" json:"traffic_in"`
+	TrafficOut  int64     `gorm:"default:0" json:"traffic_out"`
+	Connections int       `gorm:"default:0" json:"connections"`
+	RecordedAt  time.Time `gorm:"index" json:"recorded_at"`
+}
+
+// TrafficHistoryDaily 流量历史按天汇总 (RecordedAt 为当天零点，连接数取峰值)
+type TrafficHistoryDaily struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	NodeID      *uint     `gorm:"index" json:"node_id,omitempty"`
+	TargetType  string    `gorm:"size:20;default:''" json:"target_type,omitempty"`
+	TargetID    uint      `json:"target_id,omitempty"`
+	TrafficIn   int64     `gorm:"default:0" json:"traffic_in"`
+	TrafficOut  int64     `gorm:"default:0" json:"traffic_out"`
+	Connections int       `gorm:"default:0" json:"connections"`
+	RecordedAt  time.Time `gorm:"index" json:"recorded_at"`
+}
+
+// TrafficCounter 上次采样时的累计流量 (用于计算增量)
+type TrafficCounter struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Key        string    `gorm:"size:100;uniqueIndex" json:"key"` // {类型}:{id}，如 node:1、tunnel:2
+	TrafficIn  int64     `gorm:"default:0" json:"traffic_in"`
+	TrafficOut int64     `gorm:"default:0" json:"traffic_out"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
 // NotifyChannel 通知渠道配置
 type NotifyChannel struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
@@ -390,6 +529,7 @@ type OperationLog struct {
 	IP         string    `gorm:"size:50" json:"ip"`                     // 客户端 IP
 	UserAgent  string    `gorm:"size:255" json:"user_agent"`
 	Status     string    `gorm:"size:20;default:success" json:"status"` // success/failed
+	TokenName  string    `gorm:"size:100" json:"token_name,omitempty"`  // 通过 API 令牌操作时的令牌名称
 	CreatedAt  time.Time `gorm:"index" json:"created_at"`
 }
 
@@ -401,6 +541,7 @@ type Bypass struct {
 	Matchers  string    `gorm:"type:text" json:"matchers"`      // JSON 数组: ["*.google.com", "10.0.0.0/8"]
 	NodeID    *uint     `gorm:"index" json:"node_id,omitempty"` // 关联节点 (可选，nil=全局)
 	OwnerID   *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID     *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -413,6 +554,7 @@ type Admission struct {
 	Matchers  string    `gorm:"type:text" json:"matchers"`      // JSON 数组: ["192.168.0.0/16"]
 	NodeID    *uint     `gorm:"index" json:"node_id,omitempty"`
 	OwnerID   *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID     *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -424,6 +566,7 @@ type HostMapping struct {
 	Mappings  string    `gorm:"type:text" json:"mappings"` // JSON 数组: [{"hostname":"example.com","ip":"1.2.3.4"}]
 	NodeID    *uint     `gorm:"index" json:"node_id,omitempty"`
 	OwnerID   *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID     *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -435,6 +578,7 @@ type Ingress struct {
 	Rules     string    `gorm:"type:text" json:"rules"` // JSON: [{"hostname":"example.com","endpoint":"192.168.1.1:8080"}]
 	NodeID    *uint     `gorm:"index" json:"node_id,omitempty"`
 	OwnerID   *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID     *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -447,6 +591,7 @@ type Recorder struct {
 	Config    string    `gorm:"type:text" json:"config"`          // JSON 配置
 	NodeID    *uint     `gorm:"index" json:"node_id,omitempty"`
 	OwnerID   *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID     *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -458,6 +603,7 @@ type Router struct {
 	Routes    string    `gorm:"type:text" json:"routes"` // JSON: [{"net":"192.168.0.0/16","gateway":"192.168.0.1"}]
 	NodeID    *uint     `gorm:"index" json:"node_id,omitempty"`
 	OwnerID   *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID     *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -466,10 +612,11 @@ type Router struct {
 type SD struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Name      string    `gorm:"size:100;not null" json:"name"`
-	Type      string    `gorm:"size:20;default:http" json:"type"` // http, consul, etcd, redis
+	Type      string    `gorm:"size:20;default:http" json:"type"` // http, grpc (GOST 插件)
 	Config    string    `gorm:"type:text" json:"config"`          // JSON 配置
 	NodeID    *uint     `gorm:"index" json:"node_id,omitempty"`
 	OwnerID   *uint     `gorm:"index" json:"owner_id,omitempty"`
+	OrgID     *uint     `gorm:"index" json:"org_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -480,6 +627,8 @@ type ConfigVersion struct {
 	NodeID    uint      `gorm:"index;not null" json:"node_id"`
 	Config    string    `gorm:"type:text;not null" json:"config"` // YAML 配置快照
 	Comment   string    `gorm:"size:255" json:"comment"`          // 版本说明
+	Hash      string    `gorm:"size:64;index" json:"hash"`        // 配置哈希 (与 Agent 上报一致)
+	ApplyError string   `gorm:"size:500" json:"apply_error"`      // Agent 应用该配置失败的原因
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -493,6 +642,33 @@ type HealthCheckLog struct {
 	CheckedAt time.Time `gorm:"index" json:"checked_at"`
 }
 
+// NodeCommand 节点远程命令 (由 Agent 按白名单执行)
+type NodeCommand struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	NodeID     uint       `gorm:"index;not null" json:"node_id"`
+	Type       string     `gorm:"size:50;not null" json:"type"`                 // restart_gost, diagnostics, connectivity_test, rotate_api_credentials, gost_logs
+	Params     string     `gorm:"type:text" json:"params"`                      // JSON 参数
+	Status     string     `gorm:"size:20;index;default:pending" json:"status"` // pending, sent, success, failed, timeout, canceled
+	Output     string     `gorm:"type:text" json:"output"`
+	Error      string     `gorm:"size:500" json:"error"`
+	Timeout    int        `json:"timeout"` // 执行超时 (秒)
+	UserID     uint       `json:"user_id"`
+	Username   string     `gorm:"size:100" json:"username"`
+	SentAt     *time.Time `json:"sent_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// NodeLog 节点 GOST 进程日志 (由 Agent 批量上报)
+type NodeLog struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	NodeID   uint      `gorm:"index;not null" json:"node_id"`
+	Level    string    `gorm:"size:10;index" json:"level"` // debug, info, warn, error, fatal
+	Message  string    `gorm:"type:text" json:"message"`
+	LoggedAt time.Time `gorm:"index" json:"logged_at"`
+}
+
 // SiteConfig 网站配置
 type SiteConfig struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
@@ -501,40 +677,142 @@ type SiteConfig struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// InitDB 初始化数据库
-func InitDB(dbPath string) (*gorm.DB, error) {
-	// 确保目录存在
-	dir := filepath.Dir(dbPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// ScheduledJob 定时任务运行状态 (调度表达式存储在 SiteConfig)
+type ScheduledJob struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Name         string     `gorm:"size:100;uniqueIndex;not null" json:"name"`
+	Spec         string     `gorm:"size:100" json:"spec"`              // 最近一次使用的调度表达式
+	Enabled      bool       `gorm:"default:true" json:"enabled"`
+	LastRunAt    *time.Time `json:"last_run_at"`
+	NextRunAt    *time.Time `json:"next_run_at"`
+	LastDuration int64      `gorm:"default:0" json:"last_duration"`   // 上次执行耗时 (毫秒)
+	LastError    string     `gorm:"type:text" json:"last_error"`
+	RunCount     int64      `gorm:"default:0" json:"run_count"`
+	FailCount    int64      `gorm:"default:0" json:"fail_count"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Role 自定义角色 (内置角色定义在代码中，不落库)
+type Role struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"size:50;uniqueIndex;not null" json:"name"`
+	Description string    `gorm:"size:255" json:"description"`
+	Permissions string    `gorm:"type:text" json:"permissions"`    // JSON 数组: ["nodes:read", "tunnels:*"]
+	Scope       string    `gorm:"size:10;default:own" json:"scope"` // all=可访问所有用户的资源, own=仅自己的资源
+	BuiltIn     bool      `gorm:"-" json:"built_in"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// 支持的数据库驱动
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// AllModels 返回所有数据表模型 (按迁移/恢复顺序)
+func AllModels() []interface{} {
+	return []interface{}{
+		&Node{}, &Client{}, &Service{}, &User{}, &UserSession{}, &APIToken{}, &WebAuthnCredential{}, &Plan{}, &PlanResource{},
+		&Organization{}, &OrganizationMember{},
+		&TrafficHistory{}, &TrafficHistoryHourly{}, &TrafficHistoryDaily{}, &TrafficCounter{},
+		&NotifyChannel{}, &AlertRule{}, &AlertLog{}, &PortForward{}, &NodeGroup{}, &NodeGroupMember{},
+		&DNSConfig{}, &OperationLog{}, &ProxyChain{}, &ProxyChainHop{}, &Tunnel{}, &SiteConfig{},
+		&Tag{}, &NodeTag{}, &Bypass{}, &Admission{}, &HostMapping{}, &Ingress{}, &Recorder{}, &Router{}, &SD{},
+		&ConfigVersion{}, &HealthCheckLog{}, &NodeCommand{}, &NodeLog{}, &ScheduledJob{}, &Role{},
+	}
+}
+
+// NormalizeDriver 规范化数据库驱动名称
+func NormalizeDriver(driver string) (string, error) {
+	switch strings.ToLower(driver) {
+	case "", "sqlite", "sqlite3":
+		return DriverSQLite, nil
+	case "postgres", "postgresql", "pgsql":
+		return DriverPostgres, nil
+	case "mysql", "mariadb":
+		return DriverMySQL, nil
+	default:
+		return "", fmt.Errorf("unsupported database driver: %s", driver)
+	}
+}
+
+// OpenDB 按驱动打开数据库连接 (不执行迁移)
+func OpenDB(driver, dsn string) (*gorm.DB, error) {
+	driver, err := NormalizeDriver(driver)
+	if err != nil {
 		return nil, err
 	}
+	if dsn == "" {
+		return nil, fmt.Errorf("DB_DSN is required for %s", driver)
+	}
+
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	case DriverMySQL:
+		dialector = mysql.Open(mysqlDSN(dsn))
+	default:
+		// 确保目录存在
+		if err := os.MkdirAll(filepath.Dir(dsn), 0755); err != nil {
+			return nil, err
+		}
+		dialector = sqlite.Open(dsn)
+	}
 
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	return gorm.Open(dialector, &gorm.Config{
 		Logger: logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
 			SlowThreshold:             200 * time.Millisecond,
 			LogLevel:                  logger.Warn,
 			IgnoreRecordNotFoundError: true,
 		}),
+		// 关联完整性由应用层维护，不创建外键约束 (与 SQLite 默认行为一致)
+		DisableForeignKeyConstraintWhenMigrating: true,
 	})
+}
+
+// mysqlDSN 补充 parseTime 参数 (时间字段需要)
+func mysqlDSN(dsn string) string {
+	if strings.Contains(dsn, "parseTime=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&parseTime=true"
+	}
+	return dsn + "?parseTime=true"
+}
+
+// InitDB 初始化数据库
+func InitDB(driver, dsn string) (*gorm.DB, error) {
+	db, err := OpenDB(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
 
 	// 自动迁移
-	if err := db.AutoMigrate(&Node{}, &Client{}, &Service{}, &User{}, &UserSession{}, &Plan{}, &PlanResource{}, &TrafficHistory{}, &NotifyChannel{}, &AlertRule{}, &AlertLog{}, &PortForward{}, &NodeGroup{}, &NodeGroupMember{}, &DNSConfig{}, &OperationLog{}, &ProxyChain{}, &ProxyChainHop{}, &Tunnel{}, &SiteConfig{}, &Tag{}, &NodeTag{}, &Bypass{}, &Admission{}, &HostMapping{}, &Ingress{}, &Recorder{}, &Router{}, &SD{}, &ConfigVersion{}, &HealthCheckLog{}); err != nil {
+	if err := db.AutoMigrate(AllModels()...); err != nil {
 		return nil, err
 	}
 
 	// 创建索引优化查询性能
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_nodes_owner_status ON nodes(owner_id, status)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_clients_node_status ON clients(node_id, status)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_operation_logs_user_time ON operation_logs(user_id, created_at)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_traffic_histories_node_time ON traffic_histories(node_id, recorded_at)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_config_versions_node ON config_versions(node_id, created_at)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_plan_resources_plan ON plan_resources(plan_id, resource_type)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_port_forwards_node ON port_forwards(node_id, enabled)")
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_tunnels_entry_exit ON tunnels(entry_node_id, exit_node_id)")
+	createIndex(db, "nodes", "idx_nodes_owner_status", "owner_id, status")
+	createIndex(db, "clients", "idx_clients_node_status", "node_id, status")
+	createIndex(db, "operation_logs", "idx_operation_logs_user_time", "user_id, created_at")
+	createIndex(db, "traffic_histories", "idx_traffic_histories_node_time", "node_id, recorded_at")
+	createIndex(db, "traffic_history_hourlies", "idx_traffic_history_hourlies_node_time", "node_id, recorded_at")
+	createIndex(db, "traffic_history_dailies", "idx_traffic_history_dailies_node_time", "node_id, recorded_at")
+	createIndex(db, "traffic_histories", "idx_traffic_histories_target_time", "target_type, target_id, recorded_at")
+	createIndex(db, "traffic_history_hourlies", "idx_traffic_history_hourlies_target_time", "target_type, target_id, recorded_at")
+	createIndex(db, "traffic_history_dailies", "idx_traffic_history_dailies_target_time", "target_type, target_id, recorded_at")
+	createIndex(db, "config_versions", "idx_config_versions_node", "node_id, created_at")
+	createIndex(db, "node_commands", "idx_node_commands_node_time", "node_id, created_at")
+	createIndex(db, "node_logs", "idx_node_logs_node_time", "node_id, logged_at")
+	createIndex(db, "users", "idx_users_email", "email")
+	createIndex(db, "plan_resources", "idx_plan_resources_plan", "plan_id, resource_type")
+	createIndex(db, "port_forwards", "idx_port_forwards_node", "node_id, enabled")
+	createIndex(db, "tunnels", "idx_tunnels_entry_exit", "entry_node_id, exit_node_id")
 
 	// 创建默认管理员
 	var count int64
@@ -553,9 +831,44 @@ func InitDB(dbPath string) (*gorm.DB, error) {
 	// 初始化默认系统配置
 	initDefaultSiteConfigs(db)
 
+	// 一次性数据迁移
+	migrateTrafficHistoryDeltas(db)
+
 	return db, nil
 }
 
+// migrationTrafficHistoryDeltas 流量历史改为增量记录的迁移标记
+const migrationTrafficHistoryDeltas = "migration_traffic_history_deltas"
+
+// migrateTrafficHistoryDeltas 升级前的流量历史为累计值，与增量记录混在一起会产生错误的统计，首次升级时清除
+// 已有增量计数器说明历史已是增量数据，只记录标记
+func migrateTrafficHistoryDeltas(db *gorm.DB) {
+	var marker SiteConfig
+	if db.Where(&SiteConfig{Key: migrationTrafficHistoryDeltas}).First(&marker).Error == nil {
+		return
+	}
+
+	var counters int64
+	db.Model(&TrafficCounter{}).Count(&counters)
+	if counters == 0 {
+		if err := db.Where("1 = 1").Delete(&TrafficHistory{}).Error; err != nil {
+			log.Printf("Clear legacy traffic history failed: %v", err)
+			return
+		}
+	}
+	db.Create(&SiteConfig{Key: migrationTrafficHistoryDeltas, Value: time.Now().Format(time.RFC3339)})
+}
+
+// createIndex 创建索引 (已存在则跳过，MySQL 不支持 CREATE INDEX IF NOT EXISTS)
+func createIndex(db *gorm.DB, table, name, columns string) {
+	if db.Migrator().HasIndex(table, name) {
+		return
+	}
+	if err := db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s(%s)", name, table, columns)).Error; err != nil {
+		log.Printf("Create index %s failed: %v", name, err)
+	}
+}
+
 func hashPassword(password string) string {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -683,6 +996,45 @@ const (
 	ConfigSiteURL                = "site_url"                 // 站点 URL（用于邮件链接）
 	ConfigAgentAutoUpdate        = "agent_auto_update"        // Agent 自动更新开关
 	ConfigAgentForceUpdate       = "agent_force_update"       // 强制所有 Agent 更新
+	ConfigSessionIdleTimeout     = "session_idle_timeout"     // 会话空闲超时 (分钟)，超过后需重新登录，0 表示不限制
+
+	// OpenID Connect 单点登录
+	ConfigOIDCEnabled       = "oidc_enabled"        // 是否启用 OIDC 登录
+	ConfigOIDCIssuer        = "oidc_issuer"         // Issuer URL，如 https://keycloak/realms/main
+	ConfigOIDCClientID      = "oidc_client_id"      // Client ID
+	ConfigOIDCClientSecret  = "oidc_client_secret"  // Client Secret (公共客户端可留空，依赖 PKCE)
+	ConfigOIDCRedirectURL   = "oidc_redirect_url"   // 回调地址，留空则使用 {site_url}/api/oidc/callback
+	ConfigOIDCScopes        = "oidc_scopes"         // 请求的 scope (空格分隔)
+	ConfigOIDCGroupsClaim   = "oidc_groups_claim"   // 组声明路径，支持点号嵌套，如 realm_access.roles
+	ConfigOIDCRoleMapping   = "oidc_role_mapping"   // 组到角色映射 JSON 数组: [{"group":"panel-admins","role":"admin"}]，按顺序首个匹配生效
+	ConfigOIDCAutoProvision = "oidc_auto_provision" // 首次登录自动创建用户
+	ConfigOIDCButtonText    = "oidc_button_text"    // 登录按钮文字
+
+	// 认证源与 LDAP
+	ConfigAuthBackends           = "auth_backends"             // 用户名密码登录依次尝试的认证源，逗号分隔: local,ldap
+	ConfigLDAPURL                = "ldap_url"                  // 服务器地址，如 ldap://ldap.example.com:389 或 ldaps://ldap.example.com:636
+	ConfigLDAPStartTLS           = "ldap_start_tls"            // ldap:// 连接后是否执行 StartTLS
+	ConfigLDAPInsecureSkipVerify = "ldap_insecure_skip_verify" // 跳过 TLS 证书校验 (仅用于测试)
+	ConfigLDAPBindDN             = "ldap_bind_dn"              // 搜索用户使用的服务账户 DN (留空则匿名绑定)
+	ConfigLDAPBindPassword       = "ldap_bind_password"        // 服务账户密码
+	ConfigLDAPBaseDN             = "ldap_base_dn"              // 用户搜索基础 DN
+	ConfigLDAPUserFilter         = "ldap_user_filter"          // 用户过滤器，%s 替换为转义后的用户名
+	ConfigLDAPEmailAttr          = "ldap_email_attr"           // 邮箱属性
+	ConfigLDAPGroupBaseDN        = "ldap_group_base_dn"        // 组搜索基础 DN (留空则读取用户的 memberOf 属性)
+	ConfigLDAPGroupFilter        = "ldap_group_filter"         // 组过滤器，%s 替换为转义后的用户 DN
+	ConfigLDAPGroupAttr          = "ldap_group_attr"           // 组名属性
+	ConfigLDAPRoleMapping        = "ldap_role_mapping"         // 组到角色映射 JSON 数组，格式同 oidc_role_mapping
+
+	// WebAuthn / 通行密钥
+	ConfigWebAuthnRPID          = "webauthn_rp_id"          // Relying Party ID，留空则使用站点 URL 的主机名
+	ConfigWebAuthnRequiredRoles = "webauthn_required_roles" // 要求使用 WebAuthn 作为第二因素的角色，逗号分隔
+	ConfigPasskeyLoginEnabled   = "passkey_login_enabled"   // 是否允许通行密钥无密码登录
+
+	// 2FA 策略与账户锁定
+	ConfigTwoFactorRequiredRoles = "two_factor_required_roles" // 必须启用 2FA (TOTP 或安全密钥) 的角色，逗号分隔
+	ConfigLockoutThreshold       = "lockout_threshold"         // 连续登录失败多少次后锁定账户，0 表示不锁定
+	ConfigLockoutDuration        = "lockout_duration"          // 锁定时长 (分钟)，0 表示需管理员解锁
+	ConfigBruteForceIPThreshold  = "bruteforce_ip_threshold"   // 同一账户 15 分钟内来自多少个不同 IP 的失败登录触发告警
 )
 
 // initDefaultSiteConfigs 初始化默认系统配置
@@ -695,11 +1047,32 @@ func initDefaultSiteConfigs(db *gorm.DB) {
 		ConfigSiteURL:                   "",
 		ConfigAgentAutoUpdate:           "true",
 		ConfigAgentForceUpdate:          "false",
+		ConfigSessionIdleTimeout:        "1440",
+		ConfigOIDCEnabled:               "false",
+		ConfigOIDCScopes:                "openid profile email",
+		ConfigOIDCGroupsClaim:           "groups",
+		ConfigOIDCRoleMapping:           "[]",
+		ConfigOIDCAutoProvision:         "true",
+		ConfigOIDCButtonText:            "使用 SSO 登录",
+		ConfigAuthBackends:              "local",
+		ConfigLDAPStartTLS:              "false",
+		ConfigLDAPInsecureSkipVerify:    "false",
+		ConfigLDAPUserFilter:            "(uid=%s)",
+		ConfigLDAPEmailAttr:             "mail",
+		ConfigLDAPGroupFilter:           "(|(member=%s)(uniqueMember=%s))",
+		ConfigLDAPGroupAttr:             "cn",
+		ConfigLDAPRoleMapping:           "[]",
+		ConfigWebAuthnRequiredRoles:     "",
+		ConfigPasskeyLoginEnabled:       "true",
+		ConfigTwoFactorRequiredRoles:    "",
+		ConfigLockoutThreshold:          "10",
+		ConfigLockoutDuration:           "30",
+		ConfigBruteForceIPThreshold:     "5",
 	}
 
 	for key, value := range defaultConfigs {
 		var config SiteConfig
-		if db.Where("key = ?", key).First(&config).Error != nil {
+		if db.Where(&SiteConfig{Key: key}).First(&config).Error != nil {
 			db.Create(&SiteConfig{Key: key, Value: value})
 		}
 	}
@@ -0,0 +1,171 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// openTestDB 在临时目录中初始化 SQLite 数据库
+func openTestDB(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := InitDB(DriverSQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// closedPort 返回当前未监听的本地端口
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func TestNormalizeDriver(t *testing.T) {
+	cases := map[string]string{
+		"":           DriverSQLite,
+		"sqlite":     DriverSQLite,
+		"SQLite3":    DriverSQLite,
+		"postgres":   DriverPostgres,
+		"PostgreSQL": DriverPostgres,
+		"pgsql":      DriverPostgres,
+		"mysql":      DriverMySQL,
+		"MariaDB":    DriverMySQL,
+	}
+	for input, want := range cases {
+		got, err := NormalizeDriver(input)
+		if err != nil || got != want {
+			t.Errorf("NormalizeDriver(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"oracle", "mssql", "sqlite4"} {
+		if _, err := NormalizeDriver(input); err == nil {
+			t.Errorf("NormalizeDriver(%q) accepted an unsupported driver", input)
+		}
+	}
+}
+
+func TestOpenDB(t *testing.T) {
+	// SQLite: 自动创建数据库目录
+	path := filepath.Join(t.TempDir(), "nested", "data", "panel.db")
+	db, err := OpenDB("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := db.Dialector.Name(); name != DriverSQLite {
+		t.Errorf("sqlite dialector = %s", name)
+	}
+	if err := db.Exec("SELECT 1").Error; err != nil {
+		t.Errorf("sqlite query: %v", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	if _, err := OpenDB("oracle", "dsn"); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("unsupported driver: err = %v", err)
+	}
+
+	// PostgreSQL/MySQL: DSN 必填，连接失败时返回错误
+	port := closedPort(t)
+	servers := map[string]string{
+		"postgresql": fmt.Sprintf("host=127.0.0.1 port=%d user=panel dbname=panel sslmode=disable connect_timeout=2", port),
+		"mariadb":    fmt.Sprintf("panel:secret@tcp(127.0.0.1:%d)/panel?timeout=2s", port),
+	}
+	for driver, dsn := range servers {
+		if _, err := OpenDB(driver, ""); err == nil || !strings.Contains(err.Error(), "DB_DSN is required") {
+			t.Errorf("%s without dsn: err = %v", driver, err)
+		}
+		if _, err := OpenDB(driver, dsn); err == nil {
+			t.Errorf("%s connected to a closed port", driver)
+		}
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	cases := map[string]string{
+		"u:p@tcp(db:3306)/panel":                     "u:p@tcp(db:3306)/panel?parseTime=true",
+		"u:p@tcp(db:3306)/panel?charset=utf8mb4":     "u:p@tcp(db:3306)/panel?charset=utf8mb4&parseTime=true",
+		"u:p@tcp(db:3306)/panel?parseTime=false":     "u:p@tcp(db:3306)/panel?parseTime=false",
+		"u:p@tcp(db:3306)/panel?loc=UTC&parseTime=1": "u:p@tcp(db:3306)/panel?loc=UTC&parseTime=1",
+	}
+	for input, want := range cases {
+		if got := mysqlDSN(input); got != want {
+			t.Errorf("mysqlDSN(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestInitDBMigratesAllModels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "panel.db")
+	db := openTestDB(t, path)
+
+	for _, m := range AllModels() {
+		if !db.Migrator().HasTable(m) {
+			t.Errorf("table for %T not created", m)
+		}
+	}
+	indexes := map[string]string{
+		"nodes":                    "idx_nodes_owner_status",
+		"clients":                  "idx_clients_node_status",
+		"operation_logs":           "idx_operation_logs_user_time",
+		"traffic_history_hourlies": "idx_traffic_history_hourlies_target_time",
+		"traffic_history_dailies":  "idx_traffic_history_dailies_node_time",
+		"config_versions":          "idx_config_versions_node",
+		"node_commands":            "idx_node_commands_node_time",
+		"node_logs":                "idx_node_logs_node_time",
+		"users":                    "idx_users_email",
+		"plan_resources":           "idx_plan_resources_plan",
+		"port_forwards":            "idx_port_forwards_node",
+		"tunnels":                  "idx_tunnels_entry_exit",
+	}
+	for table, index := range indexes {
+		if !db.Migrator().HasIndex(table, index) {
+			t.Errorf("index %s on %s not created", index, table)
+		}
+	}
+
+	// 再次初始化: 迁移与建索引幂等，不重复创建默认管理员
+	again := openTestDB(t, path)
+	var admins int64
+	again.Model(&User{}).Where("username = ?", "admin").Count(&admins)
+	if admins != 1 {
+		t.Errorf("admin users after second InitDB = %d", admins)
+	}
+	var marker int64
+	again.Model(&SiteConfig{}).Where("key = ?", migrationTrafficHistoryDeltas).Count(&marker)
+	if marker != 1 {
+		t.Errorf("traffic history migration marker rows = %d", marker)
+	}
+}
+
+func TestCreateIndexIgnoresErrors(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "panel.db"))
+
+	createIndex(db, "nodes", "idx_test_nodes_name", "name")
+	if !db.Migrator().HasIndex("nodes", "idx_test_nodes_name") {
+		t.Fatal("index not created")
+	}
+	// 已存在或表不存在时只记录日志
+	createIndex(db, "nodes", "idx_test_nodes_name", "name")
+	createIndex(db, "no_such_table", "idx_test_missing", "id")
+	if db.Migrator().HasIndex("no_such_table", "idx_test_missing") {
+		t.Error("index created on a missing table")
+	}
+}
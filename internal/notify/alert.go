@@ -14,13 +14,19 @@ import (
 
 // AlertService 告警服务
 type AlertService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	logHook func(entry *model.AlertLog)
 }
 
 func NewAlertService(db *gorm.DB) *AlertService {
 	return &AlertService{db: db}
 }
 
+// SetLogHook 设置告警日志写入后的回调 (用于实时推送)
+func (a *AlertService) SetLogHook(hook func(entry *model.AlertLog)) {
+	a.logHook = hook
+}
+
 // CheckNodeQuota 检查节点流量配额
 func (a *AlertService) CheckNodeQuota(node *model.Node) {
 	if node.TrafficQuota <= 0 {
@@ -114,6 +120,10 @@ func targetTypeToName(targetType string) string {
 		return "节点"
 	case "client":
 		return "客户端"
+	case "tunnel":
+		return "隧道"
+	case "user":
+		return "用户"
 	default:
 		return targetType
 	}
@@ -141,6 +151,28 @@ func (a *AlertService) CheckClientQuota(client *model.Client) {
 	}
 }
 
+// CheckTunnelQuota 检查隧道流量配额
+func (a *AlertService) CheckTunnelQuota(tunnel *model.Tunnel) {
+	if tunnel.TrafficQuota <= 0 {
+		return
+	}
+
+	totalUsed := tunnel.QuotaUsed
+	usagePercent := float64(totalUsed) / float64(tunnel.TrafficQuota) * 100
+
+	// 检查预警阈值
+	a.checkQuotaWarning(tunnel.ID, "tunnel", tunnel.Name, totalUsed, tunnel.TrafficQuota, usagePercent)
+
+	if totalUsed >= tunnel.TrafficQuota && !tunnel.QuotaExceeded {
+		a.db.Model(tunnel).Update("quota_exceeded", true)
+		a.TriggerAlert("quota_exceeded", "tunnel", tunnel.ID, tunnel.Name,
+			fmt.Sprintf("隧道 %s 流量已超限\n已用: %s / 配额: %s",
+				tunnel.Name,
+				formatBytes(totalUsed),
+				formatBytes(tunnel.TrafficQuota)))
+	}
+}
+
 // CheckNodeOffline 检查节点离线
 func (a *AlertService) CheckNodeOffline(node *model.Node, previousStatus string) {
 	if previousStatus == "online" && node.Status == "offline" {
@@ -157,64 +189,74 @@ func (a *AlertService) TriggerAlert(alertType, targetType string, targetID uint,
 	var rules []model.AlertRule
 	a.db.Where("type = ? AND enabled = ?", alertType, true).Find(&rules)
 
-	for _, rule := range rules {
-		// 检查冷却时间
-		if time.Since(rule.LastAlertAt) < time.Duration(rule.CooldownMin)*time.Minute {
-			continue
-		}
+	for i := range rules {
+		a.TriggerAlertForRule(&rules[i], targetType, targetID, targetName, message)
+	}
+}
 
-		// 发送通知
-		channelIDs := strings.Split(rule.ChannelIDs, ",")
-		for _, idStr := range channelIDs {
-			idStr = strings.TrimSpace(idStr)
-			if idStr == "" {
-				continue
-			}
+// TriggerAlertForRule 按单条规则发送告警 (规则条件已由调用方判断)
+func (a *AlertService) TriggerAlertForRule(rule *model.AlertRule, targetType string, targetID uint, targetName, message string) {
+	alertType := rule.Type
+	// 检查冷却时间
+	if time.Since(rule.LastAlertAt) < time.Duration(rule.CooldownMin)*time.Minute {
+		return
+	}
 
-			channelID, err := strconv.ParseUint(idStr, 10, 32)
-			if err != nil {
-				continue
-			}
+	// 发送通知
+	channelIDs := strings.Split(rule.ChannelIDs, ",")
+	for _, idStr := range channelIDs {
+		idStr = strings.TrimSpace(idStr)
+		if idStr == "" {
+			continue
+		}
 
-			var channel model.NotifyChannel
-			if err := a.db.First(&channel, channelID).Error; err != nil {
-				continue
-			}
+		channelID, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			continue
+		}
 
-			if !channel.Enabled {
-				continue
-			}
+		var channel model.NotifyChannel
+		if err := a.db.First(&channel, channelID).Error; err != nil {
+			continue
+		}
 
-			notifier, err := CreateNotifier(&channel)
-			if err != nil {
-				log.Printf("Create notifier failed: %v", err)
-				continue
-			}
+		if !channel.Enabled {
+			continue
+		}
 
-			status := "sent"
-			title := fmt.Sprintf("[%s] %s", alertTypeToTitle(alertType), targetName)
-			if err := notifier.Send(title, message); err != nil {
-				log.Printf("Send notification failed: %v", err)
-				status = "failed"
-			}
+		notifier, err := CreateNotifier(&channel)
+		if err != nil {
+			log.Printf("Create notifier failed: %v", err)
+			continue
+		}
 
-			// 记录告警日志
-			a.db.Create(&model.AlertLog{
-				RuleID:     rule.ID,
-				RuleName:   rule.Name,
-				Type:       alertType,
-				Message:    message,
-				TargetType: targetType,
-				TargetID:   targetID,
-				TargetName: targetName,
-				Status:     status,
-				CreatedAt:  time.Now(),
-			})
+		status := "sent"
+		title := fmt.Sprintf("[%s] %s", alertTypeToTitle(alertType), targetName)
+		if err := notifier.Send(title, message); err != nil {
+			log.Printf("Send notification failed: %v", err)
+			status = "failed"
 		}
 
-		// 更新规则的最后告警时间
-		a.db.Model(&rule).Update("last_alert_at", time.Now())
+		// 记录告警日志
+		entry := &model.AlertLog{
+			RuleID:     rule.ID,
+			RuleName:   rule.Name,
+			Type:       alertType,
+			Message:    message,
+			TargetType: targetType,
+			TargetID:   targetID,
+			TargetName: targetName,
+			Status:     status,
+			CreatedAt:  time.Now(),
+		}
+		if err := a.db.Create(entry).Error; err == nil && a.logHook != nil {
+			a.logHook(entry)
+		}
 	}
+
+	// 更新规则的最后告警时间
+	rule.LastAlertAt = time.Now()
+	a.db.Model(rule).Update("last_alert_at", rule.LastAlertAt)
 }
 
 // ResetQuotas 重置流量配额（每天检查一次）
@@ -246,6 +288,19 @@ func (a *AlertService) ResetQuotas() {
 			"quota_reset_at": time.Now(),
 		})
 	}
+
+	// 重置隧道配额
+	var tunnels []model.Tunnel
+	a.db.Where("quota_reset_day = ? AND (quota_reset_at IS NULL OR quota_reset_at < ?)",
+		today, time.Now().AddDate(0, 0, -28)).Find(&tunnels)
+
+	for _, tunnel := range tunnels {
+		a.db.Model(&tunnel).Updates(map[string]interface{}{
+			"quota_used":     0,
+			"quota_exceeded": false,
+			"quota_reset_at": time.Now(),
+		})
+	}
 }
 
 // CheckOfflineNodes 检查离线节点（心跳超时）
@@ -264,6 +319,101 @@ func (a *AlertService) CheckOfflineNodes(timeoutMinutes int) {
 	}
 }
 
+// 流量突增检测默认值
+const (
+	trafficSpikeDefaultThreshold = 300              // 当前速率达到基线的百分比
+	trafficSpikeDefaultDuration  = 5                // 当前速率统计窗口 (分钟)
+	trafficSpikeBaselineWindow   = 60 * time.Minute // 基线速率统计窗口
+)
+
+// CheckTrafficSpikes 检查节点流量突增
+// 基于每分钟的 TrafficHistory 采样，比较最近 Duration 分钟的平均速率与此前一小时的基线速率，
+// 当前速率达到基线的 Threshold% 时触发告警。基线为 0 (此前无流量) 时不做判断。
+func (a *AlertService) CheckTrafficSpikes() {
+	var rules []model.AlertRule
+	a.db.Where("type = ? AND enabled = ?", "traffic_spike", true).Find(&rules)
+	if len(rules) == 0 {
+		return
+	}
+
+	var nodes []model.Node
+	a.db.Find(&nodes)
+
+	now := time.Now()
+	for _, node := range nodes {
+		// 每条规则按自身阈值独立判断，只通知达到阈值的规则
+		for i, rule := range rules {
+			condition, err := ParseCondition(rule.Condition)
+			if err != nil {
+				continue
+			}
+			threshold := condition.Threshold
+			if threshold <= 0 {
+				threshold = trafficSpikeDefaultThreshold
+			}
+			duration := condition.Duration
+			if duration <= 0 {
+				duration = trafficSpikeDefaultDuration
+			}
+
+			windowStart := now.Add(-time.Duration(duration) * time.Minute)
+			var samples []model.TrafficHistory
+			a.db.Where("node_id = ? AND recorded_at >= ?", node.ID, windowStart.Add(-trafficSpikeBaselineWindow)).
+				Order("recorded_at ASC").Find(&samples)
+
+			current, ok := trafficRate(samples, windowStart, now)
+			if !ok {
+				continue
+			}
+			baseline, ok := trafficRate(samples, windowStart.Add(-trafficSpikeBaselineWindow), windowStart)
+			if !ok || baseline <= 0 {
+				continue
+			}
+
+			percent := current / baseline * 100
+			if percent < float64(threshold) {
+				continue
+			}
+
+			a.TriggerAlertForRule(&rules[i], "node", node.ID, node.Name,
+				fmt.Sprintf("节点 %s 流量突增\n当前速率: %s/s (最近 %d 分钟)\n基线速率: %s/s (此前 60 分钟)\n当前为基线的 %.0f%% (阈值 %d%%)",
+					node.Name,
+					formatBytes(int64(current)),
+					duration,
+					formatBytes(int64(baseline)),
+					percent,
+					threshold))
+		}
+	}
+}
+
+// trafficRate 计算时间段内的平均流量速率 (bytes/s)
+// 采样为截至采样时刻的区间增量，首个采样的增量不在时间段内，只用作起点；采样不足时返回 false
+func trafficRate(samples []model.TrafficHistory, from, to time.Time) (float64, bool) {
+	var first, last *model.TrafficHistory
+	var delta int64
+	for i := range samples {
+		if samples[i].RecordedAt.Before(from) || samples[i].RecordedAt.After(to) {
+			continue
+		}
+		if first == nil {
+			first = &samples[i]
+			continue
+		}
+		delta += samples[i].TrafficIn + samples[i].TrafficOut
+		last = &samples[i]
+	}
+	if first == nil || last == nil {
+		return 0, false
+	}
+
+	seconds := last.RecordedAt.Sub(first.RecordedAt).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+	return float64(delta) / seconds, true
+}
+
 // CleanupAlertLogs 清理旧的告警日志
 func (a *AlertService) CleanupAlertLogs(retentionDays int) {
 	threshold := time.Now().AddDate(0, 0, -retentionDays)
@@ -287,6 +437,8 @@ func alertTypeToTitle(alertType string) string {
 	switch alertType {
 	case "node_offline":
 		return "节点离线"
+	case "gost_crash":
+		return "GOST 崩溃"
 	case "quota_exceeded":
 		return "流量超限"
 	case "quota_warning":
@@ -295,6 +447,10 @@ func alertTypeToTitle(alertType string) string {
 		return "流量异常"
 	case "agent_update":
 		return "Agent 更新"
+	case "account_locked":
+		return "账户锁定"
+	case "login_bruteforce":
+		return "暴力破解"
 	default:
 		return "告警"
 	}
@@ -421,6 +577,13 @@ func (a *AlertService) CreateDefaultRules() error {
 			Enabled:     true,
 			CooldownMin: 30,
 		},
+		{
+			Name:        "GOST 崩溃告警",
+			Type:        "gost_crash",
+			Condition:   "{}",
+			Enabled:     true,
+			CooldownMin: 10,
+		},
 		{
 			Name:        "流量超限告警",
 			Type:        "quota_exceeded",
@@ -442,6 +605,20 @@ func (a *AlertService) CreateDefaultRules() error {
 			Enabled:     true,
 			CooldownMin: 30,
 		},
+		{
+			Name:        "账户锁定告警",
+			Type:        "account_locked",
+			Condition:   "{}",
+			Enabled:     true,
+			CooldownMin: 5,
+		},
+		{
+			Name:        "暴力破解告警",
+			Type:        "login_bruteforce",
+			Condition:   "{}",
+			Enabled:     true,
+			CooldownMin: 30,
+		},
 	}
 
 	for _, rule := range rules {
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+func TestTrafficRate(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	samples := []model.TrafficHistory{
+		{TrafficIn: 999, RecordedAt: base}, // 起点，增量不计入
+		{TrafficIn: 60, RecordedAt: base.Add(time.Minute)},
+		{TrafficIn: 50, TrafficOut: 70, RecordedAt: base.Add(2 * time.Minute)},
+		{TrafficIn: 1000, RecordedAt: base.Add(10 * time.Minute)}, // 时间段之外
+	}
+
+	rate, ok := trafficRate(samples, base, base.Add(2*time.Minute))
+	if !ok || rate != 1.5 {
+		t.Errorf("trafficRate = %v, %v; want 1.5, true", rate, ok)
+	}
+	if _, ok := trafficRate(samples[:1], base, base.Add(time.Hour)); ok {
+		t.Error("single sample produced a rate")
+	}
+	if _, ok := trafficRate(samples, base.Add(3*time.Minute), base.Add(5*time.Minute)); ok {
+		t.Error("empty range produced a rate")
+	}
+}
+
+func TestCheckTrafficSpikes(t *testing.T) {
+	db, err := model.InitDB(model.DriverSQLite, filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	a := NewAlertService(db)
+
+	node := &model.Node{Name: "edge", Host: "10.0.0.1", AgentToken: "a"}
+	if err := db.Create(node).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 基线 1 B/s，最近 5 分钟 10 B/s (基线的 1000%)
+	now := time.Now()
+	for m := 70; m >= 0; m-- {
+		delta := int64(60)
+		if m < 5 {
+			delta = 600
+		}
+		db.Create(&model.TrafficHistory{NodeID: &node.ID, TrafficIn: delta, RecordedAt: now.Add(-time.Duration(m)*time.Minute + time.Second)})
+	}
+
+	fired := &model.AlertRule{Name: "spike", Type: "traffic_spike", Condition: `{"threshold":500,"duration":5}`, Enabled: true}
+	quiet := &model.AlertRule{Name: "big spike", Type: "traffic_spike", Condition: `{"threshold":2000,"duration":5}`, Enabled: true}
+	for _, rule := range []*model.AlertRule{fired, quiet} {
+		if err := db.Create(rule).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a.CheckTrafficSpikes()
+
+	db.First(fired, fired.ID)
+	db.First(quiet, quiet.ID)
+	if fired.LastAlertAt.IsZero() {
+		t.Error("rule with 500% threshold did not fire")
+	}
+	if !quiet.LastAlertAt.IsZero() {
+		t.Error("rule with 2000% threshold fired")
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// ==================== 个人访问令牌 ====================
+
+// APITokenPrefix 个人访问令牌前缀 (用于与 JWT 区分)
+const APITokenPrefix = "gpat_"
+
+// maxAPITokensPerUser 每个用户最多可创建的令牌数
+const maxAPITokensPerUser = 50
+
+// apiTokenTouchInterval 最后使用时间的更新间隔 (减少数据库写入)
+const apiTokenTouchInterval = time.Minute
+
+var (
+	ErrAPITokenInvalid = errors.New("invalid api token")
+	ErrAPITokenExpired = errors.New("api token expired")
+)
+
+// hashToken 计算令牌哈希 (令牌本身为高熵随机串，无需加盐)
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken 创建个人访问令牌，返回的明文令牌仅此一次可见
+func (s *Service) CreateAPIToken(userID uint, name string, scopes []string, expiresAt *time.Time) (*model.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if err := validatePermission(scope); err != nil {
+			return nil, "", err
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", errors.New("expires_at must be in the future")
+	}
+
+	var count int64
+	s.db.Model(&model.APIToken{}).Where("user_id = ?", userID).Count(&count)
+	if count >= maxAPITokensPerUser {
+		return nil, "", errors.New("too many api tokens")
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	raw := APITokenPrefix + hex.EncodeToString(buf)
+
+	token := &model.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(raw),
+		Prefix:    raw[:len(APITokenPrefix)+6],
+		Scopes:    permissionJSON(scopes),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		return nil, "", err
+	}
+	return token, raw, nil
+}
+
+// ListAPITokens 获取用户的个人访问令牌
+func (s *Service) ListAPITokens(userID uint) ([]model.APIToken, error) {
+	var tokens []model.APIToken
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// DeleteAPIToken 撤销用户的个人访问令牌
+func (s *Service) DeleteAPIToken(userID, id uint) (*model.APIToken, error) {
+	var token model.APIToken
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, s.db.Delete(&token).Error
+}
+
+// AuthenticateAPIToken 校验个人访问令牌，返回令牌及所属用户
+func (s *Service) AuthenticateAPIToken(raw, ip string) (*model.APIToken, *model.User, error) {
+	var token model.APIToken
+	if err := s.db.Where("token_hash = ?", hashToken(raw)).First(&token).Error; err != nil {
+		return nil, nil, ErrAPITokenInvalid
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		return nil, nil, ErrAPITokenExpired
+	}
+
+	user, err := s.GetUser(token.UserID)
+	if err != nil || !user.Enabled {
+		return nil, nil, ErrAPITokenInvalid
+	}
+
+	// 记录最后使用时间/IP
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenTouchInterval || token.LastUsedIP != ip {
+		s.db.Model(&token).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		})
+	}
+	return &token, user, nil
+}
+
+// APITokenActive 令牌是否仍然有效 (未删除、未过期且用户未被禁用)，用于长连接的定期校验
+func (s *Service) APITokenActive(id uint) bool {
+	var token model.APIToken
+	if err := s.db.First(&token, id).Error; err != nil {
+		return false
+	}
+	if token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()) {
+		return false
+	}
+	user, err := s.GetUser(token.UserID)
+	return err == nil && user.Enabled
+}
+
+// APITokenPermissions 令牌的授权范围
+func APITokenPermissions(token *model.APIToken) *RolePermissions {
+	return newRolePermissions(&model.Role{Name: token.Name, Permissions: token.Scopes})
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+func TestAPITokenStoresOnlyHash(t *testing.T) {
+	svc := newTestService(t)
+	user, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, raw, err := svc.CreateAPIToken(user.ID, "ci", []string{"nodes:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, APITokenPrefix) {
+		t.Fatalf("token %q missing prefix %q", raw, APITokenPrefix)
+	}
+
+	var stored model.APIToken
+	if err := svc.DB().First(&stored, token.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.TokenHash != hashToken(raw) {
+		t.Error("stored hash does not match the token")
+	}
+	if strings.Contains(stored.TokenHash, raw) || strings.Contains(stored.Prefix, raw) {
+		t.Error("plaintext token stored in the database")
+	}
+	if len(stored.Prefix) >= len(raw) {
+		t.Errorf("prefix %q exposes the whole token", stored.Prefix)
+	}
+
+	got, owner, err := svc.AuthenticateAPIToken(raw, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != token.ID || owner.ID != user.ID {
+		t.Errorf("authenticated token %d user %d, want %d %d", got.ID, owner.ID, token.ID, user.ID)
+	}
+	if _, _, err := svc.AuthenticateAPIToken(raw+"x", "127.0.0.1"); !errors.Is(err, ErrAPITokenInvalid) {
+		t.Errorf("wrong token: err = %v, want ErrAPITokenInvalid", err)
+	}
+}
+
+func TestAPITokenRevokedAndExpired(t *testing.T) {
+	svc := newTestService(t)
+	user, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 已撤销
+	revoked, raw, err := svc.CreateAPIToken(user.ID, "revoked", []string{"nodes:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.DeleteAPIToken(user.ID, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := svc.AuthenticateAPIToken(raw, "127.0.0.1"); !errors.Is(err, ErrAPITokenInvalid) {
+		t.Errorf("revoked token: err = %v, want ErrAPITokenInvalid", err)
+	}
+	if svc.APITokenActive(revoked.ID) {
+		t.Error("revoked token still active")
+	}
+
+	// 已过期
+	expiresAt := time.Now().Add(time.Hour)
+	expired, raw, err := svc.CreateAPIToken(user.ID, "expired", []string{"nodes:read"}, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.DB().Model(&model.APIToken{}).Where("id = ?", expired.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, _, err := svc.AuthenticateAPIToken(raw, "127.0.0.1"); !errors.Is(err, ErrAPITokenExpired) {
+		t.Errorf("expired token: err = %v, want ErrAPITokenExpired", err)
+	}
+	if svc.APITokenActive(expired.ID) {
+		t.Error("expired token still active")
+	}
+
+	// 过期时间必须在未来
+	past := time.Now().Add(-time.Hour)
+	if _, _, err := svc.CreateAPIToken(user.ID, "past", []string{"nodes:read"}, &past); err == nil {
+		t.Error("created a token that is already expired")
+	}
+
+	// 用户被禁用后令牌失效
+	active, raw, err := svc.CreateAPIToken(user.ID, "active", []string{"nodes:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.DB().Model(&model.User{}).Where("id = ?", user.ID).Update("enabled", false)
+	if _, _, err := svc.AuthenticateAPIToken(raw, "127.0.0.1"); !errors.Is(err, ErrAPITokenInvalid) {
+		t.Errorf("disabled user: err = %v, want ErrAPITokenInvalid", err)
+	}
+	if svc.APITokenActive(active.ID) {
+		t.Error("token of disabled user still active")
+	}
+}
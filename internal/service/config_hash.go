@@ -0,0 +1,60 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// ==================== 节点配置哈希缓存 ====================
+
+// configHashMaxAge 缓存最长有效期，兜底未触发失效的变更 (如直接修改数据库)
+const configHashMaxAge = time.Minute
+
+// configHashCache 缓存节点配置哈希，避免每次心跳都重新生成完整配置
+// 配置可能依赖任意资源 (规则、隧道、转发、代理链、停用状态)，资源变化时整体失效
+type configHashCache struct {
+	mu         sync.Mutex
+	generation uint64
+	entries    map[uint]configHashEntry
+}
+
+type configHashEntry struct {
+	hash       string
+	generation uint64
+	cachedAt   time.Time
+}
+
+func (c *configHashCache) get(nodeID uint) (string, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[nodeID]
+	if !ok || e.generation != c.generation || time.Since(e.cachedAt) > configHashMaxAge {
+		return "", c.generation, false
+	}
+	return e.hash, c.generation, true
+}
+
+// put 保存哈希，计算期间缓存已失效时丢弃
+func (c *configHashCache) put(nodeID uint, hash string, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[uint]configHashEntry)
+	}
+	c.entries[nodeID] = configHashEntry{hash: hash, generation: generation, cachedAt: time.Now()}
+}
+
+func (c *configHashCache) invalidate() {
+	c.mu.Lock()
+	c.generation++
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+// InvalidateNodeConfigHashes 资源变化后清除全部节点的配置哈希缓存
+func (s *Service) InvalidateNodeConfigHashes() {
+	s.configHashes.invalidate()
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+func TestNodeConfigHashCache(t *testing.T) {
+	svc := newTestService(t)
+	node := &model.Node{Name: "node", Host: "node.example.com", Port: 1080, Protocol: "socks5", Transport: "tcp"}
+	if err := svc.CreateNode(node); err != nil {
+		t.Fatal(err)
+	}
+
+	first := svc.GetNodeConfigHash(node.ID)
+	if first == "" {
+		t.Fatal("empty config hash")
+	}
+
+	// 绕过失效通知直接修改数据库: 命中缓存，不重新生成配置
+	svc.DB().Model(&model.Node{}).Where("id = ?", node.ID).Update("port", 2080)
+	if got := svc.GetNodeConfigHash(node.ID); got != first {
+		t.Fatal("config hash recomputed without invalidation")
+	}
+
+	svc.InvalidateNodeConfigHashes()
+	second := svc.GetNodeConfigHash(node.ID)
+	if second == first {
+		t.Fatal("config hash not recomputed after invalidation")
+	}
+
+	// 计算期间缓存失效时丢弃旧结果
+	_, generation, _ := svc.configHashes.get(node.ID)
+	svc.InvalidateNodeConfigHashes()
+	svc.configHashes.put(node.ID, "stale", generation)
+	if got := svc.GetNodeConfigHash(node.ID); got != second {
+		t.Errorf("stale hash served: %q", got)
+	}
+
+	// 停用状态变化同样使缓存失效
+	svc.setSuspended(&model.Node{}, "node", node.ID, node.Name, false, suspendReasonQuotaExceeded)
+	if got := svc.GetNodeConfigHash(node.ID); got == second {
+		t.Error("config hash not recomputed after suspension")
+	}
+}
+
+func TestNodeConfigHashCacheExpires(t *testing.T) {
+	svc := newTestService(t)
+	node := &model.Node{Name: "node", Host: "node.example.com", Port: 1080, Protocol: "socks5", Transport: "tcp"}
+	if err := svc.CreateNode(node); err != nil {
+		t.Fatal(err)
+	}
+	first := svc.GetNodeConfigHash(node.ID)
+	svc.DB().Model(&model.Node{}).Where("id = ?", node.ID).Update("port", 2080)
+
+	svc.configHashes.mu.Lock()
+	entry := svc.configHashes.entries[node.ID]
+	entry.cachedAt = time.Now().Add(-2 * configHashMaxAge)
+	svc.configHashes.entries[node.ID] = entry
+	svc.configHashes.mu.Unlock()
+
+	if got := svc.GetNodeConfigHash(node.ID); got == first {
+		t.Error("expired config hash served")
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/gost"
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// ==================== 节点配置应用状态 ====================
+
+// 配置应用状态 (Agent 上报)
+const (
+	NodeConfigApplied = "applied"
+	NodeConfigFailed  = "failed"
+)
+
+// RecordNodeConfigApplied Agent 已成功应用配置，清除失败标记
+func (s *Service) RecordNodeConfigApplied(nodeID uint) error {
+	return s.db.Model(&model.Node{}).Where("id = ?", nodeID).Updates(map[string]interface{}{
+		"config_status":            NodeConfigApplied,
+		"config_error":             "",
+		"config_failed_hash":       "",
+		"config_failed_version_id": nil,
+		"config_reported_at":       time.Now(),
+	}).Error
+}
+
+// RecordNodeConfigFailure 记录 Agent 校验或重载配置失败，返回失败配置对应的版本快照 (无法确定时为 nil)
+// 没有对应快照且面板当前配置即为失败配置时自动保存快照，便于查看与比对
+func (s *Service) RecordNodeConfigFailure(nodeID uint, hash, errMsg string) (*model.ConfigVersion, error) {
+	errMsg = truncateString(errMsg, 500)
+	now := time.Now()
+
+	var version *model.ConfigVersion
+	var found model.ConfigVersion
+	if err := s.db.Where("node_id = ? AND hash = ?", nodeID, hash).Order("id DESC").First(&found).Error; err == nil {
+		version = &found
+	} else if node, err := s.GetNode(nodeID); err == nil {
+		if data, _, err := s.RenderNodeConfig(node); err == nil && gost.ConfigHash(data) == hash {
+			snapshot := &model.ConfigVersion{
+				NodeID:    nodeID,
+				Config:    string(data),
+				Comment:   "Agent 应用失败",
+				Hash:      hash,
+				CreatedAt: now,
+			}
+			if s.db.Create(snapshot).Error == nil {
+				version = snapshot
+				s.CleanupOldVersions(nodeID, 20)
+			}
+		}
+	}
+
+	updates := map[string]interface{}{
+		"config_status":            NodeConfigFailed,
+		"config_error":             errMsg,
+		"config_failed_hash":       hash,
+		"config_failed_version_id": nil,
+		"config_reported_at":       now,
+	}
+	if version != nil {
+		version.ApplyError = errMsg
+		s.db.Model(&model.ConfigVersion{}).Where("id = ?", version.ID).Update("apply_error", errMsg)
+		updates["config_failed_version_id"] = version.ID
+	}
+	return version, s.db.Model(&model.Node{}).Where("id = ?", nodeID).Updates(updates).Error
+}
+
+// ClearNodeConfigFailure 清除失败标记，允许再次通知 Agent 加载同一配置 (手动同步时)
+func (s *Service) ClearNodeConfigFailure(nodeID uint) error {
+	return s.db.Model(&model.Node{}).Where("id = ?", nodeID).Update("config_failed_hash", "").Error
+}
+
+// ResolveNodeConfigFailure Agent 上报的配置与面板一致时清除失败状态 (失败后面板配置被改回可用版本)
+func (s *Service) ResolveNodeConfigFailure(node *model.Node, currentHash, reportedHash string) {
+	if node.ConfigStatus == NodeConfigFailed && reportedHash != "" && reportedHash == currentHash {
+		s.RecordNodeConfigApplied(node.ID)
+	}
+}
+
+// NodeConfigRejected 面板当前配置是否已被 Agent 拒绝 (配置变更或手动同步前不再通知重载)
+func NodeConfigRejected(node *model.Node, currentHash string) bool {
+	return node.ConfigFailedHash != "" && node.ConfigFailedHash == currentHash
+}
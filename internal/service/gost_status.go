@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// ==================== GOST 进程状态 ====================
+
+// GOST 进程状态 (Agent 上报)
+const (
+	NodeGostRunning = "running"
+	NodeGostDown    = "down"
+)
+
+// agentReportTimeout Agent 超过此时间未上报视为离线 (HTTP 心跳间隔 30 秒)
+const agentReportTimeout = 2 * time.Minute
+
+// GostProcessStatus Agent 心跳中的 GOST 进程状态
+type GostProcessStatus struct {
+	Running      bool       `json:"running"`
+	PID          int        `json:"pid"`
+	Crashes      int        `json:"crashes"`  // Agent 本次运行以来的意外退出次数
+	Restarts     int        `json:"restarts"` // 自动重启次数
+	LastExitCode *int       `json:"last_exit_code"`
+	LastExitAt   *time.Time `json:"last_exit_at"`
+	LastError    string     `json:"last_error"` // 退出前的 stderr 输出
+}
+
+// RecordNodeGostStatus 保存 Agent 上报的 GOST 进程状态，出现新的意外退出时触发 gost_crash 告警
+// 返回 GOST 状态 (running/down)
+func (s *Service) RecordNodeGostStatus(node *model.Node, st *GostProcessStatus) (string, error) {
+	status := NodeGostDown
+	if st.Running {
+		status = NodeGostRunning
+	}
+	lastError := tailString(st.LastError, 4000)
+
+	// 数据库时间精度可能只到秒，间隔 1 秒以上才视为新的退出
+	crashed := st.LastExitAt != nil &&
+		(node.GostLastExitAt == nil || st.LastExitAt.After(node.GostLastExitAt.Add(time.Second)))
+
+	updates := map[string]interface{}{
+		"gost_status":      status,
+		"gost_crashes":     st.Crashes,
+		"gost_restarts":    st.Restarts,
+		"gost_reported_at": time.Now(),
+	}
+	if crashed {
+		updates["gost_last_exit_code"] = st.LastExitCode
+		updates["gost_last_exit_at"] = st.LastExitAt
+		updates["gost_last_error"] = lastError
+	}
+	if err := s.db.Model(&model.Node{}).Where("id = ?", node.ID).Updates(updates).Error; err != nil {
+		return status, err
+	}
+
+	if crashed {
+		go s.alertService.TriggerAlert("gost_crash", "node", node.ID, node.Name, gostCrashMessage(node.Name, st, lastError))
+	}
+	return status, nil
+}
+
+// gostCrashMessage 生成崩溃告警内容
+func gostCrashMessage(nodeName string, st *GostProcessStatus, lastError string) string {
+	code := "未知"
+	if st.LastExitCode != nil && *st.LastExitCode >= 0 {
+		code = fmt.Sprintf("%d", *st.LastExitCode)
+	} else if st.LastExitCode != nil {
+		code = "被信号终止"
+	}
+
+	state := "Agent 正在按退避策略重启"
+	if st.Running {
+		state = "已自动重启"
+	}
+	msg := fmt.Sprintf("节点 %s 的 GOST 进程意外退出 (退出码: %s)，%s\n累计崩溃 %d 次，自动重启 %d 次",
+		nodeName, code, state, st.Crashes, st.Restarts)
+
+	if tail := tailString(lastError, 500); tail != lastError {
+		lastError = "..." + tail
+	}
+	if lastError != "" {
+		msg += "\n最近输出:\n" + lastError
+	}
+	return msg
+}
+
+// NodeAgentReporting Agent 最近是否上报过 GOST 状态 (用于区分 GOST 异常与节点离线)
+func NodeAgentReporting(node *model.Node) bool {
+	return node.GostReportedAt != nil && time.Since(*node.GostReportedAt) < agentReportTimeout
+}
+
+// tailString 保留末尾不超过 max 字节的内容，从完整的 UTF-8 字符开始 (避免 PostgreSQL 拒绝写入)
+func tailString(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	value = value[len(value)-max:]
+	for len(value) > 0 && !utf8.RuneStart(value[0]) {
+		value = value[1:]
+	}
+	return value
+}
@@ -111,6 +111,10 @@ func (h *HealthChecker) checkNode(node model.Node) {
 		status = "unhealthy"
 		errMsg = err.Error()
 		newNodeStatus = "offline"
+		// Agent 仍在上报，说明只是 GOST 异常或 API 不可达，节点保持在线 (GOST 崩溃由 gost_crash 告警)
+		if NodeAgentReporting(&node) {
+			newNodeStatus = node.Status
+		}
 	}
 
 	// 记录健康检查日志
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ==================== LDAP 认证 ====================
+
+const (
+	AuthProviderLDAP = "ldap"
+
+	ldapTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrLDAPNotConfigured  = errors.New("ldap url or base dn is not configured")
+
+	ErrPasswordManagedByLDAP = errors.New("password is managed by the LDAP directory")
+)
+
+// LDAPSettings LDAP 配置 (来自 SiteConfig)
+type LDAPSettings struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string
+	EmailAttr          string
+	GroupBaseDN        string
+	GroupFilter        string
+	GroupAttr          string
+	RoleMapping        []GroupRoleMapping
+}
+
+// LDAPEntry 目录中的用户信息
+type LDAPEntry struct {
+	DN     string   `json:"dn"`
+	Email  string   `json:"email"`
+	Groups []string `json:"groups"`
+}
+
+// AuthBackends 用户名密码登录依次尝试的认证源
+func (s *Service) AuthBackends() []string {
+	var backends []string
+	for _, b := range strings.Split(s.GetSiteConfig(model.ConfigAuthBackends), ",") {
+		b = strings.TrimSpace(b)
+		if (b == AuthProviderLocal || b == AuthProviderLDAP) && !containsString(backends, b) {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == 0 {
+		backends = []string{AuthProviderLocal}
+	}
+	return backends
+}
+
+// GetLDAPSettings 读取 LDAP 配置
+func (s *Service) GetLDAPSettings() *LDAPSettings {
+	return s.ldapSettings(s.GetSiteConfig)
+}
+
+func (s *Service) ldapSettings(get func(key string) string) *LDAPSettings {
+	settings := &LDAPSettings{
+		URL:                strings.TrimSpace(get(model.ConfigLDAPURL)),
+		StartTLS:           get(model.ConfigLDAPStartTLS) == "true",
+		InsecureSkipVerify: get(model.ConfigLDAPInsecureSkipVerify) == "true",
+		BindDN:             strings.TrimSpace(get(model.ConfigLDAPBindDN)),
+		BindPassword:       get(model.ConfigLDAPBindPassword),
+		BaseDN:             strings.TrimSpace(get(model.ConfigLDAPBaseDN)),
+		UserFilter:         strings.TrimSpace(get(model.ConfigLDAPUserFilter)),
+		EmailAttr:          strings.TrimSpace(get(model.ConfigLDAPEmailAttr)),
+		GroupBaseDN:        strings.TrimSpace(get(model.ConfigLDAPGroupBaseDN)),
+		GroupFilter:        strings.TrimSpace(get(model.ConfigLDAPGroupFilter)),
+		GroupAttr:          strings.TrimSpace(get(model.ConfigLDAPGroupAttr)),
+	}
+	if settings.UserFilter == "" {
+		settings.UserFilter = "(uid=%s)"
+	}
+	if settings.EmailAttr == "" {
+		settings.EmailAttr = "mail"
+	}
+	if settings.GroupFilter == "" {
+		settings.GroupFilter = "(|(member=%s)(uniqueMember=%s))"
+	}
+	if settings.GroupAttr == "" {
+		settings.GroupAttr = "cn"
+	}
+	settings.RoleMapping = parseRoleMapping(model.ConfigLDAPRoleMapping, get(model.ConfigLDAPRoleMapping))
+	return settings
+}
+
+// dial 连接 LDAP 服务器 (ldaps:// 直接 TLS，ldap:// 可选 StartTLS) 并使用服务账户绑定
+func (settings *LDAPSettings) dial() (*ldap.Conn, error) {
+	if settings.URL == "" || settings.BaseDN == "" {
+		return nil, ErrLDAPNotConfigured
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+	if host, _, err := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(settings.URL, "ldaps://"), "ldap://")); err == nil {
+		tlsConfig.ServerName = host
+	}
+
+	conn, err := ldap.DialURL(settings.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap connect failed: %w", err)
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if settings.StartTLS && strings.HasPrefix(strings.ToLower(settings.URL), "ldap://") {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls failed: %w", err)
+		}
+	}
+	if err := settings.bindServiceAccount(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (settings *LDAPSettings) bindServiceAccount(conn *ldap.Conn) error {
+	if settings.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(settings.BindDN, settings.BindPassword); err != nil {
+		return fmt.Errorf("ldap service account bind failed: %w", err)
+	}
+	return nil
+}
+
+// authenticate 搜索用户并以用户 DN 绑定校验密码，返回用户信息与所属组
+func (settings *LDAPSettings) authenticate(username, password string) (*LDAPEntry, error) {
+	// 空密码会被多数服务器视为匿名绑定而"成功"，必须拒绝
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := settings.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(settings.UserFilter, "%s", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		settings.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		filter, []string{"dn", settings.EmailAttr, "memberOf"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap user search failed: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	user := result.Entries[0]
+
+	if err := conn.Bind(user.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind failed: %w", err)
+	}
+
+	entry := &LDAPEntry{DN: user.DN, Email: user.GetAttributeValue(settings.EmailAttr)}
+
+	// 组查询: 配置了组基础 DN 时按过滤器搜索，否则读取 memberOf
+	if settings.GroupBaseDN == "" {
+		for _, groupDN := range user.GetAttributeValues("memberOf") {
+			entry.Groups = append(entry.Groups, firstRDNValue(groupDN))
+		}
+		return entry, nil
+	}
+	if err := settings.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+	groups, err := conn.Search(ldap.NewSearchRequest(
+		settings.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout.Seconds()), false,
+		strings.ReplaceAll(settings.GroupFilter, "%s", ldap.EscapeFilter(user.DN)), []string{settings.GroupAttr}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap group search failed: %w", err)
+	}
+	for _, group := range groups.Entries {
+		if name := group.GetAttributeValue(settings.GroupAttr); name != "" {
+			entry.Groups = append(entry.Groups, name)
+		}
+	}
+	return entry, nil
+}
+
+// firstRDNValue 取 DN 首个 RDN 的值，如 cn=admins,ou=groups,dc=example,dc=com -> admins
+func firstRDNValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// validateLDAPUser 通过 LDAP 认证用户，首次登录时创建本地影子用户 (以便资源归属等功能正常工作)
+// 已存在的非 LDAP 本地用户不会被同名的目录账户接管
+func (s *Service) validateLDAPUser(username, password string) (*model.User, error) {
+	var existing *model.User
+	if user, err := s.GetUserByUsername(username); err == nil {
+		if user.AuthProvider != AuthProviderLDAP {
+			return nil, ErrInvalidCredentials
+		}
+		existing = user
+	}
+
+	settings := s.GetLDAPSettings()
+	entry, err := settings.authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	role := s.mapGroupRole(settings.RoleMapping, entry.Groups)
+
+	if existing != nil {
+		if entry.Email != "" && (existing.Email == nil || *existing.Email != entry.Email) {
+			var count int64
+			s.db.Model(&model.User{}).Where("email = ? AND id <> ?", entry.Email, existing.ID).Count(&count)
+			if count == 0 {
+				s.db.Model(existing).Update("email", entry.Email)
+				existing.Email = &entry.Email
+			}
+		}
+		s.syncMappedRole(existing, role)
+		return existing, nil
+	}
+
+	user := &model.User{
+		Username:      username,
+		EmailVerified: true,
+		AuthProvider:  AuthProviderLDAP,
+	}
+	if err := s.createExternalUser(user, entry.Email, role); err != nil {
+		return nil, err
+	}
+	s.LogOperation(user.ID, user.Username, "register", "ldap", user.ID, "user provisioned via LDAP: "+entry.DN, "", "", "success")
+	return user, nil
+}
+
+// LDAPTestResult LDAP 连接测试结果
+type LDAPTestResult struct {
+	Success bool       `json:"success"`
+	Message string     `json:"message"`
+	User    *LDAPEntry `json:"user,omitempty"`
+	Role    string     `json:"role,omitempty"` // 按组映射得到的角色
+}
+
+// TestLDAP 测试 LDAP 连接与服务账户绑定；提供用户名密码时同时测试用户认证与组映射
+// overrides 为尚未保存的配置项，优先于已保存的配置
+func (s *Service) TestLDAP(overrides map[string]string, username, password string) *LDAPTestResult {
+	settings := s.ldapSettings(func(key string) string {
+		if value, ok := overrides[key]; ok {
+			return value
+		}
+		return s.GetSiteConfig(key)
+	})
+
+	if username == "" {
+		conn, err := settings.dial()
+		if err != nil {
+			return &LDAPTestResult{Message: err.Error()}
+		}
+		conn.Close()
+		return &LDAPTestResult{Success: true, Message: "connection and bind succeeded"}
+	}
+
+	entry, err := settings.authenticate(username, password)
+	if err != nil {
+		return &LDAPTestResult{Message: err.Error()}
+	}
+	return &LDAPTestResult{
+		Success: true,
+		Message: "user authenticated",
+		User:    entry,
+		Role:    s.mapGroupRole(settings.RoleMapping, entry.Groups),
+	}
+}
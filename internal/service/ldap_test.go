@@ -0,0 +1,479 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testLDAPEntry 目录条目，password 非空时可用于绑定
+type testLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// testLDAPServer 进程内 LDAP 服务器，仅支持简单绑定与搜索
+// 与多数真实服务器一致，空密码绑定按匿名绑定处理并返回成功
+type testLDAPServer struct {
+	ln      net.Listener
+	entries []testLDAPEntry
+
+	mu      sync.Mutex
+	binds   []string // 成功绑定的 DN
+	filters []string // 收到的搜索过滤器
+}
+
+const (
+	testLDAPBase   = "dc=example,dc=com"
+	testLDAPGroups = "ou=groups,dc=example,dc=com"
+	testLDAPSvcDN  = "cn=svc,dc=example,dc=com"
+)
+
+// values 按属性名 (忽略大小写) 取值
+func (e testLDAPEntry) values(name string) []string {
+	for attr, values := range e.attrs {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func newTestLDAPServer(t *testing.T) *testLDAPServer {
+	t.Helper()
+	people := func(uid, ou, password, mail string, memberOf ...string) testLDAPEntry {
+		attrs := map[string][]string{"uid": {uid}, "objectClass": {"inetOrgPerson"}}
+		if mail != "" {
+			attrs["mail"] = []string{mail}
+		}
+		if len(memberOf) > 0 {
+			attrs["memberOf"] = memberOf
+		}
+		return testLDAPEntry{dn: "uid=" + uid + ",ou=" + ou + "," + testLDAPBase, password: password, attrs: attrs}
+	}
+	group := func(cn string, members ...string) testLDAPEntry {
+		return testLDAPEntry{dn: "cn=" + cn + "," + testLDAPGroups, attrs: map[string][]string{
+			"cn": {cn}, "objectClass": {"groupOfNames"}, "member": members,
+		}}
+	}
+
+	srv := &testLDAPServer{entries: []testLDAPEntry{
+		{dn: testLDAPSvcDN, password: "svc-pass", attrs: map[string][]string{"cn": {"svc"}}},
+		people("alice", "people", "alice-pass", "alice@example.com", "cn=panel-admins,"+testLDAPGroups),
+		people("bob", "people", "bob-pass", "bob@example.com"),
+		people("admin", "people", "admin-ldap", "root@example.com"),
+		// 同一 uid 在两个 OU 中各有一个条目
+		people("dup", "people", "dup-pass", ""),
+		people("dup", "contractors", "dup-pass", ""),
+		group("panel-admins", "uid=alice,ou=people,"+testLDAPBase),
+		group("operators", "uid=bob,ou=people,"+testLDAPBase),
+	}}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ln = ln
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *testLDAPServer) url() string {
+	return "ldap://" + srv.ln.Addr().String()
+}
+
+// settings 使用服务账户与 memberOf 组查询的默认配置
+func (srv *testLDAPServer) settings() *LDAPSettings {
+	return &LDAPSettings{
+		URL:          srv.url(),
+		BindDN:       testLDAPSvcDN,
+		BindPassword: "svc-pass",
+		BaseDN:       testLDAPBase,
+		UserFilter:   "(&(objectClass=inetOrgPerson)(uid=%s))",
+		EmailAttr:    "mail",
+		GroupFilter:  "(|(member=%s)(uniqueMember=%s))",
+		GroupAttr:    "cn",
+	}
+}
+
+func (srv *testLDAPServer) boundAs(dn string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, b := range srv.binds {
+		if strings.EqualFold(b, dn) {
+			return true
+		}
+	}
+	return false
+}
+
+func (srv *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			srv.reply(conn, id, srv.bind(op))
+		case ldap.ApplicationSearchRequest:
+			srv.search(conn, id, op)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			srv.reply(conn, id, ldapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform))
+		}
+	}
+}
+
+func (srv *testLDAPServer) bind(op *ber.Packet) *ber.Packet {
+	dn := op.Children[1].Data.String()
+	password := op.Children[2].Data.String()
+	if password == "" {
+		return ldapResult(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess)
+	}
+	for _, e := range srv.entries {
+		if strings.EqualFold(e.dn, dn) && e.password != "" && e.password == password {
+			srv.mu.Lock()
+			srv.binds = append(srv.binds, e.dn)
+			srv.mu.Unlock()
+			return ldapResult(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess)
+		}
+	}
+	return ldapResult(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials)
+}
+
+func (srv *testLDAPServer) search(conn net.Conn, id int64, op *ber.Packet) {
+	base := strings.ToLower(op.Children[0].Data.String())
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	if decompiled, err := ldap.DecompileFilter(filter); err == nil {
+		srv.mu.Lock()
+		srv.filters = append(srv.filters, decompiled)
+		srv.mu.Unlock()
+	}
+
+	sent := int64(0)
+	for _, e := range srv.entries {
+		if !strings.HasSuffix(strings.ToLower(e.dn), base) || !matchFilter(filter, e) {
+			continue
+		}
+		if sizeLimit > 0 && sent == sizeLimit {
+			srv.reply(conn, id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded))
+			return
+		}
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"))
+		attrs := ber.NewSequence("Attributes")
+		for name, values := range e.attrs {
+			attr := ber.NewSequence("Attribute")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+			}
+			attr.AppendChild(set)
+			attrs.AppendChild(attr)
+		}
+		entry.AppendChild(attrs)
+		srv.reply(conn, id, entry)
+		sent++
+	}
+	srv.reply(conn, id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func (srv *testLDAPServer) reply(conn net.Conn, id int64, op *ber.Packet) {
+	packet := ber.NewSequence("LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	conn.Write(packet.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "MatchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Message"))
+	return result
+}
+
+// matchFilter 支持 and/or/not/等值/子串/存在 过滤器，属性值比较忽略大小写
+func matchFilter(f *ber.Packet, e testLDAPEntry) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !matchFilter(c, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if matchFilter(c, e) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(f.Children[0], e)
+	case ldap.FilterEqualityMatch:
+		want := f.Children[1].Data.String()
+		for _, v := range e.values(f.Children[0].Data.String()) {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		for _, v := range e.values(f.Children[0].Data.String()) {
+			if matchSubstrings(strings.ToLower(v), f.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(e.values(f.Data.String())) > 0
+	}
+	return false
+}
+
+func matchSubstrings(value string, parts []*ber.Packet) bool {
+	for _, p := range parts {
+		s := strings.ToLower(p.Data.String())
+		switch p.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case ldap.FilterSubstringsAny:
+			i := strings.Index(value, s)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(s):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// configureLDAP 将测试服务器写入站点配置
+func configureLDAP(t *testing.T, svc *Service, srv *testLDAPServer, backends string) {
+	t.Helper()
+	settings := srv.settings()
+	if err := svc.SetSiteConfigs(map[string]string{
+		model.ConfigAuthBackends:     backends,
+		model.ConfigLDAPURL:          settings.URL,
+		model.ConfigLDAPBindDN:       settings.BindDN,
+		model.ConfigLDAPBindPassword: settings.BindPassword,
+		model.ConfigLDAPBaseDN:       settings.BaseDN,
+		model.ConfigLDAPUserFilter:   settings.UserFilter,
+		model.ConfigLDAPRoleMapping:  `[{"group":"panel-admins","role":"admin"},{"group":"operators","role":"operator"}]`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	srv := newTestLDAPServer(t)
+
+	entry, err := srv.settings().authenticate("alice", "alice-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DN != "uid=alice,ou=people,"+testLDAPBase || entry.Email != "alice@example.com" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if _, err := srv.settings().authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+	if _, err := srv.settings().authenticate("nobody", "alice-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown user: err = %v", err)
+	}
+}
+
+func TestLDAPAuthenticateEscapesFilter(t *testing.T) {
+	srv := newTestLDAPServer(t)
+
+	// 未转义时 "al*" 会作为子串过滤器匹配 alice
+	if _, err := srv.settings().authenticate("al*", "alice-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wildcard username: err = %v", err)
+	}
+	if _, err := srv.settings().authenticate("x)(uid=alice", "alice-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("filter injection: err = %v", err)
+	}
+	if srv.boundAs("uid=alice,ou=people," + testLDAPBase) {
+		t.Error("crafted username bound as alice")
+	}
+
+	srv.mu.Lock()
+	filters := strings.Join(srv.filters, "\n")
+	srv.mu.Unlock()
+	for _, want := range []string{`(uid=al\2a)`, `(uid=x\29\28uid=alice)`} {
+		if !strings.Contains(filters, want) {
+			t.Errorf("search filters %q do not contain escaped %s", filters, want)
+		}
+	}
+}
+
+func TestLDAPAuthenticateRejectsEmptyPassword(t *testing.T) {
+	srv := newTestLDAPServer(t)
+
+	// 服务器对空密码返回匿名绑定成功，必须在客户端拒绝
+	if _, err := srv.settings().authenticate("alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("empty password: err = %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.filters) != 0 {
+		t.Errorf("directory searched for empty password: %v", srv.filters)
+	}
+}
+
+func TestLDAPAuthenticateRejectsAmbiguousUser(t *testing.T) {
+	srv := newTestLDAPServer(t)
+
+	if _, err := srv.settings().authenticate("dup", "dup-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("ambiguous user: err = %v", err)
+	}
+	if srv.boundAs("uid=dup,ou=people,"+testLDAPBase) || srv.boundAs("uid=dup,ou=contractors,"+testLDAPBase) {
+		t.Error("bound as one of several matching entries")
+	}
+}
+
+func TestLDAPGroupLookup(t *testing.T) {
+	srv := newTestLDAPServer(t)
+
+	// 未配置组基础 DN: 读取 memberOf
+	entry, err := srv.settings().authenticate("alice", "alice-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Groups) != 1 || entry.Groups[0] != "panel-admins" {
+		t.Errorf("memberOf groups = %v", entry.Groups)
+	}
+	entry, err = srv.settings().authenticate("bob", "bob-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Groups) != 0 {
+		t.Errorf("bob has no memberOf, got groups %v", entry.Groups)
+	}
+
+	// 配置组基础 DN: 按成员 DN 搜索组
+	settings := srv.settings()
+	settings.GroupBaseDN = testLDAPGroups
+	entry, err = settings.authenticate("bob", "bob-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Groups) != 1 || entry.Groups[0] != "operators" {
+		t.Errorf("group search groups = %v", entry.Groups)
+	}
+}
+
+func TestValidateLDAPUserCreatesShadowUser(t *testing.T) {
+	svc := newTestService(t)
+	srv := newTestLDAPServer(t)
+	configureLDAP(t, svc, srv, "ldap")
+
+	user, err := svc.validateLDAPUser("alice", "alice-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.AuthProvider != AuthProviderLDAP || user.Role != RoleAdmin || !user.EmailVerified {
+		t.Errorf("shadow user = provider %s role %s verified %v", user.AuthProvider, user.Role, user.EmailVerified)
+	}
+	if user.Email == nil || *user.Email != "alice@example.com" {
+		t.Errorf("shadow user email = %v", user.Email)
+	}
+
+	again, err := svc.validateLDAPUser("alice", "alice-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("second login created another user: %d vs %d", again.ID, user.ID)
+	}
+
+	// 影子用户的随机本地密码不能用于登录
+	if _, err := svc.validateLocalUser("alice", "alice-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("local login of LDAP user: err = %v", err)
+	}
+}
+
+func TestValidateLDAPUserDoesNotTakeOverLocalUser(t *testing.T) {
+	svc := newTestService(t)
+	srv := newTestLDAPServer(t)
+	configureLDAP(t, svc, srv, "ldap")
+
+	// 默认管理员 admin 是本地用户，目录中的 uid=admin 不能接管
+	if _, err := svc.validateLDAPUser("admin", "admin-ldap"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("LDAP took over local user: err = %v", err)
+	}
+	if srv.boundAs("uid=admin,ou=people," + testLDAPBase) {
+		t.Error("directory contacted for an existing local user")
+	}
+	user, err := svc.GetUserByUsername("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.AuthProvider == AuthProviderLDAP {
+		t.Error("local admin converted to LDAP user")
+	}
+}
+
+func TestValidateUserBackendOrder(t *testing.T) {
+	svc := newTestService(t)
+	srv := newTestLDAPServer(t)
+
+	// 仅本地认证时不访问目录
+	configureLDAP(t, svc, srv, "local")
+	if _, err := svc.ValidateUser("alice", "alice-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("LDAP user accepted with local backend only: err = %v", err)
+	}
+	if srv.boundAs("uid=alice,ou=people," + testLDAPBase) {
+		t.Error("directory contacted with local backend only")
+	}
+
+	// LDAP 优先，失败后回退到本地密码
+	configureLDAP(t, svc, srv, "ldap,local")
+	if user, err := svc.ValidateUser("alice", "alice-pass"); err != nil || user.AuthProvider != AuthProviderLDAP {
+		t.Errorf("LDAP login: user %v err %v", user, err)
+	}
+	if user, err := svc.ValidateUser("admin", "admin123"); err != nil || user.Username != "admin" {
+		t.Errorf("local fallback: user %v err %v", user, err)
+	}
+
+	// 目录不可用时本地账户仍可登录
+	srv.ln.Close()
+	if _, err := svc.ValidateUser("admin", "admin123"); err != nil {
+		t.Errorf("local login with directory down: %v", err)
+	}
+	if _, err := svc.ValidateUser("alice", "alice-pass"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("LDAP user with directory down: err = %v", err)
+	}
+}
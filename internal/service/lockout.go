@@ -0,0 +1,184 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"gorm.io/gorm"
+)
+
+// ==================== 2FA 策略与账户锁定 ====================
+
+const (
+	// SetupWebAuthn / SetupTwoFactor 登录后必须先完成的第二因素注册
+	SetupWebAuthn  = "webauthn"
+	SetupTwoFactor = "2fa"
+
+	bruteForceWindow = 15 * time.Minute
+)
+
+var (
+	ErrAccountLocked     = errors.New("account is locked due to too many failed login attempts")
+	ErrTwoFactorRequired = errors.New("your role requires two-factor authentication")
+)
+
+// permanentLock 锁定时长为 0 时使用的截止时间 (需管理员解锁)
+var permanentLock = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// roleInConfig 角色是否在逗号分隔的配置列表中
+func (s *Service) roleInConfig(key, role string) bool {
+	if role == "" {
+		return false
+	}
+	for _, r := range strings.Split(s.GetSiteConfig(key), ",") {
+		if strings.TrimSpace(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// siteConfigInt 读取非负整数配置 (允许 0)
+func (s *Service) siteConfigInt(key string, defaultValue int) int {
+	if v, err := strconv.Atoi(s.GetSiteConfig(key)); err == nil && v >= 0 {
+		return v
+	}
+	return defaultValue
+}
+
+// TwoFactorRequired 角色是否被要求启用 2FA (TOTP 或安全密钥均可)
+func (s *Service) TwoFactorRequired(role string) bool {
+	return s.roleInConfig(model.ConfigTwoFactorRequiredRoles, role)
+}
+
+// SecondFactorSetupRequired 返回用户登录后必须先完成的注册 (SetupWebAuthn / SetupTwoFactor)，无需注册时为空
+// 在完成注册前仅允许访问个人账户接口
+func (s *Service) SecondFactorSetupRequired(userID uint, role string) string {
+	webauthnRequired := s.WebAuthnRequired(role)
+	twoFactorRequired := s.TwoFactorRequired(role)
+	if !webauthnRequired && !twoFactorRequired {
+		return ""
+	}
+	if s.CountWebAuthnCredentials(userID) > 0 {
+		return ""
+	}
+	if webauthnRequired {
+		return SetupWebAuthn
+	}
+	var user model.User
+	if err := s.db.Select("two_factor_enabled").First(&user, userID).Error; err != nil || !user.TwoFactorEnabled {
+		return SetupTwoFactor
+	}
+	return ""
+}
+
+// CanDisableTOTP 关闭 TOTP 前检查: 角色要求 2FA 时必须仍有安全密钥
+func (s *Service) CanDisableTOTP(user *model.User) error {
+	if s.TwoFactorRequired(user.Role) && s.CountWebAuthnCredentials(user.ID) == 0 {
+		return ErrTwoFactorRequired
+	}
+	return nil
+}
+
+// AccountLocked 账户是否处于锁定期
+func AccountLocked(user *model.User) bool {
+	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+}
+
+// CheckAccountLock 登录前检查账户锁定状态，返回锁定截止时间 (用户不存在时不视为锁定)
+func (s *Service) CheckAccountLock(username string) (*time.Time, error) {
+	var user model.User
+	if err := s.db.Select("id", "locked_until").Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, nil
+	}
+	if AccountLocked(&user) {
+		return user.LockedUntil, ErrAccountLocked
+	}
+	return nil, nil
+}
+
+// RecordLoginFailure 记录一次登录失败 (密码、2FA 代码或安全密钥)
+// 连续失败达到阈值时锁定账户并告警；同一账户短时间内来自多个 IP 的失败视为暴力破解并告警
+func (s *Service) RecordLoginFailure(username, ip string) {
+	if username == "" {
+		return
+	}
+	var user model.User
+	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return
+	}
+
+	s.db.Model(&model.User{}).Where("id = ?", user.ID).
+		UpdateColumn("failed_login_attempts", gorm.Expr("failed_login_attempts + 1"))
+	s.db.Select("failed_login_attempts").First(&user, user.ID)
+
+	threshold := s.siteConfigInt(model.ConfigLockoutThreshold, 10)
+	if threshold > 0 && user.FailedLoginAttempts >= threshold && !AccountLocked(&user) {
+		lockedUntil := permanentLock
+		if minutes := s.siteConfigInt(model.ConfigLockoutDuration, 30); minutes > 0 {
+			lockedUntil = time.Now().Add(time.Duration(minutes) * time.Minute)
+		}
+		s.db.Model(&model.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"failed_login_attempts": 0,
+			"locked_until":          lockedUntil,
+		})
+
+		detail := fmt.Sprintf("account locked after %d failed login attempts", user.FailedLoginAttempts)
+		s.LogOperation(user.ID, user.Username, "lock", "user", user.ID, detail, ip, "", "success")
+		go s.alertService.TriggerAlert("account_locked", "user", user.ID, user.Username,
+			fmt.Sprintf("用户 %s 连续 %d 次登录失败，账户已锁定\n最后来源 IP: %s", user.Username, user.FailedLoginAttempts, ip))
+	}
+
+	s.checkBruteForce(&user, ip)
+}
+
+// checkBruteForce 统计时间窗口内该账户失败登录的不同来源 IP 数，达到阈值时告警 (冷却由告警规则控制)
+func (s *Service) checkBruteForce(user *model.User, ip string) {
+	ipThreshold := s.siteConfigInt(model.ConfigBruteForceIPThreshold, 5)
+	if ipThreshold <= 0 {
+		return
+	}
+	var ipCount int64
+	s.db.Model(&model.OperationLog{}).
+		Where("username = ? AND action = ? AND status = ? AND created_at > ?", user.Username, "login", "failed", time.Now().Add(-bruteForceWindow)).
+		Distinct("ip").Count(&ipCount)
+	if ipCount < int64(ipThreshold) {
+		return
+	}
+	go s.alertService.TriggerAlert("login_bruteforce", "user", user.ID, user.Username,
+		fmt.Sprintf("用户 %s 在 %d 分钟内收到来自 %d 个不同 IP 的失败登录，疑似暴力破解\n最近来源 IP: %s",
+			user.Username, int(bruteForceWindow.Minutes()), ipCount, ip))
+}
+
+// UnlockUser 解除账户锁定并清零失败计数
+func (s *Service) UnlockUser(id uint) error {
+	if _, err := s.GetUser(id); err != nil {
+		return errors.New("user not found")
+	}
+	return s.db.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+}
+
+// ResetUserTwoFactor 重置用户的全部第二因素 (TOTP、备份码与安全密钥)，用于用户丢失设备时由管理员处理
+// 若角色要求 2FA，用户下次登录后需重新注册
+func (s *Service) ResetUserTwoFactor(id uint) error {
+	if _, err := s.GetUser(id); err != nil {
+		return errors.New("user not found")
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"two_factor_enabled": false,
+			"two_factor_secret":  "",
+			"backup_codes":       "",
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&model.WebAuthnCredential{}).Error
+	})
+}
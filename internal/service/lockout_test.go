@@ -0,0 +1,182 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// failLogin 模拟一次密码错误的登录 (与登录接口一致: 先写操作日志再记录失败)
+func failLogin(svc *Service, username, ip string) {
+	svc.LogOperation(0, username, "login", "user", 0, "login failed", ip, "", "failed")
+	svc.RecordLoginFailure(username, ip)
+}
+
+// alertFired 告警规则是否已触发 (告警异步发送，等待至超时)
+func alertFired(t *testing.T, svc *Service, ruleID uint, timeout time.Duration) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		var rule model.AlertRule
+		if err := svc.DB().First(&rule, ruleID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if !rule.LastAlertAt.IsZero() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func createAlertRule(t *testing.T, svc *Service, alertType string) *model.AlertRule {
+	t.Helper()
+	rule := &model.AlertRule{Name: alertType, Type: alertType, Enabled: true}
+	if err := svc.DB().Create(rule).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func TestLoginLockoutThreshold(t *testing.T) {
+	svc := newTestService(t)
+	svc.SetSiteConfigs(map[string]string{
+		model.ConfigLockoutThreshold: "3",
+		model.ConfigLockoutDuration:  "10",
+	})
+	user, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := createAlertRule(t, svc, "account_locked")
+
+	// 成功登录清零失败计数
+	failLogin(svc, "alice", "10.0.0.1")
+	failLogin(svc, "alice", "10.0.0.1")
+	svc.UpdateUserLoginInfo(user.ID, "10.0.0.1")
+	failLogin(svc, "alice", "10.0.0.1")
+	failLogin(svc, "alice", "10.0.0.1")
+	if _, err := svc.CheckAccountLock("alice"); err != nil {
+		t.Fatal("account locked before reaching the threshold")
+	}
+
+	failLogin(svc, "alice", "10.0.0.1")
+	lockedUntil, err := svc.CheckAccountLock("alice")
+	if err != ErrAccountLocked {
+		t.Fatalf("CheckAccountLock err = %v, want ErrAccountLocked", err)
+	}
+	if d := time.Until(*lockedUntil); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("locked for %s, want about 10m", d)
+	}
+	if !alertFired(t, svc, rule.ID, 2*time.Second) {
+		t.Error("account_locked alert not triggered")
+	}
+
+	// 锁定期满后自动解锁，计数已清零
+	svc.DB().Model(&model.User{}).Where("id = ?", user.ID).Update("locked_until", time.Now().Add(-time.Second))
+	if _, err := svc.CheckAccountLock("alice"); err != nil {
+		t.Error("account still locked after expiry")
+	}
+	failLogin(svc, "alice", "10.0.0.1")
+	if _, err := svc.CheckAccountLock("alice"); err != nil {
+		t.Error("first failure after expiry locked the account again")
+	}
+}
+
+func TestLoginLockoutPermanentUntilUnlock(t *testing.T) {
+	svc := newTestService(t)
+	svc.SetSiteConfigs(map[string]string{
+		model.ConfigLockoutThreshold: "2",
+		model.ConfigLockoutDuration:  "0",
+	})
+	user, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failLogin(svc, "alice", "10.0.0.1")
+	failLogin(svc, "alice", "10.0.0.1")
+	lockedUntil, err := svc.CheckAccountLock("alice")
+	if err != ErrAccountLocked {
+		t.Fatalf("CheckAccountLock err = %v, want ErrAccountLocked", err)
+	}
+	if lockedUntil.Year() != permanentLock.Year() {
+		t.Errorf("locked until %s, want permanent lock", lockedUntil)
+	}
+
+	if err := svc.UnlockUser(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CheckAccountLock("alice"); err != nil {
+		t.Error("account still locked after UnlockUser")
+	}
+}
+
+func TestBruteForceAlert(t *testing.T) {
+	svc := newTestService(t)
+	svc.SetSiteConfigs(map[string]string{
+		model.ConfigLockoutThreshold:      "0",
+		model.ConfigBruteForceIPThreshold: "3",
+	})
+	if _, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true); err != nil {
+		t.Fatal(err)
+	}
+	rule := createAlertRule(t, svc, "login_bruteforce")
+
+	// 时间窗口之前的失败不计入
+	svc.DB().Create(&model.OperationLog{
+		Username: "alice", Action: "login", Resource: "user", IP: "10.0.0.9", Status: "failed",
+		CreatedAt: time.Now().Add(-bruteForceWindow - time.Minute),
+	})
+	failLogin(svc, "alice", "10.0.0.1")
+	failLogin(svc, "alice", "10.0.0.1")
+	failLogin(svc, "alice", "10.0.0.2")
+	if alertFired(t, svc, rule.ID, 200*time.Millisecond) {
+		t.Fatal("brute-force alert triggered below the IP threshold")
+	}
+
+	failLogin(svc, "alice", "10.0.0.3")
+	if !alertFired(t, svc, rule.ID, 2*time.Second) {
+		t.Error("brute-force alert not triggered at the IP threshold")
+	}
+	if _, err := svc.CheckAccountLock("alice"); err != nil {
+		t.Error("account locked although lockout is disabled")
+	}
+}
+
+func TestSecondFactorSetupRequired(t *testing.T) {
+	svc := newTestService(t)
+	user, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := svc.SecondFactorSetupRequired(user.ID, RoleUser); got != "" {
+		t.Errorf("no policy: got %q, want none", got)
+	}
+
+	svc.SetSiteConfig(model.ConfigTwoFactorRequiredRoles, "admin, user")
+	if got := svc.SecondFactorSetupRequired(user.ID, RoleUser); got != SetupTwoFactor {
+		t.Errorf("2FA required: got %q, want %q", got, SetupTwoFactor)
+	}
+	if got := svc.SecondFactorSetupRequired(user.ID, RoleViewer); got != "" {
+		t.Errorf("role not in policy: got %q, want none", got)
+	}
+	if err := svc.CanDisableTOTP(user); err != ErrTwoFactorRequired {
+		t.Errorf("CanDisableTOTP err = %v, want ErrTwoFactorRequired", err)
+	}
+
+	svc.DB().Model(&model.User{}).Where("id = ?", user.ID).Update("two_factor_enabled", true)
+	if got := svc.SecondFactorSetupRequired(user.ID, RoleUser); got != "" {
+		t.Errorf("TOTP enabled: got %q, want none", got)
+	}
+
+	// 要求安全密钥时 TOTP 不满足要求
+	svc.SetSiteConfig(model.ConfigWebAuthnRequiredRoles, "user")
+	if got := svc.SecondFactorSetupRequired(user.ID, RoleUser); got != SetupWebAuthn {
+		t.Errorf("WebAuthn required: got %q, want %q", got, SetupWebAuthn)
+	}
+}
@@ -0,0 +1,311 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"gorm.io/gorm"
+)
+
+// ==================== 节点远程命令 ====================
+
+// 远程命令类型 (Agent 端同样按白名单执行，不支持任意 Shell 命令)
+const (
+	NodeCommandRestartGost  = "restart_gost"
+	NodeCommandDiagnostics  = "diagnostics"
+	NodeCommandConnectivity = "connectivity_test"
+	NodeCommandRotateAPI    = "rotate_api_credentials"
+	NodeCommandGostLogs     = "gost_logs"
+)
+
+// 远程命令状态
+const (
+	NodeCommandPending  = "pending"
+	NodeCommandSent     = "sent"
+	NodeCommandSuccess  = "success"
+	NodeCommandFailed   = "failed"
+	NodeCommandTimeout  = "timeout"
+	NodeCommandCanceled = "canceled"
+)
+
+const (
+	// nodeCommandQueueTTL 命令排队等待下发的最长时间 (节点离线时)
+	nodeCommandQueueTTL = 10 * time.Minute
+	// nodeCommandMaxTimeout 命令执行超时上限 (秒)
+	nodeCommandMaxTimeout = 600
+	// nodeCommandMaxOutput 命令输出保存上限
+	nodeCommandMaxOutput = 64 * 1024
+	// nodeCommandRetentionDays 已结束命令的保留天数
+	nodeCommandRetentionDays = 30
+)
+
+var (
+	ErrNodeCommandUnknown  = errors.New("unknown command type")
+	ErrNodeCommandNotFound = errors.New("command not found")
+	ErrNodeCommandFinished = errors.New("command already finished")
+)
+
+// nodeCommandSpec 命令定义: 默认超时与参数校验 (返回规范化后的参数)
+type nodeCommandSpec struct {
+	timeout  int
+	validate func(params map[string]interface{}) (map[string]interface{}, error)
+}
+
+var nodeCommandSpecs = map[string]nodeCommandSpec{
+	NodeCommandRestartGost: {timeout: 60},
+	NodeCommandDiagnostics: {timeout: 60},
+	NodeCommandConnectivity: {timeout: 60, validate: func(params map[string]interface{}) (map[string]interface{}, error) {
+		host, _ := params["host"].(string)
+		host = strings.TrimSpace(host)
+		if !validCommandHost(host) {
+			return nil, errors.New("invalid host")
+		}
+		port := paramInt(params, "port", 0)
+		if port < 1 || port > 65535 {
+			return nil, errors.New("invalid port")
+		}
+		count := paramInt(params, "count", 3)
+		if count < 1 || count > 10 {
+			return nil, errors.New("count must be between 1 and 10")
+		}
+		timeout := paramInt(params, "timeout", 5)
+		if timeout < 1 || timeout > 30 {
+			return nil, errors.New("timeout must be between 1 and 30 seconds")
+		}
+		return map[string]interface{}{"host": host, "port": port, "count": count, "timeout": timeout}, nil
+	}},
+	NodeCommandRotateAPI: {timeout: 60},
+	NodeCommandGostLogs: {timeout: 30, validate: func(params map[string]interface{}) (map[string]interface{}, error) {
+		lines := paramInt(params, "lines", 200)
+		if lines < 1 || lines > 1000 {
+			return nil, errors.New("lines must be between 1 and 1000")
+		}
+		return map[string]interface{}{"lines": lines}, nil
+	}},
+}
+
+// validCommandHost 连通性测试目标仅允许 IP 或域名
+func validCommandHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	return strings.Trim(host, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-") == ""
+}
+
+// NodeCommandTypes 支持的远程命令类型
+func NodeCommandTypes() []string {
+	return []string{NodeCommandRestartGost, NodeCommandDiagnostics, NodeCommandConnectivity, NodeCommandRotateAPI, NodeCommandGostLogs}
+}
+
+// paramInt 读取整数参数 (JSON 数字或数字字符串)
+func paramInt(params map[string]interface{}, key string, defaultValue int) int {
+	switch v := params[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		var n int
+		if _, err := fmt.Sscanf(v, "%d", &n); err == nil {
+			return n
+		}
+		return -1
+	case nil:
+		return defaultValue
+	}
+	return -1
+}
+
+// CreateNodeCommand 创建远程命令并加入节点的命令队列
+// timeout 为 0 时使用命令的默认超时
+func (s *Service) CreateNodeCommand(nodeID uint, cmdType string, params map[string]interface{}, timeout int, userID uint, username string) (*model.NodeCommand, error) {
+	spec, ok := nodeCommandSpecs[cmdType]
+	if !ok {
+		return nil, ErrNodeCommandUnknown
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	if spec.validate != nil {
+		var err error
+		if params, err = spec.validate(params); err != nil {
+			return nil, err
+		}
+	} else {
+		params = map[string]interface{}{}
+	}
+	if timeout <= 0 {
+		timeout = spec.timeout
+	}
+	if timeout > nodeCommandMaxTimeout {
+		timeout = nodeCommandMaxTimeout
+	}
+	paramsJSON, _ := json.Marshal(params)
+
+	cmd := &model.NodeCommand{
+		NodeID:   nodeID,
+		Type:     cmdType,
+		Params:   string(paramsJSON),
+		Status:   NodeCommandPending,
+		Timeout:  timeout,
+		UserID:   userID,
+		Username: username,
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var node model.Node
+		if err := tx.First(&node, nodeID).Error; err != nil {
+			return err
+		}
+		// 轮换 GOST API 凭据: 新凭据写入节点配置，Agent 收到命令后重新下载配置并重启 GOST
+		// 凭据不写入命令参数，避免出现在命令记录中
+		if cmdType == NodeCommandRotateAPI {
+			apiUser := node.APIUser
+			if apiUser == "" {
+				apiUser = "gost-panel"
+			}
+			if err := tx.Model(&model.Node{}).Where("id = ?", nodeID).Updates(map[string]interface{}{
+				"api_user":   apiUser,
+				"api_pass":   generateToken()[:32],
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(cmd).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ListNodeCommands 获取节点最近的远程命令
+func (s *Service) ListNodeCommands(nodeID uint, limit int) ([]model.NodeCommand, error) {
+	var cmds []model.NodeCommand
+	err := s.db.Where("node_id = ?", nodeID).Order("id DESC").Limit(limit).Find(&cmds).Error
+	return cmds, err
+}
+
+// GetNodeCommand 获取节点的远程命令
+func (s *Service) GetNodeCommand(nodeID, id uint) (*model.NodeCommand, error) {
+	var cmd model.NodeCommand
+	if err := s.db.Where("id = ? AND node_id = ?", id, nodeID).First(&cmd).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNodeCommandNotFound
+		}
+		return nil, err
+	}
+	return &cmd, nil
+}
+
+// CancelNodeCommand 取消尚未结束的命令 (已下发的命令 Agent 可能仍会执行，结果将被忽略)
+func (s *Service) CancelNodeCommand(nodeID, id uint) error {
+	if _, err := s.GetNodeCommand(nodeID, id); err != nil {
+		return err
+	}
+	now := time.Now()
+	result := s.db.Model(&model.NodeCommand{}).
+		Where("id = ? AND status IN ?", id, []string{NodeCommandPending, NodeCommandSent}).
+		Updates(map[string]interface{}{"status": NodeCommandCanceled, "finished_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNodeCommandFinished
+	}
+	return nil
+}
+
+// TakePendingNodeCommands 取出待下发的命令并标记为已下发
+func (s *Service) TakePendingNodeCommands(nodeID uint) ([]model.NodeCommand, error) {
+	var pending []model.NodeCommand
+	if err := s.db.Where("node_id = ? AND status = ?", nodeID, NodeCommandPending).
+		Order("id").Find(&pending).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	taken := make([]model.NodeCommand, 0, len(pending))
+	for _, cmd := range pending {
+		// 条件更新，避免控制通道与心跳同时下发同一命令
+		result := s.db.Model(&model.NodeCommand{}).
+			Where("id = ? AND status = ?", cmd.ID, NodeCommandPending).
+			Updates(map[string]interface{}{"status": NodeCommandSent, "sent_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		cmd.Status = NodeCommandSent
+		cmd.SentAt = &now
+		taken = append(taken, cmd)
+	}
+	return taken, nil
+}
+
+// CompleteNodeCommand 记录 Agent 上报的执行结果
+func (s *Service) CompleteNodeCommand(nodeID, id uint, success bool, output, errMsg string) error {
+	status := NodeCommandFailed
+	if success {
+		status = NodeCommandSuccess
+	}
+	// 按字符截断，避免截断多字节字符后 PostgreSQL 拒绝写入
+	if truncated := truncateString(output, nodeCommandMaxOutput); truncated != output {
+		output = truncated + "\n... (truncated)"
+	}
+	errMsg = truncateString(errMsg, 500)
+
+	result := s.db.Model(&model.NodeCommand{}).
+		Where("id = ? AND node_id = ? AND status IN ?", id, nodeID, []string{NodeCommandPending, NodeCommandSent}).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"output":      output,
+			"error":       errMsg,
+			"finished_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNodeCommandFinished
+	}
+	return nil
+}
+
+// ExpireNodeCommands 将超时的命令标记为 timeout，并清理过期的命令记录
+func (s *Service) ExpireNodeCommands() error {
+	now := time.Now()
+
+	// 长时间未能下发 (节点离线)
+	if err := s.db.Model(&model.NodeCommand{}).
+		Where("status = ? AND created_at < ?", NodeCommandPending, now.Add(-nodeCommandQueueTTL)).
+		Updates(map[string]interface{}{"status": NodeCommandTimeout, "error": "agent did not pick up the command", "finished_at": now}).Error; err != nil {
+		return err
+	}
+
+	// 已下发但超时未返回结果 (留出结果上报的余量)
+	var sent []model.NodeCommand
+	if err := s.db.Select("id", "timeout", "sent_at").
+		Where("status = ?", NodeCommandSent).Find(&sent).Error; err != nil {
+		return err
+	}
+	for _, cmd := range sent {
+		if cmd.SentAt == nil || now.Before(cmd.SentAt.Add(time.Duration(cmd.Timeout)*time.Second+30*time.Second)) {
+			continue
+		}
+		s.db.Model(&model.NodeCommand{}).
+			Where("id = ? AND status = ?", cmd.ID, NodeCommandSent).
+			Updates(map[string]interface{}{"status": NodeCommandTimeout, "error": "no result within timeout", "finished_at": now})
+	}
+
+	return s.db.Where("status NOT IN ? AND created_at < ?",
+		[]string{NodeCommandPending, NodeCommandSent}, now.AddDate(0, 0, -nodeCommandRetentionDays)).
+		Delete(&model.NodeCommand{}).Error
+}
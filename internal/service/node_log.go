@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// ==================== 节点 GOST 日志 ====================
+
+// 日志级别
+const (
+	NodeLogDebug = "debug"
+	NodeLogInfo  = "info"
+	NodeLogWarn  = "warn"
+	NodeLogError = "error"
+	NodeLogFatal = "fatal"
+)
+
+const (
+	// nodeLogMaxBatch 单次上报保存的最大行数
+	nodeLogMaxBatch = 2000
+	// nodeLogMaxLine 单行保存上限
+	nodeLogMaxLine = 4096
+)
+
+// NodeLogLine Agent 上报的一行 GOST 输出
+type NodeLogLine struct {
+	Time time.Time `json:"time"`
+	Line string    `json:"line"`
+}
+
+// NodeLogQuery 日志查询条件
+type NodeLogQuery struct {
+	Levels []string
+	Since  *time.Time
+	Until  *time.Time
+	Search string
+	Limit  int
+	Offset int
+}
+
+// parseNodeLogLevel 解析日志级别: GOST 默认输出 JSON ({"level":"info","msg":...})，否则按关键字判断
+func parseNodeLogLevel(line string) string {
+	if strings.HasPrefix(line, "{") {
+		var entry struct {
+			Level string `json:"level"`
+		}
+		if json.Unmarshal([]byte(line), &entry) == nil && entry.Level != "" {
+			return normalizeNodeLogLevel(entry.Level)
+		}
+	}
+
+	lower := strings.ToLower(line)
+	for _, kw := range []struct{ key, level string }{
+		{"level=fatal", NodeLogFatal}, {"panic:", NodeLogFatal}, {"fatal", NodeLogFatal},
+		{"level=error", NodeLogError}, {"[error]", NodeLogError}, {"error", NodeLogError},
+		{"level=warn", NodeLogWarn}, {"[warn]", NodeLogWarn}, {"warning", NodeLogWarn},
+		{"level=debug", NodeLogDebug}, {"[debug]", NodeLogDebug},
+	} {
+		if strings.Contains(lower, kw.key) {
+			return kw.level
+		}
+	}
+	return NodeLogInfo
+}
+
+func normalizeNodeLogLevel(level string) string {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return NodeLogDebug
+	case "warn", "warning":
+		return NodeLogWarn
+	case "error", "err":
+		return NodeLogError
+	case "fatal", "panic":
+		return NodeLogFatal
+	default:
+		return NodeLogInfo
+	}
+}
+
+// AddNodeLogs 保存 Agent 上报的日志，dropped 为 Agent 缓冲溢出未能上报的行数
+func (s *Service) AddNodeLogs(nodeID uint, lines []NodeLogLine, dropped int) ([]model.NodeLog, error) {
+	if len(lines) > nodeLogMaxBatch {
+		dropped += len(lines) - nodeLogMaxBatch
+		lines = lines[len(lines)-nodeLogMaxBatch:]
+	}
+
+	now := time.Now()
+	logs := make([]model.NodeLog, 0, len(lines)+1)
+	if dropped > 0 {
+		loggedAt := now
+		if len(lines) > 0 && !lines[0].Time.IsZero() {
+			loggedAt = lines[0].Time
+		}
+		logs = append(logs, model.NodeLog{
+			NodeID:   nodeID,
+			Level:    NodeLogWarn,
+			Message:  fmt.Sprintf("[agent] %d log lines dropped (buffer overflow)", dropped),
+			LoggedAt: loggedAt,
+		})
+	}
+	for _, l := range lines {
+		if strings.TrimSpace(l.Line) == "" {
+			continue
+		}
+		// 按字符截断，避免截断多字节字符后 PostgreSQL 拒绝整批写入
+		msg := truncateString(l.Line, nodeLogMaxLine)
+		// Agent 时钟明显异常时使用接收时间
+		loggedAt := l.Time
+		if loggedAt.IsZero() || loggedAt.After(now.Add(5*time.Minute)) {
+			loggedAt = now
+		}
+		logs = append(logs, model.NodeLog{
+			NodeID:   nodeID,
+			Level:    parseNodeLogLevel(msg),
+			Message:  msg,
+			LoggedAt: loggedAt,
+		})
+	}
+	if len(logs) == 0 {
+		return nil, nil
+	}
+	if err := s.db.CreateInBatches(logs, 200).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// GetNodeLogs 查询节点日志 (按时间倒序)
+func (s *Service) GetNodeLogs(nodeID uint, q NodeLogQuery) ([]model.NodeLog, int64, error) {
+	var logs []model.NodeLog
+	var total int64
+
+	query := s.db.Model(&model.NodeLog{}).Where("node_id = ?", nodeID)
+
+	if len(q.Levels) > 0 {
+		query = query.Where("level IN ?", q.Levels)
+	}
+	if q.Since != nil {
+		query = query.Where("logged_at >= ?", *q.Since)
+	}
+	if q.Until != nil {
+		query = query.Where("logged_at <= ?", *q.Until)
+	}
+	if q.Search != "" {
+		query = query.Where("LOWER(message) LIKE ?", "%"+strings.ToLower(q.Search)+"%")
+	}
+
+	query.Count(&total)
+	err := query.Order("logged_at DESC, id DESC").Limit(q.Limit).Offset(q.Offset).Find(&logs).Error
+	return logs, total, err
+}
+
+// CleanupNodeLogs 清理超过保留天数的节点日志
+func (s *Service) CleanupNodeLogs(retentionDays int) error {
+	return s.db.Where("logged_at < ?", time.Now().AddDate(0, 0, -retentionDays)).
+		Delete(&model.NodeLog{}).Error
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ==================== OpenID Connect 单点登录 ====================
+
+// 认证来源
+const (
+	AuthProviderLocal = "local"
+	AuthProviderOIDC  = "oidc"
+)
+
+var (
+	ErrOIDCDisabled      = errors.New("oidc login is disabled")
+	ErrOIDCNotConfigured = errors.New("oidc issuer or client id is not configured")
+	ErrOIDCNoAccount     = errors.New("no account is linked to this identity and auto provisioning is disabled")
+)
+
+// OIDCSettings OIDC 配置 (来自 SiteConfig)
+type OIDCSettings struct {
+	Enabled       bool
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	GroupsClaim   string
+	RoleMapping   []GroupRoleMapping
+	AutoProvision bool
+}
+
+// OIDCIdentity 从 ID Token 中提取的用户身份
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Groups        []string
+}
+
+// oidcHTTPClient 与 IdP 通信使用的 HTTP 客户端
+var oidcHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// oidcProviderCache 缓存 Provider (discovery 文档与 JWKS)，issuer 变化时重新发现
+type oidcProviderCache struct {
+	mu       sync.Mutex
+	issuer   string
+	provider *oidc.Provider
+}
+
+// GetOIDCSettings 读取 OIDC 配置
+func (s *Service) GetOIDCSettings() *OIDCSettings {
+	settings := &OIDCSettings{
+		Enabled:       s.GetSiteConfig(model.ConfigOIDCEnabled) == "true",
+		Issuer:        strings.TrimSpace(s.GetSiteConfig(model.ConfigOIDCIssuer)),
+		ClientID:      strings.TrimSpace(s.GetSiteConfig(model.ConfigOIDCClientID)),
+		ClientSecret:  s.GetSiteConfig(model.ConfigOIDCClientSecret),
+		RedirectURL:   strings.TrimSpace(s.GetSiteConfig(model.ConfigOIDCRedirectURL)),
+		Scopes:        strings.Fields(s.GetSiteConfig(model.ConfigOIDCScopes)),
+		GroupsClaim:   strings.TrimSpace(s.GetSiteConfig(model.ConfigOIDCGroupsClaim)),
+		AutoProvision: s.GetSiteConfig(model.ConfigOIDCAutoProvision) != "false",
+	}
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if settings.GroupsClaim == "" {
+		settings.GroupsClaim = "groups"
+	}
+	settings.RoleMapping = parseRoleMapping(model.ConfigOIDCRoleMapping, s.GetSiteConfig(model.ConfigOIDCRoleMapping))
+	return settings
+}
+
+// oidcClient 构建 OAuth2 客户端配置与 ID Token 校验器
+func (s *Service) oidcClient(settings *OIDCSettings, redirectURL string) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	if !settings.Enabled {
+		return nil, nil, ErrOIDCDisabled
+	}
+	if settings.Issuer == "" || settings.ClientID == "" {
+		return nil, nil, ErrOIDCNotConfigured
+	}
+
+	provider, err := s.oidcProvider(settings.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &oauth2.Config{
+		ClientID:     settings.ClientID,
+		ClientSecret: settings.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       settings.Scopes,
+	}
+	verifier := provider.Verifier(&oidc.Config{ClientID: settings.ClientID})
+	return config, verifier, nil
+}
+
+// OIDCAuthURL 生成授权地址 (授权码 + PKCE S256)
+func (s *Service) OIDCAuthURL(settings *OIDCSettings, redirectURL, state, nonce, codeVerifier string) (string, error) {
+	config, _, err := s.oidcClient(settings, redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// OIDCExchange 兑换授权码并校验 ID Token (签名、issuer、audience、过期时间与 nonce)
+func (s *Service) OIDCExchange(ctx context.Context, settings *OIDCSettings, redirectURL, code, codeVerifier, nonce string) (*OIDCIdentity, error) {
+	config, verifier, err := s.oidcClient(settings, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = oidc.ClientContext(ctx, oidcHTTPClient)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("id_token missing from token response")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("invalid nonce")
+	}
+	return parseOIDCIdentity(idToken, settings.GroupsClaim)
+}
+
+func (s *Service) oidcProvider(issuer string) (*oidc.Provider, error) {
+	cache := &s.oidcProviders
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.provider != nil && cache.issuer == issuer {
+		return cache.provider, nil
+	}
+	// Provider 会在后续校验中复用该 context 获取 JWKS，不能使用请求级 context
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), oidcHTTPClient), issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	cache.issuer = issuer
+	cache.provider = provider
+	return provider, nil
+}
+
+// parseOIDCIdentity 从 ID Token 声明中提取身份信息
+func parseOIDCIdentity(idToken *oidc.IDToken, groupsClaim string) (*OIDCIdentity, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := &OIDCIdentity{Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	identity.Username, _ = claims["preferred_username"].(string)
+	identity.Groups = claimStrings(lookupClaim(claims, groupsClaim))
+	return identity, nil
+}
+
+// lookupClaim 按点号路径查找声明，如 realm_access.roles
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var current interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// ResolveOIDCUser 根据 OIDC 身份查找或创建本地用户
+// 依次按 subject、已验证邮箱匹配已有账户 (邮箱匹配时自动关联)，否则在允许时自动创建
+func (s *Service) ResolveOIDCUser(settings *OIDCSettings, identity *OIDCIdentity) (*model.User, error) {
+	subject := settings.Issuer + "|" + identity.Subject
+	mappedRole := s.mapGroupRole(settings.RoleMapping, identity.Groups)
+
+	var user model.User
+	err := s.db.Where("oidc_subject = ?", subject).First(&user).Error
+	if err != nil && identity.Email != "" && identity.EmailVerified {
+		// 关联邮箱相同的本地账户
+		if err = s.db.Where("email = ?", identity.Email).First(&user).Error; err == nil {
+			if err := s.db.Model(&user).Update("oidc_subject", subject).Error; err != nil {
+				return nil, err
+			}
+			s.LogOperation(user.ID, user.Username, "link", "oidc", user.ID, "linked OIDC identity by email", "", "", "success")
+		}
+	}
+
+	if err == nil {
+		s.syncMappedRole(&user, mappedRole)
+		return &user, nil
+	}
+
+	if !settings.AutoProvision {
+		return nil, ErrOIDCNoAccount
+	}
+	return s.provisionOIDCUser(identity, subject, mappedRole)
+}
+
+// provisionOIDCUser 自动创建 OIDC 用户
+func (s *Service) provisionOIDCUser(identity *OIDCIdentity, subject, role string) (*model.User, error) {
+	user := &model.User{
+		Username:      s.uniqueUsername(identity),
+		EmailVerified: identity.EmailVerified,
+		AuthProvider:  AuthProviderOIDC,
+		OIDCSubject:   &subject,
+	}
+	if err := s.createExternalUser(user, identity.Email, role); err != nil {
+		return nil, err
+	}
+	s.LogOperation(user.ID, user.Username, "register", "oidc", user.ID, "user provisioned via OIDC", "", "", "success")
+	return user, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.\-@]`)
+
+// uniqueUsername 生成不冲突的用户名
+func (s *Service) uniqueUsername(identity *OIDCIdentity) string {
+	base := identity.Username
+	if base == "" && identity.Email != "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	if len(base) < 3 {
+		sub := usernameInvalidChars.ReplaceAllString(identity.Subject, "")
+		if len(sub) > 8 {
+			sub = sub[:8]
+		}
+		base = "oidc_" + sub
+	}
+
+	name := base
+	for i := 2; ; i++ {
+		var count int64
+		s.db.Model(&model.User{}).Where("username = ?", name).Count(&count)
+		if count == 0 {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
@@ -0,0 +1,572 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"gorm.io/gorm"
+)
+
+// ==================== 组织 (多租户) ====================
+
+// 组织内角色
+const (
+	OrgRoleOwner  = "owner"  // 所有者: 全部组织管理权限，可删除组织
+	OrgRoleAdmin  = "admin"  // 管理员: 管理成员与组织资源
+	OrgRoleMember = "member" // 成员: 查看与使用组织资源
+)
+
+var (
+	ErrOrgNotFound     = errors.New("organization not found")
+	ErrOrgForbidden    = errors.New("insufficient organization role")
+	ErrOrgLastOwner    = errors.New("an organization must keep at least one owner")
+	ErrOrgInvalidRole  = errors.New("invalid organization role, must be owner/admin/member")
+	ErrOrgMemberExists = errors.New("user is already a member of this organization")
+	ErrOrgNotMember    = errors.New("user is not a member of this organization")
+)
+
+// orgRoleRank 组织角色等级，用于权限比较
+var orgRoleRank = map[string]int{
+	OrgRoleMember: 1,
+	OrgRoleAdmin:  2,
+	OrgRoleOwner:  3,
+}
+
+// orgResourceModels 可归属组织的资源类型 (类型名与套餐资源类型一致)
+var orgResourceModels = map[string]func() interface{}{
+	"node":         func() interface{} { return &model.Node{} },
+	"client":       func() interface{} { return &model.Client{} },
+	"tunnel":       func() interface{} { return &model.Tunnel{} },
+	"port_forward": func() interface{} { return &model.PortForward{} },
+	"proxy_chain":  func() interface{} { return &model.ProxyChain{} },
+	"node_group":   func() interface{} { return &model.NodeGroup{} },
+	"bypass":       func() interface{} { return &model.Bypass{} },
+	"admission":    func() interface{} { return &model.Admission{} },
+	"host_mapping": func() interface{} { return &model.HostMapping{} },
+	"ingress":      func() interface{} { return &model.Ingress{} },
+	"recorder":     func() interface{} { return &model.Recorder{} },
+	"router":       func() interface{} { return &model.Router{} },
+	"sd":           func() interface{} { return &model.SD{} },
+}
+
+// OrgResource 组织名下的资源摘要
+type OrgResource struct {
+	Type    string `json:"type"`
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	OwnerID *uint  `json:"owner_id,omitempty"`
+}
+
+// ownedBy 非管理员的资源可见范围: 本人所有、所属组织的资源，以及未归属任何用户和组织的公共资源 (只读)
+func (s *Service) ownedBy(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		orgIDs := s.db.Model(&model.OrganizationMember{}).Select("org_id").Where("user_id = ?", userID)
+		return db.Where("owner_id = ? OR org_id IN (?) OR (owner_id IS NULL AND org_id IS NULL)", userID, orgIDs)
+	}
+}
+
+// writableBy 非管理员可修改/删除的资源范围: 本人所有，或在所属组织中为管理员/所有者的组织资源
+// 组织成员只能查看与使用组织资源，公共资源仅全局管理员可修改
+func (s *Service) writableBy(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		orgIDs := s.db.Model(&model.OrganizationMember{}).Select("org_id").
+			Where("user_id = ? AND role IN ?", userID, []string{OrgRoleAdmin, OrgRoleOwner})
+		return db.Where("owner_id = ? OR org_id IN (?)", userID, orgIDs)
+	}
+}
+
+// ListOrganizations 获取组织列表 (管理员为全部组织，其他用户为已加入的组织)
+func (s *Service) ListOrganizations(userID uint, isAdmin bool) ([]model.Organization, error) {
+	var orgs []model.Organization
+	query := s.db.Preload("Plan").Order("id asc")
+	if !isAdmin {
+		query = query.Where("id IN (?)", s.db.Model(&model.OrganizationMember{}).Select("org_id").Where("user_id = ?", userID))
+	}
+	if err := query.Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+
+	if len(orgs) == 0 {
+		return orgs, nil
+	}
+	orgIDs := make([]uint, len(orgs))
+	for i := range orgs {
+		orgIDs[i] = orgs[i].ID
+	}
+
+	// 只统计返回的组织，避免加载全部成员记录
+	var counts []struct {
+		OrgID uint
+		Count int64
+	}
+	s.db.Model(&model.OrganizationMember{}).Select("org_id, COUNT(*) AS count").
+		Where("org_id IN ?", orgIDs).Group("org_id").Scan(&counts)
+	var mine []model.OrganizationMember
+	s.db.Select("org_id", "role").Where("org_id IN ? AND user_id = ?", orgIDs, userID).Find(&mine)
+
+	countByOrg := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		countByOrg[c.OrgID] = c.Count
+	}
+	roleByOrg := make(map[uint]string, len(mine))
+	for _, m := range mine {
+		roleByOrg[m.OrgID] = m.Role
+	}
+	for i := range orgs {
+		orgs[i].MemberCount = countByOrg[orgs[i].ID]
+		orgs[i].MyRole = roleByOrg[orgs[i].ID]
+	}
+	return orgs, nil
+}
+
+// GetOrganization 获取组织
+func (s *Service) GetOrganization(id uint) (*model.Organization, error) {
+	var org model.Organization
+	if err := s.db.Preload("Plan").First(&org, id).Error; err != nil {
+		return nil, ErrOrgNotFound
+	}
+	return &org, nil
+}
+
+// OrgRole 用户在组织中的角色，非成员返回空字符串
+func (s *Service) OrgRole(orgID, userID uint) string {
+	var member model.OrganizationMember
+	if err := s.db.Where("org_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// RequireOrgRole 检查用户在组织中的角色不低于 minRole (isAdmin 为全局管理员，不受限制)
+func (s *Service) RequireOrgRole(orgID, userID uint, isAdmin bool, minRole string) error {
+	if _, err := s.GetOrganization(orgID); err != nil {
+		return err
+	}
+	if isAdmin {
+		return nil
+	}
+	role := s.OrgRole(orgID, userID)
+	if role == "" {
+		return ErrOrgNotFound
+	}
+	if orgRoleRank[role] < orgRoleRank[minRole] {
+		return ErrOrgForbidden
+	}
+	return nil
+}
+
+// CreateOrganization 创建组织，创建者成为所有者
+func (s *Service) CreateOrganization(org *model.Organization, ownerID uint) error {
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" {
+		return errors.New("organization name is required")
+	}
+	var count int64
+	s.db.Model(&model.Organization{}).Where("name = ?", org.Name).Count(&count)
+	if count > 0 {
+		return errors.New("organization name already exists")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		org.ID = 0
+		org.PlanID = nil
+		org.Suspended = false
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.OrganizationMember{OrgID: org.ID, UserID: ownerID, Role: OrgRoleOwner}).Error
+	})
+}
+
+// UpdateOrganization 更新组织名称与描述
+func (s *Service) UpdateOrganization(id uint, name, description string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("organization name is required")
+	}
+	var count int64
+	s.db.Model(&model.Organization{}).Where("name = ? AND id <> ?", name, id).Count(&count)
+	if count > 0 {
+		return errors.New("organization name already exists")
+	}
+	return s.db.Model(&model.Organization{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"updated_at":  time.Now(),
+	}).Error
+}
+
+// UpdateOrganizationQuota 设置组织流量配额 (管理员)
+func (s *Service) UpdateOrganizationQuota(id uint, trafficQuota int64) error {
+	if trafficQuota < 0 {
+		return errors.New("traffic_quota must not be negative")
+	}
+	err := s.db.Model(&model.Organization{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"traffic_quota": trafficQuota,
+		"updated_at":    time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceOrgQuota(id)
+}
+
+// DeleteOrganization 删除组织: 组织资源归还给各自的所有者 (无所有者时归属删除者)
+func (s *Service) DeleteOrganization(id, userID uint) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, newModel := range orgResourceModels {
+			if err := tx.Model(newModel()).Where("org_id = ?", id).Updates(map[string]interface{}{
+				"org_id":   nil,
+				"owner_id": gorm.Expr("COALESCE(owner_id, ?)", userID),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("org_id = ?", id).Delete(&model.OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.Organization{}, id).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.InvalidateNodeConfigHashes()
+	return nil
+}
+
+// ==================== 组织成员 ====================
+
+// ListOrgMembers 获取组织成员
+func (s *Service) ListOrgMembers(orgID uint) ([]model.OrganizationMember, error) {
+	var members []model.OrganizationMember
+	err := s.db.Preload("User", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "username", "email", "role", "enabled")
+	}).Where("org_id = ?", orgID).Order("id asc").Find(&members).Error
+	return members, err
+}
+
+// AddOrgMember 添加组织成员
+func (s *Service) AddOrgMember(orgID, userID uint, role string) (*model.OrganizationMember, error) {
+	if _, ok := orgRoleRank[role]; !ok {
+		return nil, ErrOrgInvalidRole
+	}
+	if _, err := s.GetUser(userID); err != nil {
+		return nil, errors.New("user not found")
+	}
+	if s.OrgRole(orgID, userID) != "" {
+		return nil, ErrOrgMemberExists
+	}
+	member := &model.OrganizationMember{OrgID: orgID, UserID: userID, Role: role}
+	if err := s.db.Create(member).Error; err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// UpdateOrgMemberRole 修改成员角色 (不能移除最后一个所有者)
+func (s *Service) UpdateOrgMemberRole(orgID, userID uint, role string) error {
+	if _, ok := orgRoleRank[role]; !ok {
+		return ErrOrgInvalidRole
+	}
+	current := s.OrgRole(orgID, userID)
+	if current == "" {
+		return ErrOrgNotMember
+	}
+	if current == OrgRoleOwner && role != OrgRoleOwner && s.countOrgOwners(orgID) <= 1 {
+		return ErrOrgLastOwner
+	}
+	return s.db.Model(&model.OrganizationMember{}).Where("org_id = ? AND user_id = ?", orgID, userID).Update("role", role).Error
+}
+
+// RemoveOrgMember 移除组织成员 (成员名下的组织资源仍保留在组织中)
+func (s *Service) RemoveOrgMember(orgID, userID uint) error {
+	current := s.OrgRole(orgID, userID)
+	if current == "" {
+		return ErrOrgNotMember
+	}
+	if current == OrgRoleOwner && s.countOrgOwners(orgID) <= 1 {
+		return ErrOrgLastOwner
+	}
+	return s.db.Where("org_id = ? AND user_id = ?", orgID, userID).Delete(&model.OrganizationMember{}).Error
+}
+
+func (s *Service) countOrgOwners(orgID uint) int64 {
+	var count int64
+	s.db.Model(&model.OrganizationMember{}).Where("org_id = ? AND role = ?", orgID, OrgRoleOwner).Count(&count)
+	return count
+}
+
+// ==================== 组织资源 ====================
+
+// ListOrgResources 获取组织名下的资源
+func (s *Service) ListOrgResources(orgID uint) ([]OrgResource, error) {
+	resources := []OrgResource{}
+	for _, resourceType := range sortedOrgResourceTypes() {
+		var rows []struct {
+			ID      uint
+			Name    string
+			OwnerID *uint
+		}
+		if err := s.db.Model(orgResourceModels[resourceType]()).Select("id", "name", "owner_id").
+			Where("org_id = ?", orgID).Order("id asc").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			resources = append(resources, OrgResource{Type: resourceType, ID: row.ID, Name: row.Name, OwnerID: row.OwnerID})
+		}
+	}
+	return resources, nil
+}
+
+// AssignResourceToOrg 将资源划入组织 (非管理员只能划入自己可修改的资源)，受组织套餐数量限制
+func (s *Service) AssignResourceToOrg(orgID uint, resourceType string, resourceID, userID uint, isAdmin bool) error {
+	newModel, ok := orgResourceModels[resourceType]
+	if !ok {
+		return fmt.Errorf("unknown resource type: %s", resourceType)
+	}
+
+	var current struct {
+		OrgID *uint
+	}
+	query := s.db.Model(newModel()).Select("org_id").Where("id = ?", resourceID)
+	if !isAdmin {
+		query = query.Scopes(s.writableBy(userID))
+	}
+	if err := query.Take(&current).Error; err != nil {
+		return fmt.Errorf("%s not found", getResourceTypeName(resourceType))
+	}
+	if current.OrgID != nil && *current.OrgID == orgID {
+		return nil
+	}
+	// 从其他组织移出需要在原组织具有管理员角色
+	if current.OrgID != nil && !isAdmin {
+		if err := s.RequireOrgRole(*current.OrgID, userID, false, OrgRoleAdmin); err != nil {
+			return errors.New("resource belongs to another organization")
+		}
+	}
+	if allowed, msg := s.CheckOrgPlanResourceLimit(orgID, resourceType); !allowed {
+		return errors.New(msg)
+	}
+
+	err := s.db.Model(newModel()).Where("id = ?", resourceID).Updates(map[string]interface{}{
+		"org_id":     orgID,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	// 停用状态按所有者/组织在生成配置时判断，归属变化无需重新执行配额检查
+	s.InvalidateNodeConfigHashes()
+	return nil
+}
+
+// RemoveResourceFromOrg 将资源移出组织，归还给所有者 (无所有者时归属操作者)
+// ownerOnly 为 true 时仅允许移出操作者本人所有的资源 (普通成员)
+func (s *Service) RemoveResourceFromOrg(orgID uint, resourceType string, resourceID, userID uint, ownerOnly bool) error {
+	newModel, ok := orgResourceModels[resourceType]
+	if !ok {
+		return fmt.Errorf("unknown resource type: %s", resourceType)
+	}
+	query := s.db.Model(newModel()).Where("id = ? AND org_id = ?", resourceID, orgID)
+	if ownerOnly {
+		query = query.Where("owner_id = ?", userID)
+	}
+	result := query.Updates(map[string]interface{}{
+		"org_id":     nil,
+		"owner_id":   gorm.Expr("COALESCE(owner_id, ?)", userID),
+		"updated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%s not found in organization", getResourceTypeName(resourceType))
+	}
+	s.InvalidateNodeConfigHashes()
+	return nil
+}
+
+func sortedOrgResourceTypes() []string {
+	return []string{
+		"node", "client", "tunnel", "port_forward", "proxy_chain", "node_group",
+		"bypass", "admission", "host_mapping", "ingress", "recorder", "router", "sd",
+	}
+}
+
+// ==================== 组织套餐与配额 ====================
+
+// AssignOrgPlan 为组织分配套餐 (套餐流量配额覆盖组织配额)
+func (s *Service) AssignOrgPlan(orgID, planID uint) error {
+	plan, err := s.GetPlan(planID)
+	if err != nil {
+		return errors.New("套餐不存在")
+	}
+	if !plan.Enabled {
+		return errors.New("套餐已禁用")
+	}
+
+	now := time.Now()
+	var expireAt *time.Time
+	if plan.Duration > 0 {
+		expire := now.AddDate(0, 0, plan.Duration)
+		expireAt = &expire
+	}
+
+	err = s.db.Model(&model.Organization{}).Where("id = ?", orgID).Updates(map[string]interface{}{
+		"plan_id":        planID,
+		"plan_start_at":  now,
+		"plan_expire_at": expireAt,
+		"traffic_quota":  plan.TrafficQuota,
+		"quota_used":     0,
+		"quota_reset_at": now,
+		"quota_exceeded": false,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceOrgQuota(orgID)
+}
+
+// RemoveOrgPlan 移除组织套餐
+func (s *Service) RemoveOrgPlan(orgID uint) error {
+	err := s.db.Model(&model.Organization{}).Where("id = ?", orgID).Updates(map[string]interface{}{
+		"plan_id":        nil,
+		"plan_start_at":  nil,
+		"plan_expire_at": nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceOrgQuota(orgID)
+}
+
+// RenewOrgPlan 续期组织套餐并重置已用流量
+func (s *Service) RenewOrgPlan(orgID uint, days int) error {
+	org, err := s.GetOrganization(orgID)
+	if err != nil {
+		return err
+	}
+	if org.PlanID == nil {
+		return errors.New("组织没有套餐")
+	}
+
+	now := time.Now()
+	baseTime := now
+	if org.PlanExpireAt != nil && org.PlanExpireAt.After(now) {
+		baseTime = *org.PlanExpireAt
+	}
+
+	err = s.db.Model(&model.Organization{}).Where("id = ?", orgID).Updates(map[string]interface{}{
+		"plan_expire_at": baseTime.AddDate(0, 0, days),
+		"quota_used":     0,
+		"quota_reset_at": now,
+		"quota_exceeded": false,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceOrgQuota(orgID)
+}
+
+// ResetOrgQuota 重置组织已用流量
+func (s *Service) ResetOrgQuota(orgID uint) error {
+	err := s.db.Model(&model.Organization{}).Where("id = ?", orgID).Updates(map[string]interface{}{
+		"quota_used":     0,
+		"quota_reset_at": time.Now(),
+		"quota_exceeded": false,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceOrgQuota(orgID)
+}
+
+// CheckOrgPlanResourceLimit 检查组织是否超过套餐资源数量限制
+func (s *Service) CheckOrgPlanResourceLimit(orgID uint, resourceType string) (bool, string) {
+	org, err := s.GetOrganization(orgID)
+	if err != nil {
+		return false, "组织不存在"
+	}
+	if org.Plan == nil {
+		return true, ""
+	}
+
+	limits := map[string]int{
+		"node":         org.Plan.MaxNodes,
+		"client":       org.Plan.MaxClients,
+		"tunnel":       org.Plan.MaxTunnels,
+		"port_forward": org.Plan.MaxPortForwards,
+		"proxy_chain":  org.Plan.MaxProxyChains,
+		"node_group":   org.Plan.MaxNodeGroups,
+	}
+	maxLimit := limits[resourceType]
+	if maxLimit == 0 {
+		return true, ""
+	}
+
+	var currentCount int64
+	s.db.Model(orgResourceModels[resourceType]()).Where("org_id = ?", orgID).Count(&currentCount)
+	if int(currentCount) >= maxLimit {
+		return false, fmt.Sprintf("已达到组织套餐限制: 最多允许 %d 个%s", maxLimit, getResourceTypeName(resourceType))
+	}
+	return true, ""
+}
+
+// orgSuspendReason 返回组织需要停用的原因，无需停用时返回空字符串
+func orgSuspendReason(org *model.Organization) string {
+	if org.PlanID != nil && org.PlanExpireAt != nil && org.PlanExpireAt.Before(time.Now()) {
+		return suspendReasonPlanExpired
+	}
+	return quotaSuspendReason(org.QuotaExceeded)
+}
+
+// addOrgTraffic 累加组织已用流量 (由流量采样调用，超限状态由 EnforceQuotas 更新)
+func (s *Service) addOrgTraffic(orgTraffic map[uint]int64) {
+	for orgID, delta := range orgTraffic {
+		if delta <= 0 {
+			continue
+		}
+		s.db.Model(&model.Organization{}).Where("id = ?", orgID).
+			UpdateColumn("quota_used", gorm.Expr("quota_used + ?", delta))
+	}
+}
+
+// suspendedOrgIDs 获取已停用的组织ID集合
+func (s *Service) suspendedOrgIDs() map[uint]bool {
+	var ids []uint
+	s.db.Model(&model.Organization{}).Where("suspended = ?", true).Pluck("id", &ids)
+	result := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result
+}
+
+// ResourceWritableBy 用户是否可以修改或删除资源 (全局管理员不受限制)
+func (s *Service) ResourceWritableBy(resourceType string, resourceID, userID uint, isAdmin bool) bool {
+	newModel, ok := orgResourceModels[resourceType]
+	if !ok {
+		return false
+	}
+	query := s.db.Model(newModel()).Where("id = ?", resourceID)
+	if !isAdmin {
+		query = query.Scopes(s.writableBy(userID))
+	}
+	var count int64
+	query.Count(&count)
+	return count > 0
+}
+
+// ResourceVisibleTo 资源是否对非管理员用户可见 (本人、所属组织或公共资源)
+func (s *Service) ResourceVisibleTo(resourceType string, resourceID, userID uint) bool {
+	newModel, ok := orgResourceModels[resourceType]
+	if !ok {
+		return false
+	}
+	var count int64
+	s.db.Model(newModel()).Where("id = ?", resourceID).Scopes(s.ownedBy(userID)).Count(&count)
+	return count > 0
+}
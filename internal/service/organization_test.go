@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+func TestListOrganizationsCountsMembers(t *testing.T) {
+	svc := newTestService(t)
+	users := map[string]*model.User{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := svc.CreateUserFull(name, name+"@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user
+	}
+
+	team := &model.Organization{Name: "team"}
+	if err := svc.CreateOrganization(team, users["alice"].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.AddOrgMember(team.ID, users["bob"].ID, OrgRoleMember); err != nil {
+		t.Fatal(err)
+	}
+	other := &model.Organization{Name: "other"}
+	if err := svc.CreateOrganization(other, users["carol"].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	orgs, err := svc.ListOrganizations(users["bob"].ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orgs) != 1 || orgs[0].ID != team.ID {
+		t.Fatalf("bob sees %d organizations, want only team", len(orgs))
+	}
+	if orgs[0].MemberCount != 2 || orgs[0].MyRole != OrgRoleMember {
+		t.Errorf("team = %d members, role %q", orgs[0].MemberCount, orgs[0].MyRole)
+	}
+
+	// 管理员可以看到全部组织，不是成员时角色为空
+	orgs, err = svc.ListOrganizations(users["alice"].ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orgs) != 2 {
+		t.Fatalf("admin sees %d organizations, want 2", len(orgs))
+	}
+	if orgs[0].MyRole != OrgRoleOwner || orgs[1].MyRole != "" || orgs[1].MemberCount != 1 {
+		t.Errorf("admin listing = %+v", orgs)
+	}
+}
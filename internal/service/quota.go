@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// ==================== 配额/套餐数据面管控 ====================
+
+// 停用原因
+const (
+	suspendReasonPlanExpired   = "plan expired"
+	suspendReasonPlanExceeded  = "plan traffic exceeded"
+	suspendReasonQuotaExceeded = "traffic quota exceeded"
+)
+
+// EnforceQuotas 根据套餐状态与流量配额同步资源的停用状态
+// 状态变化会反映到生成的 GOST 配置中 (Agent 通过配置哈希自动重载)，并写入操作日志
+func (s *Service) EnforceQuotas() error {
+	// 用户: 套餐到期/套餐流量超限/用户配额超限时停用名下全部资源
+	var users []model.User
+	if err := s.db.Where("plan_id IS NOT NULL OR traffic_quota > 0 OR suspended = ?", true).
+		Find(&users).Error; err != nil {
+		return err
+	}
+	for i := range users {
+		s.enforceUser(&users[i])
+	}
+
+	// 组织: 组织套餐到期/组织配额超限时停用组织名下全部资源
+	var orgs []model.Organization
+	if err := s.db.Where("plan_id IS NOT NULL OR traffic_quota > 0 OR suspended = ?", true).
+		Find(&orgs).Error; err != nil {
+		return err
+	}
+	for i := range orgs {
+		s.enforceOrg(&orgs[i])
+	}
+
+	// 节点/客户端/隧道: 自身流量配额超限
+	var nodes []model.Node
+	s.db.Where("quota_exceeded <> suspended").Find(&nodes)
+	for _, node := range nodes {
+		s.setSuspended(&model.Node{}, "node", node.ID, node.Name, node.Suspended, quotaSuspendReason(node.QuotaExceeded))
+	}
+
+	var clients []model.Client
+	s.db.Where("quota_exceeded <> suspended").Find(&clients)
+	for _, client := range clients {
+		s.setSuspended(&model.Client{}, "client", client.ID, client.Name, client.Suspended, quotaSuspendReason(client.QuotaExceeded))
+	}
+
+	var tunnels []model.Tunnel
+	s.db.Where("quota_exceeded <> suspended").Find(&tunnels)
+	for _, tunnel := range tunnels {
+		s.setSuspended(&model.Tunnel{}, "tunnel", tunnel.ID, tunnel.Name, tunnel.Suspended, quotaSuspendReason(tunnel.QuotaExceeded))
+	}
+
+	return nil
+}
+
+// enforceUserQuota 仅同步单个用户的停用状态 (套餐或配额变更后调用)
+func (s *Service) enforceUserQuota(userID uint) error {
+	var user model.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return err
+	}
+	s.enforceUser(&user)
+	return nil
+}
+
+// enforceOrgQuota 仅同步单个组织的停用状态 (套餐或配额变更后调用)
+func (s *Service) enforceOrgQuota(orgID uint) error {
+	var org model.Organization
+	if err := s.db.First(&org, orgID).Error; err != nil {
+		return err
+	}
+	s.enforceOrg(&org)
+	return nil
+}
+
+func (s *Service) enforceUser(user *model.User) {
+	s.setSuspended(&model.User{}, "user", user.ID, user.Username, user.Suspended, s.userSuspendReason(user))
+}
+
+func (s *Service) enforceOrg(org *model.Organization) {
+	// 配额或已用流量变化后重新计算超限状态
+	exceeded := org.TrafficQuota > 0 && org.QuotaUsed >= org.TrafficQuota
+	if exceeded != org.QuotaExceeded {
+		s.db.Model(&model.Organization{}).Where("id = ?", org.ID).Update("quota_exceeded", exceeded)
+		org.QuotaExceeded = exceeded
+	}
+	s.setSuspended(&model.Organization{}, "organization", org.ID, org.Name, org.Suspended, orgSuspendReason(org))
+}
+
+// userSuspendReason 返回用户需要停用的原因，无需停用时返回空字符串
+func (s *Service) userSuspendReason(user *model.User) string {
+	expired, exceeded, err := s.CheckUserPlanStatus(user.ID)
+	if err != nil {
+		return ""
+	}
+	if expired {
+		return suspendReasonPlanExpired
+	}
+	if exceeded {
+		return suspendReasonPlanExceeded
+	}
+	return quotaSuspendReason(user.QuotaExceeded)
+}
+
+func quotaSuspendReason(quotaExceeded bool) string {
+	if quotaExceeded {
+		return suspendReasonQuotaExceeded
+	}
+	return ""
+}
+
+// setSuspended 更新停用状态，仅在状态变化时写库并记录操作日志
+func (s *Service) setSuspended(value interface{}, resource string, id uint, name string, suspended bool, reason string) {
+	suspend := reason != ""
+	if suspend == suspended {
+		return
+	}
+	if err := s.db.Model(value).Where("id = ?", id).Update("suspended", suspend).Error; err != nil {
+		log.Printf("Quota enforcement: failed to update %s %d: %v", resource, id, err)
+		return
+	}
+	s.InvalidateNodeConfigHashes()
+
+	action := "resume"
+	detail := fmt.Sprintf("%s restored", name)
+	if suspend {
+		action = "suspend"
+		detail = fmt.Sprintf("%s suspended: %s", name, reason)
+	}
+	s.LogOperation(0, "system", action, resource, id, detail, "", "", "success")
+	log.Printf("Quota enforcement: %s %s", resource, detail)
+}
+
+// suspendedUserIDs 获取已停用的用户ID集合
+func (s *Service) suspendedUserIDs() map[uint]bool {
+	var ids []uint
+	s.db.Model(&model.User{}).Where("suspended = ?", true).Pluck("id", &ids)
+	result := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result
+}
+
+// isOwnedBy 判断资源所有者 (用户或组织) 是否在集合中
+func isOwnedBy(ownerID *uint, users map[uint]bool) bool {
+	return ownerID != nil && users[*ownerID]
+}
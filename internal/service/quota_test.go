@@ -0,0 +1,107 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+func userSuspended(t *testing.T, svc *Service, id uint) bool {
+	t.Helper()
+	var user model.User
+	if err := svc.DB().First(&user, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.Suspended
+}
+
+func TestPlanChangesEnforceOnlyAffectedUser(t *testing.T) {
+	svc := newTestService(t)
+	plan := &model.Plan{Name: "basic", Duration: 30, Enabled: true}
+	if err := svc.CreatePlan(plan); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := svc.CreateUserFull("bob", "bob@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.AssignUserPlan(alice.ID, plan.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个用户的配额都已超限，但尚未执行全局检查
+	svc.DB().Model(&model.User{}).Where("id IN ?", []uint{alice.ID, bob.ID}).
+		Updates(map[string]interface{}{"traffic_quota": 100, "quota_exceeded": true})
+
+	// alice 的套餐到期: 续期只重新检查 alice
+	svc.DB().Model(&model.User{}).Where("id = ?", alice.ID).Update("plan_expire_at", time.Now().Add(-time.Hour))
+	if err := svc.RenewUserPlan(alice.ID, 30); err != nil {
+		t.Fatal(err)
+	}
+	if userSuspended(t, svc, alice.ID) {
+		t.Error("alice suspended after renewal")
+	}
+	if userSuspended(t, svc, bob.ID) {
+		t.Error("renewing alice's plan enforced bob's quota")
+	}
+
+	// 重置配额同理
+	svc.DB().Model(&model.User{}).Where("id = ?", alice.ID).Update("quota_exceeded", true)
+	if err := svc.ResetUserQuota(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if userSuspended(t, svc, bob.ID) || userSuspended(t, svc, alice.ID) {
+		t.Error("resetting bob's quota enforced other users")
+	}
+
+	// 全局检查仍处理全部用户
+	svc.DB().Model(&model.User{}).Where("id = ?", bob.ID).Update("quota_exceeded", true)
+	if err := svc.EnforceQuotas(); err != nil {
+		t.Fatal(err)
+	}
+	if !userSuspended(t, svc, alice.ID) || !userSuspended(t, svc, bob.ID) {
+		t.Error("EnforceQuotas did not suspend over-quota users")
+	}
+}
+
+func TestOrgPlanChangesEnforceOnlyAffectedOrg(t *testing.T) {
+	svc := newTestService(t)
+	owner, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := &model.Organization{Name: "team"}
+	other := &model.Organization{Name: "other"}
+	for _, org := range []*model.Organization{team, other} {
+		if err := svc.CreateOrganization(org, owner.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc.DB().Model(&model.Organization{}).Where("id IN ?", []uint{team.ID, other.ID}).
+		Updates(map[string]interface{}{"traffic_quota": 100, "quota_used": 200})
+
+	if err := svc.UpdateOrganizationQuota(team.ID, 150); err != nil {
+		t.Fatal(err)
+	}
+	var orgs []model.Organization
+	svc.DB().Order("id").Find(&orgs)
+	if !orgs[0].Suspended || !orgs[0].QuotaExceeded {
+		t.Errorf("team = suspended %v exceeded %v, want suspended", orgs[0].Suspended, orgs[0].QuotaExceeded)
+	}
+	if orgs[1].Suspended || orgs[1].QuotaExceeded {
+		t.Error("updating team's quota enforced another organization")
+	}
+
+	if err := svc.ResetOrgQuota(team.ID); err != nil {
+		t.Fatal(err)
+	}
+	svc.DB().First(&orgs[0], team.ID)
+	if orgs[0].Suspended {
+		t.Error("team still suspended after quota reset")
+	}
+}
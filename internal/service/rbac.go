@@ -0,0 +1,416 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// ==================== 角色权限 (RBAC) ====================
+
+// 权限操作
+const (
+	ActionRead   = "read"   // 查看
+	ActionWrite  = "write"  // 创建/修改/删除/下发
+	ActionConfig = "config" // 查看生成的配置、安装脚本、代理链接等含凭据的内容
+)
+
+// 角色作用范围
+const (
+	RoleScopeAll = "all" // 可访问所有用户的资源
+	RoleScopeOwn = "own" // 仅可访问自己的资源
+)
+
+// 内置角色
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleUser     = "user"
+	RoleViewer   = "viewer"
+	RoleBilling  = "billing"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleBuiltIn  = errors.New("built-in roles cannot be modified")
+	ErrRoleInUse    = errors.New("role is assigned to users")
+)
+
+// PermissionResources 权限资源列表
+var PermissionResources = []string{
+	"dashboard", "nodes", "clients", "users", "roles", "traffic-history",
+	"notify-channels", "alert-rules", "alert-logs", "operation-logs", "backup",
+	"port-forwards", "node-groups", "proxy-chains", "tunnels", "templates",
+	"site-configs", "jobs", "tags", "plans", "organizations", "metrics",
+	"bypasses", "admissions", "host-mappings", "ingresses", "recorders", "routers", "sds",
+}
+
+// PermissionActions 权限操作列表
+var PermissionActions = []string{ActionRead, ActionWrite, ActionConfig}
+
+// 代理资源: 用户可自行管理的资源
+var proxyResources = []string{
+	"nodes", "clients", "port-forwards", "node-groups", "proxy-chains", "tunnels", "tags",
+	"bypasses", "admissions", "host-mappings", "ingresses", "recorders", "routers", "sds",
+}
+
+// builtinRoles 内置角色定义
+var builtinRoles = []model.Role{
+	{
+		Name:        RoleAdmin,
+		Description: "管理员，拥有全部权限",
+		Scope:       RoleScopeAll,
+		Permissions: permissionJSON([]string{"*:*"}),
+	},
+	{
+		Name:        RoleOperator,
+		Description: "运维，管理所有用户的节点与转发资源，不可管理用户、角色与系统设置",
+		Scope:       RoleScopeAll,
+		Permissions: permissionJSON(concat(
+			grant(append(proxyResources, "notify-channels", "alert-rules"), ActionRead, ActionWrite, ActionConfig),
+			grant([]string{"dashboard", "traffic-history", "alert-logs", "templates", "users", "plans", "jobs", "operation-logs", "organizations", "metrics"}, ActionRead),
+		)),
+	},
+	{
+		Name:        RoleUser,
+		Description: "普通用户，管理自己的节点与转发资源",
+		Scope:       RoleScopeOwn,
+		Permissions: permissionJSON(concat(
+			grant(proxyResources, ActionRead, ActionWrite, ActionConfig),
+			grant([]string{"organizations"}, ActionRead, ActionWrite),
+			grant([]string{"dashboard", "traffic-history", "templates", "users", "plans"}, ActionRead),
+		)),
+	},
+	{
+		Name:        RoleViewer,
+		Description: "只读用户，查看所有资源与监控数据，不可修改，不可查看凭据",
+		Scope:       RoleScopeAll,
+		Permissions: permissionJSON(concat(
+			grant(proxyResources, ActionRead),
+			grant([]string{"dashboard", "traffic-history", "alert-rules", "alert-logs", "templates", "users", "plans", "jobs", "organizations", "metrics"}, ActionRead),
+		)),
+	},
+	{
+		Name:        RoleBilling,
+		Description: "计费，管理套餐与用户配额，查看用户与流量",
+		Scope:       RoleScopeAll,
+		Permissions: permissionJSON(concat(
+			grant([]string{"plans"}, ActionRead, ActionWrite),
+			grant([]string{"dashboard", "users", "traffic-history", "organizations"}, ActionRead),
+		)),
+	},
+}
+
+func grant(resources []string, actions ...string) []string {
+	perms := make([]string, 0, len(resources)*len(actions))
+	for _, resource := range resources {
+		for _, action := range actions {
+			perms = append(perms, resource+":"+action)
+		}
+	}
+	return perms
+}
+
+func concat(lists ...[]string) []string {
+	var result []string
+	for _, list := range lists {
+		result = append(result, list...)
+	}
+	return result
+}
+
+func permissionJSON(perms []string) string {
+	data, _ := json.Marshal(perms)
+	return string(data)
+}
+
+// RolePermissions 角色的有效权限
+type RolePermissions struct {
+	Role  string
+	Scope string
+	perms map[string]bool
+}
+
+// Allow 判断是否拥有资源的指定操作权限 (支持 * 通配)
+func (p *RolePermissions) Allow(resource, action string) bool {
+	return p.perms[resource+":"+action] || p.perms[resource+":*"] || p.perms["*:"+action] || p.perms["*:*"]
+}
+
+// AllScope 是否可访问所有用户的资源
+func (p *RolePermissions) AllScope() bool {
+	return p.Scope == RoleScopeAll
+}
+
+func newRolePermissions(role *model.Role) *RolePermissions {
+	p := &RolePermissions{Role: role.Name, Scope: role.Scope, perms: make(map[string]bool)}
+	var perms []string
+	json.Unmarshal([]byte(role.Permissions), &perms)
+	for _, perm := range perms {
+		p.perms[perm] = true
+	}
+	return p
+}
+
+func findBuiltinRole(name string) *model.Role {
+	for i := range builtinRoles {
+		if builtinRoles[i].Name == name {
+			role := builtinRoles[i]
+			role.BuiltIn = true
+			return &role
+		}
+	}
+	return nil
+}
+
+// GetRolePermissions 获取角色的有效权限
+func (s *Service) GetRolePermissions(name string) (*RolePermissions, error) {
+	role, err := s.GetRoleByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return newRolePermissions(role), nil
+}
+
+// GetUserPermissions 获取用户当前角色的有效权限 (实时读取，角色变更无需重新登录)
+func (s *Service) GetUserPermissions(userID uint) (*RolePermissions, error) {
+	role, err := s.GetUserRole(userID)
+	if err != nil {
+		return nil, err
+	}
+	return newRolePermissions(role), nil
+}
+
+// GetUserRole 获取用户当前角色定义
+func (s *Service) GetUserRole(userID uint) (*model.Role, error) {
+	var user model.User
+	if err := s.db.Select("id", "role").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	return s.GetRoleByName(user.Role)
+}
+
+// ValidateRole 校验角色是否存在
+func (s *Service) ValidateRole(name string) error {
+	_, err := s.GetRoleByName(name)
+	return err
+}
+
+// ListRoles 获取所有角色 (内置 + 自定义)
+func (s *Service) ListRoles() ([]model.Role, error) {
+	roles := make([]model.Role, 0, len(builtinRoles))
+	for _, r := range builtinRoles {
+		roles = append(roles, *findBuiltinRole(r.Name))
+	}
+
+	var custom []model.Role
+	if err := s.db.Order("id").Find(&custom).Error; err != nil {
+		return nil, err
+	}
+	return append(roles, custom...), nil
+}
+
+// GetRoleByName 按名称获取角色
+func (s *Service) GetRoleByName(name string) (*model.Role, error) {
+	if role := findBuiltinRole(name); role != nil {
+		return role, nil
+	}
+	var role model.Role
+	if err := s.db.Where(&model.Role{Name: name}).First(&role).Error; err != nil {
+		return nil, ErrRoleNotFound
+	}
+	return &role, nil
+}
+
+// GetRole 获取自定义角色
+func (s *Service) GetRole(id uint) (*model.Role, error) {
+	var role model.Role
+	if err := s.db.First(&role, id).Error; err != nil {
+		return nil, ErrRoleNotFound
+	}
+	return &role, nil
+}
+
+// CreateRole 创建自定义角色
+func (s *Service) CreateRole(role *model.Role) error {
+	if err := s.validateRole(role); err != nil {
+		return err
+	}
+	if err := s.ValidateRole(role.Name); err == nil {
+		return fmt.Errorf("role %s already exists", role.Name)
+	}
+	return s.db.Create(role).Error
+}
+
+// UpdateRole 更新自定义角色，重命名时同步更新用户的角色
+func (s *Service) UpdateRole(id uint, updated *model.Role) error {
+	role, err := s.GetRole(id)
+	if err != nil {
+		return err
+	}
+	if err := s.validateRole(updated); err != nil {
+		return err
+	}
+	if updated.Name != role.Name {
+		if err := s.ValidateRole(updated.Name); err == nil {
+			return fmt.Errorf("role %s already exists", updated.Name)
+		}
+	}
+
+	oldName := role.Name
+	role.Name = updated.Name
+	role.Description = updated.Description
+	role.Permissions = updated.Permissions
+	role.Scope = updated.Scope
+
+	tx := s.db.Begin()
+	if err := tx.Save(role).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if oldName != role.Name {
+		if err := tx.Model(&model.User{}).Where("role = ?", oldName).Update("role", role.Name).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// DeleteRole 删除自定义角色 (仍有用户使用时拒绝删除)
+func (s *Service) DeleteRole(id uint) error {
+	role, err := s.GetRole(id)
+	if err != nil {
+		return err
+	}
+	var count int64
+	s.db.Model(&model.User{}).Where("role = ?", role.Name).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("%w (%d users)", ErrRoleInUse, count)
+	}
+	return s.db.Delete(&model.Role{}, id).Error
+}
+
+// validateRole 校验并规范化自定义角色
+func (s *Service) validateRole(role *model.Role) error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" {
+		return errors.New("role name is required")
+	}
+	if findBuiltinRole(role.Name) != nil {
+		return ErrRoleBuiltIn
+	}
+	if role.Scope == "" {
+		role.Scope = RoleScopeOwn
+	}
+	if role.Scope != RoleScopeAll && role.Scope != RoleScopeOwn {
+		return fmt.Errorf("invalid scope: %s", role.Scope)
+	}
+
+	perms := []string{}
+	if role.Permissions != "" {
+		if err := json.Unmarshal([]byte(role.Permissions), &perms); err != nil || perms == nil {
+			return errors.New("permissions must be a JSON array")
+		}
+	}
+	for _, perm := range perms {
+		if err := validatePermission(perm); err != nil {
+			return err
+		}
+	}
+	role.Permissions = permissionJSON(perms)
+	return nil
+}
+
+func validatePermission(perm string) error {
+	resource, action, ok := strings.Cut(perm, ":")
+	if !ok {
+		return fmt.Errorf("invalid permission %q, expected resource:action", perm)
+	}
+	if resource != "*" && !containsString(PermissionResources, resource) {
+		return fmt.Errorf("unknown permission resource: %s", resource)
+	}
+	if action != "*" && !containsString(PermissionActions, action) {
+		return fmt.Errorf("unknown permission action: %s", action)
+	}
+	return nil
+}
+
+// GroupRoleMapping 外部认证源 (OIDC/LDAP) 的组到角色映射
+type GroupRoleMapping struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// ValidateRoleMapping 校验组角色映射配置 (JSON 数组)
+func (s *Service) ValidateRoleMapping(key, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	var mappings []GroupRoleMapping
+	if err := json.Unmarshal([]byte(value), &mappings); err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	for _, m := range mappings {
+		if m.Group == "" {
+			return fmt.Errorf("invalid %s: group is required", key)
+		}
+		if err := s.ValidateRole(m.Role); err != nil {
+			return fmt.Errorf("invalid %s: unknown role %s", key, m.Role)
+		}
+	}
+	return nil
+}
+
+// parseRoleMapping 解析组角色映射配置，格式错误时忽略
+func parseRoleMapping(key, value string) []GroupRoleMapping {
+	var mappings []GroupRoleMapping
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &mappings); err != nil {
+			log.Printf("invalid %s: %v", key, err)
+		}
+	}
+	return mappings
+}
+
+// mapGroupRole 按映射顺序返回首个匹配组对应的角色
+// 组名比较忽略大小写与前导斜杠 (Keycloak 的组声明带路径前缀，如 /panel-admins)
+func (s *Service) mapGroupRole(mappings []GroupRoleMapping, groups []string) string {
+	for _, mapping := range mappings {
+		for _, group := range groups {
+			if strings.EqualFold(strings.TrimPrefix(group, "/"), strings.TrimPrefix(mapping.Group, "/")) {
+				if err := s.ValidateRole(mapping.Role); err != nil {
+					log.Printf("role mapping for group %s references unknown role %s", mapping.Group, mapping.Role)
+					continue
+				}
+				return mapping.Role
+			}
+		}
+	}
+	return ""
+}
+
+// syncMappedRole 同步外部认证源映射的角色 (未匹配任何组时保留原角色)
+func (s *Service) syncMappedRole(user *model.User, role string) {
+	if role == "" || role == user.Role {
+		return
+	}
+	if err := s.checkLastAdmin(user.ID, role); err != nil {
+		log.Printf("keep role of user %s: %v", user.Username, err)
+		return
+	}
+	s.db.Model(user).Update("role", role)
+	user.Role = role
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,447 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// schedulerMaxSleep 调度循环最长休眠时间 (用于感知 SiteConfig 中调度表达式的变化)
+const schedulerMaxSleep = 30 * time.Second
+
+var (
+	ErrJobNotFound       = errors.New("job not found")
+	ErrJobAlreadyRunning = errors.New("job is already running")
+)
+
+// Job 定时任务定义
+type Job struct {
+	Name        string
+	Description string
+	DefaultSpec string // 默认调度表达式 (cron 标准格式，支持 @every/@hourly 等)
+	Run         func() error
+}
+
+// JobStatus 定时任务状态
+type JobStatus struct {
+	model.ScheduledJob
+	Description string `json:"description"`
+	DefaultSpec string `json:"default_spec"`
+	Running     bool   `json:"running"`
+}
+
+// JobSpecKey 定时任务调度表达式在 SiteConfig 中的键
+func JobSpecKey(name string) string {
+	return "job_" + name + "_spec"
+}
+
+// ParseJobSpec 校验并解析调度表达式
+func ParseJobSpec(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// Scheduler 进程内定时任务调度器
+type Scheduler struct {
+	db      *gorm.DB
+	jobs    []*Job
+	mu      sync.Mutex
+	running map[string]bool
+	wakeCh  chan struct{}
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewScheduler 创建定时任务调度器
+func NewScheduler(db *gorm.DB) *Scheduler {
+	return &Scheduler{
+		db:      db,
+		running: make(map[string]bool),
+		wakeCh:  make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Register 注册定时任务 (需在 Start 之前调用)
+func (sc *Scheduler) Register(job *Job) {
+	sc.jobs = append(sc.jobs, job)
+}
+
+// Start 启动调度器
+func (sc *Scheduler) Start() {
+	sc.wg.Add(1)
+	go sc.run()
+	log.Printf("Scheduler started (%d jobs)", len(sc.jobs))
+}
+
+// Stop 停止调度器
+func (sc *Scheduler) Stop() {
+	close(sc.stopCh)
+	sc.wg.Wait()
+	log.Println("Scheduler stopped")
+}
+
+// Reload 立即重新计算调度 (调度表达式或启用状态变更后调用)
+func (sc *Scheduler) Reload() {
+	select {
+	case sc.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (sc *Scheduler) run() {
+	defer sc.wg.Done()
+
+	for {
+		next := sc.tick(time.Now())
+		wait := time.Until(next)
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-sc.wakeCh:
+			timer.Stop()
+		case <-sc.stopCh:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// tick 执行到期任务，返回下一次需要唤醒的时间
+func (sc *Scheduler) tick(now time.Time) time.Time {
+	earliest := now.Add(schedulerMaxSleep)
+
+	for _, job := range sc.jobs {
+		state, err := sc.loadState(job.Name)
+		if err != nil {
+			log.Printf("Scheduler: failed to load job %s: %v", job.Name, err)
+			continue
+		}
+		if !state.Enabled {
+			continue
+		}
+
+		spec := sc.spec(job)
+		schedule, err := ParseJobSpec(spec)
+		if err != nil {
+			msg := fmt.Sprintf("invalid spec %q: %v", spec, err)
+			if state.LastError != msg {
+				sc.db.Model(&model.ScheduledJob{}).Where("name = ?", job.Name).Updates(map[string]interface{}{
+					"spec":        spec,
+					"next_run_at": nil,
+					"last_error":  msg,
+				})
+			}
+			continue
+		}
+
+		// 首次运行或调度表达式变更时重新计算下次运行时间
+		// 持久化的下次运行时间已过 (如面板停机期间) 时立即补跑一次
+		if state.Spec != spec || state.NextRunAt == nil {
+			next := schedule.Next(now)
+			state.NextRunAt = &next
+			updates := map[string]interface{}{
+				"spec":        spec,
+				"next_run_at": next,
+			}
+			if strings.HasPrefix(state.LastError, "invalid spec") {
+				updates["last_error"] = ""
+			}
+			sc.db.Model(&model.ScheduledJob{}).Where("name = ?", job.Name).Updates(updates)
+		} else if !state.NextRunAt.After(now) {
+			// 以条件更新认领本次运行，多个面板实例共享 PostgreSQL/MySQL 时只有一个实例执行
+			next := schedule.Next(now)
+			result := sc.db.Model(&model.ScheduledJob{}).
+				Where("name = ? AND next_run_at = ?", job.Name, *state.NextRunAt).
+				Update("next_run_at", next)
+			state.NextRunAt = &next
+			if result.Error == nil && result.RowsAffected == 1 {
+				sc.launch(job)
+			}
+		}
+
+		if state.NextRunAt.Before(earliest) {
+			earliest = *state.NextRunAt
+		}
+	}
+
+	return earliest
+}
+
+// spec 获取任务的调度表达式 (SiteConfig 优先，否则使用默认值)
+func (sc *Scheduler) spec(job *Job) string {
+	var config model.SiteConfig
+	if err := sc.db.Where(&model.SiteConfig{Key: JobSpecKey(job.Name)}).First(&config).Error; err == nil && config.Value != "" {
+		return config.Value
+	}
+	return job.DefaultSpec
+}
+
+// loadState 获取任务运行状态，不存在则创建
+func (sc *Scheduler) loadState(name string) (*model.ScheduledJob, error) {
+	var state model.ScheduledJob
+	err := sc.db.Where(model.ScheduledJob{Name: name}).Attrs(model.ScheduledJob{Enabled: true}).FirstOrCreate(&state).Error
+	return &state, err
+}
+
+// launch 异步执行任务，任务已在运行时返回 false
+func (sc *Scheduler) launch(job *Job) bool {
+	sc.mu.Lock()
+	if sc.running[job.Name] {
+		sc.mu.Unlock()
+		return false
+	}
+	sc.running[job.Name] = true
+	sc.mu.Unlock()
+
+	go sc.execute(job)
+	return true
+}
+
+func (sc *Scheduler) execute(job *Job) {
+	defer func() {
+		sc.mu.Lock()
+		delete(sc.running, job.Name)
+		sc.mu.Unlock()
+	}()
+
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return job.Run()
+	}()
+
+	updates := map[string]interface{}{
+		"last_run_at":   start,
+		"last_duration": time.Since(start).Milliseconds(),
+		"last_error":    "",
+		"run_count":     gorm.Expr("run_count + 1"),
+	}
+	if err != nil {
+		log.Printf("Scheduler: job %s failed: %v", job.Name, err)
+		updates["last_error"] = err.Error()
+		updates["fail_count"] = gorm.Expr("fail_count + 1")
+	}
+	sc.db.Model(&model.ScheduledJob{}).Where("name = ?", job.Name).Updates(updates)
+}
+
+func (sc *Scheduler) findJob(name string) *Job {
+	for _, job := range sc.jobs {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// Trigger 手动触发任务
+func (sc *Scheduler) Trigger(name string) error {
+	job := sc.findJob(name)
+	if job == nil {
+		return ErrJobNotFound
+	}
+	if _, err := sc.loadState(name); err != nil {
+		return err
+	}
+	if !sc.launch(job) {
+		return ErrJobAlreadyRunning
+	}
+	return nil
+}
+
+// List 获取所有任务状态
+func (sc *Scheduler) List() ([]JobStatus, error) {
+	result := make([]JobStatus, 0, len(sc.jobs))
+	for _, job := range sc.jobs {
+		state, err := sc.loadState(job.Name)
+		if err != nil {
+			return nil, err
+		}
+		state.Spec = sc.spec(job)
+
+		sc.mu.Lock()
+		running := sc.running[job.Name]
+		sc.mu.Unlock()
+
+		result = append(result, JobStatus{
+			ScheduledJob: *state,
+			Description:  job.Description,
+			DefaultSpec:  job.DefaultSpec,
+			Running:      running,
+		})
+	}
+	return result, nil
+}
+
+// SetEnabled 启用/禁用任务
+func (sc *Scheduler) SetEnabled(name string, enabled bool) error {
+	if sc.findJob(name) == nil {
+		return ErrJobNotFound
+	}
+	if _, err := sc.loadState(name); err != nil {
+		return err
+	}
+	updates := map[string]interface{}{"enabled": enabled}
+	if !enabled {
+		updates["next_run_at"] = nil
+	}
+	if err := sc.db.Model(&model.ScheduledJob{}).Where("name = ?", name).Updates(updates).Error; err != nil {
+		return err
+	}
+	sc.Reload()
+	return nil
+}
+
+// ==================== 内置定时任务 ====================
+
+// registerJobs 注册面板内置定时任务
+func (s *Service) registerJobs() {
+	s.scheduler.Register(&Job{
+		Name:        "traffic_recorder",
+		Description: "记录流量历史",
+		DefaultSpec: "@every 1m",
+		Run:         s.RecordTrafficHistory,
+	})
+	s.scheduler.Register(&Job{
+		Name:        "traffic_rollup",
+		Description: "汇总流量历史并清理过期数据",
+		DefaultSpec: "*/10 * * * *",
+		Run:         s.RollupTrafficHistory,
+	})
+	s.scheduler.Register(&Job{
+		Name:        "traffic_spike_check",
+		Description: "检测节点流量突增",
+		DefaultSpec: "@every 1m",
+		Run: func() error {
+			s.alertService.CheckTrafficSpikes()
+			return nil
+		},
+	})
+	s.scheduler.Register(&Job{
+		Name:        "session_cleaner",
+		Description: "清理过期会话",
+		DefaultSpec: "@hourly",
+		Run:         s.CleanupExpiredSessions,
+	})
+	s.scheduler.Register(&Job{
+		Name:        "quota_reset",
+		Description: "按重置日重置节点/客户端流量配额",
+		DefaultSpec: "5 0 * * *",
+		Run: func() error {
+			s.alertService.ResetQuotas()
+			return s.EnforceQuotas()
+		},
+	})
+	s.scheduler.Register(&Job{
+		Name:        "user_quota_reset",
+		Description: "按重置日重置用户流量配额",
+		DefaultSpec: "5 0 * * *",
+		Run:         s.CheckAndResetUserQuotas,
+	})
+	s.scheduler.Register(&Job{
+		Name:        "quota_enforcer",
+		Description: "停用/恢复套餐到期或流量超限的资源",
+		DefaultSpec: "@every 1m",
+		Run:         s.EnforceQuotas,
+	})
+	s.scheduler.Register(&Job{
+		Name:        "alert_log_cleanup",
+		Description: "清理旧告警日志",
+		DefaultSpec: "30 3 * * *",
+		Run: func() error {
+			s.alertService.CleanupAlertLogs(s.getIntSiteConfig(ConfigAlertLogRetentionDays, 30))
+			return nil
+		},
+	})
+	s.scheduler.Register(&Job{
+		Name:        "offline_node_check",
+		Description: "标记心跳超时的节点为离线",
+		DefaultSpec: "@every 1m",
+		Run: func() error {
+			s.alertService.CheckOfflineNodes(s.getIntSiteConfig(ConfigNodeOfflineTimeoutMin, 3))
+			return nil
+		},
+	})
+	s.scheduler.Register(&Job{
+		Name:        "node_command_timeout",
+		Description: "标记超时的节点远程命令并清理旧记录",
+		DefaultSpec: "@every 1m",
+		Run:         s.ExpireNodeCommands,
+	})
+	s.scheduler.Register(&Job{
+		Name:        "node_log_cleanup",
+		Description: "清理旧节点 GOST 日志",
+		DefaultSpec: "45 3 * * *",
+		Run: func() error {
+			return s.CleanupNodeLogs(s.getIntSiteConfig(ConfigNodeLogRetentionDays, 7))
+		},
+	})
+}
+
+// 定时任务相关配置键
+const (
+	ConfigAlertLogRetentionDays = "alert_log_retention_days"
+	ConfigNodeOfflineTimeoutMin = "node_offline_timeout_minutes"
+	ConfigNodeLogRetentionDays  = "node_log_retention_days"
+)
+
+// getIntSiteConfig 获取整数配置，未设置或无效时返回默认值
+func (s *Service) getIntSiteConfig(key string, defaultValue int) int {
+	if v, err := strconv.Atoi(s.GetSiteConfig(key)); err == nil && v > 0 {
+		return v
+	}
+	return defaultValue
+}
+
+// StartScheduler 启动定时任务调度器
+func (s *Service) StartScheduler() {
+	s.scheduler.Start()
+}
+
+// ListJobs 获取定时任务列表
+func (s *Service) ListJobs() ([]JobStatus, error) {
+	return s.scheduler.List()
+}
+
+// TriggerJob 手动触发定时任务
+func (s *Service) TriggerJob(name string) error {
+	return s.scheduler.Trigger(name)
+}
+
+// UpdateJob 更新定时任务的调度表达式/启用状态
+func (s *Service) UpdateJob(name string, spec *string, enabled *bool) error {
+	if s.scheduler.findJob(name) == nil {
+		return ErrJobNotFound
+	}
+	if spec != nil {
+		if *spec != "" {
+			if _, err := ParseJobSpec(*spec); err != nil {
+				return fmt.Errorf("invalid spec: %w", err)
+			}
+		}
+		// 空表达式表示恢复默认值
+		if err := s.SetSiteConfig(JobSpecKey(name), *spec); err != nil {
+			return err
+		}
+	}
+	if enabled != nil {
+		return s.scheduler.SetEnabled(name, *enabled)
+	}
+	s.scheduler.Reload()
+	return nil
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"gorm.io/gorm"
+)
+
+// newSharedSchedulers 创建两个共享同一数据库 (各自独立连接池) 的调度器，模拟多个面板实例
+func newSharedSchedulers(t *testing.T, runs *atomic.Int32) (*Scheduler, *Scheduler, *gorm.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "panel.db") + "?_busy_timeout=5000"
+	dbA, err := model.InitDB(model.DriverSQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbB, err := model.OpenDB(model.DriverSQLite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, db := range []*gorm.DB{dbA, dbB} {
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+		}
+	})
+
+	schedulers := make([]*Scheduler, 2)
+	for i, db := range []*gorm.DB{dbA, dbB} {
+		schedulers[i] = NewScheduler(db)
+		schedulers[i].Register(&Job{
+			Name:        "cleanup",
+			DefaultSpec: "@every 1h",
+			Run: func() error {
+				runs.Add(1)
+				return nil
+			},
+		})
+	}
+	return schedulers[0], schedulers[1], dbB
+}
+
+// makeDue 将下次运行时间设置为过去，模拟任务到期
+func makeDue(t *testing.T, sc *Scheduler, at time.Time) {
+	t.Helper()
+	if err := sc.db.Model(&model.ScheduledJob{}).Where("name = ?", "cleanup").Update("next_run_at", at).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitIdle 等待调度器启动的任务执行完毕
+func waitIdle(t *testing.T, schedulers ...*Scheduler) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, sc := range schedulers {
+		for {
+			sc.mu.Lock()
+			busy := len(sc.running) > 0
+			sc.mu.Unlock()
+			if !busy {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("job still running")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestSchedulerClaimsDueJobOnce(t *testing.T) {
+	var runs atomic.Int32
+	a, b, dbB := newSharedSchedulers(t, &runs)
+
+	// 首次调度只计算下次运行时间
+	now := time.Now()
+	a.tick(now)
+	b.tick(now)
+	if runs.Load() != 0 {
+		t.Fatalf("job ran on first tick: %d", runs.Load())
+	}
+
+	// B 读取到期状态后、认领前暂停，等待 A 完成认领与执行
+	var hold atomic.Bool
+	claimed := make(chan struct{})
+	release := make(chan struct{})
+	dbB.Callback().Update().Before("gorm:update").Register("test:hold_claim", func(tx *gorm.DB) {
+		if hold.CompareAndSwap(true, false) {
+			close(claimed)
+			<-release
+		}
+	})
+
+	makeDue(t, a, now.Add(-time.Minute))
+	hold.Store(true)
+	done := make(chan struct{})
+	go func() {
+		b.tick(time.Now())
+		close(done)
+	}()
+	<-claimed
+	a.tick(time.Now())
+	waitIdle(t, a)
+	close(release)
+	<-done
+	waitIdle(t, b)
+
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("due job ran %d times across two schedulers, want 1", got)
+	}
+	var state model.ScheduledJob
+	a.db.Where("name = ?", "cleanup").First(&state)
+	if state.RunCount != 1 || state.NextRunAt == nil || !state.NextRunAt.After(time.Now()) {
+		t.Errorf("job state after claim = run_count %d next_run_at %v", state.RunCount, state.NextRunAt)
+	}
+}
+
+func TestSchedulerConcurrentTicks(t *testing.T) {
+	var runs atomic.Int32
+	a, b, _ := newSharedSchedulers(t, &runs)
+	a.tick(time.Now())
+
+	for round := 1; round <= 5; round++ {
+		makeDue(t, a, time.Now().Add(-time.Duration(round)*time.Minute))
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for _, sc := range []*Scheduler{a, b} {
+			wg.Add(1)
+			go func(sc *Scheduler) {
+				defer wg.Done()
+				<-start
+				sc.tick(time.Now())
+			}(sc)
+		}
+		close(start)
+		wg.Wait()
+		waitIdle(t, a, b)
+
+		if got := runs.Load(); got != int32(round) {
+			t.Fatalf("round %d: job ran %d times in total, want %d", round, got, round)
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AliceNetworks/gost-panel/internal/config"
@@ -15,10 +18,15 @@ import (
 )
 
 type Service struct {
-	db            *gorm.DB
-	cfg           *config.Config
-	alertService  *notify.AlertService
-	healthChecker *HealthChecker
+	db                 *gorm.DB
+	cfg                *config.Config
+	alertService       *notify.AlertService
+	healthChecker      *HealthChecker
+	scheduler          *Scheduler
+	oidcProviders      oidcProviderCache
+	webauthnCeremonies webauthnCeremonyStore
+	configHashes       configHashCache
+	operationLogHook   func(entry *model.OperationLog)
 }
 
 func NewService(db *gorm.DB, cfg *config.Config) *Service {
@@ -36,6 +44,10 @@ func NewService(db *gorm.DB, cfg *config.Config) *Service {
 	svc.healthChecker = NewHealthChecker(db, alertSvc, 30*time.Second)
 	svc.healthChecker.Start()
 
+	// 定时任务 (由 StartScheduler 启动)
+	svc.scheduler = NewScheduler(db)
+	svc.registerJobs()
+
 	return svc
 }
 
@@ -49,13 +61,13 @@ func (s *Service) DB() *gorm.DB {
 	return s.db
 }
 
-// FilterIDsByOwner 过滤 ID 列表，只保留用户有权限的资源
+// FilterIDsByOwner 过滤 ID 列表，只保留用户可修改的资源 (用于批量操作)
 func (s *Service) FilterIDsByOwner(tableName string, ids []uint, userID uint, isAdmin bool) []uint {
 	if isAdmin {
 		return ids
 	}
 	var filtered []uint
-	s.db.Table(tableName).Where("id IN ? AND (owner_id = ? OR owner_id IS NULL)", ids, userID).Pluck("id", &filtered)
+	s.db.Table(tableName).Where("id IN ?", ids).Scopes(s.writableBy(userID)).Pluck("id", &filtered)
 	return filtered
 }
 
@@ -173,7 +185,7 @@ func (s *Service) ListNodesByOwner(userID uint, isAdmin bool) ([]model.Node, err
 	var nodes []model.Node
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.Find(&nodes).Error
 	return nodes, err
@@ -188,13 +200,14 @@ func (s *Service) ListNodesPaginated(userID uint, isAdmin bool, params Paginatio
 
 	// 权限过滤
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 
 	// 搜索过滤
 	if params.Search != "" {
-		search := "%" + params.Search + "%"
-		query = query.Where("name LIKE ? OR host LIKE ?", search, search)
+		// 统一使用 LOWER 保证各数据库下均不区分大小写
+		search := "%" + strings.ToLower(params.Search) + "%"
+		query = query.Where("LOWER(name) LIKE ? OR LOWER(host) LIKE ?", search, search)
 	}
 
 	// 计算总数
@@ -240,7 +253,7 @@ func (s *Service) GetNodeByOwner(id uint, userID uint, isAdmin bool) (*model.Nod
 	var node model.Node
 	query := s.db.Where("id = ?", id)
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.First(&node).Error
 	if err != nil {
@@ -272,6 +285,13 @@ func (s *Service) DeleteNode(id uint) error {
 		if err := tx.Where("node_id = ?", id).Delete(&model.Service{}).Error; err != nil {
 			return err
 		}
+		// 删除远程命令记录
+		if err := tx.Where("node_id = ?", id).Delete(&model.NodeCommand{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_id = ?", id).Delete(&model.NodeLog{}).Error; err != nil {
+			return err
+		}
 		// 删除节点
 		return tx.Delete(&model.Node{}, id).Error
 	})
@@ -326,34 +346,130 @@ func (s *Service) TouchNode(id uint) error {
 	return s.db.Model(&model.Node{}).Where("id = ?", id).Update("updated_at", time.Now()).Error
 }
 
-// GetNodeConfigHash 获取节点配置的哈希值（基于 updated_at）
+// BuildNodeConfig 生成节点完整 GOST 配置 (含规则、隧道与端口转发)
+// 返回配置及生成过程中被跳过的服务发现/隧道/端口转发说明
+func (s *Service) BuildNodeConfig(node *model.Node) (map[string]interface{}, []string) {
+	generator := gost.NewConfigGenerator()
+	bypasses, _ := s.GetBypassesByNode(node.ID)
+	admissions, _ := s.GetAdmissionsByNode(node.ID)
+	hostMappings, _ := s.GetHostMappingsByNode(node.ID)
+	ingresses, _ := s.GetIngressesByNode(node.ID)
+	config := generator.GenerateNodeConfigWithRules(node, bypasses, admissions, hostMappings, ingresses)
+
+	// 记录器/路由/服务发现
+	recorders, _ := s.GetRecordersByNode(node.ID)
+	routers, _ := s.GetRoutersByNode(node.ID)
+	sds, _ := s.GetSDsByNode(node.ID)
+	warnings := generator.MergeRecorderRouterSDs(config, node.ID, recorders, routers, sds)
+
+	// 节点本身、所有者或所属组织被停用时移除全部服务
+	suspendedUsers := s.suspendedUserIDs()
+	suspendedOrgs := s.suspendedOrgIDs()
+	if node.Suspended || isOwnedBy(node.OwnerID, suspendedUsers) || isOwnedBy(node.OrgID, suspendedOrgs) {
+		gost.SuspendServices(config)
+		return config, append(warnings, "node suspended: traffic quota exceeded or owner plan expired")
+	}
+
+	// 隧道 (入口服务/转发链/限速器)
+	allEntryTunnels, _ := s.GetTunnelsByEntryNode(node.ID)
+	entryTunnels := allEntryTunnels[:0]
+	for _, t := range allEntryTunnels {
+		if t.Suspended || isOwnedBy(t.OwnerID, suspendedUsers) || isOwnedBy(t.OrgID, suspendedOrgs) {
+			warnings = append(warnings, fmt.Sprintf("tunnel %s: suspended", t.Name))
+			continue
+		}
+		entryTunnels = append(entryTunnels, t)
+	}
+	exitTunnels, _ := s.GetTunnelsByExitNode(node.ID)
+	for i := range exitTunnels {
+		exitTunnels[i].ExitNode = node
+	}
+	warnings = append(warnings, generator.MergeTunnels(config, entryTunnels, exitTunnels)...)
+
+	// 端口转发
+	allForwards, _ := s.GetPortForwardsByNode(node.ID)
+	forwards := allForwards[:0]
+	for _, pf := range allForwards {
+		if isOwnedBy(pf.OwnerID, suspendedUsers) || isOwnedBy(pf.OrgID, suspendedOrgs) {
+			warnings = append(warnings, fmt.Sprintf("port forward %s: owner suspended", pf.Name))
+			continue
+		}
+		forwards = append(forwards, pf)
+	}
+	chains := make(map[uint]gost.PortForwardChain)
+	for _, pf := range forwards {
+		if pf.ChainID == nil || *pf.ChainID == 0 {
+			continue
+		}
+		if _, ok := chains[*pf.ChainID]; ok {
+			continue
+		}
+		chain, err := s.GetProxyChain(*pf.ChainID)
+		if err != nil {
+			continue
+		}
+		hops, _ := s.GetProxyChainHopsWithNodes(chain.ID)
+		chains[chain.ID] = gost.PortForwardChain{Chain: chain, Hops: hops}
+	}
+	warnings = append(warnings, generator.MergePortForwards(config, forwards, chains)...)
+
+	return config, warnings
+}
+
+// RenderNodeConfig 生成节点配置并渲染为 YAML (与下发给 Agent 的内容一致)
+func (s *Service) RenderNodeConfig(node *model.Node) ([]byte, []string, error) {
+	config, warnings := s.BuildNodeConfig(node)
+	data, err := gost.RenderConfig(config)
+	return data, warnings, err
+}
+
+// GetNodeConfigHash 获取节点配置的哈希值（渲染后 YAML 的 SHA-256）
+// 心跳频繁调用，结果缓存至资源变化 (见 InvalidateNodeConfigHashes)
 func (s *Service) GetNodeConfigHash(id uint) string {
+	hash, generation, ok := s.configHashes.get(id)
+	if ok {
+		return hash
+	}
 	node, err := s.GetNode(id)
 	if err != nil {
 		return ""
 	}
-	// 使用 updated_at 的时间戳作为简单的版本号
-	return fmt.Sprintf("%d", node.UpdatedAt.Unix())
+	data, _, err := s.RenderNodeConfig(node)
+	if err != nil {
+		return ""
+	}
+	hash = gost.ConfigHash(data)
+	s.configHashes.put(id, hash, generation)
+	return hash
 }
 
 // ==================== Client 操作 ====================
 
-// GetClientConfigHash 获取客户端配置的哈希值（考虑客户端和关联节点的更新时间）
+// BuildClientConfig 生成客户端完整配置，客户端、所有者或所属组织被停用时移除全部服务
+func (s *Service) BuildClientConfig(client *model.Client) map[string]interface{} {
+	config := gost.NewConfigGenerator().GenerateClientConfig(client)
+	if client.Suspended || isOwnedBy(client.OwnerID, s.suspendedUserIDs()) || isOwnedBy(client.OrgID, s.suspendedOrgIDs()) {
+		gost.SuspendServices(config)
+	}
+	return config
+}
+
+// RenderClientConfig 生成客户端配置并渲染为 YAML
+func (s *Service) RenderClientConfig(client *model.Client) ([]byte, error) {
+	return gost.RenderConfig(s.BuildClientConfig(client))
+}
+
+// GetClientConfigHash 获取客户端配置的哈希值（渲染后 YAML 的 SHA-256）
 func (s *Service) GetClientConfigHash(id uint) string {
-	var client model.Client
-	if err := s.db.Preload("Node").First(&client, id).Error; err != nil {
+	client, err := s.GetClient(id)
+	if err != nil || client.Node == nil {
 		return ""
 	}
-	// 使用客户端和节点的 updated_at 的最大值作为版本号
-	clientTime := client.UpdatedAt.Unix()
-	nodeTime := int64(0)
-	if client.Node.ID > 0 {
-		nodeTime = client.Node.UpdatedAt.Unix()
-	}
-	if nodeTime > clientTime {
-		return fmt.Sprintf("%d", nodeTime)
+	data, err := s.RenderClientConfig(client)
+	if err != nil {
+		return ""
 	}
-	return fmt.Sprintf("%d", clientTime)
+	return gost.ConfigHash(data)
 }
 
 func (s *Service) ListClients() ([]model.Client, error) {
@@ -367,7 +483,7 @@ func (s *Service) ListClientsByOwner(userID uint, isAdmin bool) ([]model.Client,
 	var clients []model.Client
 	query := s.db.Preload("Node").Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.Find(&clients).Error
 	return clients, err
@@ -382,13 +498,13 @@ func (s *Service) ListClientsPaginated(userID uint, isAdmin bool, params Paginat
 
 	// 权限过滤
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 
 	// 搜索过滤
 	if params.Search != "" {
-		search := "%" + params.Search + "%"
-		query = query.Where("name LIKE ?", search)
+		search := "%" + strings.ToLower(params.Search) + "%"
+		query = query.Where("LOWER(name) LIKE ?", search)
 	}
 
 	// 计算总数
@@ -433,7 +549,7 @@ func (s *Service) GetClientByOwner(id uint, userID uint, isAdmin bool) (*model.C
 	var client model.Client
 	query := s.db.Preload("Node").Where("id = ?", id)
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.First(&client).Error
 	if err != nil {
@@ -545,13 +661,35 @@ func (s *Service) GetUserByUsername(username string) (*model.User, error) {
 	return &user, err
 }
 
+// 依次尝试配置的认证源 (auth_backends)，任一认证源通过即登录成功
 func (s *Service) ValidateUser(username, password string) (*model.User, error) {
+	for _, backend := range s.AuthBackends() {
+		switch backend {
+		case AuthProviderLocal:
+			if user, err := s.validateLocalUser(username, password); err == nil {
+				return user, nil
+			}
+		case AuthProviderLDAP:
+			user, err := s.validateLDAPUser(username, password)
+			if err == nil {
+				return user, nil
+			}
+			if !errors.Is(err, ErrInvalidCredentials) {
+				log.Printf("LDAP: login of %s failed: %v", username, err)
+			}
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// validateLocalUser 使用本地密码认证 (LDAP 影子用户只能通过 LDAP 认证)
+func (s *Service) validateLocalUser(username, password string) (*model.User, error) {
 	user, err := s.GetUserByUsername(username)
-	if err != nil {
-		return nil, errors.New("invalid credentials")
+	if err != nil || user.AuthProvider == AuthProviderLDAP {
+		return nil, ErrInvalidCredentials
 	}
 	if !model.CheckPassword(user.Password, password) {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 	return user, nil
 }
@@ -614,7 +752,10 @@ func (s *Service) CreateUserFull(username, email, password, role string, enabled
 		EmailVerified:   emailVerified,
 	}
 	if user.Role == "" {
-		user.Role = "user"
+		user.Role = RoleUser
+	}
+	if err := s.ValidateRole(user.Role); err != nil {
+		return nil, err
 	}
 
 	err := s.db.Create(user).Error
@@ -640,12 +781,42 @@ func (s *Service) UpdateUser(id uint, updates map[string]interface{}) error {
 		}
 	}
 
+	if role, ok := updates["role"]; ok {
+		name, _ := role.(string)
+		if err := s.ValidateRole(name); err != nil {
+			return err
+		}
+		if err := s.checkLastAdmin(id, name); err != nil {
+			return err
+		}
+	}
+
 	delete(updates, "id")
 	delete(updates, "created_at")
 
 	return s.db.Model(&model.User{}).Where("id = ?", id).Updates(updates).Error
 }
 
+// checkLastAdmin 防止将最后一个管理员降级
+func (s *Service) checkLastAdmin(id uint, newRole string) error {
+	if newRole == RoleAdmin {
+		return nil
+	}
+	var user model.User
+	if err := s.db.Select("id", "role").First(&user, id).Error; err != nil {
+		return err
+	}
+	if user.Role != RoleAdmin {
+		return nil
+	}
+	var adminCount int64
+	s.db.Model(&model.User{}).Where("role = ?", RoleAdmin).Count(&adminCount)
+	if adminCount <= 1 {
+		return errors.New("cannot change the role of the last admin user")
+	}
+	return nil
+}
+
 // DeleteUser 删除用户
 func (s *Service) DeleteUser(id uint) error {
 	// 不允许删除最后一个管理员
@@ -661,6 +832,9 @@ func (s *Service) DeleteUser(id uint) error {
 		return errors.New("cannot delete the last admin user")
 	}
 
+	s.db.Where("user_id = ?", id).Delete(&model.APIToken{})
+	s.db.Where("user_id = ?", id).Delete(&model.WebAuthnCredential{})
+	s.db.Where("user_id = ?", id).Delete(&model.OrganizationMember{})
 	return s.db.Delete(&model.User{}, id).Error
 }
 
@@ -670,6 +844,9 @@ func (s *Service) ChangePassword(id uint, oldPassword, newPassword string) error
 	if err != nil {
 		return errors.New("user not found")
 	}
+	if user.AuthProvider == AuthProviderLDAP {
+		return ErrPasswordManagedByLDAP
+	}
 
 	if !model.CheckPassword(user.Password, oldPassword) {
 		return errors.New("incorrect old password")
@@ -699,6 +876,30 @@ func (s *Service) IsEmailVerificationRequired() bool {
 	return s.GetSiteConfig(model.ConfigEmailVerificationRequired) == "true"
 }
 
+// createExternalUser 创建外部认证源 (OIDC/LDAP) 的本地影子用户
+// 使用随机密码 (仅能通过对应认证源登录)，未指定角色时使用默认角色，邮箱已被占用时不设置邮箱
+func (s *Service) createExternalUser(user *model.User, email, role string) error {
+	if role == "" {
+		role = s.GetSiteConfig(model.ConfigDefaultRole)
+		if role == "" || s.ValidateRole(role) != nil {
+			role = RoleUser
+		}
+	}
+	if email != "" {
+		var count int64
+		s.db.Model(&model.User{}).Where("email = ?", email).Count(&count)
+		if count == 0 {
+			user.Email = &email
+		}
+	}
+
+	user.Password = model.HashPassword(GenerateToken())
+	user.Role = role
+	user.Enabled = true
+	user.PasswordChanged = true
+	return s.db.Create(user).Error
+}
+
 // GenerateToken 生成随机令牌
 func GenerateToken() string {
 	b := make([]byte, 32)
@@ -735,8 +936,8 @@ func (s *Service) RegisterUser(username, email, password string) (*model.User, e
 
 	// 获取默认角色
 	defaultRole := s.GetSiteConfig(model.ConfigDefaultRole)
-	if defaultRole == "" {
-		defaultRole = "user"
+	if defaultRole == "" || s.ValidateRole(defaultRole) != nil {
+		defaultRole = RoleUser
 	}
 
 	// 确定邮箱验证状态
@@ -802,6 +1003,9 @@ func (s *Service) RequestPasswordReset(email string) (*model.User, string, error
 	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
 		return nil, "", errors.New("email not found")
 	}
+	if user.AuthProvider == AuthProviderLDAP {
+		return nil, "", ErrPasswordManagedByLDAP
+	}
 
 	// 生成重置令牌
 	resetToken := GenerateToken()
@@ -863,8 +1067,9 @@ func (s *Service) GetUserByVerificationToken(token string) (*model.User, error)
 func (s *Service) UpdateUserLoginInfo(userID uint, ip string) error {
 	now := time.Now()
 	return s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
-		"last_login_at": now,
-		"last_login_ip": ip,
+		"last_login_at":         now,
+		"last_login_ip":         ip,
+		"failed_login_attempts": 0,
 	}).Error
 }
 
@@ -1001,11 +1206,15 @@ func (s *Service) CheckUserQuota(userID uint) (bool, error) {
 
 // ResetUserQuota 重置用户配额
 func (s *Service) ResetUserQuota(userID uint) error {
-	return s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+	err := s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
 		"quota_used":      0,
 		"quota_exceeded":  false,
 		"quota_reset_at":  time.Now(),
 	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceUserQuota(userID)
 }
 
 // CheckAndResetUserQuotas 检查并重置所有用户的配额 (按月重置日)
@@ -1048,6 +1257,10 @@ func (s *Service) GetUsersWithTrafficSummary() ([]map[string]interface{}, error)
 			"last_login_ip":    user.LastLoginIP,
 			"created_at":       user.CreatedAt,
 			"updated_at":       user.UpdatedAt,
+			// 2FA 与账户锁定
+			"two_factor_enabled":    user.TwoFactorEnabled,
+			"failed_login_attempts": user.FailedLoginAttempts,
+			"locked_until":          user.LockedUntil,
 			// 流量配额相关
 			"traffic_quota":    user.TrafficQuota,
 			"quota_used":       user.QuotaUsed,
@@ -1101,7 +1314,7 @@ func (s *Service) GetStats() (*Stats, error) {
 		TrafficOut  int64
 		Connections int
 	}
-	s.db.Model(&model.Node{}).Select("SUM(traffic_in) as traffic_in, SUM(traffic_out) as traffic_out, SUM(connections) as connections").Scan(&result)
+	s.db.Model(&model.Node{}).Select("COALESCE(SUM(traffic_in), 0) as traffic_in, COALESCE(SUM(traffic_out), 0) as traffic_out, COALESCE(SUM(connections), 0) as connections").Scan(&result)
 	stats.TotalTrafficIn = result.TrafficIn
 	stats.TotalTrafficOut = result.TrafficOut
 	stats.TotalConnections = result.Connections
@@ -1111,38 +1324,327 @@ func (s *Service) GetStats() (*Stats, error) {
 
 // ==================== Traffic History ====================
 
-// RecordTrafficHistory 记录流量历史
+// RecordTrafficHistory 记录流量历史 (本次采样间隔内的流量增量)
+// 除节点和总体数据外，还记录隧道、客户端、端口转发以及用户 (聚合名下节点/客户端/隧道) 的流量
 func (s *Service) RecordTrafficHistory() error {
 	now := time.Now()
 
-	// 获取所有节点的当前流量数据
+	// 升级前的累计值历史由 model.InitDB 的一次性迁移清除
+	var counterList []model.TrafficCounter
+	s.db.Find(&counterList)
+	counters := make(map[string]*model.TrafficCounter, len(counterList))
+	for i := range counterList {
+		counters[counterList[i].Key] = &counterList[i]
+	}
+
+	var histories []model.TrafficHistory
+	userTraffic := make(map[uint]*model.TrafficHistory)
+	addUserTraffic := func(ownerID *uint, deltaIn, deltaOut int64, connections int) {
+		if ownerID == nil {
+			return
+		}
+		h, ok := userTraffic[*ownerID]
+		if !ok {
+			h = &model.TrafficHistory{TargetType: "user", TargetID: *ownerID, RecordedAt: now}
+			userTraffic[*ownerID] = h
+		}
+		h.TrafficIn += deltaIn
+		h.TrafficOut += deltaOut
+		h.Connections += connections
+	}
+	// 组织已用流量 (组织名下节点/客户端/隧道)
+	orgTraffic := make(map[uint]int64)
+	addOrgTraffic := func(orgID *uint, deltaIn, deltaOut int64) {
+		if orgID != nil {
+			orgTraffic[*orgID] += deltaIn + deltaOut
+		}
+	}
+
+	// 节点
 	var nodes []model.Node
 	s.db.Find(&nodes)
 
-	for _, node := range nodes {
-		history := &model.TrafficHistory{
+	var totalIn, totalOut int64
+	totalConnections := 0
+	for i := range nodes {
+		node := &nodes[i]
+		deltaIn, deltaOut := s.trafficDelta(counters, fmt.Sprintf("node:%d", node.ID), node.TrafficIn, node.TrafficOut, now)
+		histories = append(histories, model.TrafficHistory{
 			NodeID:      &node.ID,
-			TrafficIn:   node.TrafficIn,
-			TrafficOut:  node.TrafficOut,
+			TrafficIn:   deltaIn,
+			TrafficOut:  deltaOut,
 			Connections: node.Connections,
 			RecordedAt:  now,
-		}
-		s.db.Create(history)
+		})
+		addUserTraffic(node.OwnerID, deltaIn, deltaOut, node.Connections)
+		addOrgTraffic(node.OrgID, deltaIn, deltaOut)
+
+		totalIn += deltaIn
+		totalOut += deltaOut
+		totalConnections += node.Connections
 	}
 
-	// 记录总体流量
-	stats, _ := s.GetStats()
-	totalHistory := &model.TrafficHistory{
+	// 总体流量
+	histories = append(histories, model.TrafficHistory{
 		NodeID:      nil, // nil 表示总体数据
-		TrafficIn:   stats.TotalTrafficIn,
-		TrafficOut:  stats.TotalTrafficOut,
-		Connections: stats.TotalConnections,
+		TrafficIn:   totalIn,
+		TrafficOut:  totalOut,
+		Connections: totalConnections,
 		RecordedAt:  now,
+	})
+
+	// 客户端
+	var clients []model.Client
+	s.db.Find(&clients)
+	for _, client := range clients {
+		deltaIn, deltaOut := s.trafficDelta(counters, fmt.Sprintf("client:%d", client.ID), client.TrafficIn, client.TrafficOut, now)
+		histories = append(histories, model.TrafficHistory{
+			TargetType: "client",
+			TargetID:   client.ID,
+			TrafficIn:  deltaIn,
+			TrafficOut: deltaOut,
+			RecordedAt: now,
+		})
+		addUserTraffic(client.OwnerID, deltaIn, deltaOut, 0)
+		addOrgTraffic(client.OrgID, deltaIn, deltaOut)
+	}
+
+	// 隧道 (流量已计入入口节点，入口节点属于同一用户/组织时不重复计入)
+	entryNodes := make(map[uint]*model.Node, len(nodes))
+	for i := range nodes {
+		entryNodes[nodes[i].ID] = &nodes[i]
+	}
+	var tunnels []model.Tunnel
+	s.db.Find(&tunnels)
+	for _, tunnel := range tunnels {
+		deltaIn, deltaOut := s.trafficDelta(counters, fmt.Sprintf("tunnel:%d", tunnel.ID), tunnel.TrafficIn, tunnel.TrafficOut, now)
+		histories = append(histories, model.TrafficHistory{
+			TargetType: "tunnel",
+			TargetID:   tunnel.ID,
+			TrafficIn:  deltaIn,
+			TrafficOut: deltaOut,
+			RecordedAt: now,
+		})
+		entry := entryNodes[tunnel.EntryNodeID]
+		if entry == nil || !sameID(entry.OwnerID, tunnel.OwnerID) {
+			addUserTraffic(tunnel.OwnerID, deltaIn, deltaOut, 0)
+		}
+		if entry == nil || !sameID(entry.OrgID, tunnel.OrgID) {
+			addOrgTraffic(tunnel.OrgID, deltaIn, deltaOut)
+		}
+	}
+
+	// 端口转发 (不计入用户流量，与 GetUserTrafficSummary 口径一致)
+	var forwards []model.PortForward
+	s.db.Find(&forwards)
+	for _, pf := range forwards {
+		deltaIn, deltaOut := s.trafficDelta(counters, fmt.Sprintf("port_forward:%d", pf.ID), pf.TrafficIn, pf.TrafficOut, now)
+		histories = append(histories, model.TrafficHistory{
+			TargetType: "port_forward",
+			TargetID:   pf.ID,
+			TrafficIn:  deltaIn,
+			TrafficOut: deltaOut,
+			RecordedAt: now,
+		})
+	}
+
+	// 用户
+	for _, h := range userTraffic {
+		histories = append(histories, *h)
+	}
+	s.addOrgTraffic(orgTraffic)
+
+	return s.db.CreateInBatches(histories, 200).Error
+}
+
+// sameID 两个可选 ID 是否均已设置且相同
+func sameID(a, b *uint) bool {
+	return a != nil && b != nil && *a == *b
+}
+
+// trafficDelta 根据上次采样的累计值计算增量并更新采样记录
+// 首次采样返回 0；累计值变小 (计数被重置) 时以当前值作为增量
+func (s *Service) trafficDelta(counters map[string]*model.TrafficCounter, key string, trafficIn, trafficOut int64, now time.Time) (int64, int64) {
+	counter, ok := counters[key]
+	if !ok {
+		s.db.Create(&model.TrafficCounter{Key: key, TrafficIn: trafficIn, TrafficOut: trafficOut, UpdatedAt: now})
+		return 0, 0
+	}
+
+	deltaIn := trafficIn - counter.TrafficIn
+	if deltaIn < 0 {
+		deltaIn = trafficIn
+	}
+	deltaOut := trafficOut - counter.TrafficOut
+	if deltaOut < 0 {
+		deltaOut = trafficOut
+	}
+
+	if trafficIn != counter.TrafficIn || trafficOut != counter.TrafficOut {
+		s.db.Model(counter).Updates(map[string]interface{}{
+			"traffic_in":  trafficIn,
+			"traffic_out": trafficOut,
+			"updated_at":  now,
+		})
+	}
+	return deltaIn, deltaOut
+}
+
+// 流量历史保留时长配置键
+const (
+	ConfigTrafficRawRetentionHours   = "traffic_history_raw_retention_hours"
+	ConfigTrafficHourlyRetentionDays = "traffic_history_hourly_retention_days"
+	ConfigTrafficDailyRetentionDays  = "traffic_history_daily_retention_days"
+)
+
+// 流量历史粒度
+const (
+	TrafficGranularityMinute = "minute"
+	TrafficGranularityHour   = "hour"
+	TrafficGranularityDay    = "day"
+)
+
+// trafficRetention 获取各粒度的保留时长
+func (s *Service) trafficRetention() (raw, hourly, daily time.Duration) {
+	// 原始数据至少保留 2 小时，保证汇总任务能覆盖完整的小时
+	rawHours := s.getIntSiteConfig(ConfigTrafficRawRetentionHours, 48)
+	if rawHours < 2 {
+		rawHours = 2
+	}
+	raw = time.Duration(rawHours) * time.Hour
+	hourly = time.Duration(s.getIntSiteConfig(ConfigTrafficHourlyRetentionDays, 31)) * 24 * time.Hour
+	daily = time.Duration(s.getIntSiteConfig(ConfigTrafficDailyRetentionDays, 730)) * 24 * time.Hour
+	return raw, hourly, daily
+}
+
+// trafficBucket 按节点和时间段聚合的流量
+type trafficBucket struct {
+	NodeID      *uint
+	TargetType  string
+	TargetID    uint
+	TrafficIn   int64
+	TrafficOut  int64
+	Connections int
+	RecordedAt  time.Time
+}
+
+// aggregateTraffic 将流量记录按节点和时间段聚合 (流量求和，连接数取峰值)
+func aggregateTraffic(points []trafficBucket, truncate func(time.Time) time.Time) []trafficBucket {
+	type bucketKey struct {
+		nodeID     uint
+		total      bool
+		targetType string
+		targetID   uint
+		start      int64
+	}
+	index := make(map[bucketKey]int)
+	var buckets []trafficBucket
+	for _, p := range points {
+		start := truncate(p.RecordedAt)
+		key := bucketKey{total: p.NodeID == nil, targetType: p.TargetType, targetID: p.TargetID, start: start.Unix()}
+		if p.NodeID != nil {
+			key.nodeID = *p.NodeID
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(buckets)
+			index[key] = i
+			buckets = append(buckets, trafficBucket{NodeID: p.NodeID, TargetType: p.TargetType, TargetID: p.TargetID, RecordedAt: start})
+		}
+		buckets[i].TrafficIn += p.TrafficIn
+		buckets[i].TrafficOut += p.TrafficOut
+		if p.Connections > buckets[i].Connections {
+			buckets[i].Connections = p.Connections
+		}
+	}
+	return buckets
+}
+
+func truncateHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// RollupTrafficHistory 将分钟数据汇总为小时/天数据，并按保留时长清理过期数据
+// 每次从最近一个已汇总的时间段开始重新计算，重复执行结果一致
+func (s *Service) RollupTrafficHistory() error {
+	// 小时汇总
+	var hourStart time.Time
+	var lastHour model.TrafficHistoryHourly
+	if err := s.db.Order("recorded_at desc").First(&lastHour).Error; err == nil {
+		hourStart = lastHour.RecordedAt
+	}
+	var raws []model.TrafficHistory
+	if err := s.db.Where("recorded_at >= ?", hourStart).Order("recorded_at asc").Find(&raws).Error; err != nil {
+		return err
+	}
+	if len(raws) > 0 {
+		points := make([]trafficBucket, len(raws))
+		for i, h := range raws {
+			points[i] = trafficBucket{h.NodeID, h.TargetType, h.TargetID, h.TrafficIn, h.TrafficOut, h.Connections, h.RecordedAt}
+		}
+		from := truncateHour(raws[0].RecordedAt)
+		hourly := aggregateTraffic(points, truncateHour)
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("recorded_at >= ?", from).Delete(&model.TrafficHistoryHourly{}).Error; err != nil {
+				return err
+			}
+			for _, b := range hourly {
+				row := model.TrafficHistoryHourly{NodeID: b.NodeID, TargetType: b.TargetType, TargetID: b.TargetID, TrafficIn: b.TrafficIn, TrafficOut: b.TrafficOut, Connections: b.Connections, RecordedAt: b.RecordedAt}
+				if err := tx.Create(&row).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 天汇总
+	var dayStart time.Time
+	var lastDay model.TrafficHistoryDaily
+	if err := s.db.Order("recorded_at desc").First(&lastDay).Error; err == nil {
+		dayStart = lastDay.RecordedAt
+	}
+	var hours []model.TrafficHistoryHourly
+	if err := s.db.Where("recorded_at >= ?", dayStart).Order("recorded_at asc").Find(&hours).Error; err != nil {
+		return err
+	}
+	if len(hours) > 0 {
+		points := make([]trafficBucket, len(hours))
+		for i, h := range hours {
+			points[i] = trafficBucket{h.NodeID, h.TargetType, h.TargetID, h.TrafficIn, h.TrafficOut, h.Connections, h.RecordedAt}
+		}
+		from := truncateDay(hours[0].RecordedAt)
+		daily := aggregateTraffic(points, truncateDay)
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("recorded_at >= ?", from).Delete(&model.TrafficHistoryDaily{}).Error; err != nil {
+				return err
+			}
+			for _, b := range daily {
+				row := model.TrafficHistoryDaily{NodeID: b.NodeID, TargetType: b.TargetType, TargetID: b.TargetID, TrafficIn: b.TrafficIn, TrafficOut: b.TrafficOut, Connections: b.Connections, RecordedAt: b.RecordedAt}
+				if err := tx.Create(&row).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
-	s.db.Create(totalHistory)
 
-	// 清理超过 24 小时的数据
-	s.db.Where("recorded_at < ?", now.Add(-24*time.Hour)).Delete(&model.TrafficHistory{})
+	// 清理过期数据
+	now := time.Now()
+	rawRetention, hourlyRetention, dailyRetention := s.trafficRetention()
+	s.db.Where("recorded_at < ?", now.Add(-rawRetention)).Delete(&model.TrafficHistory{})
+	s.db.Where("recorded_at < ?", now.Add(-hourlyRetention)).Delete(&model.TrafficHistoryHourly{})
+	s.db.Where("recorded_at < ?", now.Add(-dailyRetention)).Delete(&model.TrafficHistoryDaily{})
 
 	return nil
 }
@@ -1155,37 +1657,76 @@ type TrafficPoint struct {
 	Connections int       `json:"connections"`
 }
 
-// GetTrafficHistory 获取流量历史数据
-func (s *Service) GetTrafficHistory(nodeID *uint, hours int) ([]TrafficPoint, error) {
-	if hours <= 0 {
-		hours = 1
+// ResolveTrafficGranularity 确定查询使用的粒度
+// 未指定 (或 auto) 时按时间跨度和各粒度的保留时长自动选择
+func (s *Service) ResolveTrafficGranularity(from, to time.Time, granularity string) (string, error) {
+	switch granularity {
+	case TrafficGranularityMinute, TrafficGranularityHour, TrafficGranularityDay:
+		return granularity, nil
+	case "", "auto":
+	default:
+		return "", fmt.Errorf("invalid granularity: %s", granularity)
 	}
-	if hours > 24 {
-		hours = 24
+
+	rawRetention, hourlyRetention, _ := s.trafficRetention()
+	span := to.Sub(from)
+	age := time.Since(from)
+	switch {
+	case span <= 24*time.Hour && age <= rawRetention:
+		return TrafficGranularityMinute, nil
+	case span <= 31*24*time.Hour && age <= hourlyRetention:
+		return TrafficGranularityHour, nil
+	default:
+		return TrafficGranularityDay, nil
 	}
+}
 
-	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+// GetTrafficHistory 获取节点流量历史数据 (nodeID 为空时返回总体数据)
+func (s *Service) GetTrafficHistory(nodeID *uint, from, to time.Time, granularity string) ([]TrafficPoint, error) {
+	return s.queryTrafficHistory("", nodeID, from, to, granularity)
+}
 
-	var histories []model.TrafficHistory
-	query := s.db.Where("recorded_at > ?", since).Order("recorded_at asc")
+// GetTargetTrafficHistory 获取隧道/客户端/端口转发/用户的流量历史数据
+func (s *Service) GetTargetTrafficHistory(targetType string, targetID uint, from, to time.Time, granularity string) ([]TrafficPoint, error) {
+	return s.queryTrafficHistory(targetType, &targetID, from, to, granularity)
+}
 
-	if nodeID != nil {
-		query = query.Where("node_id = ?", *nodeID)
-	} else {
-		query = query.Where("node_id IS NULL")
+// queryTrafficHistory 按粒度查询流量历史，targetType 为空时 id 表示节点ID
+func (s *Service) queryTrafficHistory(targetType string, id *uint, from, to time.Time, granularity string) ([]TrafficPoint, error) {
+	var table interface{}
+	switch granularity {
+	case TrafficGranularityMinute:
+		table = &model.TrafficHistory{}
+	case TrafficGranularityHour:
+		table = &model.TrafficHistoryHourly{}
+	case TrafficGranularityDay:
+		table = &model.TrafficHistoryDaily{}
+	default:
+		return nil, fmt.Errorf("invalid granularity: %s", granularity)
 	}
 
-	if err := query.Find(&histories).Error; err != nil {
+	query := s.db.Model(table).Where("recorded_at >= ? AND recorded_at <= ?", from, to).Order("recorded_at asc")
+	switch {
+	case targetType != "":
+		query = query.Where("target_type = ? AND target_id = ?", targetType, *id)
+	case id != nil:
+		query = query.Where("target_type = ? AND node_id = ?", "", *id)
+	default:
+		query = query.Where("target_type = ? AND node_id IS NULL", "")
+	}
+
+	var rows []trafficBucket
+	if err := query.Select("traffic_in, traffic_out, connections, recorded_at").Scan(&rows).Error; err != nil {
 		return nil, err
 	}
 
-	points := make([]TrafficPoint, len(histories))
-	for i, h := range histories {
+	points := make([]TrafficPoint, len(rows))
+	for i, r := range rows {
 		points[i] = TrafficPoint{
-			Time:        h.RecordedAt,
-			TrafficIn:   h.TrafficIn,
-			TrafficOut:  h.TrafficOut,
-			Connections: h.Connections,
+			Time:        r.RecordedAt,
+			TrafficIn:   r.TrafficIn,
+			TrafficOut:  r.TrafficOut,
+			Connections: r.Connections,
 		}
 	}
 
@@ -1206,7 +1747,7 @@ func (s *Service) ListPortForwards(userID uint, isAdmin bool) ([]model.PortForwa
 	var forwards []model.PortForward
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.Find(&forwards).Error
 	return forwards, err
@@ -1226,7 +1767,7 @@ func (s *Service) GetPortForwardByOwner(id uint, userID uint, isAdmin bool) (*mo
 	var forward model.PortForward
 	query := s.db.Where("id = ?", id)
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.First(&forward).Error
 	if err != nil {
@@ -1235,6 +1776,13 @@ func (s *Service) GetPortForwardByOwner(id uint, userID uint, isAdmin bool) (*mo
 	return &forward, nil
 }
 
+// GetPortForwardsByNode 获取节点上启用的端口转发
+func (s *Service) GetPortForwardsByNode(nodeID uint) ([]model.PortForward, error) {
+	var forwards []model.PortForward
+	err := s.db.Where("node_id = ? AND enabled = ?", nodeID, true).Order("id ASC").Find(&forwards).Error
+	return forwards, err
+}
+
 func (s *Service) CreatePortForward(forward *model.PortForward) error {
 	forward.CreatedAt = time.Now()
 	forward.UpdatedAt = time.Now()
@@ -1258,7 +1806,7 @@ func (s *Service) ListNodeGroups(userID uint, isAdmin bool) ([]model.NodeGroup,
 	var groups []model.NodeGroup
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.Find(&groups).Error
 	return groups, err
@@ -1278,7 +1826,7 @@ func (s *Service) GetNodeGroupByOwner(id uint, userID uint, isAdmin bool) (*mode
 	var group model.NodeGroup
 	query := s.db.Where("id = ?", id)
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.First(&group).Error
 	if err != nil {
@@ -1358,7 +1906,7 @@ func (s *Service) GetNodeGroupMembersWithNodes(groupID uint) ([]gost.NodeMemberW
 
 // LogOperation 记录操作日志
 func (s *Service) LogOperation(userID uint, username, action, resource string, resourceID uint, detail, ip, userAgent, status string) {
-	log := &model.OperationLog{
+	s.AddOperationLog(&model.OperationLog{
 		UserID:     userID,
 		Username:   username,
 		Action:     action,
@@ -1368,8 +1916,19 @@ func (s *Service) LogOperation(userID uint, username, action, resource string, r
 		IP:         ip,
 		UserAgent:  userAgent,
 		Status:     status,
+	})
+}
+
+// AddOperationLog 写入操作日志
+func (s *Service) AddOperationLog(entry *model.OperationLog) {
+	if err := s.db.Create(entry).Error; err == nil && s.operationLogHook != nil {
+		s.operationLogHook(entry)
 	}
-	s.db.Create(log)
+}
+
+// SetOperationLogHook 设置操作日志写入后的回调 (用于实时推送)
+func (s *Service) SetOperationLogHook(hook func(entry *model.OperationLog)) {
+	s.operationLogHook = hook
 }
 
 // GetOperationLogs 获取操作日志列表
@@ -1410,7 +1969,7 @@ func (s *Service) GetProxyChainByOwner(id uint, userID uint, isAdmin bool) (*mod
 	var chain model.ProxyChain
 	query := s.db.Where("id = ?", id)
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.First(&chain).Error
 	return &chain, err
@@ -1438,7 +1997,7 @@ func (s *Service) ListProxyChains(ownerID *uint) ([]model.ProxyChain, error) {
 	var chains []model.ProxyChain
 	query := s.db.Model(&model.ProxyChain{})
 	if ownerID != nil {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", *ownerID)
+		query = query.Scopes(s.ownedBy(*ownerID))
 	}
 	err := query.Order("id ASC").Find(&chains).Error
 	return chains, err
@@ -1492,7 +2051,7 @@ func (s *Service) GetTunnelByOwner(id uint, userID uint, isAdmin bool) (*model.T
 	var tunnel model.Tunnel
 	query := s.db.Preload("EntryNode").Preload("ExitNode").Where("id = ?", id)
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	err := query.First(&tunnel).Error
 	return &tunnel, err
@@ -1515,7 +2074,26 @@ func (s *Service) DeleteTunnel(id uint) error {
 
 // UpdateTunnelTraffic 更新隧道流量统计 (增量)
 func (s *Service) UpdateTunnelTraffic(id uint, trafficIn, trafficOut int64) error {
-	return s.db.Model(&model.Tunnel{}).Where("id = ?", id).
+	err := s.db.Model(&model.Tunnel{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"traffic_in":  gorm.Expr("traffic_in + ?", trafficIn),
+			"traffic_out": gorm.Expr("traffic_out + ?", trafficOut),
+			"quota_used":  gorm.Expr("quota_used + ?", trafficIn+trafficOut),
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	// 检查流量配额
+	if tunnel, err := s.GetTunnel(id); err == nil {
+		s.alertService.CheckTunnelQuota(tunnel)
+	}
+	return nil
+}
+
+// UpdatePortForwardTrafficByName 按节点和服务名更新端口转发流量统计 (增量)
+func (s *Service) UpdatePortForwardTrafficByName(nodeID uint, name string, trafficIn, trafficOut int64) error {
+	return s.db.Model(&model.PortForward{}).Where("node_id = ? AND name = ?", nodeID, name).
 		Updates(map[string]interface{}{
 			"traffic_in":  gorm.Expr("traffic_in + ?", trafficIn),
 			"traffic_out": gorm.Expr("traffic_out + ?", trafficOut),
@@ -1524,11 +2102,21 @@ func (s *Service) UpdateTunnelTraffic(id uint, trafficIn, trafficOut int64) erro
 
 // UpdateClientTraffic 更新客户端流量统计 (增量)
 func (s *Service) UpdateClientTraffic(id uint, trafficIn, trafficOut int64) error {
-	return s.db.Model(&model.Client{}).Where("id = ?", id).
+	err := s.db.Model(&model.Client{}).Where("id = ?", id).
 		Updates(map[string]interface{}{
 			"traffic_in":  gorm.Expr("traffic_in + ?", trafficIn),
 			"traffic_out": gorm.Expr("traffic_out + ?", trafficOut),
+			"quota_used":  gorm.Expr("quota_used + ?", trafficIn+trafficOut),
 		}).Error
+	if err != nil {
+		return err
+	}
+
+	// 检查流量配额
+	if client, err := s.GetClient(id); err == nil {
+		s.alertService.CheckClientQuota(client)
+	}
+	return nil
 }
 
 // ListTunnels 获取隧道列表
@@ -1536,7 +2124,7 @@ func (s *Service) ListTunnels(ownerID *uint) ([]model.Tunnel, error) {
 	var tunnels []model.Tunnel
 	query := s.db.Preload("EntryNode").Preload("ExitNode")
 	if ownerID != nil {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", *ownerID)
+		query = query.Scopes(s.ownedBy(*ownerID))
 	}
 	err := query.Order("id ASC").Find(&tunnels).Error
 	return tunnels, err
@@ -1545,14 +2133,14 @@ func (s *Service) ListTunnels(ownerID *uint) ([]model.Tunnel, error) {
 // GetTunnelsByEntryNode 获取指定入口节点的所有隧道
 func (s *Service) GetTunnelsByEntryNode(nodeID uint) ([]model.Tunnel, error) {
 	var tunnels []model.Tunnel
-	err := s.db.Preload("ExitNode").Where("entry_node_id = ? AND enabled = ?", nodeID, true).Find(&tunnels).Error
+	err := s.db.Preload("ExitNode").Where("entry_node_id = ? AND enabled = ?", nodeID, true).Order("id ASC").Find(&tunnels).Error
 	return tunnels, err
 }
 
 // GetTunnelsByExitNode 获取指定出口节点的所有隧道
 func (s *Service) GetTunnelsByExitNode(nodeID uint) ([]model.Tunnel, error) {
 	var tunnels []model.Tunnel
-	err := s.db.Preload("EntryNode").Where("exit_node_id = ? AND enabled = ?", nodeID, true).Find(&tunnels).Error
+	err := s.db.Preload("EntryNode").Where("exit_node_id = ? AND enabled = ?", nodeID, true).Order("id ASC").Find(&tunnels).Error
 	return tunnels, err
 }
 
@@ -1592,7 +2180,7 @@ func (s *Service) DeleteNotifyChannel(id uint) error {
 // GetSiteConfig 获取单个配置
 func (s *Service) GetSiteConfig(key string) string {
 	var config model.SiteConfig
-	if err := s.db.Where("key = ?", key).First(&config).Error; err != nil {
+	if err := s.db.Where(&model.SiteConfig{Key: key}).First(&config).Error; err != nil {
 		return ""
 	}
 	return config.Value
@@ -1612,7 +2200,7 @@ func (s *Service) GetSiteConfigs() map[string]string {
 // SetSiteConfig 设置配置
 func (s *Service) SetSiteConfig(key, value string) error {
 	var config model.SiteConfig
-	if err := s.db.Where("key = ?", key).First(&config).Error; err != nil {
+	if err := s.db.Where(&model.SiteConfig{Key: key}).First(&config).Error; err != nil {
 		// 不存在则创建
 		config = model.SiteConfig{Key: key, Value: value}
 		return s.db.Create(&config).Error
@@ -1856,7 +2444,7 @@ func (s *Service) AssignUserPlan(userID, planID uint) error {
 		expireAt = &expire
 	}
 
-	return s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+	err = s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
 		"plan_id":           planID,
 		"plan_start_at":     now,
 		"plan_expire_at":    expireAt,
@@ -1866,16 +2454,24 @@ func (s *Service) AssignUserPlan(userID, planID uint) error {
 		"quota_used":        0,
 		"quota_exceeded":    false,
 	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceUserQuota(userID)
 }
 
 // RemoveUserPlan 移除用户套餐
 func (s *Service) RemoveUserPlan(userID uint) error {
-	return s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+	err := s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
 		"plan_id":           nil,
 		"plan_start_at":     nil,
 		"plan_expire_at":    nil,
 		"plan_traffic_used": 0,
 	}).Error
+	if err != nil {
+		return err
+	}
+	return s.enforceUserQuota(userID)
 }
 
 // RenewUserPlan 续期用户套餐
@@ -1899,12 +2495,18 @@ func (s *Service) RenewUserPlan(userID uint, days int) error {
 
 	newExpireAt := baseTime.AddDate(0, 0, days)
 
-	return s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+	err := s.db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
 		"plan_expire_at":    newExpireAt,
 		"plan_traffic_used": 0,
 		"quota_used":        0,
 		"quota_exceeded":    false,
 	}).Error
+	if err != nil {
+		return err
+	}
+
+	// 续期后立即恢复被停用的资源
+	return s.enforceUserQuota(userID)
 }
 
 // CheckUserPlanStatus 检查用户套餐状态 (是否过期或超限)
@@ -1958,7 +2560,7 @@ func (s *Service) ListBypasses(userID uint, isAdmin bool) ([]model.Bypass, error
 	var bypasses []model.Bypass
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	return bypasses, query.Find(&bypasses).Error
 }
@@ -1982,6 +2584,7 @@ func (s *Service) UpdateBypass(id uint, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now()
 	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "org_id")
 	return s.db.Model(&model.Bypass{}).Where("id = ?", id).Updates(updates).Error
 }
 
@@ -1991,7 +2594,7 @@ func (s *Service) DeleteBypass(id uint) error {
 
 func (s *Service) GetBypassesByNode(nodeID uint) ([]model.Bypass, error) {
 	var bypasses []model.Bypass
-	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Find(&bypasses).Error
+	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Order("id ASC").Find(&bypasses).Error
 	return bypasses, err
 }
 
@@ -2001,7 +2604,7 @@ func (s *Service) ListAdmissions(userID uint, isAdmin bool) ([]model.Admission,
 	var admissions []model.Admission
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	return admissions, query.Find(&admissions).Error
 }
@@ -2025,6 +2628,7 @@ func (s *Service) UpdateAdmission(id uint, updates map[string]interface{}) error
 	updates["updated_at"] = time.Now()
 	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "org_id")
 	return s.db.Model(&model.Admission{}).Where("id = ?", id).Updates(updates).Error
 }
 
@@ -2034,7 +2638,7 @@ func (s *Service) DeleteAdmission(id uint) error {
 
 func (s *Service) GetAdmissionsByNode(nodeID uint) ([]model.Admission, error) {
 	var admissions []model.Admission
-	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Find(&admissions).Error
+	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Order("id ASC").Find(&admissions).Error
 	return admissions, err
 }
 
@@ -2044,7 +2648,7 @@ func (s *Service) ListHostMappings(userID uint, isAdmin bool) ([]model.HostMappi
 	var mappings []model.HostMapping
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	return mappings, query.Find(&mappings).Error
 }
@@ -2068,6 +2672,7 @@ func (s *Service) UpdateHostMapping(id uint, updates map[string]interface{}) err
 	updates["updated_at"] = time.Now()
 	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "org_id")
 	return s.db.Model(&model.HostMapping{}).Where("id = ?", id).Updates(updates).Error
 }
 
@@ -2077,7 +2682,7 @@ func (s *Service) DeleteHostMapping(id uint) error {
 
 func (s *Service) GetHostMappingsByNode(nodeID uint) ([]model.HostMapping, error) {
 	var mappings []model.HostMapping
-	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Find(&mappings).Error
+	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Order("id ASC").Find(&mappings).Error
 	return mappings, err
 }
 
@@ -2087,7 +2692,7 @@ func (s *Service) ListIngresses(userID uint, isAdmin bool) ([]model.Ingress, err
 	var ingresses []model.Ingress
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	return ingresses, query.Find(&ingresses).Error
 }
@@ -2111,6 +2716,7 @@ func (s *Service) UpdateIngress(id uint, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now()
 	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "org_id")
 	return s.db.Model(&model.Ingress{}).Where("id = ?", id).Updates(updates).Error
 }
 
@@ -2120,7 +2726,7 @@ func (s *Service) DeleteIngress(id uint) error {
 
 func (s *Service) GetIngressesByNode(nodeID uint) ([]model.Ingress, error) {
 	var ingresses []model.Ingress
-	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Find(&ingresses).Error
+	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Order("id ASC").Find(&ingresses).Error
 	return ingresses, err
 }
 
@@ -2130,7 +2736,7 @@ func (s *Service) ListRecorders(userID uint, isAdmin bool) ([]model.Recorder, er
 	var recorders []model.Recorder
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	return recorders, query.Find(&recorders).Error
 }
@@ -2154,6 +2760,7 @@ func (s *Service) UpdateRecorder(id uint, updates map[string]interface{}) error
 	updates["updated_at"] = time.Now()
 	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "org_id")
 	return s.db.Model(&model.Recorder{}).Where("id = ?", id).Updates(updates).Error
 }
 
@@ -2163,7 +2770,7 @@ func (s *Service) DeleteRecorder(id uint) error {
 
 func (s *Service) GetRecordersByNode(nodeID uint) ([]model.Recorder, error) {
 	var recorders []model.Recorder
-	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Find(&recorders).Error
+	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Order("id ASC").Find(&recorders).Error
 	return recorders, err
 }
 
@@ -2173,7 +2780,7 @@ func (s *Service) ListRouters(userID uint, isAdmin bool) ([]model.Router, error)
 	var routers []model.Router
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	return routers, query.Find(&routers).Error
 }
@@ -2197,6 +2804,7 @@ func (s *Service) UpdateRouter(id uint, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now()
 	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "org_id")
 	return s.db.Model(&model.Router{}).Where("id = ?", id).Updates(updates).Error
 }
 
@@ -2206,7 +2814,7 @@ func (s *Service) DeleteRouter(id uint) error {
 
 func (s *Service) GetRoutersByNode(nodeID uint) ([]model.Router, error) {
 	var routers []model.Router
-	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Find(&routers).Error
+	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Order("id ASC").Find(&routers).Error
 	return routers, err
 }
 
@@ -2216,7 +2824,7 @@ func (s *Service) ListSDs(userID uint, isAdmin bool) ([]model.SD, error) {
 	var sds []model.SD
 	query := s.db.Order("id desc")
 	if !isAdmin {
-		query = query.Where("owner_id = ? OR owner_id IS NULL", userID)
+		query = query.Scopes(s.ownedBy(userID))
 	}
 	return sds, query.Find(&sds).Error
 }
@@ -2240,6 +2848,7 @@ func (s *Service) UpdateSD(id uint, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now()
 	delete(updates, "id")
 	delete(updates, "created_at")
+	delete(updates, "org_id")
 	return s.db.Model(&model.SD{}).Where("id = ?", id).Updates(updates).Error
 }
 
@@ -2249,7 +2858,7 @@ func (s *Service) DeleteSD(id uint) error {
 
 func (s *Service) GetSDsByNode(nodeID uint) ([]model.SD, error) {
 	var sds []model.SD
-	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Find(&sds).Error
+	err := s.db.Where("node_id = ? OR node_id IS NULL", nodeID).Order("id ASC").Find(&sds).Error
 	return sds, err
 }
 
@@ -2261,6 +2870,7 @@ func (s *Service) SaveConfigVersion(nodeID uint, config string, comment string)
 		NodeID:    nodeID,
 		Config:    config,
 		Comment:   comment,
+		Hash:      gost.ConfigHash([]byte(config)),
 		CreatedAt: time.Now(),
 	}
 	return s.db.Create(version).Error
@@ -2491,38 +3101,147 @@ func getResourceTypeName(resourceType string) string {
 
 // ==================== 会话管理 ====================
 
-// CreateUserSession 创建用户会话
-func (s *Service) CreateUserSession(userID uint, jti, ip, userAgent string, expiresAt time.Time) error {
+// 会话令牌有效期
+const (
+	AccessTokenTTL    = 15 * time.Minute   // 访问令牌 (JWT) 有效期
+	SessionRefreshTTL = 7 * 24 * time.Hour // 刷新令牌有效期，每次刷新顺延 (滑动会话)
+
+	// refreshRaceWindow 并发刷新 (如多个标签页同时刷新) 时，刚被轮换的旧令牌在此窗口内不视为重放
+	refreshRaceWindow = 30 * time.Second
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrRefreshTokenRotated = errors.New("refresh token already rotated")
+	ErrSessionIdle         = errors.New("session expired due to inactivity")
+)
+
+// CreateUserSession 创建用户会话，返回刷新令牌 (明文仅返回一次)
+func (s *Service) CreateUserSession(userID uint, jti, ip, userAgent string, expiresAt time.Time) (string, error) {
+	refreshToken := newRefreshToken(jti)
 	session := &model.UserSession{
-		UserID:     userID,
-		TokenJTI:   jti,
-		IP:         ip,
-		UserAgent:  userAgent,
-		CreatedAt:  time.Now(),
-		ExpiresAt:  expiresAt,
-		LastActive: time.Now(),
+		UserID:           userID,
+		TokenJTI:         jti,
+		IP:               ip,
+		UserAgent:        userAgent,
+		CreatedAt:        time.Now(),
+		ExpiresAt:        expiresAt,
+		LastActive:       time.Now(),
+		RefreshTokenHash: hashToken(refreshToken),
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// newRefreshToken 生成刷新令牌，格式为 <会话 JTI>.<随机串>，JTI 用于定位会话以检测重放
+func newRefreshToken(jti string) string {
+	return jti + "." + GenerateToken()
+}
+
+// sessionIdleTimeout 会话空闲超时，0 表示不限制
+func (s *Service) sessionIdleTimeout() time.Duration {
+	minutes, err := strconv.Atoi(s.GetSiteConfig(model.ConfigSessionIdleTimeout))
+	if err != nil || minutes <= 0 {
+		return 0
 	}
-	return s.db.Create(session).Error
+	return time.Duration(minutes) * time.Minute
 }
 
-// ValidateSession 验证会话是否有效
+// ValidateSession 验证会话是否有效 (未过期且未超过空闲超时)
 func (s *Service) ValidateSession(jti string) bool {
+	query := s.db.Where("token_jti = ? AND expires_at > ?", jti, time.Now())
+	if idle := s.sessionIdleTimeout(); idle > 0 {
+		query = query.Where("last_active > ?", time.Now().Add(-idle))
+	}
 	var session model.UserSession
-	if err := s.db.Where("token_jti = ? AND expires_at > ?", jti, time.Now()).First(&session).Error; err != nil {
+	if err := query.First(&session).Error; err != nil {
 		return false
 	}
 	return true
 }
 
-// UpdateSessionActivity 更新会话活跃时间（每5分钟更新一次）
+// RefreshSession 使用刷新令牌续期会话并轮换刷新令牌
+// 已轮换的旧令牌再次出现 (超过并发窗口) 视为令牌泄露，撤销整个会话
+func (s *Service) RefreshSession(refreshToken, ip, userAgent string) (*model.UserSession, *model.User, string, error) {
+	jti, _, ok := strings.Cut(refreshToken, ".")
+	if !ok || jti == "" {
+		return nil, nil, "", ErrRefreshTokenInvalid
+	}
+
+	var session model.UserSession
+	if err := s.db.Where("token_jti = ?", jti).First(&session).Error; err != nil {
+		return nil, nil, "", ErrRefreshTokenInvalid
+	}
+
+	now := time.Now()
+	hash := hashToken(refreshToken)
+	if session.RefreshTokenHash == "" || hash != session.RefreshTokenHash {
+		if hash == session.PreviousRefreshHash && session.RefreshedAt != nil && now.Sub(*session.RefreshedAt) < refreshRaceWindow {
+			return nil, nil, "", ErrRefreshTokenRotated
+		}
+		s.db.Delete(&session)
+		s.LogOperation(session.UserID, "", "revoke", "user_session", session.ID, "refresh token reuse detected", ip, userAgent, "failed")
+		return nil, nil, "", ErrRefreshTokenReused
+	}
+	if !session.ExpiresAt.After(now) {
+		return nil, nil, "", ErrRefreshTokenInvalid
+	}
+	if idle := s.sessionIdleTimeout(); idle > 0 && now.Sub(session.LastActive) > idle {
+		s.db.Delete(&session)
+		return nil, nil, "", ErrSessionIdle
+	}
+
+	user, err := s.GetUser(session.UserID)
+	if err != nil || !user.Enabled {
+		s.db.Delete(&session)
+		return nil, nil, "", ErrRefreshTokenInvalid
+	}
+
+	// 以当前哈希为条件更新，保证并发刷新时只有一个请求轮换成功
+	newToken := newRefreshToken(jti)
+	result := s.db.Model(&model.UserSession{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":    hashToken(newToken),
+			"previous_refresh_hash": hash,
+			"refreshed_at":          now,
+			"expires_at":            now.Add(SessionRefreshTTL),
+			"ip":                    ip,
+			"user_agent":            userAgent,
+		})
+	if result.Error != nil {
+		return nil, nil, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, "", ErrRefreshTokenRotated
+	}
+	return &session, user, newToken, nil
+}
+
+// DeleteSessionByJTI 删除指定 JTI 的会话 (退出登录)
+func (s *Service) DeleteSessionByJTI(jti string) error {
+	return s.db.Where("token_jti = ?", jti).Delete(&model.UserSession{}).Error
+}
+
+// sessionActivityInterval 会话活跃时间的最小写入间隔
+const sessionActivityInterval = 5 * time.Minute
+
+// UpdateSessionActivity 更新会话活跃时间（默认每5分钟更新一次）
+// 空闲超时较短时按超时的一半更新，避免活跃用户因写入间隔被判定为空闲
 func (s *Service) UpdateSessionActivity(jti string) {
 	var session model.UserSession
 	if err := s.db.Where("token_jti = ?", jti).First(&session).Error; err != nil {
 		return
 	}
 
-	// 只有距离上次更新超过5分钟才更新
-	if time.Since(session.LastActive) > 5*time.Minute {
+	interval := sessionActivityInterval
+	if idle := s.sessionIdleTimeout(); idle > 0 && idle/2 < interval {
+		interval = idle / 2
+	}
+	if time.Since(session.LastActive) > interval {
 		s.db.Model(&session).Update("last_active", time.Now())
 	}
 }
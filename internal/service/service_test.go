@@ -0,0 +1,26 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/config"
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+// newTestService 使用临时 SQLite 数据库创建 Service
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	db, err := model.InitDB(model.DriverSQLite, filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewService(db, &config.Config{JWTSecret: "test-secret"})
+	t.Cleanup(func() {
+		svc.Close()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return svc
+}
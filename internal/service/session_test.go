@@ -0,0 +1,216 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/google/uuid"
+)
+
+// newTestSession 为新用户创建会话，返回会话 JTI 与刷新令牌
+func newTestSession(t *testing.T, svc *Service) (string, string) {
+	t.Helper()
+	user, err := svc.CreateUserFull("alice", "alice@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jti := uuid.NewString()
+	refreshToken, err := svc.CreateUserSession(user.ID, jti, "127.0.0.1", "test", time.Now().Add(SessionRefreshTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jti, refreshToken
+}
+
+// ageRotation 将上次轮换时间移出并发刷新窗口
+func ageRotation(t *testing.T, svc *Service, jti string) {
+	t.Helper()
+	if err := svc.DB().Model(&model.UserSession{}).Where("token_jti = ?", jti).
+		Update("refreshed_at", time.Now().Add(-2*refreshRaceWindow)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sessionExists(svc *Service, jti string) bool {
+	var count int64
+	svc.DB().Model(&model.UserSession{}).Where("token_jti = ?", jti).Count(&count)
+	return count > 0
+}
+
+func TestRefreshSessionRotatesToken(t *testing.T) {
+	svc := newTestService(t)
+	jti, first := newTestSession(t, svc)
+
+	var stored model.UserSession
+	svc.DB().Where("token_jti = ?", jti).First(&stored)
+	if stored.RefreshTokenHash == first || stored.RefreshTokenHash != hashToken(first) {
+		t.Errorf("refresh token stored as %q, want its hash", stored.RefreshTokenHash)
+	}
+
+	session, user, second, err := svc.RefreshSession(first, "10.0.0.1", "browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.TokenJTI != jti || user.Username != "alice" {
+		t.Errorf("refreshed session = %s for %s", session.TokenJTI, user.Username)
+	}
+	if second == first {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	svc.DB().Where("token_jti = ?", jti).First(&stored)
+	if stored.RefreshTokenHash != hashToken(second) || stored.PreviousRefreshHash != hashToken(first) {
+		t.Error("rotated hashes not stored")
+	}
+	if stored.IP != "10.0.0.1" || !stored.ExpiresAt.After(time.Now().Add(SessionRefreshTTL-time.Minute)) {
+		t.Errorf("session after refresh = ip %s expires %v", stored.IP, stored.ExpiresAt)
+	}
+
+	// 新令牌可以继续轮换
+	if _, _, third, err := svc.RefreshSession(second, "10.0.0.1", "browser"); err != nil || third == second {
+		t.Errorf("second rotation = %v", err)
+	}
+}
+
+func TestRefreshSessionRejectsInvalidTokens(t *testing.T) {
+	svc := newTestService(t)
+	jti, refreshToken := newTestSession(t, svc)
+
+	for _, token := range []string{"", "no-separator", ".secret", "unknown-jti.secret"} {
+		if _, _, _, err := svc.RefreshSession(token, "", ""); !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Errorf("RefreshSession(%q) = %v", token, err)
+		}
+	}
+
+	// 过期会话不能续期
+	svc.DB().Model(&model.UserSession{}).Where("token_jti = ?", jti).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, _, _, err := svc.RefreshSession(refreshToken, "", ""); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("expired session: %v", err)
+	}
+}
+
+func TestRefreshSessionReuseRevokesSession(t *testing.T) {
+	svc := newTestService(t)
+	jti, first := newTestSession(t, svc)
+
+	_, _, second, err := svc.RefreshSession(first, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发刷新窗口内重放旧令牌只返回已轮换，不撤销会话
+	if _, _, _, err := svc.RefreshSession(first, "", ""); !errors.Is(err, ErrRefreshTokenRotated) {
+		t.Fatalf("replay within race window: %v", err)
+	}
+	if !sessionExists(svc, jti) {
+		t.Fatal("session revoked by a concurrent refresh")
+	}
+
+	// 窗口之后重放已轮换的令牌视为泄露，撤销整个会话
+	ageRotation(t, svc, jti)
+	if _, _, _, err := svc.RefreshSession(first, "", ""); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replay of rotated token: %v", err)
+	}
+	if sessionExists(svc, jti) {
+		t.Fatal("session still exists after reuse detection")
+	}
+	if svc.ValidateSession(jti) {
+		t.Error("access token still valid after reuse detection")
+	}
+	// 合法持有者的最新令牌同样失效
+	if _, _, _, err := svc.RefreshSession(second, "", ""); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("current token after revocation: %v", err)
+	}
+}
+
+func TestRefreshSessionReuseOfOlderGeneration(t *testing.T) {
+	svc := newTestService(t)
+	jti, first := newTestSession(t, svc)
+
+	_, _, second, err := svc.RefreshSession(first, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := svc.RefreshSession(second, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// 两代之前的令牌不在并发窗口的豁免范围内
+	if _, _, _, err := svc.RefreshSession(first, "", ""); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replay of older token: %v", err)
+	}
+	if sessionExists(svc, jti) {
+		t.Error("session not revoked")
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	svc := newTestService(t)
+	jti, refreshToken := newTestSession(t, svc)
+	if err := svc.SetSiteConfig(model.ConfigSessionIdleTimeout, "30"); err != nil {
+		t.Fatal(err)
+	}
+	if !svc.ValidateSession(jti) {
+		t.Fatal("active session rejected")
+	}
+
+	// 空闲超过超时时间: 访问令牌与刷新令牌都失效
+	svc.DB().Model(&model.UserSession{}).Where("token_jti = ?", jti).Update("last_active", time.Now().Add(-31*time.Minute))
+	if svc.ValidateSession(jti) {
+		t.Error("idle session accepted")
+	}
+	if _, _, _, err := svc.RefreshSession(refreshToken, "", ""); !errors.Is(err, ErrSessionIdle) {
+		t.Errorf("refresh of idle session: %v", err)
+	}
+	if sessionExists(svc, jti) {
+		t.Error("idle session not deleted")
+	}
+}
+
+func TestSessionIdleTimeoutDisabled(t *testing.T) {
+	svc := newTestService(t)
+	jti, refreshToken := newTestSession(t, svc)
+	if err := svc.SetSiteConfig(model.ConfigSessionIdleTimeout, "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.DB().Model(&model.UserSession{}).Where("token_jti = ?", jti).Update("last_active", time.Now().Add(-24*time.Hour))
+	if !svc.ValidateSession(jti) {
+		t.Error("session rejected without idle timeout")
+	}
+	if _, _, _, err := svc.RefreshSession(refreshToken, "", ""); err != nil {
+		t.Errorf("refresh without idle timeout: %v", err)
+	}
+}
+
+func TestUpdateSessionActivity(t *testing.T) {
+	svc := newTestService(t)
+	jti, _ := newTestSession(t, svc)
+	lastActive := func() time.Time {
+		var session model.UserSession
+		svc.DB().Where("token_jti = ?", jti).First(&session)
+		return session.LastActive
+	}
+	setLastActive := func(ago time.Duration) {
+		svc.DB().Model(&model.UserSession{}).Where("token_jti = ?", jti).Update("last_active", time.Now().Add(-ago))
+	}
+
+	// 空闲超时较长时每 5 分钟最多写入一次
+	setLastActive(3 * time.Minute)
+	before := lastActive()
+	svc.UpdateSessionActivity(jti)
+	if !lastActive().Equal(before) {
+		t.Error("activity written within the default interval")
+	}
+
+	// 空闲超时较短时按超时的一半写入，活跃用户不会被判定为空闲
+	svc.SetSiteConfig(model.ConfigSessionIdleTimeout, "4")
+	svc.UpdateSessionActivity(jti)
+	if time.Since(lastActive()) > time.Minute {
+		t.Error("activity not refreshed with a short idle timeout")
+	}
+	if !svc.ValidateSession(jti) {
+		t.Error("active session rejected")
+	}
+}
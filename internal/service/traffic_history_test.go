@@ -0,0 +1,181 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+)
+
+func mustCreate(t *testing.T, svc *Service, rows ...interface{}) {
+	t.Helper()
+	for _, row := range rows {
+		if err := svc.DB().Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// userTrafficIn 最近一次采样中用户的入站流量
+func userTrafficIn(t *testing.T, svc *Service, userID uint) int64 {
+	t.Helper()
+	var h model.TrafficHistory
+	if err := svc.DB().Where("target_type = ? AND target_id = ?", "user", userID).Order("id DESC").First(&h).Error; err != nil {
+		t.Fatal(err)
+	}
+	return h.TrafficIn
+}
+
+func TestRecordTrafficHistoryCountsOwnTunnelsOnce(t *testing.T) {
+	svc := newTestService(t)
+	db := svc.DB()
+	alice, bob := uint(10), uint(11)
+	team := uint(20)
+
+	aliceNode := &model.Node{Name: "alice-node", Host: "10.0.0.1", AgentToken: "a", OwnerID: &alice, OrgID: &team}
+	bobNode := &model.Node{Name: "bob-node", Host: "10.0.0.2", AgentToken: "b", OwnerID: &bob}
+	mustCreate(t, svc, aliceNode, bobNode)
+	// 入口在自己节点上的隧道，流量已计入节点
+	own := &model.Tunnel{Name: "own", EntryNodeID: aliceNode.ID, ExitNodeID: bobNode.ID, OwnerID: &alice, OrgID: &team}
+	// 入口在他人节点上的隧道
+	shared := &model.Tunnel{Name: "shared", EntryNodeID: bobNode.ID, ExitNodeID: aliceNode.ID, OwnerID: &alice, OrgID: &team}
+	org := &model.Organization{ID: team, Name: "team"}
+	mustCreate(t, svc, own, shared, org)
+
+	// 首次采样建立基准
+	if err := svc.RecordTrafficHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Model(aliceNode).Update("traffic_in", 1000)
+	db.Model(own).Update("traffic_in", 300)
+	db.Model(bobNode).Update("traffic_in", 50)
+	db.Model(shared).Update("traffic_in", 50)
+	if err := svc.RecordTrafficHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := userTrafficIn(t, svc, alice); got != 1050 {
+		t.Errorf("alice traffic in = %d, want 1050 (node 1000 + tunnel on bob's node 50)", got)
+	}
+	if got := userTrafficIn(t, svc, bob); got != 50 {
+		t.Errorf("bob traffic in = %d, want 50", got)
+	}
+	db.First(org, team)
+	if org.QuotaUsed != 1050 {
+		t.Errorf("org quota used = %d, want 1050", org.QuotaUsed)
+	}
+
+	// 每个隧道仍有自己的历史
+	var tunnelRows int64
+	db.Model(&model.TrafficHistory{}).Where("target_type = ?", "tunnel").Count(&tunnelRows)
+	if tunnelRows != 4 {
+		t.Errorf("tunnel history rows = %d, want 4", tunnelRows)
+	}
+}
+
+// sumTraffic 汇总表中总体数据的流量合计
+func sumTraffic(t *testing.T, svc *Service, table interface{}) int64 {
+	t.Helper()
+	var total int64
+	if err := svc.DB().Model(table).Where("target_type = ? AND node_id IS NULL", "").
+		Select("COALESCE(SUM(traffic_in + traffic_out), 0)").Scan(&total).Error; err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+func TestRollupTrafficHistory(t *testing.T) {
+	svc := newTestService(t)
+	base := truncateHour(time.Now().Add(-3 * time.Hour))
+	for i, offset := range []time.Duration{10, 20, 70, 130, 140} {
+		mustCreate(t, svc, &model.TrafficHistory{
+			TrafficIn:   int64(100 * (i + 1)),
+			TrafficOut:  10,
+			Connections: i,
+			RecordedAt:  base.Add(offset * time.Minute),
+		})
+	}
+	const rawTotal = 100 + 200 + 300 + 400 + 500 + 5*10
+
+	for run := 0; run < 2; run++ {
+		if err := svc.RollupTrafficHistory(); err != nil {
+			t.Fatal(err)
+		}
+		if got := sumTraffic(t, svc, &model.TrafficHistoryHourly{}); got != rawTotal {
+			t.Errorf("run %d: hourly total = %d, want %d", run, got, rawTotal)
+		}
+		if got := sumTraffic(t, svc, &model.TrafficHistoryDaily{}); got != rawTotal {
+			t.Errorf("run %d: daily total = %d, want %d", run, got, rawTotal)
+		}
+	}
+
+	var hours []model.TrafficHistoryHourly
+	svc.DB().Order("recorded_at").Find(&hours)
+	if len(hours) != 3 {
+		t.Fatalf("hourly rows = %d, want 3", len(hours))
+	}
+	if hours[0].TrafficIn != 300 || hours[0].Connections != 1 || !hours[0].RecordedAt.Equal(base) {
+		t.Errorf("first hour = %+v, want 300 bytes in, peak 1 connection at %s", hours[0], base)
+	}
+
+	// 新数据写入已汇总的小时后再次汇总，只重新计算该小时
+	mustCreate(t, svc, &model.TrafficHistory{TrafficIn: 1000, RecordedAt: base.Add(150 * time.Minute)})
+	if err := svc.RollupTrafficHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sumTraffic(t, svc, &model.TrafficHistoryHourly{}); got != rawTotal+1000 {
+		t.Errorf("hourly total after new data = %d, want %d", got, rawTotal+1000)
+	}
+	if got := sumTraffic(t, svc, &model.TrafficHistoryDaily{}); got != rawTotal+1000 {
+		t.Errorf("daily total after new data = %d, want %d", got, rawTotal+1000)
+	}
+
+	points, err := svc.GetTrafficHistory(nil, base, time.Now(), TrafficGranularityHour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 {
+		t.Errorf("hourly points = %d, want 3", len(points))
+	}
+}
+
+func TestResolveTrafficGranularity(t *testing.T) {
+	svc := newTestService(t)
+	now := time.Now()
+	cases := []struct {
+		name        string
+		from, to    time.Time
+		granularity string
+		want        string
+	}{
+		{"last hour", now.Add(-time.Hour), now, "", TrafficGranularityMinute},
+		{"last day", now.Add(-24 * time.Hour), now, "auto", TrafficGranularityMinute},
+		{"last week", now.Add(-7 * 24 * time.Hour), now, "", TrafficGranularityHour},
+		// 跨度短但原始数据已过保留期 (默认 48 小时)
+		{"old day", now.Add(-72 * time.Hour), now.Add(-60 * time.Hour), "", TrafficGranularityHour},
+		{"last quarter", now.Add(-90 * 24 * time.Hour), now, "", TrafficGranularityDay},
+		// 小时数据已过保留期 (默认 31 天)
+		{"old week", now.Add(-60 * 24 * time.Hour), now.Add(-53 * 24 * time.Hour), "", TrafficGranularityDay},
+		{"explicit", now.Add(-90 * 24 * time.Hour), now, TrafficGranularityMinute, TrafficGranularityMinute},
+	}
+	for _, tc := range cases {
+		got, err := svc.ResolveTrafficGranularity(tc.from, tc.to, tc.granularity)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: granularity = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	if _, err := svc.ResolveTrafficGranularity(now.Add(-time.Hour), now, "week"); err == nil {
+		t.Error("invalid granularity accepted")
+	}
+
+	// 配置的保留时长影响自动选择
+	svc.SetSiteConfig(ConfigTrafficRawRetentionHours, "2")
+	if got, _ := svc.ResolveTrafficGranularity(now.Add(-6*time.Hour), now, ""); got != TrafficGranularityHour {
+		t.Errorf("with 2h raw retention: granularity = %q, want %q", got, TrafficGranularityHour)
+	}
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ==================== WebAuthn / 通行密钥 ====================
+
+const (
+	SecondFactorTOTP     = "totp"
+	SecondFactorWebAuthn = "webauthn"
+
+	webauthnCeremonyTTL = 5 * time.Minute
+	maxWebAuthnPerUser  = 20
+)
+
+var (
+	ErrWebAuthnSessionInvalid = errors.New("security key challenge expired or invalid, please try again")
+	ErrWebAuthnNotRegistered  = errors.New("no security key registered")
+	ErrWebAuthnCloned         = errors.New("security key signature counter mismatch, the authenticator may be cloned")
+	ErrWebAuthnLimit          = errors.New("too many security keys registered")
+	ErrWebAuthnRequired       = errors.New("your role requires at least one security key")
+	ErrPasskeyLoginDisabled   = errors.New("passkey login is disabled")
+)
+
+// webauthnCeremony 进行中的注册/认证挑战 (一次性使用)
+type webauthnCeremony struct {
+	kind    string
+	userID  uint // 无密码登录时为 0
+	session webauthn.SessionData
+}
+
+// webauthnCeremonyStore 挑战数据仅保存在内存中，重启后需重新发起
+type webauthnCeremonyStore struct {
+	mu         sync.Mutex
+	ceremonies map[string]*webauthnCeremony
+}
+
+func (store *webauthnCeremonyStore) put(ceremony *webauthnCeremony) string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.ceremonies == nil {
+		store.ceremonies = make(map[string]*webauthnCeremony)
+	}
+	now := time.Now()
+	for id, c := range store.ceremonies {
+		if now.After(c.session.Expires) {
+			delete(store.ceremonies, id)
+		}
+	}
+	id := GenerateToken()
+	store.ceremonies[id] = ceremony
+	return id
+}
+
+// take 取出并删除挑战，类型或用户不匹配、已过期均视为无效
+func (store *webauthnCeremonyStore) take(id, kind string, userID uint) (*webauthnCeremony, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	ceremony, ok := store.ceremonies[id]
+	if !ok {
+		return nil, ErrWebAuthnSessionInvalid
+	}
+	delete(store.ceremonies, id)
+	if ceremony.kind != kind || ceremony.userID != userID || time.Now().After(ceremony.session.Expires) {
+		return nil, ErrWebAuthnSessionInvalid
+	}
+	return ceremony, nil
+}
+
+// webauthnUser 适配 webauthn.User 接口
+type webauthnUser struct {
+	user        *model.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte                         { return webauthnUserHandle(u.user.ID) }
+func (u *webauthnUser) WebAuthnName() string                       { return u.user.Username }
+func (u *webauthnUser) WebAuthnDisplayName() string                { return u.user.Username }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// webauthnUserHandle 用户句柄: 用户 ID 的 8 字节大端编码
+func webauthnUserHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// relyingParty 按面板地址构建 RP 配置，RP ID 默认为站点主机名
+func (s *Service) relyingParty(origin string) (*webauthn.WebAuthn, error) {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid site url: %s", origin)
+	}
+	rpID := strings.TrimSpace(s.GetSiteConfig(model.ConfigWebAuthnRPID))
+	if rpID == "" {
+		rpID = parsed.Hostname()
+	}
+	displayName := s.GetSiteConfig(model.ConfigSiteName)
+	if displayName == "" {
+		displayName = "GOST Panel"
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: displayName,
+		RPOrigins:     []string{parsed.Scheme + "://" + parsed.Host},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webauthnCeremonyTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webauthnCeremonyTTL},
+		},
+	})
+}
+
+// loadWebAuthnUser 读取用户及其已注册的凭据
+func (s *Service) loadWebAuthnUser(user *model.User) (*webauthnUser, error) {
+	records, err := s.ListWebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	u := &webauthnUser{user: user}
+	for _, record := range records {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(record.Data), &credential); err != nil {
+			continue
+		}
+		u.credentials = append(u.credentials, credential)
+	}
+	return u, nil
+}
+
+// ListWebAuthnCredentials 获取用户的 WebAuthn 凭据
+func (s *Service) ListWebAuthnCredentials(userID uint) ([]model.WebAuthnCredential, error) {
+	var credentials []model.WebAuthnCredential
+	err := s.db.Where("user_id = ?", userID).Order("id").Find(&credentials).Error
+	return credentials, err
+}
+
+// CountWebAuthnCredentials 统计用户已注册的凭据数
+func (s *Service) CountWebAuthnCredentials(userID uint) int64 {
+	var count int64
+	s.db.Model(&model.WebAuthnCredential{}).Where("user_id = ?", userID).Count(&count)
+	return count
+}
+
+// WebAuthnRequired 角色是否被要求使用 WebAuthn 作为第二因素
+func (s *Service) WebAuthnRequired(role string) bool {
+	return s.roleInConfig(model.ConfigWebAuthnRequiredRoles, role)
+}
+
+// SecondFactors 密码登录后需要完成的第二因素，任选其一即可
+// 角色要求 WebAuthn 且已注册凭据时，不再接受 TOTP
+func (s *Service) SecondFactors(user *model.User) []string {
+	hasWebAuthn := s.CountWebAuthnCredentials(user.ID) > 0
+	if hasWebAuthn && s.WebAuthnRequired(user.Role) {
+		return []string{SecondFactorWebAuthn}
+	}
+	var factors []string
+	if user.TwoFactorEnabled {
+		factors = append(factors, SecondFactorTOTP)
+	}
+	if hasWebAuthn {
+		factors = append(factors, SecondFactorWebAuthn)
+	}
+	return factors
+}
+
+// PasskeyLoginEnabled 是否允许通行密钥无密码登录
+func (s *Service) PasskeyLoginEnabled() bool {
+	return s.GetSiteConfig(model.ConfigPasskeyLoginEnabled) != "false"
+}
+
+// BeginWebAuthnRegistration 发起凭据注册，返回浏览器 navigator.credentials.create 的参数与挑战 ID
+func (s *Service) BeginWebAuthnRegistration(origin string, user *model.User) (*protocol.CredentialCreation, string, error) {
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	u, err := s.loadWebAuthnUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(u.credentials) >= maxWebAuthnPerUser {
+		return nil, "", ErrWebAuthnLimit
+	}
+
+	creation, session, err := rp.BeginRegistration(u,
+		webauthn.WithExclusions(webauthn.Credentials(u.credentials).CredentialDescriptors()),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+		webauthn.WithExtensions(protocol.AuthenticationExtensions{"credProps": true}))
+	if err != nil {
+		return nil, "", err
+	}
+	id := s.webauthnCeremonies.put(&webauthnCeremony{kind: "register", userID: user.ID, session: *session})
+	return creation, id, nil
+}
+
+// FinishWebAuthnRegistration 校验注册响应并保存凭据
+func (s *Service) FinishWebAuthnRegistration(origin string, user *model.User, ceremonyID, name string, response []byte) (*model.WebAuthnCredential, error) {
+	ceremony, err := s.webauthnCeremonies.take(ceremonyID, "register", user.ID)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.loadWebAuthnUser(user)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registration response: %w", err)
+	}
+	credential, err := rp.CreateCredential(u, ceremony.session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("registration verification failed: %w", err)
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+	var transports []string
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = fmt.Sprintf("Security key %d", len(u.credentials)+1)
+	}
+	record := &model.WebAuthnCredential{
+		UserID:       user.ID,
+		Name:         truncateString(name, 100),
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Data:         string(data),
+		Transports:   truncateString(strings.Join(transports, ","), 100),
+		Discoverable: isResidentKey(parsed.ClientExtensionResults["credProps"]),
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, errors.New("security key is already registered")
+	}
+	return record, nil
+}
+
+// isResidentKey 解析 credProps 扩展结果 {"rk": true}
+func isResidentKey(credProps interface{}) bool {
+	props, ok := credProps.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	rk, _ := props["rk"].(bool)
+	return rk
+}
+
+func truncateString(value string, max int) string {
+	if runes := []rune(value); len(runes) > max {
+		return string(runes[:max])
+	}
+	return value
+}
+
+// RenameWebAuthnCredential 重命名凭据
+func (s *Service) RenameWebAuthnCredential(userID, id uint, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	result := s.db.Model(&model.WebAuthnCredential{}).Where("id = ? AND user_id = ?", id, userID).
+		Update("name", truncateString(name, 100))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebAuthnNotRegistered
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential 删除凭据；角色要求 WebAuthn 时不能删除最后一个
+func (s *Service) DeleteWebAuthnCredential(user *model.User, id uint) error {
+	var credential model.WebAuthnCredential
+	if err := s.db.Where("id = ? AND user_id = ?", id, user.ID).First(&credential).Error; err != nil {
+		return ErrWebAuthnNotRegistered
+	}
+	if s.CountWebAuthnCredentials(user.ID) <= 1 {
+		if s.WebAuthnRequired(user.Role) {
+			return ErrWebAuthnRequired
+		}
+		if s.TwoFactorRequired(user.Role) && !user.TwoFactorEnabled {
+			return ErrTwoFactorRequired
+		}
+	}
+	return s.db.Delete(&credential).Error
+}
+
+// BeginWebAuthnLogin 发起第二因素认证 (限定为用户已注册的凭据)
+func (s *Service) BeginWebAuthnLogin(origin string, user *model.User) (*protocol.CredentialAssertion, string, error) {
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	u, err := s.loadWebAuthnUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(u.credentials) == 0 {
+		return nil, "", ErrWebAuthnNotRegistered
+	}
+	assertion, session, err := rp.BeginLogin(u)
+	if err != nil {
+		return nil, "", err
+	}
+	id := s.webauthnCeremonies.put(&webauthnCeremony{kind: "login", userID: user.ID, session: *session})
+	return assertion, id, nil
+}
+
+// FinishWebAuthnLogin 校验第二因素认证响应
+func (s *Service) FinishWebAuthnLogin(origin string, user *model.User, ceremonyID string, response []byte) error {
+	ceremony, err := s.webauthnCeremonies.take(ceremonyID, "login", user.ID)
+	if err != nil {
+		return err
+	}
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return err
+	}
+	u, err := s.loadWebAuthnUser(user)
+	if err != nil {
+		return err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return fmt.Errorf("invalid assertion: %w", err)
+	}
+	credential, err := rp.ValidateLogin(u, ceremony.session, parsed)
+	if err != nil {
+		return fmt.Errorf("assertion verification failed: %w", err)
+	}
+	return s.recordWebAuthnUse(user.ID, credential)
+}
+
+// BeginPasskeyLogin 发起无密码登录 (可发现凭据，由认证器选择账户，必须验证用户)
+func (s *Service) BeginPasskeyLogin(origin string) (*protocol.CredentialAssertion, string, error) {
+	if !s.PasskeyLoginEnabled() {
+		return nil, "", ErrPasskeyLoginDisabled
+	}
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	assertion, session, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", err
+	}
+	id := s.webauthnCeremonies.put(&webauthnCeremony{kind: "passkey", session: *session})
+	return assertion, id, nil
+}
+
+// FinishPasskeyLogin 校验无密码登录响应，返回凭据所属用户
+func (s *Service) FinishPasskeyLogin(origin, ceremonyID string, response []byte) (*model.User, error) {
+	ceremony, err := s.webauthnCeremonies.take(ceremonyID, "passkey", 0)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid assertion: %w", err)
+	}
+
+	var owner *model.User
+	credential, err := rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, ErrWebAuthnNotRegistered
+		}
+		user, err := s.GetUser(uint(binary.BigEndian.Uint64(userHandle)))
+		if err != nil {
+			return nil, ErrWebAuthnNotRegistered
+		}
+		owner = user
+		return s.loadWebAuthnUser(user)
+	}, ceremony.session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("assertion verification failed: %w", err)
+	}
+	if err := s.recordWebAuthnUse(owner.ID, credential); err != nil {
+		return nil, err
+	}
+	return owner, nil
+}
+
+// recordWebAuthnUse 更新签名计数与使用时间；计数器回退说明凭据可能被克隆，拒绝登录
+func (s *Service) recordWebAuthnUse(userID uint, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return ErrWebAuthnCloned
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return s.db.Model(&model.WebAuthnCredential{}).
+		Where("user_id = ? AND credential_id = ?", userID, base64.RawURLEncoding.EncodeToString(credential.ID)).
+		Updates(map[string]interface{}{"data": string(data), "last_used_at": &now}).Error
+}
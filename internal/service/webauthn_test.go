@@ -0,0 +1,379 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/AliceNetworks/gost-panel/internal/model"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+const testWebAuthnOrigin = "https://panel.example.com"
+
+// softAuthenticator 软件认证器: P-256 密钥、none 证明格式，签名计数可手动设置
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	credID    []byte
+	handle    []byte // 注册时的用户句柄，无密码登录时返回
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{key: key, credID: credID}
+}
+
+const (
+	authFlagUserPresent  = 0x01
+	authFlagUserVerified = 0x04
+	authFlagAttestedData = 0x40
+)
+
+func (a *softAuthenticator) authData(rpID string, flags byte) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	data := append(rpHash[:], flags|authFlagUserPresent|authFlagUserVerified)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func clientData(t *testing.T, kind string, challenge []byte) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{
+		"type":        kind,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      testWebAuthnOrigin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// register 模拟 navigator.credentials.create，返回注册响应 JSON
+func (a *softAuthenticator) register(t *testing.T, creation *protocol.CredentialCreation) []byte {
+	t.Helper()
+	opts := creation.Response
+	if handle, ok := opts.User.ID.(protocol.URLEncodedBase64); ok {
+		a.handle = handle
+	}
+
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authData := a.authData(opts.RelyingParty.ID, authFlagAttestedData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credID)))
+	authData = append(authData, a.credID...)
+	authData = append(authData, coseKey...)
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64(clientData(t, "webauthn.create", opts.Challenge)),
+			"attestationObject": b64(attestation),
+			"transports":        []string{"usb"},
+		},
+		"clientExtensionResults": map[string]interface{}{"credProps": map[string]bool{"rk": true}},
+	})
+	return response
+}
+
+// assert 模拟 navigator.credentials.get，userHandle 为空时不返回用户句柄
+func (a *softAuthenticator) assert(t *testing.T, assertion *protocol.CredentialAssertion, userHandle []byte) []byte {
+	t.Helper()
+	opts := assertion.Response
+	authData := a.authData(opts.RelyingPartyID, 0)
+	clientDataJSON := clientData(t, "webauthn.get", opts.Challenge)
+	clientHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := map[string]interface{}{
+		"clientDataJSON":    b64(clientDataJSON),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+	}
+	if userHandle != nil {
+		resp["userHandle"] = b64(userHandle)
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"id":       b64(a.credID),
+		"rawId":    b64(a.credID),
+		"type":     "public-key",
+		"response": resp,
+	})
+	return response
+}
+
+// registerSoftKey 为用户注册一个软件认证器
+func registerSoftKey(t *testing.T, svc *Service, user *model.User, name string) (*softAuthenticator, *model.WebAuthnCredential) {
+	t.Helper()
+	key := newSoftAuthenticator(t)
+	creation, ceremonyID, err := svc.BeginWebAuthnRegistration(testWebAuthnOrigin, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := svc.FinishWebAuthnRegistration(testWebAuthnOrigin, user, ceremonyID, name, key.register(t, creation))
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	return key, record
+}
+
+// webauthnLogin 使用认证器完成一次第二因素认证
+func webauthnLogin(t *testing.T, svc *Service, user *model.User, key *softAuthenticator) error {
+	t.Helper()
+	assertion, ceremonyID, err := svc.BeginWebAuthnLogin(testWebAuthnOrigin, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc.FinishWebAuthnLogin(testWebAuthnOrigin, user, ceremonyID, key.assert(t, assertion, nil))
+}
+
+func newWebAuthnTestUser(t *testing.T, svc *Service, username string) *model.User {
+	t.Helper()
+	user, err := svc.CreateUserFull(username, username+"@example.com", "Str0ng-Passw0rd!", RoleUser, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	svc := newTestService(t)
+	user := newWebAuthnTestUser(t, svc, "alice")
+
+	key, record := registerSoftKey(t, svc, user, " YubiKey ")
+	if record.Name != "YubiKey" || record.CredentialID != b64(key.credID) {
+		t.Errorf("credential = name %q id %s", record.Name, record.CredentialID)
+	}
+	if !record.Discoverable || record.Transports != "usb" {
+		t.Errorf("credential discoverable/transports = %v/%q", record.Discoverable, record.Transports)
+	}
+	if factors := svc.SecondFactors(user); len(factors) != 1 || factors[0] != SecondFactorWebAuthn {
+		t.Errorf("second factors = %v", factors)
+	}
+
+	// 已注册的凭据出现在排除列表中，重复注册被拒绝
+	creation, ceremonyID, err := svc.BeginWebAuthnRegistration(testWebAuthnOrigin, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creation.Response.CredentialExcludeList) != 1 {
+		t.Errorf("exclude list = %v", creation.Response.CredentialExcludeList)
+	}
+	if _, err := svc.FinishWebAuthnRegistration(testWebAuthnOrigin, user, ceremonyID, "again", key.register(t, creation)); err == nil {
+		t.Error("same authenticator registered twice")
+	}
+}
+
+func TestWebAuthnRegistrationRejectsWrongOrigin(t *testing.T) {
+	svc := newTestService(t)
+	user := newWebAuthnTestUser(t, svc, "alice")
+
+	key := newSoftAuthenticator(t)
+	creation, ceremonyID, err := svc.BeginWebAuthnRegistration("https://other.example.com", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 认证器始终声明 testWebAuthnOrigin
+	if _, err := svc.FinishWebAuthnRegistration("https://other.example.com", user, ceremonyID, "", key.register(t, creation)); err == nil {
+		t.Error("registration accepted from a different origin")
+	}
+}
+
+func TestFinishWebAuthnLogin(t *testing.T) {
+	svc := newTestService(t)
+	user := newWebAuthnTestUser(t, svc, "alice")
+	key, record := registerSoftKey(t, svc, user, "key")
+
+	key.signCount = 1
+	if err := webauthnLogin(t, svc, user, key); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	var stored model.WebAuthnCredential
+	svc.DB().First(&stored, record.ID)
+	if stored.LastUsedAt == nil {
+		t.Error("last used time not recorded")
+	}
+
+	// 重放: 同一挑战与响应不能再次使用
+	key.signCount = 2
+	assertion, ceremonyID, err := svc.BeginWebAuthnLogin(testWebAuthnOrigin, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := key.assert(t, assertion, nil)
+	if err := svc.FinishWebAuthnLogin(testWebAuthnOrigin, user, ceremonyID, response); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if err := svc.FinishWebAuthnLogin(testWebAuthnOrigin, user, ceremonyID, response); !errors.Is(err, ErrWebAuthnSessionInvalid) {
+		t.Errorf("replayed ceremony: err = %v", err)
+	}
+}
+
+func TestFinishWebAuthnLoginRejectsOtherUsersCeremony(t *testing.T) {
+	svc := newTestService(t)
+	alice := newWebAuthnTestUser(t, svc, "alice")
+	bob := newWebAuthnTestUser(t, svc, "bob")
+	aliceKey, _ := registerSoftKey(t, svc, alice, "alice")
+	registerSoftKey(t, svc, bob, "bob")
+
+	// bob 的临时令牌配合 alice 发起的挑战
+	assertion, ceremonyID, err := svc.BeginWebAuthnLogin(testWebAuthnOrigin, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKey.signCount = 1
+	response := aliceKey.assert(t, assertion, nil)
+	if err := svc.FinishWebAuthnLogin(testWebAuthnOrigin, bob, ceremonyID, response); !errors.Is(err, ErrWebAuthnSessionInvalid) {
+		t.Errorf("cross-user ceremony: err = %v", err)
+	}
+	// 挑战已被消耗
+	if err := svc.FinishWebAuthnLogin(testWebAuthnOrigin, alice, ceremonyID, response); !errors.Is(err, ErrWebAuthnSessionInvalid) {
+		t.Errorf("ceremony reused after failed attempt: err = %v", err)
+	}
+
+	// 登录挑战不能用于注册
+	_, ceremonyID, err = svc.BeginWebAuthnLogin(testWebAuthnOrigin, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.FinishWebAuthnRegistration(testWebAuthnOrigin, alice, ceremonyID, "", response); !errors.Is(err, ErrWebAuthnSessionInvalid) {
+		t.Errorf("login ceremony used for registration: err = %v", err)
+	}
+}
+
+func TestWebAuthnCloneWarning(t *testing.T) {
+	svc := newTestService(t)
+	user := newWebAuthnTestUser(t, svc, "alice")
+	key, _ := registerSoftKey(t, svc, user, "key")
+
+	key.signCount = 5
+	if err := webauthnLogin(t, svc, user, key); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	// 计数器回退: 另一个认证器副本使用了较旧的计数
+	key.signCount = 3
+	if err := webauthnLogin(t, svc, user, key); !errors.Is(err, ErrWebAuthnCloned) {
+		t.Errorf("counter regression: err = %v", err)
+	}
+	key.signCount = 6
+	if err := webauthnLogin(t, svc, user, key); err != nil {
+		t.Errorf("login after rejected clone: %v", err)
+	}
+}
+
+func TestFinishPasskeyLogin(t *testing.T) {
+	svc := newTestService(t)
+	alice := newWebAuthnTestUser(t, svc, "alice")
+	bob := newWebAuthnTestUser(t, svc, "bob")
+	key, _ := registerSoftKey(t, svc, alice, "passkey")
+	registerSoftKey(t, svc, bob, "bob")
+
+	passkeyLogin := func(handle []byte) (*model.User, error) {
+		assertion, ceremonyID, err := svc.BeginPasskeyLogin(testWebAuthnOrigin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.signCount++
+		return svc.FinishPasskeyLogin(testWebAuthnOrigin, ceremonyID, key.assert(t, assertion, handle))
+	}
+
+	user, err := passkeyLogin(key.handle)
+	if err != nil {
+		t.Fatalf("passkey login: %v", err)
+	}
+	if user.ID != alice.ID {
+		t.Errorf("passkey login returned user %d, want %d", user.ID, alice.ID)
+	}
+
+	// 用户句柄指向其他用户、不存在的用户或格式错误
+	for name, handle := range map[string][]byte{
+		"other user":   webauthnUserHandle(bob.ID),
+		"unknown user": webauthnUserHandle(9999),
+		"short handle": {1, 2, 3},
+	} {
+		if user, err := passkeyLogin(handle); err == nil {
+			t.Errorf("%s: passkey login succeeded as %s", name, user.Username)
+		}
+	}
+
+	if err := svc.SetSiteConfig(model.ConfigPasskeyLoginEnabled, "false"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := svc.BeginPasskeyLogin(testWebAuthnOrigin); !errors.Is(err, ErrPasskeyLoginDisabled) {
+		t.Errorf("passkey login disabled: err = %v", err)
+	}
+}
+
+func TestDeleteWebAuthnCredentialKeepsRequiredKey(t *testing.T) {
+	svc := newTestService(t)
+	user := newWebAuthnTestUser(t, svc, "alice")
+	if err := svc.SetSiteConfig(model.ConfigWebAuthnRequiredRoles, RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	_, first := registerSoftKey(t, svc, user, "first")
+	_, second := registerSoftKey(t, svc, user, "second")
+
+	if err := svc.DeleteWebAuthnCredential(user, first.ID); err != nil {
+		t.Fatalf("delete one of two keys: %v", err)
+	}
+	if err := svc.DeleteWebAuthnCredential(user, second.ID); !errors.Is(err, ErrWebAuthnRequired) {
+		t.Errorf("delete last required key: err = %v", err)
+	}
+	if svc.CountWebAuthnCredentials(user.ID) != 1 {
+		t.Error("last required key was deleted")
+	}
+
+	// 其他用户不能删除该凭据
+	other := newWebAuthnTestUser(t, svc, "bob")
+	if err := svc.DeleteWebAuthnCredential(other, second.ID); !errors.Is(err, ErrWebAuthnNotRegistered) {
+		t.Errorf("delete another user's key: err = %v", err)
+	}
+
+	if err := svc.SetSiteConfig(model.ConfigWebAuthnRequiredRoles, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.DeleteWebAuthnCredential(user, second.ID); err != nil {
+		t.Errorf("delete last key when not required: %v", err)
+	}
+}
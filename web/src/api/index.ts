@@ -61,16 +61,61 @@ api.interceptors.request.use((config) => {
   return config
 })
 
+// 刷新访问令牌 (并发请求共用同一次刷新)
+let refreshing: Promise<string> | null = null
+
+const refreshAccessToken = (): Promise<string> => {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem('refresh_token') || ''
+    refreshing = axios
+      .post('/api/token/refresh', { refresh_token: refreshToken })
+      .then((res) => {
+        localStorage.setItem('token', res.data.token)
+        localStorage.setItem('refresh_token', res.data.refresh_token)
+        return res.data.token as string
+      })
+      .catch((err) => {
+        // 409: 其他标签页已完成刷新，使用其保存的新令牌
+        if (err.response?.status === 409 && localStorage.getItem('refresh_token') !== refreshToken) {
+          return localStorage.getItem('token') as string
+        }
+        throw err
+      })
+      .finally(() => {
+        refreshing = null
+      })
+  }
+  return refreshing
+}
+
+const redirectToLogin = () => {
+  if (isRedirecting) return
+  isRedirecting = true
+  localStorage.removeItem('token')
+  localStorage.removeItem('refresh_token')
+  router.push({ name: 'login' }).finally(() => {
+    isRedirecting = false
+  })
+}
+
 // 响应拦截器
 api.interceptors.response.use(
   (response) => response.data,
-  (error) => {
-    if (error.response?.status === 401 && !isRedirecting) {
-      isRedirecting = true
-      localStorage.removeItem('token')
-      router.push({ name: 'login' }).finally(() => {
-        isRedirecting = false
-      })
+  async (error) => {
+    const config = error.config
+    if (error.response?.status === 401) {
+      // 访问令牌过期时使用刷新令牌续期后重试一次
+      if (config && !config._retried && localStorage.getItem('refresh_token') && !config.url?.startsWith('/login')) {
+        config._retried = true
+        try {
+          const token = await refreshAccessToken()
+          config.headers.Authorization = `Bearer ${token}`
+          return api(config)
+        } catch {
+          // 刷新失败，需重新登录
+        }
+      }
+      redirectToLogin()
     }
     return Promise.reject(error)
   }
@@ -79,6 +124,7 @@ api.interceptors.response.use(
 // 认证
 export const login = (username: string, password: string): Promise<LoginResponse> =>
   api.post('/login', { username, password })
+export const logout = () => api.post('/logout')
 
 // 统计
 export const getStats = () => api.get('/stats')
@@ -105,6 +151,20 @@ export const getNodeHealthLogs = (nodeId: number, limit: number = 50) =>
   api.get(`/nodes/${nodeId}/health-logs`, { params: { limit } })
 export const getHealthSummary = () => api.get('/health-summary')
 
+// 节点远程命令
+export const getNodeCommands = (nodeId: number, limit: number = 50) =>
+  api.get(`/nodes/${nodeId}/commands`, { params: { limit } })
+export const createNodeCommand = (nodeId: number, data: { type: string; params?: Record<string, any>; timeout?: number }) =>
+  api.post(`/nodes/${nodeId}/commands`, data)
+export const cancelNodeCommand = (nodeId: number, commandId: number) =>
+  api.post(`/nodes/${nodeId}/commands/${commandId}/cancel`)
+
+// 节点 GOST 日志
+export const getNodeLogs = (
+  nodeId: number,
+  params: { level?: string; q?: string; from?: string; to?: string; limit?: number; offset?: number } = {}
+) => api.get(`/nodes/${nodeId}/logs`, { params })
+
 // 节点批量操作
 export const batchEnableNodes = (ids: number[]) => api.post('/nodes/batch-enable', { ids })
 export const batchDisableNodes = (ids: number[]) => api.post('/nodes/batch-disable', { ids })
@@ -139,6 +199,23 @@ export const changePassword = (oldPassword: string, newPassword: string) =>
 export const verifyUserEmail = (id: number) => api.post(`/users/${id}/verify-email`)
 export const resendVerification = (id: number) => api.post(`/users/${id}/resend-verification`)
 export const resetUserQuota = (id: number) => api.post(`/users/${id}/reset-quota`)
+export const unlockUser = (id: number) => api.post(`/users/${id}/unlock`)
+export const resetUserTwoFactor = (id: number) => api.post(`/users/${id}/reset-2fa`)
+
+// 角色权限
+export const getRoles = () => api.get('/roles')
+export const getRole = (id: number | string) => api.get(`/roles/${id}`)
+export const createRole = (data: any) => api.post('/roles', data)
+export const updateRole = (id: number, data: any) => api.put(`/roles/${id}`, data)
+export const deleteRole = (id: number) => api.delete(`/roles/${id}`)
+export const getPermissionResources = () => api.get('/permissions')
+export const getMyPermissions = () => api.get('/profile/permissions')
+
+// 个人访问令牌
+export const getAPITokens = () => api.get('/profile/tokens')
+export const createAPIToken = (data: { name: string; scopes: string[]; expires_at?: string; expires_in_days?: number }) =>
+  api.post('/profile/tokens', data)
+export const deleteAPIToken = (id: number) => api.delete(`/profile/tokens/${id}`)
 
 // 个人账户设置
 export const getProfile = () => api.get('/profile')
@@ -150,6 +227,20 @@ export const verify2FA = (code: string) => api.post('/profile/2fa/verify', { cod
 export const disable2FA = (password: string) => api.post('/profile/2fa/disable', { password })
 export const login2FA = (temp_token: string, code: string) => api.post('/login/2fa', { temp_token, code })
 
+// WebAuthn 安全密钥 / 通行密钥
+export const getWebAuthnCredentials = () => api.get('/profile/webauthn')
+export const beginWebAuthnRegistration = () => api.post('/profile/webauthn/register/begin')
+export const finishWebAuthnRegistration = (session_id: string, name: string, credential: any) =>
+  api.post('/profile/webauthn/register/finish', { session_id, name, credential })
+export const renameWebAuthnCredential = (id: number, name: string) => api.put(`/profile/webauthn/${id}`, { name })
+export const deleteWebAuthnCredential = (id: number) => api.delete(`/profile/webauthn/${id}`)
+export const loginWebAuthnBegin = (temp_token: string) => api.post('/login/webauthn/begin', { temp_token })
+export const loginWebAuthnFinish = (temp_token: string, session_id: string, credential: any) =>
+  api.post('/login/webauthn/finish', { temp_token, session_id, credential })
+export const passkeyLoginBegin = () => api.post('/login/passkey/begin')
+export const passkeyLoginFinish = (session_id: string, credential: any) =>
+  api.post('/login/passkey/finish', { session_id, credential })
+
 // 用户注册和验证 (公开接口)
 export const register = (username: string, email: string, password: string) =>
   api.post('/register', { username, email, password })
@@ -281,6 +372,8 @@ export const getHealthInfo = () => axios.get('/api/health').then(r => r.data)
 export const getAgentVersion = () => axios.get('/agent/version').then(r => r.data)
 export const getSiteConfigs = () => api.get('/site-configs')
 export const updateSiteConfigs = (data: Record<string, string>) => api.put('/site-configs', data)
+export const testLDAP = (data: { configs?: Record<string, string>; username?: string; password?: string }) =>
+  api.post('/site-configs/ldap/test', data)
 
 // 节点标签
 export const getTags = () => api.get('/tags')
@@ -313,6 +406,28 @@ export const assignUserPlan = (userId: number, planId: number) => api.post(`/use
 export const removeUserPlan = (userId: number) => api.post(`/users/${userId}/remove-plan`)
 export const renewUserPlan = (userId: number, days: number) => api.post(`/users/${userId}/renew-plan`, { days })
 
+// 组织 (多租户)
+export const getOrganizations = () => api.get('/organizations')
+export const getOrganization = (id: number) => api.get(`/organizations/${id}`)
+export const createOrganization = (data: { name: string; description?: string }) => api.post('/organizations', data)
+export const updateOrganization = (id: number, data: { name: string; description?: string; traffic_quota?: number }) =>
+  api.put(`/organizations/${id}`, data)
+export const deleteOrganization = (id: number) => api.delete(`/organizations/${id}`)
+export const getOrgMembers = (id: number) => api.get(`/organizations/${id}/members`)
+export const addOrgMember = (id: number, data: { username: string; role: string }) => api.post(`/organizations/${id}/members`, data)
+export const updateOrgMember = (id: number, userId: number, role: string) =>
+  api.put(`/organizations/${id}/members/${userId}`, { role })
+export const removeOrgMember = (id: number, userId: number) => api.delete(`/organizations/${id}/members/${userId}`)
+export const getOrgResources = (id: number) => api.get(`/organizations/${id}/resources`)
+export const assignOrgResource = (id: number, type: string, resourceId: number) =>
+  api.post(`/organizations/${id}/resources`, { type, id: resourceId })
+export const removeOrgResource = (id: number, type: string, resourceId: number) =>
+  api.delete(`/organizations/${id}/resources/${type}/${resourceId}`)
+export const assignOrgPlan = (id: number, planId: number) => api.post(`/organizations/${id}/assign-plan`, { plan_id: planId })
+export const removeOrgPlan = (id: number) => api.post(`/organizations/${id}/remove-plan`)
+export const renewOrgPlan = (id: number, days: number) => api.post(`/organizations/${id}/renew-plan`, { days })
+export const resetOrgQuota = (id: number) => api.post(`/organizations/${id}/reset-quota`)
+
 // Bypass 分流规则
 export const getBypasses = () => api.get('/bypasses')
 export const getBypass = (id: number) => api.get(`/bypasses/${id}`)
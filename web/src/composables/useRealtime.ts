@@ -0,0 +1,68 @@
+import { ref, onUnmounted, getCurrentInstance } from 'vue'
+
+export interface RealtimeMessage {
+  type: string
+  data: any
+}
+
+const RECONNECT_DELAY = 5000
+
+// 订阅 WebSocket 推送主题 (nodes / tunnels / alerts / operation-logs / node-logs / stats)，断线自动重连
+// 服务端按角色权限与资源归属过滤事件，无权限的主题会被忽略
+export function useRealtime(topics: string[], onMessage: (msg: RealtimeMessage) => void) {
+  const connected = ref(false)
+  let ws: WebSocket | null = null
+  let reconnectTimer: ReturnType<typeof setTimeout> | null = null
+  let stopped = false
+
+  const connect = () => {
+    if (stopped) return
+    const token = localStorage.getItem('token')
+    if (!token) return
+
+    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
+    const url = `${protocol}//${window.location.host}/ws?topics=${encodeURIComponent(topics.join(','))}`
+    try {
+      // 浏览器无法设置 Authorization 头，令牌通过子协议传递
+      ws = new WebSocket(url, ['bearer', token])
+    } catch {
+      return
+    }
+
+    ws.onopen = () => {
+      connected.value = true
+    }
+    ws.onmessage = (event) => {
+      try {
+        onMessage(JSON.parse(event.data))
+      } catch {
+        // Ignore parse errors
+      }
+    }
+    ws.onclose = () => {
+      connected.value = false
+      if (stopped) return
+      if (reconnectTimer) clearTimeout(reconnectTimer)
+      reconnectTimer = setTimeout(connect, RECONNECT_DELAY)
+    }
+    ws.onerror = () => {
+      ws?.close()
+    }
+  }
+
+  const stop = () => {
+    stopped = true
+    if (reconnectTimer) {
+      clearTimeout(reconnectTimer)
+      reconnectTimer = null
+    }
+    ws?.close()
+    ws = null
+  }
+
+  // 在 setup 之外 (如弹窗打开时) 调用需自行 stop
+  if (getCurrentInstance()) onUnmounted(stop)
+  connect()
+
+  return { connected, stop }
+}
@@ -0,0 +1,87 @@
+// WebAuthn 浏览器端封装: 服务端选项中的二进制字段为 base64url 字符串，需与 ArrayBuffer 互相转换
+
+const toBuffer = (value: string): ArrayBuffer => {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/')
+  const padded = base64 + '='.repeat((4 - (base64.length % 4)) % 4)
+  const binary = atob(padded)
+  const bytes = new Uint8Array(binary.length)
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i)
+  }
+  return bytes.buffer
+}
+
+const toBase64URL = (buffer: ArrayBuffer | null): string | undefined => {
+  if (!buffer) return undefined
+  const bytes = new Uint8Array(buffer)
+  let binary = ''
+  for (let i = 0; i < bytes.length; i++) {
+    binary += String.fromCharCode(bytes[i])
+  }
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+}
+
+const convertDescriptors = (list?: any[]) =>
+  list?.map((item) => ({ ...item, id: toBuffer(item.id) }))
+
+export function useWebAuthn() {
+  const isSupported = () => typeof window !== 'undefined' && !!window.PublicKeyCredential
+
+  // 注册: navigator.credentials.create，返回可直接提交给服务端的 JSON
+  const createCredential = async (options: any) => {
+    const publicKey = options.publicKey
+    const credential = (await navigator.credentials.create({
+      publicKey: {
+        ...publicKey,
+        challenge: toBuffer(publicKey.challenge),
+        user: { ...publicKey.user, id: toBuffer(publicKey.user.id) },
+        excludeCredentials: convertDescriptors(publicKey.excludeCredentials),
+      },
+    })) as PublicKeyCredential | null
+    if (!credential) throw new Error('cancelled')
+
+    const response = credential.response as AuthenticatorAttestationResponse
+    return {
+      id: credential.id,
+      rawId: toBase64URL(credential.rawId),
+      type: credential.type,
+      authenticatorAttachment: (credential as any).authenticatorAttachment || undefined,
+      clientExtensionResults: credential.getClientExtensionResults(),
+      response: {
+        clientDataJSON: toBase64URL(response.clientDataJSON),
+        attestationObject: toBase64URL(response.attestationObject),
+        transports: response.getTransports ? response.getTransports() : [],
+      },
+    }
+  }
+
+  // 认证: navigator.credentials.get (allowCredentials 为空时由认证器选择通行密钥)
+  const getAssertion = async (options: any) => {
+    const publicKey = options.publicKey
+    const credential = (await navigator.credentials.get({
+      publicKey: {
+        ...publicKey,
+        challenge: toBuffer(publicKey.challenge),
+        allowCredentials: convertDescriptors(publicKey.allowCredentials),
+      },
+    })) as PublicKeyCredential | null
+    if (!credential) throw new Error('cancelled')
+
+    const response = credential.response as AuthenticatorAssertionResponse
+    return {
+      id: credential.id,
+      rawId: toBase64URL(credential.rawId),
+      type: credential.type,
+      authenticatorAttachment: (credential as any).authenticatorAttachment || undefined,
+      clientExtensionResults: credential.getClientExtensionResults(),
+      response: {
+        clientDataJSON: toBase64URL(response.clientDataJSON),
+        authenticatorData: toBase64URL(response.authenticatorData),
+        signature: toBase64URL(response.signature),
+        userHandle: toBase64URL(response.userHandle),
+      },
+    }
+  }
+
+  return { isSupported, createCredential, getAssertion }
+}
@@ -54,6 +54,7 @@ export default {
     notify: 'Alerts',
     operationLogs: 'Audit Logs',
     plans: 'Plans',
+    organizations: 'Organizations',
     settings: 'Settings',
   },
   auth: {
@@ -54,6 +54,7 @@ export default {
     notify: '告警通知',
     operationLogs: '操作日志',
     plans: '套餐管理',
+    organizations: '组织',
     settings: '网站设置',
   },
   auth: {
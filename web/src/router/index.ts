@@ -101,6 +101,11 @@ const router = createRouter({
           name: 'plans',
           component: () => import('../views/Plans.vue'),
         },
+        {
+          path: 'organizations',
+          name: 'organizations',
+          component: () => import('../views/Organizations.vue'),
+        },
         {
           path: 'rules',
           name: 'rules',
@@ -1,6 +1,6 @@
 import { defineStore } from 'pinia'
 import { ref } from 'vue'
-import { login as apiLogin } from '../api'
+import { login as apiLogin, logout as apiLogout } from '../api'
 import type { User } from '../types'
 
 export const useUserStore = defineStore('user', () => {
@@ -18,18 +18,32 @@ export const useUserStore = defineStore('user', () => {
       return res // 返回 temp_token，由 Login.vue 处理
     }
 
-    token.value = res.token
-    user.value = res.user
-    localStorage.setItem('token', res.token)
-    localStorage.setItem('user', JSON.stringify(res.user))
+    setSession(res.token, res.refresh_token || '', res.user)
+    return res
+  }
+
+  // 保存登录令牌与用户信息
+  const setSession = (accessToken: string, refreshToken: string, u: User | null) => {
+    token.value = accessToken
+    user.value = u
+    localStorage.setItem('token', accessToken)
+    localStorage.setItem('refresh_token', refreshToken)
+    localStorage.setItem('user', JSON.stringify(u))
   }
 
   const logout = () => {
+    // 撤销服务端会话，使刷新令牌失效
+    if (token.value) {
+      apiLogout().catch(() => {})
+    }
     token.value = ''
     user.value = null
     localStorage.removeItem('token')
+    localStorage.removeItem('refresh_token')
     localStorage.removeItem('user')
+    localStorage.removeItem('webauthn_setup_required')
+    localStorage.removeItem('two_factor_setup_required')
   }
 
-  return { token, user, login, logout }
+  return { token, user, login, logout, setSession }
 })
@@ -32,9 +32,14 @@ export interface User extends BaseEntity {
 
 export interface LoginResponse {
   token: string
+  refresh_token?: string
+  expires_in?: number
   user: User
   requires_2fa?: boolean
   temp_token?: string
+  methods?: string[] // 可用的第二因素: totp / webauthn
+  webauthn_setup_required?: boolean
+  two_factor_setup_required?: boolean
 }
 
 export interface ProfileUpdateRequest {
@@ -79,6 +84,7 @@ export interface Node extends BaseEntity {
   quota_exceeded?: boolean
   // 所有者
   owner_id?: number
+  org_id?: number
   last_seen?: string
   tags?: Tag[]
 }
@@ -132,6 +138,7 @@ export interface Client extends BaseEntity {
   quota_exceeded?: boolean
   // 所有者
   owner_id?: number
+  org_id?: number
   last_seen?: string
 }
 
@@ -169,6 +176,7 @@ export interface PortForward extends BaseEntity {
   chain_id?: number
   enabled: boolean
   owner_id?: number
+  org_id?: number
   node_name?: string
 }
 
@@ -182,6 +190,7 @@ export interface NodeGroup extends BaseEntity {
   health_check?: boolean
   check_interval?: number
   owner_id?: number
+  org_id?: number
   members?: NodeGroupMember[]
 }
 
@@ -204,6 +213,7 @@ export interface ProxyChain extends BaseEntity {
   target_addr?: string
   enabled: boolean
   owner_id?: number
+  org_id?: number
   hops?: ProxyChainHop[]
 }
 
@@ -232,6 +242,7 @@ export interface Tunnel extends BaseEntity {
   quota_reset_day?: number
   speed_limit?: number
   owner_id?: number
+  org_id?: number
   entry_node?: Node
   exit_node?: Node
 }
@@ -338,6 +349,7 @@ export interface Bypass extends BaseEntity {
   matchers: string // JSON array
   node_id?: number
   owner_id?: number
+  org_id?: number
 }
 
 // Admission 准入控制
@@ -347,6 +359,7 @@ export interface Admission extends BaseEntity {
   matchers: string // JSON array
   node_id?: number
   owner_id?: number
+  org_id?: number
 }
 
 // HostMapping 主机映射
@@ -355,6 +368,7 @@ export interface HostMapping extends BaseEntity {
   mappings: string // JSON array of {hostname, ip, prefer}
   node_id?: number
   owner_id?: number
+  org_id?: number
 }
 
 // Ingress 反向代理
@@ -363,6 +377,7 @@ export interface Ingress extends BaseEntity {
   rules: string // JSON: [{"hostname":"example.com","endpoint":"192.168.1.1:8080"}]
   node_id?: number
   owner_id?: number
+  org_id?: number
 }
 
 // Recorder 流量记录
@@ -372,6 +387,7 @@ export interface Recorder extends BaseEntity {
   config: string // JSON config
   node_id?: number
   owner_id?: number
+  org_id?: number
 }
 
 export type IngressCreateRequest = Record<string, unknown>
@@ -385,15 +401,17 @@ export interface Router extends BaseEntity {
   routes: string // JSON: [{"net":"192.168.0.0/16","gateway":"192.168.0.1"}]
   node_id?: number
   owner_id?: number
+  org_id?: number
 }
 
 // SD 服务发现
 export interface SD extends BaseEntity {
   name: string
-  type: string // http, consul, etcd, redis
+  type: string // http, grpc
   config: string // JSON config
   node_id?: number
   owner_id?: number
+  org_id?: number
 }
 
 export type RouterCreateRequest = Record<string, unknown>